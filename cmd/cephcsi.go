@@ -26,6 +26,7 @@ import (
 	"github.com/ceph/ceph-csi/internal/cephfs"
 	"github.com/ceph/ceph-csi/internal/controller"
 	"github.com/ceph/ceph-csi/internal/controller/persistentvolume"
+	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/liveness"
 	nfsdriver "github.com/ceph/ceph-csi/internal/nfs/driver"
 	rbddriver "github.com/ceph/ceph-csi/internal/rbd/driver"
@@ -50,6 +51,9 @@ const (
 	pollTime     = 60 // seconds
 	probeTimeout = 3  // seconds
 
+	connIdleTimeout = 10 * time.Minute
+	radosRetryDelay = 100 * time.Millisecond
+
 	// use default namespace if namespace is not set.
 	defaultNS = "default"
 
@@ -75,6 +79,9 @@ func init() {
 	flag.IntVar(&conf.PidLimit, "pidlimit", 0, "the PID limit to configure through cgroups")
 	flag.BoolVar(&conf.IsControllerServer, "controllerserver", false, "start cephcsi controller server")
 	flag.BoolVar(&conf.IsNodeServer, "nodeserver", false, "start cephcsi node server")
+	flag.StringVar(&conf.LogFormat, "logformat", log.FormatText, "format of the log messages [text|json]")
+	flag.StringVar(&conf.LogLevelFile, "loglevel-file", "",
+		"file with the log verbosity, the verbosity is updated when the contents of the file change")
 	flag.StringVar(
 		&conf.DomainLabels,
 		"domainlabels",
@@ -105,6 +112,8 @@ func init() {
 		"fusemountoptions",
 		"",
 		"Comma separated string of mount options accepted by ceph-fuse mounter")
+	flag.BoolVar(&conf.CephFSAutoRemount, "cephfs-auto-remount", false,
+		"remount stale cephfs kernel mounts that use recover_session=clean when their stats are requested")
 
 	// liveness/grpc metrics related flags
 	flag.IntVar(&conf.MetricsPort, "metricsport", 8080, "TCP port for liveness/grpc metrics requests")
@@ -117,6 +126,14 @@ func init() {
 	flag.DurationVar(&conf.PoolTimeout, "timeout", time.Second*probeTimeout, "probe timeout in seconds")
 
 	flag.BoolVar(&conf.EnableGRPCMetrics, "enablegrpcmetrics", false, "[DEPRECATED] enable grpc metrics")
+	flag.BoolVar(&conf.EnableRPCMetrics, "enable-rpc-metrics", false,
+		"enable the metrics with the duration and status code of the RPCs, served on the metrics endpoint")
+	flag.DurationVar(&conf.SlowRPCThreshold, "slow-rpc-threshold", 2*time.Minute,
+		"log a warning for RPCs that are running longer than this, 0 disables the warnings")
+	flag.DurationVar(&conf.SlowRPCInterval, "slow-rpc-interval", time.Minute,
+		"interval at which the warning for a slow RPC is repeated")
+	flag.DurationVar(&conf.ShutdownGrace, "shutdown-grace-period", 25*time.Second,
+		"time the running RPCs get to finish when the driver receives SIGTERM")
 	flag.StringVar(
 		&conf.HistogramOption,
 		"histogramoption",
@@ -146,10 +163,50 @@ func init() {
 		"Minimum number of snapshots required on rbd image to start flattening")
 	flag.BoolVar(&conf.SkipForceFlatten, "skipforceflatten", false,
 		"skip image flattening if kernel support mapping of rbd images which has the deep-flatten feature")
+	flag.DurationVar(&conf.RbdTrashExpiry, "rbd-trash-expiry", 0,
+		"time a deleted rbd image is kept in the trash, 0 removes it right away")
+	flag.BoolVar(&conf.VerifyEncryptionPrereqs, "verifyencryptionprereqs", false,
+		"verify that cryptsetup and the dm_crypt kernel module are available for encrypted volumes")
+	flag.IntVar(&conf.LuksMinPassphraseLength, "luks-min-passphrase-length", util.DefaultMinPassphraseLength,
+		"minimal length in bytes of the passphrase that encrypted volumes are formatted with")
 
 	flag.BoolVar(&conf.Version, "version", false, "Print cephcsi version information")
 	flag.BoolVar(&conf.EnableProfiling, "enableprofiling", false, "enable go profiling")
 
+	// connection pool related flags
+	flag.DurationVar(&conf.ConnIdleTimeout, "conn-idle-timeout", connIdleTimeout,
+		"time after which unused connections to Ceph clusters are closed")
+	flag.IntVar(&conf.ConnPoolMaxSize, "conn-pool-max-size", 0,
+		"maximum number of connections to Ceph clusters, the least recently used idle connection is"+
+			" closed when the limit is reached (0 for no limit)")
+
+	// rados retry related flags
+	flag.IntVar(&conf.RadosRetries, "rados-retries", 3,
+		"number of retries of rados operations that failed with a transient error (ETIMEDOUT, EAGAIN, ENOTCONN)")
+	flag.DurationVar(&conf.RadosRetryDelay, "rados-retry-delay", radosRetryDelay,
+		"delay before the first retry of a rados operation, the delay doubles for every next retry")
+
+	// journal check related flags
+	flag.BoolVar(&conf.JournalCheck, "journal-check", false,
+		"check the journals of a pool for dangling entries and exit, only used with type controller")
+	flag.BoolVar(&conf.JournalRepair, "journal-repair", false,
+		"remove the dangling entries found with journal-check from the journals")
+	flag.BoolVar(&conf.JournalScanPool, "journal-scan-pool", false,
+		"list all objects in the pool to check the journals of all instances and unreferenced UUID directories,"+
+			" this reads the name of every object in the pool")
+	flag.StringVar(&conf.JournalClusterID, "journal-clusterid", "", "clusterID of the pool with the journals")
+	flag.StringVar(&conf.JournalPool, "journal-pool", "", "pool with the journals")
+	flag.StringVar(&conf.JournalFsName, "journal-fsname", "",
+		"CephFS filesystem of the journals, RBD images are checked when not set")
+	flag.StringVar(&conf.JournalSecretPath, "journal-secret-path", "",
+		"directory with the keys of the secret used to connect to the cluster")
+
+	// trash restore related flags
+	flag.StringVar(&conf.RestoreVolumeID, "restore-volume-id", "",
+		"restore the RBD image of the volume from the trash and re-create its journal, only used with type controller")
+	flag.StringVar(&conf.RestorePool, "restore-pool", "", "pool of the trashed image")
+	flag.StringVar(&conf.RestoreRadosNamespace, "restore-radosnamespace", "", "rados namespace of the trashed image")
+
 	// CSI-Addons configuration
 	flag.StringVar(&conf.CSIAddonsEndpoint, "csi-addons-endpoint", "unix:///tmp/csi-addons.sock", "CSI-Addons endpoint")
 
@@ -196,6 +253,12 @@ func main() {
 		printVersion()
 		os.Exit(0)
 	}
+	if err := log.SetFormat(conf.LogFormat); err != nil {
+		logAndExit(err.Error())
+	}
+	if conf.LogLevelFile != "" {
+		go log.WatchLogLevelFile(conf.LogLevelFile)
+	}
 	log.DefaultLog("Driver version: %s and Git version: %s", util.DriverVersion, util.GitCommit)
 
 	if conf.Vtype == "" {
@@ -210,7 +273,31 @@ func main() {
 
 	setPIDLimit(&conf)
 
-	if conf.EnableGRPCMetrics || conf.Vtype == livenessType {
+	if conf.ConnPoolMaxSize < 0 {
+		logAndExit("conn-pool-max-size flag value should not be negative")
+	}
+	util.ConfigureConnPool(conf.ConnIdleTimeout, conf.ConnPoolMaxSize)
+
+	if conf.RadosRetries < 0 {
+		logAndExit("rados-retries flag value should not be negative")
+	}
+	util.ConfigureRadosRetries(conf.RadosRetries, conf.RadosRetryDelay)
+
+	if conf.LuksMinPassphraseLength < 0 {
+		logAndExit("luks-min-passphrase-length flag value should not be negative")
+	}
+	util.ConfigureMinPassphraseLength(conf.LuksMinPassphraseLength)
+
+	if conf.SlowRPCThreshold < 0 || conf.SlowRPCInterval <= 0 {
+		logAndExit("slow-rpc-threshold flag value should not be negative and slow-rpc-interval should be positive")
+	}
+	csicommon.ConfigureSlowRPCWatchdog(conf.SlowRPCThreshold, conf.SlowRPCInterval)
+
+	if conf.RbdTrashExpiry < 0 {
+		logAndExit("rbd-trash-expiry flag value should not be negative")
+	}
+
+	if conf.EnableGRPCMetrics || conf.EnableRPCMetrics || conf.Vtype == livenessType {
 		// validate metrics endpoint
 		conf.MetricsIP = os.Getenv("POD_IP")
 
@@ -228,6 +315,10 @@ func main() {
 		log.FatalLogMsg("failed to write ceph configuration file (%v)", err)
 	}
 
+	if conf.Vtype == rbdType || conf.Vtype == cephFSType || conf.Vtype == nfsType {
+		csicommon.ShutdownOnSignal(conf.ShutdownGrace, util.DestroyConnPool)
+	}
+
 	log.DefaultLog("Starting driver type: %v with name: %v", conf.Vtype, dname)
 	switch conf.Vtype {
 	case rbdType:
@@ -248,6 +339,24 @@ func main() {
 		liveness.Run(&conf)
 
 	case controllerType:
+		if conf.RestoreVolumeID != "" {
+			err = runRestoreTrashedVolume(&conf)
+			if err != nil {
+				logAndExit(err.Error())
+			}
+
+			break
+		}
+
+		if conf.JournalCheck || conf.JournalRepair {
+			err = runJournalCheck(&conf)
+			if err != nil {
+				logAndExit(err.Error())
+			}
+
+			break
+		}
+
 		cfg := controller.Config{
 			DriverName:  dname,
 			Namespace:   conf.DriverNamespace,
@@ -0,0 +1,194 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/core"
+	fsutil "github.com/ceph/ceph-csi/internal/cephfs/util"
+	"github.com/ceph/ceph-csi/internal/journal"
+	"github.com/ceph/ceph-csi/internal/rbd"
+	"github.com/ceph/ceph-csi/internal/util"
+)
+
+// runJournalCheck checks the journals of the pool for dangling entries and
+// prints them. The dangling entries are removed when JournalRepair is set.
+func runJournalCheck(conf *util.Config) error {
+	ctx := context.Background()
+
+	if conf.JournalClusterID == "" || conf.JournalPool == "" {
+		return errors.New("journal-clusterid and journal-pool are required to check the journals")
+	}
+
+	monitors, err := util.Mons(util.CsiConfigFile, conf.JournalClusterID)
+	if err != nil {
+		return fmt.Errorf("failed to get monitors for clusterID %q: %w", conf.JournalClusterID, err)
+	}
+
+	secrets, err := readSecretPath(conf.JournalSecretPath)
+	if err != nil {
+		return err
+	}
+
+	var (
+		cr        *util.Credentials
+		namespace string
+		exists    journal.VolumeExistsFunc
+	)
+	if conf.JournalFsName == "" {
+		cr, err = util.NewUserCredentials(secrets)
+		if err != nil {
+			return err
+		}
+		defer cr.DeleteCredentials()
+
+		namespace, err = util.GetRadosNamespace(util.CsiConfigFile, conf.JournalClusterID)
+		if err != nil {
+			return err
+		}
+		exists = rbd.JournalVolumeExists(monitors, namespace, cr)
+	} else {
+		cr, err = util.NewAdminCredentials(secrets)
+		if err != nil {
+			return err
+		}
+		defer cr.DeleteCredentials()
+
+		namespace = fsutil.RadosNamespace
+		subvolumeGroup, sErr := util.CephFSSubvolumeGroup(util.CsiConfigFile, conf.JournalClusterID)
+		if sErr != nil {
+			return sErr
+		}
+
+		conn := &util.ClusterConnection{}
+		err = conn.Connect(monitors, cr)
+		if err != nil {
+			return err
+		}
+		defer conn.Destroy()
+		exists = core.JournalVolumeExists(conn, monitors, conf.JournalFsName, subvolumeGroup)
+	}
+
+	opts := journal.CheckOptions{
+		InstanceID: conf.InstanceID,
+		ScanPool:   conf.JournalScanPool,
+	}
+	if opts.InstanceID == "" {
+		opts.InstanceID = "default"
+	}
+
+	j, err := journal.NewCSIVolumeJournal(opts.InstanceID).Connect(monitors, namespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	report, err := j.CheckJournals(ctx, conf.JournalPool, opts, exists)
+	if err != nil {
+		return fmt.Errorf("failed to check the journals in pool %q: %w", conf.JournalPool, err)
+	}
+	printJournalReport(report)
+
+	if !conf.JournalRepair || len(report.Dangling) == 0 {
+		return nil
+	}
+
+	err = j.RepairJournals(ctx, report)
+	if err != nil {
+		return fmt.Errorf("failed to repair the journals in pool %q: %w", conf.JournalPool, err)
+	}
+	fmt.Printf("Removed %d dangling entries\n", len(report.Dangling))
+
+	return nil
+}
+
+// readSecretPath reads the keys of a secret mounted in the directory.
+func readSecretPath(dir string) (map[string]string, error) {
+	if dir == "" {
+		return nil, errors.New("journal-secret-path is required to connect to the cluster")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret path %q: %w", dir, err)
+	}
+
+	secrets := map[string]string{}
+	for _, f := range files {
+		// skip the hidden files and directories of a mounted Kubernetes secret
+		if strings.HasPrefix(f.Name(), ".") || f.IsDir() {
+			continue
+		}
+
+		value, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret key %q: %w", f.Name(), err)
+		}
+		secrets[f.Name()] = strings.TrimSpace(string(value))
+	}
+
+	return secrets, nil
+}
+
+func printJournalReport(report *journal.CheckReport) {
+	fmt.Printf("Pool: %s\n", report.Pool)
+	if report.Namespace != "" {
+		fmt.Printf("Namespace: %s\n", report.Namespace)
+	}
+	fmt.Printf("Journals: %s\n", strings.Join(report.Directories, ", "))
+	fmt.Printf("Entries: %d\n", report.Entries)
+	fmt.Printf("Dangling entries: %d\n", len(report.Dangling))
+
+	for _, e := range report.Dangling {
+		fmt.Printf("  - uuid=%s request=%q volume=%q pool=%s journal=%q: %s\n",
+			e.UUID, e.RequestName, e.VolumeName, e.Pool, e.Directory, e.Reason)
+	}
+}
+
+// runRestoreTrashedVolume restores the image of a deleted RBD volume from the
+// trash, and re-creates the journal of the volume.
+func runRestoreTrashedVolume(conf *util.Config) error {
+	if conf.RestorePool == "" {
+		return errors.New("restore-pool is required to restore a volume")
+	}
+
+	secrets, err := readSecretPath(conf.JournalSecretPath)
+	if err != nil {
+		return err
+	}
+	cr, err := util.NewUserCredentials(secrets)
+	if err != nil {
+		return err
+	}
+	defer cr.DeleteCredentials()
+
+	rbd.InitJournals(conf.InstanceID)
+	err = rbd.RestoreTrashedVolume(context.Background(), conf.RestoreVolumeID, conf.RestorePool,
+		conf.RestoreRadosNamespace, cr)
+	if err != nil {
+		return fmt.Errorf("failed to restore volume %q: %w", conf.RestoreVolumeID, err)
+	}
+	fmt.Printf("Restored volume %s\n", conf.RestoreVolumeID)
+
+	return nil
+}
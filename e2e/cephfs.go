@@ -21,14 +21,19 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util"
 
 	snapapi "github.com/kubernetes-csi/external-snapshotter/client/v6/apis/volumesnapshot/v1"
 	. "github.com/onsi/ginkgo/v2" // nolint
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/kubernetes/test/e2e/framework"
 	e2edebug "k8s.io/kubernetes/test/e2e/framework/debug"
+	e2epv "k8s.io/kubernetes/test/e2e/framework/pv"
 	"k8s.io/pod-security-admission/api"
 )
 
@@ -44,6 +49,10 @@ var (
 	cephFSExamplePath     = examplePath + "cephfs/"
 	subvolumegroup        = "e2e"
 	fileSystemName        = "myfs"
+
+	// cloneDelay is the delay in seconds before a clone starts, while
+	// the clone of a deleted volume is canceled
+	cloneDelay = 60
 )
 
 func deployCephfsPlugin() {
@@ -95,8 +104,9 @@ func createORDeleteCephfsResources(action kubectlAction) {
 		},
 		// the node-plugin itself
 		&yamlResourceNamespaced{
-			filename:  cephFSDirPath + cephFSNodePlugin,
-			namespace: cephCSINamespace,
+			filename:          cephFSDirPath + cephFSNodePlugin,
+			namespace:         cephCSINamespace,
+			enableAutoRemount: true,
 		},
 	}
 
@@ -935,6 +945,201 @@ var _ = Describe(cephfsType, func() {
 				}
 			})
 
+			By("validate kernel mount options from the cluster configuration", func() {
+				err := deleteResource(cephFSExamplePath + "storageclass.yaml")
+				if err != nil {
+					framework.Failf("failed to delete storageclass: %v", err)
+				}
+
+				// re-define configmap with kernel mount options for the cluster.
+				clusterID := "clusterID-1"
+				mountOption := "recover_session=clean"
+				clusterInfo := map[string]map[string]string{
+					clusterID: {
+						"subvolumeGroup":     "subvolgrp1",
+						"kernelMountOptions": mountOption,
+					},
+				}
+				err = createCustomConfigMap(f.ClientSet, cephFSDirPath, clusterInfo)
+				if err != nil {
+					framework.Failf("failed to create configmap: %v", err)
+				}
+				params := map[string]string{
+					"clusterID": clusterID,
+					"mounter":   "kernel",
+				}
+				err = createCephfsStorageClass(f.ClientSet, f, false, params)
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+
+				pvc, err := loadPVC(pvcPath)
+				if err != nil {
+					framework.Failf("failed to load PVC: %v", err)
+				}
+				pvc.Namespace = f.UniqueName
+				app, err := loadApp(appPath)
+				if err != nil {
+					framework.Failf("failed to load application: %v", err)
+				}
+				app.Namespace = f.UniqueName
+				app.Labels = map[string]string{"app": app.Name}
+				err = createPVCAndApp("", f, pvc, app, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to create PVC or application: %v", err)
+				}
+
+				opt := metav1.ListOptions{
+					LabelSelector: fmt.Sprintf("app=%s", app.Name),
+				}
+				mountPath := app.Spec.Containers[0].VolumeMounts[0].MountPath
+				stdOut, stdErr, err := execCommandInPod(
+					f,
+					fmt.Sprintf("grep ' %s ' /proc/mounts", mountPath),
+					app.Namespace,
+					&opt)
+				if err != nil || stdErr != "" {
+					framework.Failf("failed to read mounts: %v (%s)", err, stdErr)
+				}
+				if !strings.Contains(stdOut, mountOption) {
+					framework.Failf("mount option %q not found in %q", mountOption, stdOut)
+				}
+
+				err = deletePVCAndApp("", f, pvc, app)
+				if err != nil {
+					framework.Failf("failed to delete PVC or application: %v", err)
+				}
+				err = deleteResource(cephFSExamplePath + "storageclass.yaml")
+				if err != nil {
+					framework.Failf("failed to delete storageclass: %v", err)
+				}
+				err = deleteConfigMap(cephFSDirPath)
+				if err != nil {
+					framework.Failf("failed to delete configmap: %v", err)
+				}
+				err = createConfigMap(cephFSDirPath, f.ClientSet, f)
+				if err != nil {
+					framework.Failf("failed to create configmap: %v", err)
+				}
+				err = createCephfsStorageClass(f.ClientSet, f, false, nil)
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+			})
+
+			By("recover a kernel mount after the client was blocklisted", func() {
+				err := deleteResource(cephFSExamplePath + "storageclass.yaml")
+				if err != nil {
+					framework.Failf("failed to delete storageclass: %v", err)
+				}
+
+				// the volumes of the cluster are mounted with
+				// recover_session=clean, which --cephfs-auto-remount needs
+				clusterID := "clusterID-1"
+				clusterInfo := map[string]map[string]string{
+					clusterID: {
+						"subvolumeGroup":     "subvolgrp1",
+						"kernelMountOptions": "recover_session=clean",
+					},
+				}
+				err = createCustomConfigMap(f.ClientSet, cephFSDirPath, clusterInfo)
+				if err != nil {
+					framework.Failf("failed to create configmap: %v", err)
+				}
+				err = createCephfsStorageClass(f.ClientSet, f, false, map[string]string{
+					"clusterID": clusterID,
+					"mounter":   "kernel",
+				})
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+
+				replicas := int32(1)
+				pvc, depl, err := validatePVCAndDeploymentAppBinding(
+					f, pvcPath, deplPath, f.UniqueName, &replicas, deployTimeout,
+				)
+				if err != nil {
+					framework.Failf("failed to create PVC and Deployment: %v", err)
+				}
+				listOpt := &metav1.ListOptions{
+					LabelSelector: fmt.Sprintf("app=%s", depl.Labels["app"]),
+				}
+				mountPath := depl.Spec.Template.Spec.Containers[0].VolumeMounts[0].MountPath
+				filePath := mountPath + "/remount-test"
+				_, stdErr, err := execCommandInPod(f, fmt.Sprintf("echo remount > %s && sync", filePath),
+					depl.Namespace, listOpt)
+				if err != nil || stdErr != "" {
+					framework.Failf("failed to write to %s: %v (%s)", filePath, err, stdErr)
+				}
+
+				// evicting the kernel client of the volume blocklists it,
+				// which makes its mount stale
+				_, pv, err := getPVCAndPV(f.ClientSet, pvc.Name, pvc.Namespace)
+				if err != nil {
+					framework.Failf("failed to get PVC and PV: %v", err)
+				}
+				subvolumePath := pv.Spec.CSI.VolumeAttributes["subvolumePath"]
+				_, stdErr, err = execCommandInToolBoxPod(f,
+					fmt.Sprintf("ceph tell mds.%s:0 client evict client_metadata.root=%s", fileSystemName, subvolumePath),
+					rookNamespace)
+				if err != nil || stdErr != "" {
+					framework.Failf("failed to evict the client of %s: %v (%s)", subvolumePath, err, stdErr)
+				}
+
+				// running containers keep the stale mount, a new pod uses
+				// the remounted volume
+				deplPods, err := listPods(f, depl.Namespace, listOpt)
+				if err != nil {
+					framework.Failf("failed to list pods for Deployment: %v", err)
+				}
+				err = deletePod(deplPods[0].Name, depl.Namespace, c, deployTimeout)
+				if err != nil {
+					framework.Failf(err.Error())
+				}
+				err = waitForDeploymentComplete(c, depl.Name, depl.Namespace, deployTimeout)
+				if err != nil {
+					framework.Failf(err.Error())
+				}
+
+				// the kubelet calls NodeGetVolumeStats periodically, which
+				// remounts the stale volume
+				timeout := time.Duration(deployTimeout) * time.Minute
+				err = wait.PollImmediate(poll, timeout, func() (bool, error) {
+					stdOut, stdErr, execErr := execCommandInPod(f, "cat "+filePath, depl.Namespace, listOpt)
+					if execErr != nil || stdErr != "" {
+						framework.Logf("volume is not recovered yet: %v (%s)", execErr, stdErr)
+
+						return false, nil
+					}
+
+					return strings.TrimSpace(stdOut) == "remount", nil
+				})
+				if err != nil {
+					framework.Failf("volume was not recovered after the client was blocklisted: %v", err)
+				}
+
+				err = deletePVCAndDeploymentApp(f, pvc, depl)
+				if err != nil {
+					framework.Failf("failed to delete PVC and Deployment: %v", err)
+				}
+				err = deleteResource(cephFSExamplePath + "storageclass.yaml")
+				if err != nil {
+					framework.Failf("failed to delete storageclass: %v", err)
+				}
+				err = deleteConfigMap(cephFSDirPath)
+				if err != nil {
+					framework.Failf("failed to delete configmap: %v", err)
+				}
+				err = createConfigMap(cephFSDirPath, f.ClientSet, f)
+				if err != nil {
+					framework.Failf("failed to create configmap: %v", err)
+				}
+				err = createCephfsStorageClass(f.ClientSet, f, false, nil)
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+			})
+
 			By("Resize PVC and check application directory size", func() {
 				err := resizePVCAndValidateSize(pvcPath, appPath, f)
 				if err != nil {
@@ -1156,6 +1361,182 @@ var _ = Describe(cephfsType, func() {
 				validateOmapCount(f, 0, cephfsType, metadataPool, volumesType)
 			})
 
+			By("delete a PVC while its clone is in progress", func() {
+				// delay the start of clones, so that the clone is still in
+				// progress when its volume is deleted
+				_, stdErr, err := execCommandInToolBoxPod(f,
+					fmt.Sprintf("ceph config set mgr mgr/volumes/snapshot_clone_delay %d", cloneDelay),
+					rookNamespace)
+				if err != nil || stdErr != "" {
+					framework.Failf("failed to set the clone delay: %v (%s)", err, stdErr)
+				}
+				resetCloneDelay := func() {
+					_, rmStdErr, rmErr := execCommandInToolBoxPod(f,
+						"ceph config rm mgr mgr/volumes/snapshot_clone_delay",
+						rookNamespace)
+					if rmErr != nil || rmStdErr != "" {
+						framework.Logf("failed to reset the clone delay: %v (%s)", rmErr, rmStdErr)
+					}
+				}
+				// do not delay the clones of the next tests, also when this
+				// test fails
+				defer resetCloneDelay()
+
+				pvc, err := loadPVC(pvcPath)
+				if err != nil {
+					framework.Failf("failed to load PVC: %v", err)
+				}
+				pvc.Namespace = f.UniqueName
+				err = createPVCAndvalidatePV(f.ClientSet, pvc, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to create PVC: %v", err)
+				}
+				pv, err := getBoundPV(f.ClientSet, pvc)
+				if err != nil {
+					framework.Failf("failed to get PV: %v", err)
+				}
+				parentSubVol := pv.Spec.CSI.VolumeAttributes["subvolumeName"]
+
+				pvcClone, err := loadPVC(pvcSmartClonePath)
+				if err != nil {
+					framework.Failf("failed to load PVC: %v", err)
+				}
+				pvcClone.Namespace = f.UniqueName
+				pvcClone.Spec.DataSource.Name = pvc.Name
+				// the clone is not bound until the clone completed
+				err = createPVCAndvalidatePV(f.ClientSet, pvcClone, 0)
+				if err != nil {
+					framework.Failf("failed to create pvc clone: %v", err)
+				}
+
+				// wait for the subvolume of the clone
+				var cloneSubVol string
+				timeout := time.Duration(deployTimeout) * time.Minute
+				err = wait.PollImmediate(poll, timeout, func() (bool, error) {
+					subVols, lErr := listCephFSSubVolumes(f, fileSystemName, subvolumegroup)
+					if lErr != nil {
+						framework.Logf("failed to list CephFS subvolumes: %v", lErr)
+
+						return false, nil
+					}
+					for _, subVol := range subVols {
+						if subVol.Name != parentSubVol {
+							cloneSubVol = subVol.Name
+						}
+					}
+
+					return cloneSubVol != "", nil
+				})
+				if err != nil {
+					framework.Failf("subvolume of the clone was not created: %v", err)
+				}
+
+				// the PV of a clone is only created once the clone completed,
+				// create a PV for the volume of the clone that is in progress,
+				// the volume ID only differs from the volume handle of the
+				// parent PV in the UUID
+				var volID util.CSIIdentifier
+				err = volID.DecomposeCSIID(pv.Spec.CSI.VolumeHandle)
+				if err != nil {
+					framework.Failf("failed to decompose volume handle %s: %v", pv.Spec.CSI.VolumeHandle, err)
+				}
+				volID.ObjectUUID = strings.TrimPrefix(cloneSubVol, strings.TrimSuffix(parentSubVol, volID.ObjectUUID))
+				cloneVolID, err := volID.ComposeCSIID()
+				if err != nil {
+					framework.Failf("failed to compose volume ID of clone %s: %v", cloneSubVol, err)
+				}
+				pvName := "pv-clone-in-progress"
+				clonePV := getStaticPV(
+					pvName,
+					cloneVolID,
+					pvc.Spec.Resources.Requests.Storage().String(),
+					cephFSNodePluginSecretName,
+					cephCSINamespace,
+					*pvc.Spec.StorageClassName,
+					"cephfs.csi.ceph.com",
+					false,
+					pv.Spec.CSI.VolumeAttributes,
+					map[string]string{
+						"pv.kubernetes.io/provisioned-by":                            "cephfs.csi.ceph.com",
+						"volume.kubernetes.io/provisioner-deletion-secret-name":      cephFSProvisionerSecretName,
+						"volume.kubernetes.io/provisioner-deletion-secret-namespace": cephCSINamespace,
+					},
+					v1.PersistentVolumeReclaimDelete)
+				staticPVC := getStaticPVC(
+					"pvc-clone-in-progress",
+					pvName,
+					pvc.Spec.Resources.Requests.Storage().String(),
+					f.UniqueName,
+					*pvc.Spec.StorageClassName,
+					false)
+				err = createPVCAndPV(f.ClientSet, staticPVC, clonePV)
+				if err != nil {
+					framework.Failf("failed to create PV and PVC of the clone: %v", err)
+				}
+				err = e2epv.WaitOnPVandPVC(
+					f.ClientSet,
+					&framework.TimeoutContext{ClaimBound: timeout, PVBound: timeout},
+					f.UniqueName,
+					clonePV,
+					staticPVC)
+				if err != nil {
+					framework.Failf("failed to bind PV and PVC of the clone: %v", err)
+				}
+
+				// the clone should still be in progress when the volume is
+				// deleted, otherwise the test does not cancel it
+				state, err := getCephFSCloneState(f, fileSystemName, subvolumegroup, cloneSubVol)
+				if err != nil {
+					framework.Failf("failed to get the state of clone %s: %v", cloneSubVol, err)
+				}
+				if state != "pending" && state != "in-progress" {
+					framework.Failf("clone %s is %q, expected it to be in progress", cloneSubVol, state)
+				}
+
+				err = deletePVCAndValidatePV(f.ClientSet, staticPVC, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to delete PVC of the clone: %v", err)
+				}
+
+				// the partially cloned subvolume should be removed
+				subVols, err := listCephFSSubVolumes(f, fileSystemName, subvolumegroup)
+				if err != nil {
+					framework.Failf("failed to list CephFS subvolumes: %v", err)
+				}
+				for _, subVol := range subVols {
+					if subVol.Name == cloneSubVol {
+						framework.Failf("subvolume %s of the deleted clone was not removed", cloneSubVol)
+					}
+				}
+
+				// the provisioner retries to create the clone PVC until it
+				// is deleted, the retried clone is not delayed anymore
+				resetCloneDelay()
+				err = wait.PollImmediate(poll, timeout, func() (bool, error) {
+					bound, gErr := getPersistentVolumeClaim(f.ClientSet, pvcClone.Namespace, pvcClone.Name)
+					if gErr != nil {
+						framework.Logf("failed to get pvc clone: %v", gErr)
+
+						return false, nil
+					}
+
+					return bound.Status.Phase == v1.ClaimBound, nil
+				})
+				if err != nil {
+					framework.Failf("pvc clone was not bound: %v", err)
+				}
+				err = deletePVCAndValidatePV(f.ClientSet, pvcClone, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to delete pvc clone: %v", err)
+				}
+				err = deletePVCAndValidatePV(f.ClientSet, pvc, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to delete PVC: %v", err)
+				}
+				validateSubvolumeCount(f, 0, fileSystemName, subvolumegroup)
+				validateOmapCount(f, 0, cephfsType, metadataPool, volumesType)
+			})
+
 			By("Delete snapshot after deleting subvolume and snapshot from backend", func() {
 				err := createCephFSSnapshotClass(f)
 				if err != nil {
@@ -211,6 +211,33 @@ func listCephFSSubVolumes(f *framework.Framework, filesystem, groupname string)
 	return subVols, nil
 }
 
+// getCephFSCloneState returns the state of the clone, like "pending",
+// "in-progress" or "complete".
+func getCephFSCloneState(f *framework.Framework, filesystem, groupname, clone string) (string, error) {
+	stdout, stdErr, err := execCommandInToolBoxPod(
+		f,
+		fmt.Sprintf("ceph fs clone status %s %s --group_name=%s --format=json", filesystem, clone, groupname),
+		rookNamespace)
+	if err != nil {
+		return "", err
+	}
+	if stdErr != "" {
+		return "", fmt.Errorf("error getting clone status %v", stdErr)
+	}
+
+	var cloneStatus struct {
+		Status struct {
+			State string `json:"state"`
+		} `json:"status"`
+	}
+	err = json.Unmarshal([]byte(stdout), &cloneStatus)
+	if err != nil {
+		return "", err
+	}
+
+	return cloneStatus.Status.State, nil
+}
+
 type cephfsSubvolumeMetadata struct {
 	PVCNameKey      string `json:"csi.storage.k8s.io/pvc/name"`
 	PVCNamespaceKey string `json:"csi.storage.k8s.io/pvc/namespace"`
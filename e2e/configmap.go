@@ -120,7 +120,7 @@ func createCustomConfigMap(
 		conmap[i].Monitors = mons
 	}
 
-	// fill radosNamespace and subvolgroups
+	// fill radosNamespace, subvolgroups and mount options
 	for cluster, confItems := range clusterInfo {
 		for i, j := range confItems {
 			switch i {
@@ -136,6 +136,12 @@ func createCustomConfigMap(
 						conmap[c].RBD.RadosNamespace = j
 					}
 				}
+			case "kernelMountOptions":
+				for c := range conmap {
+					if conmap[c].ClusterID == cluster {
+						conmap[c].CephFS.KernelMountOptions = j
+					}
+				}
 			}
 		}
 	}
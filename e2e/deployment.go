@@ -240,6 +240,9 @@ type yamlResourceNamespaced struct {
 	// enable topology support (for RBD)
 	enableTopology bool
 	domainLabel    string
+
+	// enable the remount of stale kernel mounts (for CephFS)
+	enableAutoRemount bool
 }
 
 func (yrn *yamlResourceNamespaced) Do(action kubectlAction) error {
@@ -260,6 +263,10 @@ func (yrn *yamlResourceNamespaced) Do(action kubectlAction) error {
 		data = addTopologyDomainsToDSYaml(data, yrn.domainLabel)
 	}
 
+	if yrn.enableAutoRemount {
+		data = enableAutoRemountInTemplate(data)
+	}
+
 	err = retryKubectlInput(yrn.namespace, action, data, deployTimeout)
 	if err != nil {
 		return fmt.Errorf("failed to %s resource %q in namespace %q: %w", action, yrn.filename, yrn.namespace, err)
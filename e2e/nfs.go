@@ -585,6 +585,13 @@ var _ = Describe("nfs", func() {
 				}
 			})
 
+			By("Resize PVC while the application is writing to it", func() {
+				err := resizePVCWhileWriting(pvcPath, appPath, f)
+				if err != nil {
+					framework.Failf("failed to resize PVC while writing: %v", err)
+				}
+			})
+
 			By("create a PVC clone and bind it to an app", func() {
 				var wg sync.WaitGroup
 				totalCount := 3
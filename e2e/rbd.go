@@ -1783,6 +1783,129 @@ var _ = Describe("RBD", func() {
 				}
 			})
 
+			By("Create encrypted ROX+Block Mode PVC and bind to multiple pods via deployment", func() {
+				err := deleteResource(rbdExamplePath + "storageclass.yaml")
+				if err != nil {
+					framework.Failf("failed to delete storageclass: %v", err)
+				}
+				err = createRBDStorageClass(
+					f.ClientSet,
+					f,
+					defaultSCName,
+					nil,
+					map[string]string{"encrypted": "true"},
+					deletePolicy)
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+
+				// create PVC and bind it to an app, so that the device is
+				// encrypted before it is cloned
+				pvc, err := loadPVC(rawPvcPath)
+				if err != nil {
+					framework.Failf("failed to load PVC: %v", err)
+				}
+				pvc.Namespace = f.UniqueName
+				app, err := loadApp(rawAppPath)
+				if err != nil {
+					framework.Failf("failed to load application: %v", err)
+				}
+				app.Namespace = f.UniqueName
+				err = createPVCAndApp("", f, pvc, app, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to create PVC and application: %v", err)
+				}
+				// validate created backend rbd images
+				validateRBDImageCount(f, 1, defaultRBDPool)
+				validateOmapCount(f, 1, rbdType, defaultRBDPool, volumesType)
+				err = deletePod(app.Name, app.Namespace, f.ClientSet, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to delete application: %v", err)
+				}
+
+				// create clone PVC as ROX, the image is mapped and the
+				// encrypted device is opened read-only
+				pvcClone, err := loadPVC(pvcBlockSmartClonePath)
+				if err != nil {
+					framework.Failf("failed to load PVC: %v", err)
+				}
+				pvcClone.Spec.DataSource.Name = pvc.Name
+				pvcClone.Namespace = f.UniqueName
+				pvcClone.Spec.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany}
+				volumeMode := v1.PersistentVolumeBlock
+				pvcClone.Spec.VolumeMode = &volumeMode
+				appClone, err := loadAppDeployment(deployBlockAppPath)
+				if err != nil {
+					framework.Failf("failed to load application deployment: %v", err)
+				}
+				appClone.Namespace = f.UniqueName
+				appClone.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName = pvcClone.Name
+				appClone.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ReadOnly = true
+				err = createPVCAndDeploymentApp(f, pvcClone, appClone, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to create PVC and application: %v", err)
+				}
+
+				err = waitForDeploymentComplete(f.ClientSet, appClone.Name, appClone.Namespace, deployTimeout)
+				if err != nil {
+					framework.Failf("timeout waiting for deployment to be in running state: %v", err)
+				}
+
+				// validate created backend rbd images
+				validateRBDImageCount(f, 3, defaultRBDPool)
+				validateOmapCount(f, 2, rbdType, defaultRBDPool, volumesType)
+
+				devPath := appClone.Spec.Template.Spec.Containers[0].VolumeDevices[0].DevicePath
+				cmd := fmt.Sprintf("dd if=/dev/zero of=%s bs=1M count=10", devPath)
+
+				opt := metav1.ListOptions{
+					LabelSelector: fmt.Sprintf("app=%s", appClone.Name),
+				}
+				podList, err := e2epod.PodClientNS(f, appClone.Namespace).List(context.TODO(), opt)
+				if err != nil {
+					framework.Failf("get pod list failed: %v", err)
+				}
+				if len(podList.Items) != int(*appClone.Spec.Replicas) {
+					framework.Failf("podlist contains %d items, expected %d items", len(podList.Items), *appClone.Spec.Replicas)
+				}
+				for _, pod := range podList.Items {
+					var stdErr string
+					_, stdErr, err = execCommandInPodWithName(f, cmd, pod.Name, pod.Spec.Containers[0].Name, appClone.Namespace)
+					if err != nil {
+						framework.Logf("command %q failed: %v", cmd, err)
+					}
+					readOnlyErr := fmt.Sprintf("'%s': Operation not permitted", devPath)
+					if !strings.Contains(stdErr, readOnlyErr) {
+						framework.Failf(stdErr)
+					}
+				}
+				err = deletePVCAndDeploymentApp(f, pvcClone, appClone)
+				if err != nil {
+					framework.Failf("failed to delete PVC and application: %v", err)
+				}
+				// delete parent pvc
+				err = deletePVCAndValidatePV(f.ClientSet, pvc, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to delete PVC: %v", err)
+				}
+				// validate created backend rbd images
+				validateRBDImageCount(f, 0, defaultRBDPool)
+				validateOmapCount(f, 0, rbdType, defaultRBDPool, volumesType)
+				// validate images in trash
+				err = waitToRemoveImagesFromTrash(f, defaultRBDPool, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to validate rbd images in pool %s trash: %v", rbdOptions(defaultRBDPool), err)
+				}
+				err = deleteResource(rbdExamplePath + "storageclass.yaml")
+				if err != nil {
+					framework.Failf("failed to delete storageclass: %v", err)
+				}
+				err = createRBDStorageClass(f.ClientSet, f, defaultSCName, nil, nil, deletePolicy)
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+			})
+
 			By("perform IO on rbd-nbd volume after nodeplugin restart", func() {
 				if !testNBD {
 					framework.Logf("skipping NBD test")
@@ -2755,6 +2878,45 @@ var _ = Describe("RBD", func() {
 				validateOmapCount(f, 0, rbdType, defaultRBDPool, volumesType)
 			})
 
+			By("Resize btrfs Filesystem PVC and check application directory size", func() {
+				if !testRBDBtrfs {
+					framework.Logf("skipping RBD btrfs test")
+
+					return
+				}
+				for _, encrypted := range []string{"false", "true"} {
+					err := deleteResource(rbdExamplePath + "storageclass.yaml")
+					if err != nil {
+						framework.Failf("failed to delete storageclass: %v", err)
+					}
+					err = createRBDStorageClass(
+						f.ClientSet,
+						f,
+						defaultSCName,
+						nil,
+						map[string]string{"csi.storage.k8s.io/fstype": "btrfs", "encrypted": encrypted},
+						deletePolicy)
+					if err != nil {
+						framework.Failf("failed to create storageclass: %v", err)
+					}
+					err = resizePVCAndValidateSize(pvcPath, appPath, f)
+					if err != nil {
+						framework.Failf("failed to resize btrfs PVC (encrypted: %s): %v", encrypted, err)
+					}
+					// validate created backend rbd images
+					validateRBDImageCount(f, 0, defaultRBDPool)
+					validateOmapCount(f, 0, rbdType, defaultRBDPool, volumesType)
+				}
+				err := deleteResource(rbdExamplePath + "storageclass.yaml")
+				if err != nil {
+					framework.Failf("failed to delete storageclass: %v", err)
+				}
+				err = createRBDStorageClass(f.ClientSet, f, defaultSCName, nil, nil, deletePolicy)
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+			})
+
 			By("Resize Block PVC and check Device size", func() {
 				err := resizePVCAndValidateSize(rawPvcPath, rawAppPath, f)
 				if err != nil {
@@ -4401,6 +4563,102 @@ var _ = Describe("RBD", func() {
 				validateOmapCount(f, 0, rbdType, defaultRBDPool, volumesType)
 			})
 
+			By("validate rbd image stripe of a PVC restored with a different striping", func() {
+				stripeUnit := 65536
+				stripeCount := 4
+				// the restored image gets the object size of its parent
+				objectSize := 4194304
+
+				err := createRBDSnapshotClass(f)
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+				defer func() {
+					err = deleteRBDSnapshotClass()
+					if err != nil {
+						framework.Failf("failed to delete VolumeSnapshotClass: %v", err)
+					}
+				}()
+
+				// create the parent PVC without striping
+				pvc, err := loadPVC(pvcPath)
+				if err != nil {
+					framework.Failf("failed to load PVC: %v", err)
+				}
+				pvc.Namespace = f.UniqueName
+				err = createPVCAndvalidatePV(f.ClientSet, pvc, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to create PVC: %v", err)
+				}
+
+				snap := getSnapshot(snapshotPath)
+				snap.Namespace = f.UniqueName
+				snap.Spec.Source.PersistentVolumeClaimName = &pvc.Name
+				err = createSnapshot(&snap, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to create snapshot: %v", err)
+				}
+
+				// restore the snapshot with a striped StorageClass
+				err = deleteResource(rbdExamplePath + "storageclass.yaml")
+				if err != nil {
+					framework.Failf("failed to delete storageclass: %v", err)
+				}
+				err = createRBDStorageClass(
+					f.ClientSet,
+					f,
+					defaultSCName,
+					nil,
+					map[string]string{
+						"stripeUnit":  fmt.Sprintf("%d", stripeUnit),
+						"stripeCount": fmt.Sprintf("%d", stripeCount),
+					},
+					deletePolicy)
+				if err != nil {
+					framework.Failf("failed to create storageclass: %v", err)
+				}
+				defer func() {
+					err = deleteResource(rbdExamplePath + "storageclass.yaml")
+					if err != nil {
+						framework.Failf("failed to delete storageclass: %v", err)
+					}
+					err = createRBDStorageClass(f.ClientSet, f, defaultSCName, nil, nil, deletePolicy)
+					if err != nil {
+						framework.Failf("failed to create storageclass: %v", err)
+					}
+				}()
+
+				pvcClone, err := loadPVC(pvcClonePath)
+				if err != nil {
+					framework.Failf("failed to load PVC: %v", err)
+				}
+				pvcClone.Namespace = f.UniqueName
+				err = createPVCAndvalidatePV(f.ClientSet, pvcClone, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to create PVC: %v", err)
+				}
+				err = validateStripe(f, pvcClone, stripeUnit, stripeCount, objectSize)
+				if err != nil {
+					framework.Failf("failed to validate stripe for restored PVC: %v", err)
+				}
+
+				err = deleteSnapshot(&snap, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to delete snapshot: %v", err)
+				}
+				err = deletePVCAndValidatePV(f.ClientSet, pvcClone, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to delete PVC: %v", err)
+				}
+				err = deletePVCAndValidatePV(f.ClientSet, pvc, deployTimeout)
+				if err != nil {
+					framework.Failf("failed to delete PVC: %v", err)
+				}
+				// validate created backend rbd images
+				validateRBDImageCount(f, 0, defaultRBDPool)
+				validateOmapCount(f, 0, rbdType, defaultRBDPool, volumesType)
+			})
+
 			By("create a PVC and check PVC/PV metadata on RBD image after setmetadata is set to false", func() {
 				err := createRBDSnapshotClass(f)
 				if err != nil {
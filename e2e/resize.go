@@ -215,3 +215,67 @@ func checkAppMntSize(f *framework.Framework, opt *metav1.ListOptions, size, cmd,
 		return true, nil
 	})
 }
+
+// resizePVCWhileWriting expands a filesystem PVC while the application keeps
+// writing to it, and verifies that none of the writes failed.
+func resizePVCWhileWriting(pvcPath, appPath string, f *framework.Framework) error {
+	size := "1Gi"
+	expandSize := "10Gi"
+	pvc, err := loadPVC(pvcPath)
+	if err != nil {
+		return err
+	}
+	pvc.Namespace = f.UniqueName
+	pvc.Spec.Resources.Requests[v1.ResourceStorage] = resource.MustParse(size)
+
+	app, err := loadApp(appPath)
+	if err != nil {
+		return err
+	}
+	app.Labels = map[string]string{"app": "resize-write-pvc"}
+	app.Namespace = f.UniqueName
+
+	err = createPVCAndApp("", f, pvc, app, deployTimeout)
+	if err != nil {
+		return err
+	}
+
+	opt := metav1.ListOptions{
+		LabelSelector: "app=resize-write-pvc",
+	}
+	mountPath := app.Spec.Containers[0].VolumeMounts[0].MountPath
+	errFile := mountPath + "/write-errors"
+	// keep rewriting a file in the background, failed writes are logged
+	writeCmd := fmt.Sprintf("touch %[2]s && (while true; do "+
+		"dd if=/dev/zero of=%[1]s/data bs=1M count=64 conv=fsync 2>/dev/null || echo failed >> %[2]s; "+
+		"done) > /dev/null 2>&1 &", mountPath, errFile)
+	_, stdErr, err := execCommandInPod(f, writeCmd, app.Namespace, &opt)
+	if err != nil {
+		return fmt.Errorf("failed to start writing to %s: %w", mountPath, err)
+	}
+	if stdErr != "" {
+		return fmt.Errorf("failed to start writing to %s: %s", mountPath, stdErr)
+	}
+
+	err = expandPVCSize(f.ClientSet, pvc, expandSize, deployTimeout)
+	if err != nil {
+		return err
+	}
+	err = checkDirSize(app, f, &opt, expandSize)
+	if err != nil {
+		return err
+	}
+
+	failures, stdErr, err := execCommandInPod(f, "grep -c failed "+errFile+" || true", app.Namespace, &opt)
+	if err != nil {
+		return fmt.Errorf("failed to check write errors: %w", err)
+	}
+	if stdErr != "" {
+		return fmt.Errorf("failed to check write errors: %s", stdErr)
+	}
+	if count := strings.TrimSpace(failures); count != "0" {
+		return fmt.Errorf("%s writes failed while resizing PVC %s", count, pvc.Name)
+	}
+
+	return deletePVCAndApp("", f, pvc, app)
+}
@@ -87,6 +87,7 @@ var (
 	testCephFSFscrypt bool
 	testRBD           bool
 	testRBDFSCrypt    bool
+	testRBDBtrfs      bool
 	testNBD           bool
 	testNFS           bool
 	helmTest          bool
@@ -827,6 +828,12 @@ func enableTopologyInTemplate(data string) string {
 	return strings.ReplaceAll(data, "--feature-gates=Topology=false", "--feature-gates=Topology=true")
 }
 
+func enableAutoRemountInTemplate(data string) string {
+	re := regexp.MustCompile(`(\n(\s+)- "--nodeserver=true")`)
+
+	return re.ReplaceAllString(data, `$1`+"\n"+`$2- "--cephfs-auto-remount=true"`)
+}
+
 func writeDataAndCalChecksum(app *v1.Pod, opt *metav1.ListOptions, f *framework.Framework) (string, error) {
 	filePath := app.Spec.Containers[0].VolumeMounts[0].MountPath + "/test"
 	// write data in PVC
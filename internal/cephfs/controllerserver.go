@@ -340,6 +340,13 @@ func (cs *ControllerServer) CreateVolume(
 			if err != nil {
 				return nil, status.Error(codes.Internal, err.Error())
 			}
+
+			if volOptions.Earmark != "" {
+				err = volClient.SetEarmark(ctx, volOptions.Earmark)
+				if err != nil {
+					return nil, status.Error(codes.Internal, err.Error())
+				}
+			}
 		}
 
 		// remove kubernetes csi prefixed parameters.
@@ -429,6 +436,18 @@ func (cs *ControllerServer) CreateVolume(
 
 			return nil, status.Error(codes.Internal, err.Error())
 		}
+
+		if volOptions.Earmark != "" {
+			err = volClient.SetEarmark(ctx, volOptions.Earmark)
+			if err != nil {
+				purgeErr := volClient.PurgeVolume(ctx, true)
+				if purgeErr != nil {
+					log.ErrorLog(ctx, "failed to delete volume %s: %v", vID.FsSubvolName, purgeErr)
+				}
+
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
 	}
 
 	log.DebugLog(ctx, "cephfs: successfully created backing volume named %s for request name %s",
@@ -504,6 +523,21 @@ func (cs *ControllerServer) DeleteVolume(
 
 		log.ErrorLog(ctx, "Error returned from newVolumeOptionsFromVolID: %v", err)
 
+		// the volume is still being cloned, cancel the clone and remove the
+		// partially cloned subvolume
+		if cerrors.IsCloneRetryError(err) {
+			if acquired := cs.VolumeLocks.TryAcquire(volOptions.RequestName); !acquired {
+				return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volOptions.RequestName)
+			}
+			defer cs.VolumeLocks.Release(volOptions.RequestName)
+
+			if err = cs.cancelCloneAndUndoReservation(ctx, volOptions, vID, secrets); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+
 		// All errors other than ErrVolumeNotFound should return an error back to the caller
 		if !errors.Is(err, cerrors.ErrVolumeNotFound) {
 			return nil, status.Error(codes.Internal, err.Error())
@@ -577,6 +611,7 @@ func (cs *ControllerServer) cleanUpBackingVolume(
 
 		volClient := core.NewSubVolume(volOptions.GetConnection(),
 			&volOptions.SubVolume, volOptions.ClusterID, cs.ClusterName, cs.SetMetadata)
+		logEarmark(ctx, volClient, volOptions.VolID)
 		if err := volClient.PurgeVolume(ctx, false); err != nil {
 			log.ErrorLog(ctx, "failed to delete volume %s: %v", volID, err)
 			if errors.Is(err, cerrors.ErrVolumeHasSnapshots) {
@@ -643,6 +678,69 @@ func (cs *ControllerServer) cleanUpBackingVolume(
 	return nil
 }
 
+// cancelCloneAndUndoReservation cancels the pending or in-progress clone of
+// the volume, removes the partially cloned subvolume and its reservation.
+func (cs *ControllerServer) cancelCloneAndUndoReservation(
+	ctx context.Context,
+	volOptions *store.VolumeOptions,
+	vID *store.VolumeIdentifier,
+	secrets map[string]string,
+) error {
+	cr, err := util.NewAdminCredentials(secrets)
+	if err != nil {
+		return err
+	}
+	defer cr.DeleteCredentials()
+
+	// the connection of volOptions has been released already when
+	// building the volume options failed
+	conn := &util.ClusterConnection{}
+	if err = conn.Connect(volOptions.Monitors, cr); err != nil {
+		return err
+	}
+	defer conn.Destroy()
+
+	volClient := core.NewSubVolume(conn, &volOptions.SubVolume, volOptions.ClusterID, cs.ClusterName, cs.SetMetadata)
+	cloneState, err := volClient.GetCloneState(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.DebugLog(ctx, "canceling clone of volume %s: %v", vID.FsSubvolName, cloneState.ToError())
+	if err = volClient.CancelClone(ctx); err != nil {
+		return err
+	}
+
+	err = volClient.PurgeVolume(ctx, true)
+	if err != nil && !errors.Is(err, cerrors.ErrVolumeNotFound) {
+		return err
+	}
+
+	// PVC-PVC clones use an intermediate snapshot of the parent volume
+	// that is named after the clone, it is not needed anymore
+	parentVol, snapName := cloneState.Source()
+	if snapName == vID.FsSubvolName {
+		err = volClient.CleanupSnapshotFromSubvolume(ctx, &parentVol)
+		if err != nil {
+			return err
+		}
+	}
+
+	return store.UndoVolReservation(ctx, volOptions, *vID, secrets)
+}
+
+// logEarmark logs the earmark of the subvolume for debugging purposes.
+func logEarmark(ctx context.Context, volClient core.SubVolumeClient, volID string) {
+	earmark, err := volClient.GetEarmark(ctx)
+	if err != nil {
+		log.DebugLog(ctx, "failed to get earmark of volume %s: %v", volID, err)
+
+		return
+	}
+
+	log.DebugLog(ctx, "volume %s has earmark %q", volID, earmark)
+}
+
 // ValidateVolumeCapabilities checks whether the volume capabilities requested
 // are supported.
 func (cs *ControllerServer) ValidateVolumeCapabilities(
@@ -722,6 +820,13 @@ func (cs *ControllerServer) ControllerExpandVolume(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// subvolumes created before metadata support was enabled do not carry
+	// the cluster name, back-fill it now that the subvolume is accessed.
+	if err = volClient.SetAllMetadata(nil); err != nil {
+		log.WarningLog(ctx, "failed to set metadata on volume %s: %v",
+			fsutil.VolumeID(volIdentifier.FsSubvolName), err)
+	}
+
 	return &csi.ControllerExpandVolumeResponse{
 		CapacityBytes:         RoundOffSize,
 		NodeExpansionRequired: false,
@@ -844,6 +949,12 @@ func (cs *ControllerServer) CreateSnapshot(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// back-fill the cluster name on parent subvolumes that were created
+	// before metadata support was enabled.
+	if err = volClient.SetAllMetadata(nil); err != nil {
+		log.WarningLog(ctx, "failed to set metadata on volume %s: %v", sourceVolID, err)
+	}
+
 	metadata := k8s.GetSnapshotMetadata(req.GetParameters())
 	if sid != nil {
 		// check snapshot is protected
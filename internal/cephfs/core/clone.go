@@ -18,6 +18,7 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -32,6 +33,9 @@ type cephFSCloneState struct {
 	state    admin.CloneState
 	errno    string
 	errorMsg string
+	// progress of an in-progress clone, empty when not reported by Ceph.
+	progress string
+	source   admin.CloneSource
 }
 
 const (
@@ -50,6 +54,10 @@ func (cs cephFSCloneState) ToError() error {
 	case CephFSCloneError.state:
 		return fmt.Errorf("%w: %s (%s)", cerrors.ErrInvalidClone, cs.errorMsg, cs.errno)
 	case admin.CloneInProgress:
+		if cs.progress != "" {
+			return fmt.Errorf("%w: %s cloned", cerrors.ErrCloneInProgress, cs.progress)
+		}
+
 		return cerrors.ErrCloneInProgress
 	case admin.ClonePending:
 		return cerrors.ErrClonePending
@@ -60,6 +68,15 @@ func (cs cephFSCloneState) ToError() error {
 	return nil
 }
 
+// Source returns the subvolume and the name of the snapshot that is cloned.
+func (cs cephFSCloneState) Source() (SubVolume, string) {
+	return SubVolume{
+		VolID:          cs.source.SubVolume,
+		FsName:         cs.source.Volume,
+		SubvolumeGroup: cs.source.Group,
+	}, cs.source.Snapshot
+}
+
 // CreateCloneFromSubvolume creates a clone from a subvolume.
 func (s *subVolumeClient) CreateCloneFromSubvolume(
 	ctx context.Context,
@@ -267,7 +284,107 @@ func (s *subVolumeClient) GetCloneState(ctx context.Context) (cephFSCloneState,
 		state:    cs.State,
 		errno:    errno,
 		errorMsg: errStr,
+		source:   cs.Source,
+	}
+
+	if cs.State == admin.CloneInProgress {
+		state.progress = s.getCloneProgress(ctx)
 	}
 
 	return state, nil
 }
+
+// cloneProgressReport is the progress report of an in-progress clone as
+// returned by "fs clone status".
+type cloneProgressReport struct {
+	PercentageCloned string `json:"percentage cloned"`
+	AmountCloned     string `json:"amount cloned"`
+}
+
+// parseCloneProgress returns the progress from the "fs clone status" output.
+// An empty string is returned when the output has no progress report, which
+// is the case for Ceph releases before Squid.
+func parseCloneProgress(buf []byte) (string, error) {
+	var status struct {
+		Status struct {
+			ProgressReport *cloneProgressReport `json:"progress_report"`
+		} `json:"status"`
+	}
+
+	err := json.Unmarshal(buf, &status)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse clone status: %w", err)
+	}
+
+	report := status.Status.ProgressReport
+	switch {
+	case report == nil || report.PercentageCloned == "":
+		return "", nil
+	case report.AmountCloned == "":
+		return report.PercentageCloned, nil
+	default:
+		return fmt.Sprintf("%s (%s)", report.PercentageCloned, report.AmountCloned), nil
+	}
+}
+
+// getCloneProgress returns the progress of the clone. go-ceph does not
+// provide the progress report yet, so it is fetched with a raw mgr command.
+// Failures are logged, an empty string is returned in that case.
+func (s *subVolumeClient) getCloneProgress(ctx context.Context) string {
+	cmd := map[string]string{
+		"prefix":     "fs clone status",
+		"vol_name":   s.FsName,
+		"clone_name": s.VolID,
+		"format":     "json",
+	}
+	if s.SubvolumeGroup != admin.NoGroup {
+		cmd["group_name"] = s.SubvolumeGroup
+	}
+
+	buf, stat, err := s.conn.MgrCommand(cmd)
+	if err != nil {
+		log.DebugLog(ctx, "could not get clone progress for volume %s with ID %s: %v (%s)",
+			s.FsName, s.VolID, err, stat)
+
+		return ""
+	}
+
+	progress, err := parseCloneProgress(buf)
+	if err != nil {
+		log.DebugLog(ctx, "could not get clone progress for volume %s with ID %s: %v", s.FsName, s.VolID, err)
+	}
+
+	return progress
+}
+
+// CancelClone cancels the pending or in-progress clone of the subvolume. In
+// case the clone is not pending or in-progress anymore when it gets canceled,
+// nil is returned as well. The subvolume needs to be removed by the caller.
+func (s *subVolumeClient) CancelClone(ctx context.Context) error {
+	fsa, err := s.conn.GetFSAdmin()
+	if err != nil {
+		log.ErrorLog(ctx, "could not get FSAdmin, can not cancel clone %s: %v", s.VolID, err)
+
+		return err
+	}
+
+	err = fsa.CancelClone(s.FsName, s.SubvolumeGroup, s.VolID)
+	if err == nil {
+		log.DebugLog(ctx, "canceled clone %s in fs %s", s.VolID, s.FsName)
+
+		return nil
+	}
+
+	// the clone may have completed (or failed) since the state was
+	// checked, in which case there is nothing to cancel anymore
+	cloneState, stateErr := s.GetCloneState(ctx)
+	if stateErr == nil && !cerrors.IsCloneRetryError(cloneState.ToError()) {
+		log.DebugLog(ctx, "clone %s is not in progress anymore, not canceling it: %v", s.VolID, err)
+
+		return nil
+	}
+
+	log.ErrorLog(ctx, "failed to cancel clone %s in fs %s: %v", s.VolID, s.FsName, err)
+
+	return err
+}
@@ -29,13 +29,71 @@ import (
 func TestCloneStateToError(t *testing.T) {
 	t.Parallel()
 	errorState := make(map[cephFSCloneState]error)
-	errorState[cephFSCloneState{fsa.CloneComplete, "", ""}] = nil
+	errorState[cephFSCloneState{state: fsa.CloneComplete}] = nil
 	errorState[CephFSCloneError] = cerrors.ErrInvalidClone
-	errorState[cephFSCloneState{fsa.CloneInProgress, "", ""}] = cerrors.ErrCloneInProgress
-	errorState[cephFSCloneState{fsa.ClonePending, "", ""}] = cerrors.ErrClonePending
-	errorState[cephFSCloneState{fsa.CloneFailed, "", ""}] = cerrors.ErrCloneFailed
+	errorState[cephFSCloneState{state: fsa.CloneInProgress}] = cerrors.ErrCloneInProgress
+	errorState[cephFSCloneState{state: fsa.ClonePending}] = cerrors.ErrClonePending
+	errorState[cephFSCloneState{state: fsa.CloneFailed}] = cerrors.ErrCloneFailed
 
 	for state, err := range errorState {
 		assert.True(t, errors.Is(state.ToError(), err))
 	}
 }
+
+func TestCloneStateToErrorWithProgress(t *testing.T) {
+	t.Parallel()
+	state := cephFSCloneState{state: fsa.CloneInProgress, progress: "12.24% (1.2 GiB/9.8 GiB)"}
+	err := state.ToError()
+	assert.ErrorIs(t, err, cerrors.ErrCloneInProgress)
+	assert.True(t, cerrors.IsCloneRetryError(err))
+	assert.Contains(t, err.Error(), "12.24% (1.2 GiB/9.8 GiB) cloned")
+}
+
+func TestParseCloneProgress(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		output   string
+		progress string
+		wantErr  bool
+	}{
+		{
+			name: "in-progress clone with progress report",
+			output: `{"status": {"state": "in-progress", "source": {"volume": "myfs", "subvolume": "sv1",
+				"snapshot": "snap1", "group": "csi"}, "progress_report": {"percentage cloned": "12.24%",
+				"amount cloned": "1.2 GiB/9.8 GiB", "files cloned": "10/100"}}}`,
+			progress: "12.24% (1.2 GiB/9.8 GiB)",
+		},
+		{
+			name: "progress report without amount",
+			output: `{"status": {"state": "in-progress", "progress_report":
+				{"percentage cloned": "50%"}}}`,
+			progress: "50%",
+		},
+		{
+			name: "no progress report",
+			output: `{"status": {"state": "in-progress", "source": {"volume": "myfs", "subvolume": "sv1",
+				"snapshot": "snap1"}}}`,
+			progress: "",
+		},
+		{
+			name:    "invalid output",
+			output:  "clone in progress",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			progress, err := parseCloneProgress([]byte(tt.output))
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.progress, progress)
+		})
+	}
+}
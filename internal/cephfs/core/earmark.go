@@ -0,0 +1,149 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+const (
+	// EarmarkScopeNFS is the top-level scope of earmarks for subvolumes
+	// that are exported over NFS.
+	EarmarkScopeNFS = "nfs"
+	// EarmarkScopeSMB is the top-level scope of earmarks for subvolumes
+	// that are shared over SMB.
+	EarmarkScopeSMB = "smb"
+
+	// missingMgrCommand is the prefix of the status returned by the Ceph
+	// Manager when a command is not known.
+	missingMgrCommand = "No handler found"
+)
+
+var (
+	// ErrEarmarkNotSupported is returned when the Ceph cluster does not
+	// support subvolume earmarks.
+	ErrEarmarkNotSupported = errors.New("subvolume earmark operations are not supported")
+
+	// ErrInvalidEarmark is returned when an earmark is not in the
+	// <scope>[.<subsection>...] format, or uses an unknown scope.
+	ErrInvalidEarmark = errors.New("invalid earmark")
+
+	// ErrEarmarkConflict is returned when a subvolume is earmarked for a
+	// different usage than the one requested.
+	ErrEarmarkConflict = errors.New("subvolume earmark conflict")
+)
+
+// ValidateEarmark checks that the earmark starts with a known scope and has no
+// empty subsections. An empty earmark is valid and means no earmark.
+func ValidateEarmark(earmark string) error {
+	if earmark == "" {
+		return nil
+	}
+
+	sections := strings.Split(earmark, ".")
+	for _, section := range sections {
+		if section == "" {
+			return fmt.Errorf("%w %q: empty section", ErrInvalidEarmark, earmark)
+		}
+	}
+
+	switch sections[0] {
+	case EarmarkScopeNFS, EarmarkScopeSMB:
+		return nil
+	default:
+		return fmt.Errorf("%w %q: scope should be %q or %q", ErrInvalidEarmark, earmark,
+			EarmarkScopeNFS, EarmarkScopeSMB)
+	}
+}
+
+// EarmarkScope returns the top-level scope of the earmark.
+func EarmarkScope(earmark string) string {
+	scope, _, _ := strings.Cut(earmark, ".")
+
+	return scope
+}
+
+// CheckEarmarkConflict returns ErrEarmarkConflict when the earmark claims the
+// subvolume for a different scope than the passed one. Subvolumes without an
+// earmark can be used for any scope.
+func CheckEarmarkConflict(earmark, scope string) error {
+	if earmark == "" || EarmarkScope(earmark) == scope {
+		return nil
+	}
+
+	return fmt.Errorf("%w: subvolume is earmarked %q, can not be used for %s", ErrEarmarkConflict, earmark, scope)
+}
+
+// earmarkCommand runs a "fs subvolume earmark" command for the subvolume and
+// returns the output.
+func (s *subVolumeClient) earmarkCommand(op string, args map[string]string) (string, error) {
+	cmd := map[string]string{
+		"prefix":     "fs subvolume earmark " + op,
+		"vol_name":   s.FsName,
+		"sub_name":   s.VolID,
+		"group_name": s.SubvolumeGroup,
+		"format":     "json",
+	}
+	for k, v := range args {
+		cmd[k] = v
+	}
+
+	buf, stat, err := s.conn.MgrCommand(cmd)
+	if err != nil {
+		if strings.HasPrefix(stat, missingMgrCommand) {
+			return "", ErrEarmarkNotSupported
+		}
+
+		return "", fmt.Errorf("failed to %s earmark of subvolume %s in fs %s: %w (%s)",
+			op, s.VolID, s.FsName, err, stat)
+	}
+
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// SetEarmark sets the earmark on the subvolume.
+func (s *subVolumeClient) SetEarmark(ctx context.Context, earmark string) error {
+	if err := ValidateEarmark(earmark); err != nil {
+		return err
+	}
+
+	_, err := s.earmarkCommand("set", map[string]string{"earmark": earmark})
+	if err != nil {
+		log.ErrorLog(ctx, "failed to set earmark %q on subvolume %s: %v", earmark, s.VolID, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// GetEarmark returns the earmark of the subvolume, or an empty string when
+// the subvolume has no earmark.
+func (s *subVolumeClient) GetEarmark(ctx context.Context) (string, error) {
+	earmark, err := s.earmarkCommand("get", nil)
+	if err != nil {
+		return "", err
+	}
+
+	// the earmark is returned as a JSON string when it is set
+	return strings.Trim(earmark, `"`), nil
+}
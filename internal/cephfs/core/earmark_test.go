@@ -0,0 +1,84 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEarmark(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		earmark string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"nfs", "nfs", false},
+		{"smb", "smb", false},
+		{"smb with cluster", "smb.cluster.cluster1", false},
+		{"unknown scope", "iscsi", true},
+		{"scope prefix only", "nfsv4", true},
+		{"empty section", "smb..cluster1", true},
+		{"trailing dot", "nfs.", true},
+		{"leading dot", ".smb", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateEarmark(tt.earmark)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidEarmark)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckEarmarkConflict(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		earmark  string
+		scope    string
+		conflict bool
+	}{
+		{"no earmark for nfs", "", EarmarkScopeNFS, false},
+		{"no earmark for smb", "", EarmarkScopeSMB, false},
+		{"nfs for nfs", "nfs", EarmarkScopeNFS, false},
+		{"smb cluster for smb", "smb.cluster.cluster1", EarmarkScopeSMB, false},
+		{"smb for nfs", "smb", EarmarkScopeNFS, true},
+		{"smb cluster for nfs", "smb.cluster.cluster1", EarmarkScopeNFS, true},
+		{"nfs for smb", "nfs", EarmarkScopeSMB, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := CheckEarmarkConflict(tt.earmark, tt.scope)
+			if tt.conflict {
+				assert.ErrorIs(t, err, ErrEarmarkConflict)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
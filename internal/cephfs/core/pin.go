@@ -0,0 +1,158 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	fsAdmin "github.com/ceph/go-ceph/cephfs/admin"
+)
+
+const (
+	// PinTypeExport pins the subvolumegroup to a single MDS rank.
+	PinTypeExport = "export"
+	// PinTypeDistributed spreads the subvolumes of the subvolumegroup over
+	// all MDS ranks.
+	PinTypeDistributed = "distributed"
+	// PinTypeRandom pins a random fraction of the subvolumes to random MDS
+	// ranks.
+	PinTypeRandom = "random"
+)
+
+// ErrInvalidPin is returned when the pin type or pin setting of a
+// subvolumegroup is not valid.
+var ErrInvalidPin = errors.New("invalid subvolumegroup pin")
+
+// unpinSettings contains the pin setting that removes the pin of each type.
+var unpinSettings = map[string]string{
+	PinTypeExport:      "-1",
+	PinTypeDistributed: "0",
+	PinTypeRandom:      "0",
+}
+
+// ValidateSubVolumeGroupPin validates the pin type and pin setting, see
+// https://docs.ceph.com/en/latest/cephfs/multimds/#setting-subtree-partitioning-policies
+// A pin without type and setting is valid, no pin is configured then.
+func ValidateSubVolumeGroupPin(pin util.SubvolumeGroupPin) error {
+	if pin.Type == "" && pin.Setting == "" {
+		return nil
+	}
+	if pin.Type == "" || pin.Setting == "" {
+		return fmt.Errorf("%w: both type and setting need to be set", ErrInvalidPin)
+	}
+
+	switch pin.Type {
+	case PinTypeExport:
+		// a rank of -1 removes the export pin
+		rank, err := strconv.ParseInt(pin.Setting, 10, 64)
+		if err != nil || rank < -1 {
+			return fmt.Errorf("%w: %s pin setting %q should be an MDS rank or -1", ErrInvalidPin, pin.Type, pin.Setting)
+		}
+	case PinTypeDistributed:
+		if pin.Setting != "0" && pin.Setting != "1" {
+			return fmt.Errorf("%w: %s pin setting %q should be 0 or 1", ErrInvalidPin, pin.Type, pin.Setting)
+		}
+	case PinTypeRandom:
+		fraction, err := strconv.ParseFloat(pin.Setting, 64)
+		if err != nil || fraction < 0 || fraction > 1 {
+			return fmt.Errorf("%w: %s pin setting %q should be between 0.0 and 1.0", ErrInvalidPin, pin.Type, pin.Setting)
+		}
+	default:
+		return fmt.Errorf("%w: unknown pin type %q, should be %q, %q or %q", ErrInvalidPin, pin.Type,
+			PinTypeExport, PinTypeDistributed, PinTypeRandom)
+	}
+
+	return nil
+}
+
+// subVolumeGroupPinner pins a subvolumegroup, it is implemented by
+// admin.FSAdmin.
+type subVolumeGroupPinner interface {
+	PinSubVolumeGroup(volume, group, pintype, pinsetting string) (string, error)
+}
+
+// pinSubVolumeGroup pins the subvolumegroup when the pin differs from the one
+// that was applied before, pins that did not change are not applied again.
+// Only pins that were applied by this process are removed, either when they
+// are replaced by a pin of another type or when the pin is removed from the
+// configuration. Pins that were set by others, or before a restart, are left
+// untouched.
+func pinSubVolumeGroup(
+	ctx context.Context,
+	pinner subVolumeGroupPinner,
+	applied map[string]util.SubvolumeGroupPin,
+	fsName,
+	group string,
+	pin *util.SubvolumeGroupPin,
+) error {
+	current, known := applied[fsName]
+	if pin == nil {
+		if !known {
+			return nil
+		}
+		err := unpinSubVolumeGroup(ctx, pinner, fsName, group, current.Type)
+		if err != nil {
+			return err
+		}
+		delete(applied, fsName)
+
+		return nil
+	}
+	if known && current == *pin {
+		return nil
+	}
+
+	if known && current.Type != pin.Type {
+		err := unpinSubVolumeGroup(ctx, pinner, fsName, group, current.Type)
+		if err != nil {
+			return err
+		}
+		delete(applied, fsName)
+	}
+
+	_, err := pinner.PinSubVolumeGroup(fsName, group, pin.Type, pin.Setting)
+	if err != nil {
+		return fmt.Errorf("failed to set %s pin %q on subvolumegroup %s in fs %s: %w",
+			pin.Type, pin.Setting, group, fsName, err)
+	}
+	log.DebugLog(ctx, "cephfs: set %s pin %q on subvolume group %s", pin.Type, pin.Setting, group)
+	applied[fsName] = *pin
+
+	return nil
+}
+
+// unpinSubVolumeGroup removes the pin of the given type from the
+// subvolumegroup. Clusters that do not support pinning have no pins to
+// remove.
+func unpinSubVolumeGroup(ctx context.Context, pinner subVolumeGroupPinner, fsName, group, pinType string) error {
+	_, err := pinner.PinSubVolumeGroup(fsName, group, pinType, unpinSettings[pinType])
+	var notImplemented fsAdmin.NotImplementedError
+	if errors.As(err, &notImplemented) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to remove %s pin from subvolumegroup %s in fs %s: %w", pinType, group, fsName, err)
+	}
+	log.DebugLog(ctx, "cephfs: removed %s pin from subvolume group %s", pinType, group)
+
+	return nil
+}
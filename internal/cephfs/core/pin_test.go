@@ -0,0 +1,168 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/util"
+
+	fsAdmin "github.com/ceph/go-ceph/cephfs/admin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSubVolumeGroupPin(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		pinType string
+		setting string
+		wantErr bool
+	}{
+		{"no pin", "", "", false},
+		{"export", PinTypeExport, "2", false},
+		{"export unpin", PinTypeExport, "-1", false},
+		{"distributed", PinTypeDistributed, "1", false},
+		{"random", PinTypeRandom, "0.01", false},
+		{"type without setting", PinTypeExport, "", true},
+		{"setting without type", "", "1", true},
+		{"unknown type", "ephemeral", "1", true},
+		{"export below -1", PinTypeExport, "-2", true},
+		{"export not a rank", PinTypeExport, "one", true},
+		{"distributed not 0 or 1", PinTypeDistributed, "2", true},
+		{"random above 1", PinTypeRandom, "1.5", true},
+		{"random negative", PinTypeRandom, "-0.1", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateSubVolumeGroupPin(util.SubvolumeGroupPin{Type: tt.pinType, Setting: tt.setting})
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPin)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+type fakePinner struct {
+	pins []string
+	err  error
+}
+
+func (f *fakePinner) PinSubVolumeGroup(volume, group, pintype, pinsetting string) (string, error) {
+	f.pins = append(f.pins, volume+" "+pintype+"="+pinsetting)
+
+	return "", f.err
+}
+
+func TestPinSubVolumeGroup(t *testing.T) {
+	t.Parallel()
+	ctx := context.TODO()
+
+	t.Run("no pin configured", func(ts *testing.T) {
+		ts.Parallel()
+		pinner := &fakePinner{}
+		applied := make(map[string]util.SubvolumeGroupPin)
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", nil))
+		assert.Empty(ts, pinner.pins)
+	})
+
+	t.Run("repin only on change", func(ts *testing.T) {
+		ts.Parallel()
+		pinner := &fakePinner{}
+		applied := make(map[string]util.SubvolumeGroupPin)
+		pin := &util.SubvolumeGroupPin{Type: PinTypeExport, Setting: "1"}
+
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+		assert.Equal(ts, []string{"fs export=1"}, pinner.pins)
+
+		// a changed pin is applied on the next call
+		pinner.pins = nil
+		pin = &util.SubvolumeGroupPin{Type: PinTypeExport, Setting: "0"}
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+		assert.Equal(ts, []string{"fs export=0"}, pinner.pins)
+
+		// a pin of another type replaces the previous pin
+		pinner.pins = nil
+		pin = &util.SubvolumeGroupPin{Type: PinTypeRandom, Setting: "0.5"}
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+		assert.Equal(ts, []string{"fs export=-1", "fs random=0.5"}, pinner.pins)
+
+		// each filesystem has its own subvolumegroup
+		pinner.pins = nil
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs2", "csi", pin))
+		assert.Equal(ts, []string{"fs2 random=0.5"}, pinner.pins)
+	})
+
+	t.Run("unpin removed pin", func(ts *testing.T) {
+		ts.Parallel()
+		pinner := &fakePinner{}
+		applied := make(map[string]util.SubvolumeGroupPin)
+		pin := &util.SubvolumeGroupPin{Type: PinTypeDistributed, Setting: "1"}
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+
+		pinner.pins = nil
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", nil))
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", nil))
+		assert.Equal(ts, []string{"fs distributed=0"}, pinner.pins)
+	})
+
+	t.Run("unpin failure", func(ts *testing.T) {
+		ts.Parallel()
+		pinner := &fakePinner{}
+		applied := make(map[string]util.SubvolumeGroupPin)
+		pin := &util.SubvolumeGroupPin{Type: PinTypeExport, Setting: "1"}
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+
+		// the unpin is retried on the next call
+		pinner.err = errors.New("mgr unavailable")
+		assert.Error(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", nil))
+		pinner.err = nil
+		pinner.pins = nil
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", nil))
+		assert.Equal(ts, []string{"fs export=-1"}, pinner.pins)
+	})
+
+	t.Run("pinning not supported", func(ts *testing.T) {
+		ts.Parallel()
+		pinner := &fakePinner{err: fsAdmin.NotImplementedError{}}
+		applied := make(map[string]util.SubvolumeGroupPin)
+		assert.Error(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi",
+			&util.SubvolumeGroupPin{Type: PinTypeExport, Setting: "0"}))
+		assert.Empty(ts, applied)
+	})
+
+	t.Run("retry after failure", func(ts *testing.T) {
+		ts.Parallel()
+		pinner := &fakePinner{err: errors.New("mgr unavailable")}
+		applied := make(map[string]util.SubvolumeGroupPin)
+		pin := &util.SubvolumeGroupPin{Type: PinTypeRandom, Setting: "0.5"}
+
+		assert.Error(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+		pinner.err = nil
+		pinner.pins = nil
+		assert.NoError(ts, pinSubVolumeGroup(ctx, pinner, applied, "fs", "csi", pin))
+		assert.Equal(ts, []string{"fs random=0.5"}, pinner.pins)
+	})
+}
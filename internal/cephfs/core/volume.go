@@ -25,6 +25,7 @@ import (
 
 	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
 	fsutil "github.com/ceph/ceph-csi/internal/cephfs/util"
+	"github.com/ceph/ceph-csi/internal/journal"
 	"github.com/ceph/ceph-csi/internal/util"
 	"github.com/ceph/ceph-csi/internal/util/log"
 
@@ -44,6 +45,7 @@ var clusterAdditionalInfo = make(map[string]*localClusterState)
 type Subvolume struct {
 	BytesQuota int64
 	Path       string
+	DataPool   string
 	Features   []string
 }
 
@@ -68,6 +70,8 @@ type SubVolumeClient interface {
 	CreateCloneFromSubvolume(ctx context.Context, parentvolOpt *SubVolume) error
 	// GetCloneState returns the clone state of the subvolume.
 	GetCloneState(ctx context.Context) (cephFSCloneState, error)
+	// CancelClone cancels the pending or in-progress clone of the subvolume.
+	CancelClone(ctx context.Context) error
 	// CreateCloneFromSnapshot creates a clone from the subvolume snapshot.
 	CreateCloneFromSnapshot(ctx context.Context, snap Snapshot) error
 	// CleanupSnapshotFromSubvolume removes the snapshot from the subvolume.
@@ -77,6 +81,11 @@ type SubVolumeClient interface {
 	SetAllMetadata(parameters map[string]string) error
 	// UnsetAllMetadata unset all the metadata from arg keys on subvolume.
 	UnsetAllMetadata(keys []string) error
+
+	// SetEarmark sets the earmark on the subvolume.
+	SetEarmark(ctx context.Context, earmark string) error
+	// GetEarmark returns the earmark of the subvolume.
+	GetEarmark(ctx context.Context) (string, error)
 }
 
 // subVolumeClient implements SubVolumeClient interface.
@@ -96,6 +105,10 @@ type SubVolume struct {
 	Pool           string   // pool name where subvolume will be created.
 	Features       []string // subvolume features.
 	Size           int64    // subvolume size.
+
+	// GroupPin is the MDS pinning of the subvolume group, it is nil when
+	// no pin is configured.
+	GroupPin *util.SubvolumeGroupPin
 }
 
 // NewSubVolume returns a new subvolume client.
@@ -168,6 +181,7 @@ func (s *subVolumeClient) GetSubVolumeInfo(ctx context.Context) (*Subvolume, err
 	subvol := Subvolume{
 		// only set BytesQuota when it is of type ByteCount
 		Path:     info.Path,
+		DataPool: info.DataPool,
 		Features: make([]string, len(info.Features)),
 	}
 	bc, ok := info.BytesQuota.(fsAdmin.ByteCount)
@@ -207,6 +221,9 @@ type localClusterState struct {
 	// set true once a subvolumegroup is created
 	// for corresponding filesystem in a cluster.
 	subVolumeGroupsCreated map[string]bool
+	// subVolumeGroupPins contains the pin that was last set on the
+	// subvolumegroup of each filesystem in the cluster.
+	subVolumeGroupPins map[string]util.SubvolumeGroupPin
 }
 
 func newLocalClusterState(clusterID string) {
@@ -215,6 +232,7 @@ func newLocalClusterState(clusterID string) {
 	if _, keyPresent := clusterAdditionalInfo[clusterID]; !keyPresent {
 		clusterAdditionalInfo[clusterID] = &localClusterState{}
 		clusterAdditionalInfo[clusterID].subVolumeGroupsCreated = make(map[string]bool)
+		clusterAdditionalInfo[clusterID].subVolumeGroupPins = make(map[string]util.SubvolumeGroupPin)
 	}
 }
 
@@ -247,6 +265,15 @@ func (s *subVolumeClient) CreateVolume(ctx context.Context) error {
 		clusterAdditionalInfo[s.clusterID].subVolumeGroupsCreated[s.FsName] = true
 	}
 
+	// (re)pin the subvolumegroup if the pin changed since it was last set.
+	err = pinSubVolumeGroup(ctx, ca, clusterAdditionalInfo[s.clusterID].subVolumeGroupPins,
+		s.FsName, s.SubvolumeGroup, s.GroupPin)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to pin subvolume group %s, for the vol %s: %s", s.SubvolumeGroup, s.VolID, err)
+
+		return err
+	}
+
 	opts := fsAdmin.SubVolumeOptions{
 		Size: fsAdmin.ByteCount(s.Size),
 	}
@@ -263,6 +290,8 @@ func (s *subVolumeClient) CreateVolume(ctx context.Context) error {
 			// Reset the subVolumeGroupsCreated so that we can try again to create the
 			// subvolumegroup in next request if the error is Not Found.
 			clusterAdditionalInfo[s.clusterID].subVolumeGroupsCreated[s.FsName] = false
+			// the subvolumegroup is pinned again once it is created.
+			delete(clusterAdditionalInfo[s.clusterID].subVolumeGroupPins, s.FsName)
 		}
 
 		return err
@@ -368,3 +397,76 @@ func checkSubvolumeHasFeature(feature string, subVolFeatures []string) bool {
 
 	return false
 }
+
+// JournalVolumeExists returns a journal.VolumeExistsFunc that checks if the
+// subvolume, or the subvolume snapshot, of a journal entry exists.
+// Snapshot-backed volumes have no subvolume, their backing snapshot is
+// checked instead, its reservation is read from the journal with monitors.
+func JournalVolumeExists(
+	conn *util.ClusterConnection,
+	monitors, fsName, subvolumeGroup string,
+) journal.VolumeExistsFunc {
+	return func(ctx context.Context, entry *journal.Entry) (bool, error) {
+		vol := &SubVolume{
+			VolID:          entry.VolumeName,
+			FsName:         fsName,
+			SubvolumeGroup: subvolumeGroup,
+		}
+
+		var err error
+		switch {
+		case entry.IsSnapshot:
+			// snapshots are taken of the parent subvolume
+			vol.VolID = entry.SourceName
+			_, err = NewSnapshot(conn, entry.VolumeName, "", "", false, vol).GetSnapshotInfo(ctx)
+		case entry.BackingSnapshotID != "":
+			var snapName string
+			snapName, vol.VolID, err = backingSnapshot(ctx, conn, monitors, entry)
+			if err == nil {
+				_, err = NewSnapshot(conn, snapName, "", "", false, vol).GetSnapshotInfo(ctx)
+			}
+		default:
+			_, err = NewSubVolume(conn, vol, "", "", false).GetSubVolumeInfo(ctx)
+		}
+		if err != nil {
+			if errors.Is(err, cerrors.ErrVolumeNotFound) || errors.Is(err, cerrors.ErrSnapNotFound) ||
+				errors.Is(err, util.ErrKeyNotFound) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		return true, nil
+	}
+}
+
+// backingSnapshot returns the name of the backing snapshot of the
+// snapshot-backed volume of the journal entry, and the name of its
+// subvolume. The snapshot is reserved in the pool of the entry. It returns
+// util.ErrKeyNotFound when the reservation of the snapshot was removed.
+func backingSnapshot(
+	ctx context.Context,
+	conn *util.ClusterConnection,
+	monitors string,
+	entry *journal.Entry,
+) (string, string, error) {
+	var vi util.CSIIdentifier
+	err := vi.DecomposeCSIID(entry.BackingSnapshotID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode backing snapshot ID %q: %w", entry.BackingSnapshotID, err)
+	}
+
+	j, err := journal.NewCSISnapshotJournal("").Connect(monitors, fsutil.RadosNamespace, conn.Creds)
+	if err != nil {
+		return "", "", err
+	}
+	defer j.Destroy()
+
+	attrs, err := j.GetImageAttributes(ctx, entry.Pool, vi.ObjectUUID, true)
+	if err != nil {
+		return "", "", err
+	}
+
+	return attrs.ImageName, attrs.SourceName, nil
+}
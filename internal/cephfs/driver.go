@@ -70,20 +70,24 @@ func NewNodeServer(
 	topology map[string]string,
 	kernelMountOptions string,
 	fuseMountOptions string,
+	crushLocationMap map[string]string,
 ) *NodeServer {
-	return &NodeServer{
+	ns := &NodeServer{
 		DefaultNodeServer:  csicommon.NewDefaultNodeServer(d, t, topology),
 		VolumeLocks:        util.NewVolumeLocks(),
 		kernelMountOptions: kernelMountOptions,
 		fuseMountOptions:   fuseMountOptions,
 	}
+	ns.SetReadAffinityMountOptions(crushLocationMap)
+
+	return ns
 }
 
 // Run start a non-blocking grpc controller,node and identityserver for
 // ceph CSI driver which can serve multiple parallel requests.
 func (fs *Driver) Run(conf *util.Config) {
 	var err error
-	var topology map[string]string
+	var topology, crushLocationMap map[string]string
 
 	// Configuration
 	if err = mounter.LoadAvailableMounters(conf); err != nil {
@@ -121,6 +125,14 @@ func (fs *Driver) Run(conf *util.Config) {
 			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
 		})
 	}
+
+	if conf.EnableReadAffinity {
+		crushLocationMap, err = util.GetCrushLocationMap(conf.CrushLocationLabels, conf.NodeID)
+		if err != nil {
+			log.FatalLogMsg(err.Error())
+		}
+	}
+
 	// Create gRPC servers
 
 	fs.is = NewIdentityServer(fs.cd)
@@ -130,7 +142,8 @@ func (fs *Driver) Run(conf *util.Config) {
 		if err != nil {
 			log.FatalLogMsg(err.Error())
 		}
-		fs.ns = NewNodeServer(fs.cd, conf.Vtype, topology, conf.KernelMountOptions, conf.FuseMountOptions)
+		fs.ns = NewNodeServer(fs.cd, conf.Vtype, topology, conf.KernelMountOptions, conf.FuseMountOptions,
+			crushLocationMap)
 	}
 
 	if conf.IsControllerServer {
@@ -143,10 +156,15 @@ func (fs *Driver) Run(conf *util.Config) {
 		if err != nil {
 			log.FatalLogMsg(err.Error())
 		}
-		fs.ns = NewNodeServer(fs.cd, conf.Vtype, topology, conf.KernelMountOptions, conf.FuseMountOptions)
+		fs.ns = NewNodeServer(fs.cd, conf.Vtype, topology, conf.KernelMountOptions, conf.FuseMountOptions,
+			crushLocationMap)
 		fs.cs = NewControllerServer(fs.cd)
 	}
 
+	if fs.ns != nil {
+		fs.ns.autoRemount = conf.CephFSAutoRemount
+	}
+
 	server := csicommon.NewNonBlockingGRPCServer()
 	srv := csicommon.Servers{
 		IS: fs.is,
@@ -155,13 +173,18 @@ func (fs *Driver) Run(conf *util.Config) {
 		// passing nil for replication server as cephFS does not support mirroring.
 		RS: nil,
 	}
+	if conf.EnableRPCMetrics {
+		csicommon.EnableRPCMetrics()
+	}
 	server.Start(conf.Endpoint, conf.HistogramOption, srv, conf.EnableGRPCMetrics)
 	if conf.EnableGRPCMetrics {
 		log.WarningLogMsg("EnableGRPCMetrics is deprecated")
+	}
+	if conf.EnableGRPCMetrics || conf.EnableRPCMetrics {
 		go util.StartMetricsServer(conf)
 	}
 	if conf.EnableProfiling {
-		if !conf.EnableGRPCMetrics {
+		if !conf.EnableGRPCMetrics && !conf.EnableRPCMetrics {
 			go util.StartMetricsServer(conf)
 		}
 		log.DebugLogMsg("Registering profiling handler")
@@ -23,6 +23,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	cerrors "github.com/ceph/ceph-csi/internal/cephfs/errors"
 	"github.com/ceph/ceph-csi/internal/cephfs/mounter"
@@ -47,6 +48,15 @@ type NodeServer struct {
 	VolumeLocks        *util.VolumeLocks
 	kernelMountOptions string
 	fuseMountOptions   string
+	// readAffinityMountOptions contains kernel mount options to enable read
+	// affinity.
+	readAffinityMountOptions string
+	// autoRemount enables the remount of stale kernel mounts that use
+	// recover_session=clean, remountAttempts counts the failed attempts
+	// per volume.
+	autoRemount         bool
+	remountAttemptsLock sync.Mutex
+	remountAttempts     map[string]int
 }
 
 func getCredentialsForVolume(
@@ -179,16 +189,9 @@ func (ns *NodeServer) NodeStageVolume(
 	}
 	defer volOptions.Destroy()
 
-	// Skip extracting NetNamespaceFilePath if the clusterID is empty.
-	// In case of pre-provisioned volume the clusterID is not set in the
-	// volume context.
-	if volOptions.ClusterID != "" {
-		volOptions.NetNamespaceFilePath, err = util.GetCephFSNetNamespaceFilePath(
-			util.CsiConfigFile,
-			volOptions.ClusterID)
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
+	err = setClusterOptions(volOptions)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if volOptions.BackingSnapshot {
@@ -251,12 +254,14 @@ func (ns *NodeServer) NodeStageVolume(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if _, isFuse := mnt.(*mounter.FuseMounter); isFuse {
-		// FUSE mount recovery needs NodeStageMountinfo records.
+	if _, isFuse := mnt.(*mounter.FuseMounter); isFuse || ns.canRemount(mnt, volOptions) {
+		// FUSE mount recovery and the remount of stale kernel mounts need
+		// NodeStageMountinfo records.
 
 		if err = fsutil.WriteNodeStageMountinfo(volID, &fsutil.NodeStageMountinfo{
 			VolumeCapability: req.GetVolumeCapability(),
 			Secrets:          req.GetSecrets(),
+			VolumeContext:    req.GetVolumeContext(),
 		}); err != nil {
 			log.ErrorLog(ctx, "cephfs: failed to write NodeStageMountinfo for volume %s: %v", volID, err)
 
@@ -273,6 +278,38 @@ func (ns *NodeServer) NodeStageVolume(
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// setClusterOptions sets the network namespace and merges the mount options
+// of the cluster of the volume from the configuration file.
+func setClusterOptions(volOptions *store.VolumeOptions) error {
+	// Skip extracting NetNamespaceFilePath if the clusterID is empty.
+	// In case of pre-provisioned volume the clusterID is not set in the
+	// volume context.
+	if volOptions.ClusterID == "" {
+		return nil
+	}
+
+	var err error
+	volOptions.NetNamespaceFilePath, err = util.GetCephFSNetNamespaceFilePath(
+		util.CsiConfigFile,
+		volOptions.ClusterID)
+	if err != nil {
+		return err
+	}
+
+	// mount options of the volume take precedence over the ones of
+	// the cluster
+	kernelMountOptions, fuseMountOptions, err := util.GetCephFSMountOptions(
+		util.CsiConfigFile,
+		volOptions.ClusterID)
+	if err != nil {
+		return err
+	}
+	volOptions.KernelMountOptions = util.MountOptionsMerge(volOptions.KernelMountOptions, kernelMountOptions)
+	volOptions.FuseMountOptions = util.MountOptionsMerge(volOptions.FuseMountOptions, fuseMountOptions)
+
+	return nil
+}
+
 func (ns *NodeServer) mount(
 	ctx context.Context,
 	mnt mounter.VolumeMounter,
@@ -292,12 +329,14 @@ func (ns *NodeServer) mount(
 
 	log.DebugLog(ctx, "cephfs: mounting volume %s with %s", volID, mnt.Name())
 
+	// mount options of the driver have the lowest precedence
 	switch mnt.(type) {
 	case *mounter.FuseMounter:
-		volOptions.FuseMountOptions = util.MountOptionsAdd(volOptions.FuseMountOptions, ns.fuseMountOptions)
+		volOptions.FuseMountOptions = util.MountOptionsMerge(volOptions.FuseMountOptions, ns.fuseMountOptions)
 	case *mounter.KernelMounter:
-		volOptions.KernelMountOptions = util.MountOptionsAdd(volOptions.KernelMountOptions, ns.kernelMountOptions)
+		volOptions.KernelMountOptions = util.MountOptionsMerge(volOptions.KernelMountOptions, ns.kernelMountOptions)
 	}
+	ns.appendReadAffinityMountOptions(ctx, mnt, volOptions)
 
 	const readOnly = "ro"
 
@@ -445,8 +484,17 @@ func (ns *NodeServer) NodePublishVolume(
 	targetPath := req.GetTargetPath()
 	volID := fsutil.VolumeID(req.GetVolumeId())
 
-	// Considering kubelet make sure the stage and publish operations
-	// are serialized, we dont need any extra locking in nodePublish
+	// the volume lock keeps the remount of a stale volume from racing with
+	// the bind mount. Without auto-remount, kubelet makes sure the node
+	// operations of a volume are serialized and no extra locking is needed.
+	if ns.autoRemount {
+		if acquired := ns.VolumeLocks.TryAcquire(req.GetVolumeId()); !acquired {
+			log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, req.GetVolumeId())
+
+			return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, req.GetVolumeId())
+		}
+		defer ns.VolumeLocks.Release(req.GetVolumeId())
+	}
 
 	if err := util.CreateMountPoint(targetPath); err != nil {
 		log.ErrorLog(ctx, "failed to create mount point at %s: %v", targetPath, err)
@@ -535,8 +583,18 @@ func (ns *NodeServer) NodeUnpublishVolume(
 		return nil, err
 	}
 
-	// considering kubelet make sure node operations like unpublish/unstage...etc can not be called
-	// at same time, an explicit locking at time of nodeunpublish is not required.
+	// the volume lock keeps the remount of a stale volume from racing with
+	// the unmount. Without auto-remount, kubelet makes sure the node
+	// operations of a volume are serialized and no extra locking is needed.
+	if ns.autoRemount {
+		if acquired := ns.VolumeLocks.TryAcquire(req.GetVolumeId()); !acquired {
+			log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, req.GetVolumeId())
+
+			return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, req.GetVolumeId())
+		}
+		defer ns.VolumeLocks.Release(req.GetVolumeId())
+	}
+
 	targetPath := req.GetTargetPath()
 	isMnt, err := util.IsMountPoint(ns.Mounter, targetPath)
 	if err != nil {
@@ -606,6 +664,7 @@ func (ns *NodeServer) NodeUnstageVolume(
 
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	ns.resetRemountAttempts(volID)
 
 	isMnt, err := util.IsMountPoint(ns.Mounter, stagingTargetPath)
 	if err != nil {
@@ -688,6 +747,15 @@ func (ns *NodeServer) NodeGetVolumeStats(
 	}
 
 	stat, err := os.Stat(targetPath)
+	if err != nil && ns.autoRemount && isStaleMountError(err) {
+		log.WarningLog(ctx, "stale mount detected in %q: %v", targetPath, err)
+		rErr := ns.tryRemountStaleVolume(ctx, req.GetVolumeId(), targetPath, req.GetStagingTargetPath())
+		if rErr != nil {
+			log.ErrorLog(ctx, "cephfs: failed to remount stale volume %s: %v", req.GetVolumeId(), rErr)
+		} else {
+			stat, err = os.Stat(targetPath)
+		}
+	}
 	if err != nil {
 		if util.IsCorruptedMountError(err) {
 			log.WarningLog(ctx, "corrupted mount detected in %q: %v", targetPath, err)
@@ -709,3 +777,29 @@ func (ns *NodeServer) NodeGetVolumeStats(
 
 	return nil, status.Errorf(codes.InvalidArgument, "targetpath %q is not a directory or device", targetPath)
 }
+
+// SetReadAffinityMountOptions sets the kernel mount options that enable read
+// affinity from the crush location of the node.
+func (ns *NodeServer) SetReadAffinityMountOptions(crushLocationMap map[string]string) {
+	ns.readAffinityMountOptions = util.ConstructReadAffinityMapOption(crushLocationMap)
+}
+
+// appendReadAffinityMountOptions appends readAffinityMountOptions to the
+// kernel mount options. The ceph-fuse mounter does not support read affinity,
+// the volume is mounted without it.
+func (ns *NodeServer) appendReadAffinityMountOptions(
+	ctx context.Context,
+	mnt mounter.VolumeMounter,
+	volOptions *store.VolumeOptions,
+) {
+	if ns.readAffinityMountOptions == "" {
+		return
+	}
+
+	switch mnt.(type) {
+	case *mounter.KernelMounter:
+		volOptions.KernelMountOptions = util.MountOptionsAdd(volOptions.KernelMountOptions, ns.readAffinityMountOptions)
+	default:
+		log.WarningLog(ctx, "cephfs: read affinity is not supported by the %s mounter, mounting without it", mnt.Name())
+	}
+}
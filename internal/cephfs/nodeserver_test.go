@@ -0,0 +1,78 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/mounter"
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeServer_appendReadAffinityMountOptions(t *testing.T) {
+	t.Parallel()
+	readAffinity := "read_from_replica=localize,crush_location=region:west"
+	tests := []struct {
+		name                     string
+		mounter                  mounter.VolumeMounter
+		kernelMountOptions       string
+		readAffinityMountOptions string
+		want                     string
+	}{
+		{
+			name:                     "empty options, empty readAffinityMountOptions & kernel mounter",
+			mounter:                  &mounter.KernelMounter{},
+			kernelMountOptions:       "",
+			readAffinityMountOptions: "",
+			want:                     "",
+		},
+		{
+			name:                     "empty options, filled readAffinityMountOptions & kernel mounter",
+			mounter:                  &mounter.KernelMounter{},
+			kernelMountOptions:       "",
+			readAffinityMountOptions: readAffinity,
+			want:                     readAffinity,
+		},
+		{
+			name:                     "filled options, filled readAffinityMountOptions & kernel mounter",
+			mounter:                  &mounter.KernelMounter{},
+			kernelMountOptions:       "ms_mode=secure",
+			readAffinityMountOptions: readAffinity,
+			want:                     "ms_mode=secure," + readAffinity,
+		},
+		{
+			name:                     "filled options, filled readAffinityMountOptions & fuse mounter",
+			mounter:                  &mounter.FuseMounter{},
+			kernelMountOptions:       "ms_mode=secure",
+			readAffinityMountOptions: readAffinity,
+			want:                     "ms_mode=secure",
+		},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ns := &NodeServer{readAffinityMountOptions: tc.readAffinityMountOptions}
+			volOptions := &store.VolumeOptions{KernelMountOptions: tc.kernelMountOptions}
+			ns.appendReadAffinityMountOptions(context.TODO(), tc.mounter, volOptions)
+			assert.Equal(t, tc.want, volOptions.KernelMountOptions)
+		})
+	}
+}
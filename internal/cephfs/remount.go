@@ -0,0 +1,265 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/ceph/ceph-csi/internal/cephfs/mounter"
+	"github.com/ceph/ceph-csi/internal/cephfs/store"
+	fsutil "github.com/ceph/ceph-csi/internal/cephfs/util"
+	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/fscrypt"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	mountutil "k8s.io/mount-utils"
+)
+
+const (
+	// maxRemountAttempts is the number of times a stale mount of a volume
+	// is remounted without success, before the remount is not tried again.
+	maxRemountAttempts = 3
+
+	// recoverSessionClean is the kernel mount option that makes the client
+	// reconnect to the cluster after it was blocklisted, dropping its dirty
+	// data. Only mounts with this option are remounted.
+	recoverSessionClean = "recover_session=clean"
+)
+
+// isStaleMountError returns true if err is returned by the kernel client for
+// a mount that lost its session with the MDS.
+func isStaleMountError(err error) bool {
+	return errors.Is(err, syscall.ENOTCONN) || errors.Is(err, syscall.ESTALE)
+}
+
+// hasRecoverSessionClean returns true if the comma separated mount options
+// contain recover_session=clean.
+func hasRecoverSessionClean(mountOptions string) bool {
+	for _, opt := range strings.Split(mountOptions, ",") {
+		if strings.TrimSpace(opt) == recoverSessionClean {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canRemount returns true if the stale mount of the volume may be remounted,
+// which is only the case for kernel mounts with recover_session=clean.
+func (ns *NodeServer) canRemount(mnt mounter.VolumeMounter, volOptions *store.VolumeOptions) bool {
+	if _, isKernel := mnt.(*mounter.KernelMounter); !isKernel || !ns.autoRemount {
+		return false
+	}
+
+	return hasRecoverSessionClean(volOptions.KernelMountOptions)
+}
+
+// addRemountAttempt counts an attempt to remount the volume, and returns the
+// number of the attempt. It returns 0 if the volume was remounted
+// maxRemountAttempts times without success.
+func (ns *NodeServer) addRemountAttempt(volID string) int {
+	ns.remountAttemptsLock.Lock()
+	defer ns.remountAttemptsLock.Unlock()
+
+	if ns.remountAttempts == nil {
+		ns.remountAttempts = make(map[string]int)
+	}
+	if ns.remountAttempts[volID] >= maxRemountAttempts {
+		return 0
+	}
+	ns.remountAttempts[volID]++
+
+	return ns.remountAttempts[volID]
+}
+
+// resetRemountAttempts forgets the remount attempts of the volume, after it
+// was remounted or unstaged.
+func (ns *NodeServer) resetRemountAttempts(volID string) {
+	ns.remountAttemptsLock.Lock()
+	defer ns.remountAttemptsLock.Unlock()
+
+	delete(ns.remountAttempts, volID)
+}
+
+// tryRemountStaleVolume unmounts the stale kernel mount of the volume from
+// the staging path, mounts it again, and bind-mounts it again to all target
+// paths it was bind-mounted to. The volume lock is taken, so that the remount
+// does not race with NodeStageVolume, NodeUnstageVolume, NodePublishVolume or
+// NodeUnpublishVolume.
+//
+// The containers that are running keep the stale mount in their own mount
+// namespace, only the containers that are started after the remount use the
+// new mount.
+func (ns *NodeServer) tryRemountStaleVolume(
+	ctx context.Context,
+	volumeID, targetPath, stagingTargetPath string,
+) error {
+	if stagingTargetPath == "" {
+		return errors.New("the staging target path is needed to remount the volume")
+	}
+
+	if acquired := ns.VolumeLocks.TryAcquire(volumeID); !acquired {
+		return fmt.Errorf(util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer ns.VolumeLocks.Release(volumeID)
+
+	volID := fsutil.VolumeID(volumeID)
+	nsMountinfo, err := fsutil.GetNodeStageMountinfo(volID)
+	if err != nil {
+		return err
+	}
+	if nsMountinfo == nil || nsMountinfo.VolumeContext == nil {
+		return fmt.Errorf("volume %s was not staged with %s and --cephfs-auto-remount", volumeID, recoverSessionClean)
+	}
+
+	volOptions, err := ns.getVolumeOptions(ctx, volID, nsMountinfo.VolumeContext, nsMountinfo.Secrets)
+	if err != nil {
+		return err
+	}
+	defer volOptions.Destroy()
+
+	err = setClusterOptions(volOptions)
+	if err != nil {
+		return err
+	}
+
+	mnt, err := mounter.New(volOptions)
+	if err != nil {
+		return err
+	}
+	if _, isKernel := mnt.(*mounter.KernelMounter); !isKernel {
+		return fmt.Errorf("volume %s is not mounted with the kernel client", volumeID)
+	}
+
+	attempt := ns.addRemountAttempt(volumeID)
+	if attempt == 0 {
+		return fmt.Errorf("volume %s was not remounted after %d attempts, not trying again",
+			volumeID, maxRemountAttempts)
+	}
+	log.WarningLog(ctx, "cephfs: remounting stale volume %s at %s (attempt %d of %d)",
+		volumeID, stagingTargetPath, attempt, maxRemountAttempts)
+
+	mis, err := util.ReadMountInfoForProc("self")
+	if err != nil {
+		return err
+	}
+	targets := stagingBindMounts(stagingTargetPath, targetPath, mis)
+
+	// unmounts the bind mounts of the volume too
+	err = mounter.UnmountAll(ctx, stagingTargetPath)
+	if err != nil {
+		return err
+	}
+
+	err = ns.mount(ctx, mnt, volOptions, volID, stagingTargetPath, nsMountinfo.Secrets, nsMountinfo.VolumeCapability)
+	if err != nil {
+		return err
+	}
+	err = maybeUnlockFileEncryption(ctx, volOptions, stagingTargetPath, volID)
+	if err != nil {
+		return err
+	}
+
+	source := stagingTargetPath
+	if volOptions.IsEncrypted() {
+		source = fscrypt.AppendEncyptedSubdirectory(stagingTargetPath)
+	}
+	for _, target := range targets {
+		mountOptions := csicommon.ConstructMountOptions([]string{"bind", "_netdev"}, nsMountinfo.VolumeCapability)
+		if target.readOnly {
+			mountOptions = append(mountOptions, "ro")
+		}
+		err = mounter.BindMount(ctx, source, target.path, target.readOnly, mountOptions)
+		if err != nil {
+			return err
+		}
+	}
+
+	ns.resetRemountAttempts(volumeID)
+	log.DefaultLog("cephfs: remounted stale volume %s at %s and %d target paths",
+		volumeID, stagingTargetPath, len(targets))
+
+	return nil
+}
+
+// bindMount is a target path that the staging path of a volume is
+// bind-mounted to.
+type bindMount struct {
+	path     string
+	readOnly bool
+}
+
+// stagingBindMounts returns the target paths in mis that the staging path is
+// bind-mounted to, which are all unmounted by mounter.UnmountAll. These are
+// the mounts of the same filesystem, with a root at or below the root of the
+// staging mount. targetPath is always returned, even when it is not found.
+func stagingBindMounts(stagingTargetPath, targetPath string, mis []mountutil.MountInfo) []bindMount {
+	idx := -1
+	for i := range mis {
+		if mis[i].MountPoint == stagingTargetPath {
+			idx = i
+		}
+	}
+
+	targets := []bindMount{}
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			targets = append(targets, bindMount{path: path, readOnly: isReadOnlyMountpoint(path, mis)})
+		}
+	}
+	if idx != -1 {
+		staging := mis[idx]
+		for i := range mis {
+			mi := mis[i]
+			if mi.Major != staging.Major || mi.Minor != staging.Minor || !isSubPath(mi.Root, staging.Root) {
+				continue
+			}
+			if isSubPath(mi.MountPoint, stagingTargetPath) {
+				continue
+			}
+			add(mi.MountPoint)
+		}
+	}
+	add(targetPath)
+
+	return targets
+}
+
+// isSubPath returns true if path is dir, or below dir.
+func isSubPath(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// isReadOnlyMountpoint returns true if the last mount at mountpoint in mis
+// has the "ro" mount option.
+func isReadOnlyMountpoint(mountpoint string, mis []mountutil.MountInfo) bool {
+	readOnly := false
+	for i := range mis {
+		if mis[i].MountPoint == mountpoint {
+			readOnly = csicommon.MountOptionContains(mis[i].MountOptions, "ro")
+		}
+	}
+
+	return readOnly
+}
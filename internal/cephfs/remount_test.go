@@ -0,0 +1,130 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cephfs
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	mountutil "k8s.io/mount-utils"
+)
+
+func TestIsStaleMountError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"ENOTCONN", &os.PathError{Op: "stat", Path: "/mnt", Err: syscall.ENOTCONN}, true},
+		{"ESTALE", &os.PathError{Op: "stat", Path: "/mnt", Err: syscall.ESTALE}, true},
+		{"wrapped ENOTCONN", fmt.Errorf("failed: %w", syscall.ENOTCONN), true},
+		{"EIO", &os.PathError{Op: "stat", Path: "/mnt", Err: syscall.EIO}, false},
+		{"not exist", os.ErrNotExist, false},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, ts.want, isStaleMountError(ts.err))
+		})
+	}
+}
+
+func TestHasRecoverSessionClean(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		options string
+		want    bool
+	}{
+		{"", false},
+		{"recover_session=clean", true},
+		{"ms_mode=secure, recover_session=clean", true},
+		{"recover_session=no", false},
+		{"wsync,recover_session=cleanup", false},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.options, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, ts.want, hasRecoverSessionClean(ts.options))
+		})
+	}
+}
+
+func TestAddRemountAttempt(t *testing.T) {
+	t.Parallel()
+	ns := &NodeServer{}
+
+	for i := 1; i <= maxRemountAttempts; i++ {
+		assert.Equal(t, i, ns.addRemountAttempt("vol-1"))
+	}
+	// the attempts are bounded
+	assert.Equal(t, 0, ns.addRemountAttempt("vol-1"))
+	// other volumes are counted separately
+	assert.Equal(t, 1, ns.addRemountAttempt("vol-2"))
+
+	ns.resetRemountAttempts("vol-1")
+	assert.Equal(t, 1, ns.addRemountAttempt("vol-1"))
+}
+
+func TestIsReadOnlyMountpoint(t *testing.T) {
+	t.Parallel()
+	mis := []mountutil.MountInfo{
+		{MountPoint: "/staging", MountOptions: []string{"rw", "relatime"}},
+		{MountPoint: "/target-rw", MountOptions: []string{"rw", "relatime"}},
+		{MountPoint: "/target-ro", MountOptions: []string{"ro", "relatime"}},
+		// mounted over with a read-write mount
+		{MountPoint: "/target-over", MountOptions: []string{"ro"}},
+		{MountPoint: "/target-over", MountOptions: []string{"rw"}},
+	}
+
+	assert.False(t, isReadOnlyMountpoint("/target-rw", mis))
+	assert.True(t, isReadOnlyMountpoint("/target-ro", mis))
+	assert.False(t, isReadOnlyMountpoint("/target-over", mis))
+	assert.False(t, isReadOnlyMountpoint("/unknown", mis))
+}
+
+func TestStagingBindMounts(t *testing.T) {
+	t.Parallel()
+	root := "/volumes/csi/csi-vol-1/uuid"
+	mis := []mountutil.MountInfo{
+		{Major: 0, Minor: 50, Root: root, MountPoint: "/staging", MountOptions: []string{"rw"}},
+		{Major: 0, Minor: 50, Root: root, MountPoint: "/pod-1/mount", MountOptions: []string{"rw"}},
+		{Major: 0, Minor: 50, Root: root, MountPoint: "/pod-2/mount", MountOptions: []string{"ro"}},
+		// encrypted volumes are bind-mounted from a subdirectory
+		{Major: 0, Minor: 50, Root: root + "/ceph-csi-encrypted", MountPoint: "/pod-3/mount", MountOptions: []string{"rw"}},
+		// another volume that shares the superblock
+		{Major: 0, Minor: 50, Root: "/volumes/csi/csi-vol-10/uuid", MountPoint: "/pod-4/mount", MountOptions: []string{"rw"}},
+		// another filesystem
+		{Major: 0, Minor: 51, Root: root, MountPoint: "/pod-5/mount", MountOptions: []string{"rw"}},
+	}
+
+	targets := stagingBindMounts("/staging", "/pod-1/mount", mis)
+	assert.Equal(t, []bindMount{
+		{path: "/pod-1/mount"},
+		{path: "/pod-2/mount", readOnly: true},
+		{path: "/pod-3/mount"},
+	}, targets)
+
+	// the target path of the request is remounted when it is not found
+	targets = stagingBindMounts("/unknown", "/pod-1/mount", mis)
+	assert.Equal(t, []bindMount{{path: "/pod-1/mount"}}, targets)
+}
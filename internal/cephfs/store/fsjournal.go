@@ -184,10 +184,21 @@ func CheckVolExists(ctx context.Context,
 		}
 	}
 
-	// check if topology constraints match what is found
-	// TODO: we need an API to fetch subvolume attributes (size/datapool and others), based
-	// on which we can evaluate which topology this belongs to.
-	// TODO: CephFS topology support is postponed till we get the same
+	// check if topology constraints match the data pool of the subvolume
+	if volOptions.TopologyPools != nil && imageData.ImageAttributes.BackingSnapshotID == "" {
+		var info *core.Subvolume
+		info, err = vol.GetSubVolumeInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		_, _, volOptions.Topology, err = util.MatchPoolAndTopology(volOptions.TopologyPools,
+			volOptions.TopologyRequirement, info.DataPool)
+		if err != nil {
+			return nil, err
+		}
+		volOptions.Pool = info.DataPool
+	}
+
 	// TODO: size checks
 
 	// found a volume already available, process and return it!
@@ -60,6 +60,8 @@ type VolumeOptions struct {
 	TopologyRequirement  *csi.TopologyRequirement
 	Topology             map[string]string
 	FscID                int64
+	// Earmark claims the subvolume for a usage (nfs or smb)
+	Earmark string
 
 	// Encryption provides access to optional VolumeEncryption functions
 	Encryption *util.VolumeEncryption
@@ -265,6 +267,25 @@ func NewVolumeOptions(
 		return nil, err
 	}
 
+	if err = extractOptionalOption(&opts.Earmark, "earmark", volOptions); err != nil {
+		return nil, err
+	}
+
+	if err = core.ValidateEarmark(opts.Earmark); err != nil {
+		return nil, err
+	}
+
+	groupPin, err := util.CephFSSubvolumeGroupPin(util.CsiConfigFile, opts.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	if err = core.ValidateSubVolumeGroupPin(groupPin); err != nil {
+		return nil, err
+	}
+	if groupPin.Type != "" {
+		opts.GroupPin = &groupPin
+	}
+
 	if err = opts.InitKMS(ctx, volOptions, req.GetSecrets()); err != nil {
 		return nil, fmt.Errorf("failed to init KMS: %w", err)
 	}
@@ -293,19 +314,13 @@ func NewVolumeOptions(
 		return nil, err
 	}
 
-	// store topology information from the request
-	opts.TopologyPools, opts.TopologyRequirement, err = util.GetTopologyFromRequest(req)
+	// store topology information from the request, the data pool of the
+	// subvolume is selected from the pools in ReserveVol()
+	opts.TopologyPools, opts.TopologyRequirement, err = util.GetDataPoolTopologyFromRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: we need an API to fetch subvolume attributes (size/datapool and others), based
-	// on which we can evaluate which topology this belongs to.
-	// CephFS tracker: https://tracker.ceph.com/issues/44277
-	if opts.TopologyPools != nil {
-		return nil, errors.New("topology based provisioning is not supported for CephFS backed volumes")
-	}
-
 	opts.ProvisionVolume = true
 
 	if opts.BackingSnapshot {
@@ -313,6 +328,12 @@ func NewVolumeOptions(
 			return nil, errors.New("backingSnapshot option requires snapshot volume source")
 		}
 
+		// the data of snapshot backed volumes stays in the pool of the
+		// parent subvolume
+		if opts.TopologyPools != nil {
+			return nil, errors.New("topology constrained data pools are not supported for snapshot backed volumes")
+		}
+
 		opts.BackingSnapshotID = req.GetVolumeContentSource().GetSnapshot().GetSnapshotId()
 
 		err = opts.populateVolumeOptionsFromBackingSnapshot(ctx, cr, req.GetSecrets(), clusterName, setMetadata)
@@ -497,6 +518,15 @@ func (vo *VolumeOptions) populateVolumeOptionsFromSubvolume(
 		vo.RootPath, err = vol.GetVolumeRootPathCeph(ctx)
 	}
 
+	// subvolume info is not available for clones that did not complete
+	// yet, return the clone state so that callers can act on it
+	if err != nil && !errors.Is(err, cerrors.ErrVolumeNotFound) {
+		cloneState, cloneErr := vol.GetCloneState(ctx)
+		if cloneErr == nil && cerrors.IsCloneRetryError(cloneState.ToError()) {
+			return cloneState.ToError()
+		}
+	}
+
 	return err
 }
 
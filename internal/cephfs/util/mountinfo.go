@@ -29,7 +29,8 @@ import (
 )
 
 // This file provides functionality to store various mount information
-// in a file. It's currently used to restore ceph-fuse mounts.
+// in a file. It's currently used to restore ceph-fuse mounts and to remount
+// stale kernel mounts.
 // Mount info is stored in `/csi/mountinfo`.
 
 const (
@@ -44,6 +45,7 @@ type nodeStageMountinfoRecord struct {
 	VolumeCapabilityProtoJSON string            `json:",omitempty"`
 	MountOptions              []string          `json:",omitempty"`
 	Secrets                   map[string]string `json:",omitempty"`
+	VolumeContext             map[string]string `json:",omitempty"`
 }
 
 // NodeStageMountinfo describes mountinfo of a volume.
@@ -51,6 +53,7 @@ type NodeStageMountinfo struct {
 	VolumeCapability *csi.VolumeCapability
 	Secrets          map[string]string
 	MountOptions     []string
+	VolumeContext    map[string]string
 }
 
 func fmtNodeStageMountinfoFilename(volID VolumeID) string {
@@ -67,6 +70,7 @@ func (mi *NodeStageMountinfo) toNodeStageMountinfoRecord() (*nodeStageMountinfoR
 		VolumeCapabilityProtoJSON: string(bs),
 		MountOptions:              mi.MountOptions,
 		Secrets:                   mi.Secrets,
+		VolumeContext:             mi.VolumeContext,
 	}, nil
 }
 
@@ -80,6 +84,7 @@ func (r *nodeStageMountinfoRecord) toNodeStageMountinfo() (*NodeStageMountinfo,
 		VolumeCapability: volCapability,
 		MountOptions:     r.MountOptions,
 		Secrets:          r.Secrets,
+		VolumeContext:    r.VolumeContext,
 	}, nil
 }
 
@@ -68,6 +68,13 @@ const (
 	// (optional) StartTime is the time the snapshot schedule
 	// begins, can be specified using the ISO 8601 time format.
 	schedulingStartTimeKey = "schedulingStartTime"
+
+	// mirrorStatusStalenessKey to get the mirrorStatusStaleness from the
+	// parameters.
+	// (optional) Maximum age of the mirroring status of a secondary image,
+	// in the form of a duration like "10m". A non-forced promote is refused
+	// when rbd-mirror did not update the status within this window.
+	mirrorStatusStalenessKey = "mirrorStatusStaleness"
 )
 
 // ReplicationServer struct of rbd CSI driver with supported methods of Replication
@@ -202,6 +209,66 @@ func validateSchedulingInterval(interval string) error {
 	return errors.New("interval specified without d, h, m suffix")
 }
 
+// getMirrorStatusStaleness returns the maximum allowed age of the mirroring
+// status from the parameters. Zero is returned when it is not set.
+func getMirrorStatusStaleness(parameters map[string]string) (time.Duration, error) {
+	val, ok := parameters[mirrorStatusStalenessKey]
+	if !ok {
+		return 0, nil
+	}
+
+	staleness, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %s %q: %v", mirrorStatusStalenessKey, val, err)
+	}
+	if staleness <= 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "%s %q must be positive", mirrorStatusStalenessKey, val)
+	}
+
+	return staleness, nil
+}
+
+// checkMirrorStatusStaleness verifies that rbd-mirror updated the local
+// mirroring status of a secondary image within the staleness window, so that
+// promoting it does not silently lose the writes that were never replayed.
+// When force is set the check does not fail, the lag is logged instead.
+func checkMirrorStatusStaleness(
+	ctx context.Context,
+	mirrorStatus *librbd.GlobalMirrorImageStatus,
+	staleness time.Duration,
+	force bool,
+	now time.Time,
+) error {
+	localStatus, err := mirrorStatus.LocalStatus()
+	if err != nil {
+		if force {
+			log.WarningLog(ctx, "no local mirroring status found for image %s, force promoting it: %v",
+				mirrorStatus.Name, err)
+
+			return nil
+		}
+
+		return status.Errorf(codes.FailedPrecondition,
+			"failed to get local mirroring status of image %s: %v", mirrorStatus.Name, err)
+	}
+
+	lag := now.Sub(time.Unix(localStatus.LastUpdate, 0))
+	if lag <= staleness {
+		return nil
+	}
+
+	if force {
+		log.WarningLog(ctx, "mirroring status of image %s was last updated %s ago, force promoting it",
+			mirrorStatus.Name, lag.Truncate(time.Second))
+
+		return nil
+	}
+
+	return status.Errorf(codes.FailedPrecondition,
+		"mirroring status of image %s was last updated %s ago, exceeding %s=%s",
+		mirrorStatus.Name, lag.Truncate(time.Second), mirrorStatusStalenessKey, staleness)
+}
+
 // EnableVolumeReplication extracts the RBD volume information from the
 // volumeID, If the image is present it will enable the mirroring based on the
 // user provided information.
@@ -258,6 +325,16 @@ func (rs *ReplicationServer) EnableVolumeReplication(ctx context.Context,
 	}
 
 	if mirroringInfo.State != librbd.MirrorImageEnabled {
+		err = rbdVol.ValidateMirroringMode()
+		if err != nil {
+			log.ErrorLog(ctx, err.Error())
+			if errors.Is(err, corerbd.ErrMirroringDisabled) {
+				return nil, status.Error(codes.FailedPrecondition, err.Error())
+			}
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
 		err = rbdVol.EnableImageMirroring(mirroringMode)
 		if err != nil {
 			log.ErrorLog(ctx, err.Error())
@@ -351,6 +428,11 @@ func (rs *ReplicationServer) PromoteVolume(ctx context.Context,
 	}
 	defer cr.DeleteCredentials()
 
+	staleness, err := getMirrorStatusStaleness(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
 	if acquired := rs.VolumeLocks.TryAcquire(volumeID); !acquired {
 		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, volumeID)
 
@@ -390,6 +472,22 @@ func (rs *ReplicationServer) PromoteVolume(ctx context.Context,
 
 	// promote secondary to primary
 	if !mirroringInfo.Primary {
+		if staleness != 0 {
+			mirrorStatus, sErr := rbdVol.GetImageMirroringStatus()
+			if sErr != nil {
+				log.ErrorLog(ctx, sErr.Error())
+
+				return nil, status.Error(codes.Internal, sErr.Error())
+			}
+
+			err = checkMirrorStatusStaleness(ctx, mirrorStatus, staleness, req.GetForce(), time.Now())
+			if err != nil {
+				log.ErrorLog(ctx, err.Error())
+
+				return nil, err
+			}
+		}
+
 		if req.GetForce() {
 			// workaround for https://github.com/ceph/ceph-csi/issues/2736
 			// TODO: remove this workaround when the issue is fixed
@@ -718,7 +816,16 @@ func (rs *ReplicationServer) GetVolumeReplicationInfo(ctx context.Context,
 		return nil, status.Errorf(codes.Internal, "failed to get remote status: %v", err)
 	}
 
+	// a stale status of an unreachable peer site must not be reported as
+	// a successful sync.
+	if !remoteStatus.Up {
+		return nil, status.Errorf(codes.Unavailable,
+			"remote site %q of %s is unreachable, last update %s",
+			remoteStatus.MirrorUUID, volumeID, time.Unix(remoteStatus.LastUpdate, 0).UTC())
+	}
+
 	description := remoteStatus.Description
+	logReplayProgress(ctx, volumeID, description)
 	lastSyncTime, err := getLastSyncTime(description)
 	if err != nil {
 		if errors.Is(err, corerbd.ErrLastSyncTimeNotFound) {
@@ -756,39 +863,100 @@ func RemoteStatus(gmis *librbd.GlobalMirrorImageStatus) (librbd.SiteMirrorImageS
 	return ss, err
 }
 
-// This function gets the local snapshot time from the description
-// of localStatus and converts it into required type.
-func getLastSyncTime(description string) (*timestamppb.Timestamp, error) {
-	// Format of the description will be as followed:
-	// description = "replaying,{"bytes_per_second":0.0,
-	// "bytes_per_snapshot":149504.0,"local_snapshot_timestamp":1662655501
-	// ,"remote_snapshot_timestamp":1662655501}"
-	// In case there is no local snapshot timestamp return an error as the
-	// LastSyncTime is required.
+// replayStatus contains the snapshot based mirroring details that
+// rbd-mirror reports as JSON in the description of a site status.
+type replayStatus struct {
+	BytesPerSecond     float64 `json:"bytes_per_second"`
+	BytesPerSnapshot   float64 `json:"bytes_per_snapshot"`
+	LocalSnapshotTime  int64   `json:"local_snapshot_timestamp"`
+	RemoteSnapshotTime int64   `json:"remote_snapshot_timestamp"`
+	// ReplayState and SyncingPercent are only reported by recent Ceph
+	// versions, ReplayState is "syncing" while a snapshot is being copied.
+	ReplayState    string `json:"replay_state"`
+	SyncingPercent int64  `json:"syncing_percent"`
+}
+
+// replayStateSyncing is the replay state reported while rbd-mirror copies a
+// mirror snapshot to the peer site.
+const replayStateSyncing = "syncing"
+
+// parseReplayStatus splits the description of a site status into the state
+// and the replay status. The format of the description will be as followed:
+// description = "replaying,{"bytes_per_second":0.0,
+// "bytes_per_snapshot":149504.0,"local_snapshot_timestamp":1662655501
+// ,"remote_snapshot_timestamp":1662655501}"
+// ErrLastSyncTimeNotFound is returned when the description does not carry
+// any replay status.
+func parseReplayStatus(description string) (string, *replayStatus, error) {
 	if description == "" {
-		return nil, fmt.Errorf("empty description: %w", corerbd.ErrLastSyncTimeNotFound)
+		return "", nil, fmt.Errorf("empty description: %w", corerbd.ErrLastSyncTimeNotFound)
 	}
 	splittedString := strings.SplitN(description, ",", 2)
 	if len(splittedString) == 1 {
-		return nil, fmt.Errorf("no local snapshot timestamp: %w", corerbd.ErrLastSyncTimeNotFound)
+		return splittedString[0], nil, fmt.Errorf("no local snapshot timestamp: %w", corerbd.ErrLastSyncTimeNotFound)
 	}
-	type localStatus struct {
-		LocalSnapshotTime int64 `json:"local_snapshot_timestamp"`
+
+	rs := &replayStatus{}
+	err := json.Unmarshal([]byte(splittedString[1]), rs)
+	if err != nil {
+		return splittedString[0], nil, fmt.Errorf("failed to unmarshal description: %w", err)
 	}
 
-	var localSnapTime localStatus
-	err := json.Unmarshal([]byte(splittedString[1]), &localSnapTime)
+	return splittedString[0], rs, nil
+}
+
+// progress returns the completion percentage of the mirror snapshot that is
+// currently synced to the peer site. When the replay state is not reported,
+// the sync is considered complete once the local snapshot caught up with the
+// remote snapshot.
+func (rs *replayStatus) progress() int64 {
+	if rs.ReplayState == replayStateSyncing {
+		return rs.SyncingPercent
+	}
+	if rs.LocalSnapshotTime != 0 && rs.LocalSnapshotTime >= rs.RemoteSnapshotTime {
+		return 100
+	}
+
+	return 0
+}
+
+// logReplayProgress logs the sync progress of the volume, the replication
+// info response has no fields to carry it to the caller.
+func logReplayProgress(ctx context.Context, volumeID, description string) {
+	state, rs, err := parseReplayStatus(description)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal description: %w", err)
+		log.DebugLog(ctx, "no replay status found for %s in %q: %v", volumeID, description, err)
+
+		return
+	}
+
+	log.UsefulLog(
+		ctx,
+		"replication of %s is %q, %d%% of the last snapshot synced, bytes per snapshot=%.0f, bytes per second=%.0f",
+		volumeID,
+		state,
+		rs.progress(),
+		rs.BytesPerSnapshot,
+		rs.BytesPerSecond)
+}
+
+// This function gets the local snapshot time from the description
+// of localStatus and converts it into required type.
+func getLastSyncTime(description string) (*timestamppb.Timestamp, error) {
+	// In case there is no local snapshot timestamp return an error as the
+	// LastSyncTime is required.
+	_, rs, err := parseReplayStatus(description)
+	if err != nil {
+		return nil, err
 	}
 
 	// If the json unmarsal is successful but the local snapshot time is 0, we
 	// need to consider it as an error as the LastSyncTime is required.
-	if localSnapTime.LocalSnapshotTime == 0 {
+	if rs.LocalSnapshotTime == 0 {
 		return nil, fmt.Errorf("empty local snapshot timestamp: %w", corerbd.ErrLastSyncTimeNotFound)
 	}
 
-	lastUpdateTime := time.Unix(localSnapTime.LocalSnapshotTime, 0)
+	lastUpdateTime := time.Unix(rs.LocalSnapshotTime, 0)
 	lastSyncTime := timestamppb.New(lastUpdateTime)
 
 	return lastSyncTime, nil
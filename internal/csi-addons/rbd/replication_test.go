@@ -27,6 +27,8 @@ import (
 
 	librbd "github.com/ceph/go-ceph/rbd"
 	"github.com/ceph/go-ceph/rbd/admin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -494,3 +496,171 @@ func TestValidateLastSyncTime(t *testing.T) {
 		})
 	}
 }
+
+func TestParseReplayStatus(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name             string
+		description      string
+		state            string
+		bytesPerSnapshot float64
+		progress         int64
+		expectedErr      string
+	}{
+		{
+			"syncing description",
+			//nolint:lll // sample output cannot be split into multiple lines.
+			`replaying,{"bytes_per_second":1048576.0,"bytes_per_snapshot":10485760.0,"local_snapshot_timestamp":1662655501,"remote_snapshot_timestamp":1662655801,"replay_state":"syncing","syncing_percent":42,"syncing_snapshot_timestamp":1662655801}`,
+			"replaying",
+			10485760,
+			42,
+			"",
+		},
+		{
+			"replaying description of an idle image",
+			//nolint:lll // sample output cannot be split into multiple lines.
+			`replaying,{"bytes_per_second":0.0,"bytes_per_snapshot":149504.0,"local_snapshot_timestamp":1662655501,"remote_snapshot_timestamp":1662655501,"replay_state":"idle"}`,
+			"replaying",
+			149504,
+			100,
+			"",
+		},
+		{
+			"replaying description without replay state",
+			//nolint:lll // sample output cannot be split into multiple lines.
+			`replaying,{"bytes_per_second":0.0,"bytes_per_snapshot":149504.0,"local_snapshot_timestamp":1662655501,"remote_snapshot_timestamp":1662655801}`,
+			"replaying",
+			149504,
+			0,
+			"",
+		},
+		{
+			"stopped description",
+			`stopped`,
+			"stopped",
+			0,
+			0,
+			corerbd.ErrLastSyncTimeNotFound.Error(),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			state, rs, err := parseReplayStatus(tt.description)
+			if state != tt.state {
+				t.Errorf("parseReplayStatus() state = %q, expected %q", state, tt.state)
+			}
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Errorf("parseReplayStatus() error = %v, expected: %v", err, tt.expectedErr)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseReplayStatus() returned unexpected error: %v", err)
+			}
+			if rs.BytesPerSnapshot != tt.bytesPerSnapshot {
+				t.Errorf("BytesPerSnapshot = %v, expected %v", rs.BytesPerSnapshot, tt.bytesPerSnapshot)
+			}
+			if p := rs.progress(); p != tt.progress {
+				t.Errorf("progress() = %d, expected %d", p, tt.progress)
+			}
+		})
+	}
+}
+
+func TestCheckMirrorStatusStaleness(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1662655801, 0)
+	staleness := 5 * time.Minute
+	fresh := librbd.GlobalMirrorImageStatus{
+		Name: "fresh",
+		SiteStatuses: []librbd.SiteMirrorImageStatus{
+			{
+				MirrorUUID: "",
+				State:      librbd.MirrorImageStatusStateReplaying,
+				LastUpdate: now.Add(-time.Minute).Unix(),
+				Up:         true,
+			},
+		},
+	}
+	stale := librbd.GlobalMirrorImageStatus{
+		Name: "stale",
+		SiteStatuses: []librbd.SiteMirrorImageStatus{
+			{
+				MirrorUUID: "",
+				State:      librbd.MirrorImageStatusStateReplaying,
+				LastUpdate: now.Add(-time.Hour).Unix(),
+				Up:         true,
+			},
+		},
+	}
+	missing := librbd.GlobalMirrorImageStatus{
+		Name: "missing",
+		SiteStatuses: []librbd.SiteMirrorImageStatus{
+			{
+				MirrorUUID: "remote",
+				State:      librbd.MirrorImageStatusStateUnknown,
+				LastUpdate: now.Unix(),
+				Up:         true,
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		mirrorStatus librbd.GlobalMirrorImageStatus
+		force        bool
+		wantCode     codes.Code
+		wantMessage  string
+	}{
+		{"fresh status", fresh, false, codes.OK, ""},
+		{"stale status", stale, false, codes.FailedPrecondition, "last updated 1h0m0s ago"},
+		{"stale status with force", stale, true, codes.OK, ""},
+		{"missing status", missing, false, codes.FailedPrecondition, "failed to get local mirroring status"},
+		{"missing status with force", missing, true, codes.OK, ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkMirrorStatusStaleness(context.TODO(), &tt.mirrorStatus, staleness, tt.force, now)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("checkMirrorStatusStaleness() code = %v, want %v (error: %v)", code, tt.wantCode, err)
+			}
+			if tt.wantMessage != "" && !strings.Contains(err.Error(), tt.wantMessage) {
+				t.Errorf("checkMirrorStatusStaleness() error = %v, want message %q", err, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestGetMirrorStatusStaleness(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		want       time.Duration
+		wantErr    bool
+	}{
+		{"not set", map[string]string{}, 0, false},
+		{"valid duration", map[string]string{mirrorStatusStalenessKey: "10m"}, 10 * time.Minute, false},
+		{"invalid duration", map[string]string{mirrorStatusStalenessKey: "10"}, 0, true},
+		{"negative duration", map[string]string{mirrorStatusStalenessKey: "-1m"}, 0, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := getMirrorStatusStaleness(tt.parameters)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getMirrorStatusStaleness() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getMirrorStatusStaleness() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
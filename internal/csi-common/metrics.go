@@ -0,0 +1,72 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// rpcMetricsEnabled is set by EnableRPCMetrics.
+	rpcMetricsEnabled bool
+
+	// the labels are limited to the method and the status code, so that the
+	// number of series does not grow with the number of volumes.
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "csi",
+		Name:      "rpc_duration_seconds",
+		Help:      "Duration of the gRPC calls handled by the driver",
+		// from 10ms up to ~5.5 minutes, provisioning can be slow
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+	}, []string{"method"})
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "csi",
+		Name:      "rpc_requests_total",
+		Help:      "Number of gRPC calls handled by the driver by status code",
+	}, []string{"method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(rpcDuration)
+	prometheus.MustRegister(rpcRequests)
+}
+
+// EnableRPCMetrics adds the interceptor that records the duration and the
+// status code of the gRPC calls to the servers that are created afterwards.
+func EnableRPCMetrics() {
+	rpcMetricsEnabled = true
+}
+
+func rpcMetrics(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	rpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	rpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+
+	return resp, err
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRPCMetrics(t *testing.T) {
+	t.Parallel()
+
+	const method = "/csi.v1.Test/RPCMetrics"
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if req == nil {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+
+		return req, nil
+	}
+
+	for _, req := range []interface{}{"ok", "ok", nil} {
+		resp, err := rpcMetrics(context.Background(), req, info, handler)
+		assert.Equal(t, req, resp)
+		if req == nil {
+			assert.Equal(t, codes.NotFound, status.Code(err))
+		}
+	}
+
+	assert.InDelta(t, 2, testutil.ToFloat64(rpcRequests.WithLabelValues(method, codes.OK.String())), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(rpcRequests.WithLabelValues(method, codes.NotFound.String())), 0)
+
+	// scrape the registry that is served on the metrics endpoint
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	var samples uint64
+	for _, family := range families {
+		if family.GetName() != "csi_rpc_duration_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				assert.Equal(t, "method", label.GetName(), "unexpected label on the duration histogram")
+				if label.GetValue() == method {
+					samples = m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	assert.Equal(t, uint64(3), samples)
+}
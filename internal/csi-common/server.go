@@ -109,6 +109,7 @@ func (s *nonBlockingGRPCServer) serve(endpoint, hstOptions string, srv Servers,
 
 	server := grpc.NewServer(opts...)
 	s.server = server
+	registerServer(server)
 
 	if srv.IS != nil {
 		csi.RegisterIdentityServer(server, srv.IS)
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+// stoppableServer is the part of grpc.Server that is used to stop it.
+type stoppableServer interface {
+	// GracefulStop stops accepting new calls and waits for the running
+	// calls to finish.
+	GracefulStop()
+	// Stop closes all connections and cancels the running calls.
+	Stop()
+}
+
+var (
+	// grpcServers are the gRPC servers that are stopped on shutdown.
+	grpcServersLock sync.Mutex
+	grpcServers     []stoppableServer
+)
+
+// registerServer adds a gRPC server that is stopped on shutdown.
+func registerServer(server stoppableServer) {
+	grpcServersLock.Lock()
+	defer grpcServersLock.Unlock()
+
+	grpcServers = append(grpcServers, server)
+}
+
+// ShutdownOnSignal stops the gRPC servers when the process receives SIGTERM
+// or SIGINT. The servers stop accepting new calls, and the running calls get
+// gracePeriod to finish. cleanup is called once all calls finished, before the
+// process exits. The calls that are still running after the grace period are
+// logged, and the process exits without calling cleanup.
+func ShutdownOnSignal(gracePeriod time.Duration, cleanup func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+		log.DefaultLog("received signal %s, stopping the gRPC servers", sig)
+
+		grpcServersLock.Lock()
+		servers := grpcServers
+		grpcServersLock.Unlock()
+
+		if !drainServers(servers, gracePeriod, watchdog) {
+			os.Exit(1)
+		}
+		cleanup()
+		os.Exit(0)
+	}()
+}
+
+// drainServers gracefully stops the servers, and waits up to gracePeriod for
+// the running calls to finish. When the grace period passes, the calls that
+// are still running are logged and the servers are stopped forcefully. It
+// returns true if all calls finished.
+func drainServers(servers []stoppableServer, gracePeriod time.Duration, w *slowRPCWatchdog) bool {
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(s stoppableServer) {
+			defer wg.Done()
+			s.GracefulStop()
+		}(server)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(gracePeriod)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		log.DefaultLog("all gRPC calls finished, stopped the gRPC servers")
+
+		return true
+	case <-timer.C:
+	}
+
+	for _, call := range w.running() {
+		w.warn(call.ctx, "GRPC call %s for volume %q did not finish within the grace period of %s (running for %s)",
+			call.method, call.volumeID, gracePeriod, time.Since(call.start).Round(time.Millisecond))
+	}
+	for _, server := range servers {
+		server.Stop()
+	}
+
+	return false
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// fakeServer handles calls through the watchdog, like a gRPC server.
+type fakeServer struct {
+	w       *slowRPCWatchdog
+	running sync.WaitGroup
+	// stopped is closed by Stop, it cancels the running calls.
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newFakeServer(w *slowRPCWatchdog) *fakeServer {
+	return &fakeServer{w: w, stopped: make(chan struct{})}
+}
+
+// call starts a call to method that takes duration to finish.
+func (s *fakeServer) call(method, volumeID string, duration time.Duration) {
+	s.running.Add(1)
+	started := make(chan struct{})
+	go func() {
+		defer s.running.Done()
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		req := &csi.NodeStageVolumeRequest{VolumeId: volumeID}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(started)
+			select {
+			case <-time.After(duration):
+			case <-s.stopped:
+			}
+
+			return req, nil
+		}
+		_, _ = s.w.intercept(context.Background(), req, info, handler)
+	}()
+	<-started
+}
+
+func (s *fakeServer) GracefulStop() {
+	s.running.Wait()
+}
+
+func (s *fakeServer) Stop() {
+	s.stopOnce.Do(func() { close(s.stopped) })
+	s.running.Wait()
+}
+
+func TestDrainServers(t *testing.T) {
+	t.Parallel()
+
+	t.Run("drained", func(ts *testing.T) {
+		ts.Parallel()
+		recorder := &warnings{}
+		w := &slowRPCWatchdog{warn: recorder.warn}
+		server := newFakeServer(w)
+		server.call("/csi.v1.Node/NodeStageVolume", "vol-1", 50*time.Millisecond)
+
+		assert.True(ts, drainServers([]stoppableServer{server}, time.Minute, w))
+		assert.Empty(ts, w.running())
+		assert.Equal(ts, 0, recorder.count())
+	})
+
+	t.Run("grace period exceeded", func(ts *testing.T) {
+		ts.Parallel()
+		recorder := &warnings{}
+		w := &slowRPCWatchdog{warn: recorder.warn}
+		server := newFakeServer(w)
+		server.call("/csi.v1.Node/NodeStageVolume", "vol-1", 10*time.Millisecond)
+		server.call("/csi.v1.Node/NodeStageVolume", "vol-2", time.Minute)
+
+		start := time.Now()
+		assert.False(ts, drainServers([]stoppableServer{server}, 100*time.Millisecond, w))
+		assert.Less(ts, time.Since(start), 10*time.Second)
+		assert.Empty(ts, w.running())
+
+		require.Equal(ts, 1, recorder.count())
+		msg := recorder.messages[0]
+		assert.True(ts, strings.Contains(msg, "/csi.v1.Node/NodeStageVolume"), msg)
+		assert.True(ts, strings.Contains(msg, `"vol-2"`), msg)
+	})
+}
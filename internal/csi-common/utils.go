@@ -112,7 +112,11 @@ func isReplicationRequest(req interface{}) bool {
 // NewMiddlewareServerOption creates a new grpc.ServerOption that configures a
 // common format for log messages and other gRPC related handlers.
 func NewMiddlewareServerOption(withMetrics bool) grpc.ServerOption {
-	middleWare := []grpc.UnaryServerInterceptor{contextIDInjector, logGRPC, panicHandler}
+	middleWare := []grpc.UnaryServerInterceptor{contextIDInjector, logGRPC, watchdog.intercept}
+	if rpcMetricsEnabled {
+		middleWare = append(middleWare, rpcMetrics)
+	}
+	middleWare = append(middleWare, panicHandler)
 
 	if withMetrics {
 		middleWare = append(middleWare, grpc_prometheus.UnaryServerInterceptor)
@@ -169,6 +173,16 @@ func getReqID(req interface{}) string {
 	return reqID
 }
 
+// getVolumeID returns the volume ID of the request, or an empty string if the
+// request does not carry one.
+func getVolumeID(req interface{}) string {
+	if r, ok := req.(interface{ GetVolumeId() string }); ok {
+		return r.GetVolumeId()
+	}
+
+	return ""
+}
+
 var id uint64
 
 func contextIDInjector(
@@ -182,6 +196,10 @@ func contextIDInjector(
 	if reqID := getReqID(req); reqID != "" {
 		ctx = context.WithValue(ctx, log.ReqID, reqID)
 	}
+	ctx = context.WithValue(ctx, log.MethodKey, info.FullMethod)
+	if volID := getVolumeID(req); volID != "" {
+		ctx = context.WithValue(ctx, log.VolumeIDKey, volID)
+	}
 
 	return handler(ctx, req)
 }
@@ -207,7 +225,7 @@ func logGRPC(
 	}
 	resp, err := handler(ctx, req)
 	if err != nil {
-		klog.Errorf(log.Log(ctx, "GRPC error: %v"), err)
+		log.ErrorLog(ctx, "GRPC error: %v", err)
 	} else {
 		log.TraceLog(ctx, "GRPC response: %s", protosanitizer.StripSecrets(resp))
 	}
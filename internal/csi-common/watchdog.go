@@ -0,0 +1,163 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+var (
+	rpcInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "csi",
+		Name:      "rpc_in_flight",
+		Help:      "Number of gRPC calls that are being handled by the driver",
+	}, []string{"method"})
+
+	// watchdog is used by the gRPC servers that are created afterwards.
+	watchdog = &slowRPCWatchdog{
+		threshold: 2 * time.Minute,
+		interval:  time.Minute,
+		warn:      log.WarningLog,
+	}
+)
+
+func init() {
+	prometheus.MustRegister(rpcInFlight)
+}
+
+// ConfigureSlowRPCWatchdog sets the time after which a warning is logged for
+// a gRPC call that has not returned yet, and the interval at which the
+// warning is repeated. A zero threshold disables the warnings.
+func ConfigureSlowRPCWatchdog(threshold, interval time.Duration) {
+	watchdog.threshold = threshold
+	watchdog.interval = interval
+}
+
+// slowRPCWatchdog counts the gRPC calls in flight, and logs warnings for the
+// calls that take longer than the threshold.
+type slowRPCWatchdog struct {
+	threshold time.Duration
+	interval  time.Duration
+	warn      func(ctx context.Context, message string, args ...interface{})
+
+	// calls are the gRPC calls in flight, by a sequence number.
+	callsLock sync.Mutex
+	calls     map[uint64]*runningCall
+	lastCall  uint64
+}
+
+// runningCall is a gRPC call in flight.
+type runningCall struct {
+	ctx      context.Context
+	method   string
+	volumeID string
+	start    time.Time
+}
+
+// track adds the call to the calls in flight, and returns the function that
+// removes it again.
+func (w *slowRPCWatchdog) track(ctx context.Context, method string, req interface{}) func() {
+	w.callsLock.Lock()
+	defer w.callsLock.Unlock()
+
+	if w.calls == nil {
+		w.calls = make(map[uint64]*runningCall)
+	}
+	w.lastCall++
+	seq := w.lastCall
+	w.calls[seq] = &runningCall{
+		ctx:      ctx,
+		method:   method,
+		volumeID: getVolumeID(req),
+		start:    time.Now(),
+	}
+
+	return func() {
+		w.callsLock.Lock()
+		defer w.callsLock.Unlock()
+		delete(w.calls, seq)
+	}
+}
+
+// running returns the gRPC calls in flight.
+func (w *slowRPCWatchdog) running() []*runningCall {
+	w.callsLock.Lock()
+	defer w.callsLock.Unlock()
+
+	calls := make([]*runningCall, 0, len(w.calls))
+	for _, call := range w.calls {
+		calls = append(calls, call)
+	}
+
+	return calls
+}
+
+func (w *slowRPCWatchdog) intercept(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	inFlight := rpcInFlight.WithLabelValues(info.FullMethod)
+	inFlight.Inc()
+	defer inFlight.Dec()
+	defer w.track(ctx, info.FullMethod, req)()
+
+	if w.threshold <= 0 {
+		return handler(ctx, req)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		w.watch(ctx, info.FullMethod, done)
+	}()
+	// wait for the watch to return, so that no warning is logged after the
+	// call returned
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	return handler(ctx, req)
+}
+
+// watch logs a warning when the threshold passes, and repeats it every
+// interval until done is closed.
+func (w *slowRPCWatchdog) watch(ctx context.Context, method string, done <-chan struct{}) {
+	start := time.Now()
+	timer := time.NewTimer(w.threshold)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			w.warn(ctx, "GRPC call %s is still running after %s", method, time.Since(start).Round(time.Millisecond))
+			timer.Reset(w.interval)
+		}
+	}
+}
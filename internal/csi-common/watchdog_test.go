@@ -0,0 +1,106 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// warnings records the messages of a slowRPCWatchdog.
+type warnings struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (w *warnings) warn(ctx context.Context, message string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.messages = append(w.messages, fmt.Sprintf(message, args...))
+}
+
+func (w *warnings) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.messages)
+}
+
+func TestSlowRPCWatchdog(t *testing.T) {
+	t.Parallel()
+
+	const method = "/csi.v1.Test/SlowRPCWatchdog"
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	recorder := &warnings{}
+	w := &slowRPCWatchdog{
+		threshold: 30 * time.Millisecond,
+		interval:  20 * time.Millisecond,
+		warn:      recorder.warn,
+	}
+
+	var inFlight float64
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		inFlight = testutil.ToFloat64(rpcInFlight.WithLabelValues(method))
+		time.Sleep(150 * time.Millisecond)
+
+		return req, nil
+	}
+
+	resp, err := w.intercept(context.Background(), "req", info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "req", resp)
+	assert.InDelta(t, 1, inFlight, 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(rpcInFlight.WithLabelValues(method)), 0)
+
+	// warned after 30ms, and repeated every 20ms while sleeping 150ms
+	logged := recorder.count()
+	assert.GreaterOrEqual(t, logged, 2)
+	for _, m := range recorder.messages {
+		assert.True(t, strings.HasPrefix(m, "GRPC call "+method+" is still running after "), m)
+	}
+
+	// nothing is logged after the call returned
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, logged, recorder.count())
+}
+
+func TestSlowRPCWatchdogFast(t *testing.T) {
+	t.Parallel()
+
+	recorder := &warnings{}
+	w := &slowRPCWatchdog{
+		threshold: time.Minute,
+		interval:  time.Minute,
+		warn:      recorder.warn,
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Test/SlowRPCWatchdogFast"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+
+	_, err := w.intercept(context.Background(), "req", info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, recorder.count())
+}
@@ -0,0 +1,434 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/google/uuid"
+)
+
+const (
+	volumesDirectoryPrefix = "csi.volumes."
+	snapsDirectoryPrefix   = "csi.snaps."
+)
+
+// Reasons for reporting an entry of the journal as dangling.
+const (
+	// ReasonMissingUUIDDirectory is reported for a request name key in the
+	// csiDirectory without the UUID directory it points to.
+	ReasonMissingUUIDDirectory = "UUID directory is missing"
+	// ReasonMissingVolume is reported for a reservation without the RBD image
+	// or CephFS subvolume (or snapshot).
+	ReasonMissingVolume = "volume is missing"
+	// ReasonMissingRequestName is reported for a UUID directory without a
+	// request name key in any csiDirectory, and without a volume.
+	ReasonMissingRequestName = "request name key and volume are missing"
+)
+
+// Entry describes a reservation in the journal.
+type Entry struct {
+	Directory   string // csiDirectory with the request name key, empty if the key is missing
+	RequestName string // CSI request name of the reservation
+	UUID        string // UUID of the reservation
+	Pool        string // pool of the UUID directory and the volume
+	VolumeName  string // name of the RBD image or CephFS subvolume (or snapshot)
+	SourceName  string // name of the parent volume of a snapshot
+	IsSnapshot  bool   // the reservation is for a snapshot
+	Reason      string // reason why the entry is dangling
+
+	// BackingSnapshotID is the ID of the backing snapshot of a CephFS
+	// snapshot-backed or RBD roxSharedClone volume, which have no volume of
+	// their own.
+	BackingSnapshotID string
+}
+
+// VolumeExistsFunc reports whether the volume (or snapshot) of a journal entry
+// exists.
+type VolumeExistsFunc func(ctx context.Context, entry *Entry) (bool, error)
+
+// CheckReport is the result of checking the journals of a pool.
+type CheckReport struct {
+	Pool        string
+	Namespace   string
+	Directories []string // csiDirectories found in the pool
+	Entries     int      // number of request name keys in the csiDirectories
+	Dangling    []*Entry // entries that should be removed from the journal
+}
+
+// journalConfig returns the journal Config for the csiDirectory object, or nil
+// if the object is not a csiDirectory.
+func journalConfig(oid, namespace string) *Config {
+	switch {
+	case strings.HasPrefix(oid, volumesDirectoryPrefix):
+		return NewCSIVolumeJournalWithNamespace(strings.TrimPrefix(oid, volumesDirectoryPrefix), namespace)
+	case strings.HasPrefix(oid, snapsDirectoryPrefix):
+		return NewCSISnapshotJournalWithNamespace(strings.TrimPrefix(oid, snapsDirectoryPrefix), namespace)
+	}
+
+	return nil
+}
+
+// isUUIDDirectory returns true if the object is a UUID directory of a volume
+// or a snapshot.
+func isUUIDDirectory(oid string) (bool, bool) {
+	for _, cj := range []*Config{NewCSIVolumeJournal(""), NewCSISnapshotJournal("")} {
+		if !strings.HasPrefix(oid, cj.cephUUIDDirectoryPrefix) {
+			continue
+		}
+		if _, err := uuid.Parse(strings.TrimPrefix(oid, cj.cephUUIDDirectoryPrefix)); err == nil {
+			return true, cj.cephSnapSourceKey != ""
+		}
+	}
+
+	return false, false
+}
+
+// withConfig returns a copy of the connection that uses the journal Config.
+func (conn *Connection) withConfig(cj *Config) *Connection {
+	c := *conn
+	c.config = cj
+
+	return &c
+}
+
+// CheckOptions selects the journals that CheckJournals checks.
+type CheckOptions struct {
+	// InstanceID is the instance of Ceph-CSI of which the csiDirectories
+	// (csi.volumes.<InstanceID> and csi.snaps.<InstanceID>) are checked.
+	InstanceID string
+	// ScanPool lists all objects in the pool, to check the csiDirectories
+	// of all instances and to find UUID directories that are not referenced
+	// by any csiDirectory. Listing the objects reads the names of all
+	// objects in the pool, including the data objects of the volumes.
+	ScanPool bool
+}
+
+/*
+CheckJournals checks the CSI journals (csi.volumes.* and csi.snaps.*) in the pool and
+the namespace of the connection, and reports the entries that are dangling. The volumes
+are cross-referenced with the exists function. The omaps are only read, RepairJournals
+removes the dangling entries of the report.
+
+Dangling entries are:
+  - request name keys in a csiDirectory without the UUID directory they point to
+  - request name keys in a csiDirectory of which the volume does not exist
+  - UUID directories that are not referenced by any csiDirectory of the pool, of which
+    the volume does not exist, these are only found with opts.ScanPool
+
+Only the csiDirectories of opts.InstanceID are checked, their keys are listed in chunks.
+With opts.ScanPool the objects in the pool are listed to find all csiDirectories and the
+unreferenced UUID directories.
+
+NOTE: A reservation that is in progress can be reported as dangling, the journals should
+be checked while no volumes are provisioned in the pool.
+*/
+func (conn *Connection) CheckJournals(
+	ctx context.Context,
+	pool string,
+	opts CheckOptions,
+	exists VolumeExistsFunc,
+) (*CheckReport, error) {
+	namespace := conn.config.namespace
+	report := &CheckReport{
+		Pool:      pool,
+		Namespace: namespace,
+	}
+
+	directories := []string{volumesDirectoryPrefix + opts.InstanceID, snapsDirectoryPrefix + opts.InstanceID}
+	var uuidDirectories []string
+	if opts.ScanPool {
+		var err error
+		directories, uuidDirectories, err = conn.listJournalObjects(pool)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(directories)
+
+	// UUID directories in the pool that are referenced by a request name key
+	referenced := map[string]bool{}
+	poolNames := map[int64]string{}
+	for _, directory := range directories {
+		dirConn := conn.withConfig(journalConfig(directory, namespace))
+		found, err := dirConn.checkDirectory(ctx, pool, exists, report, referenced, poolNames)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			report.Directories = append(report.Directories, directory)
+		}
+	}
+
+	for _, oid := range uuidDirectories {
+		if referenced[oid] {
+			continue
+		}
+
+		entry, err := conn.checkUUIDDirectory(ctx, pool, oid, exists)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			report.Dangling = append(report.Dangling, entry)
+		}
+	}
+
+	return report, nil
+}
+
+// listJournalObjects lists the objects in the pool, and returns the
+// csiDirectories and the UUID directories.
+func (conn *Connection) listJournalObjects(pool string) ([]string, []string, error) {
+	namespace := conn.config.namespace
+	ioctx, err := openOmapIOContext(conn, pool, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ioctx.Destroy()
+
+	var directories, uuidDirectories []string
+	err = ioctx.ListObjects(func(oid string) {
+		if journalConfig(oid, namespace) != nil {
+			directories = append(directories, oid)
+		} else if ok, _ := isUUIDDirectory(oid); ok {
+			uuidDirectories = append(uuidDirectories, oid)
+		}
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list objects in pool %q: %w", pool, err)
+	}
+	sort.Strings(uuidDirectories)
+
+	return directories, uuidDirectories, nil
+}
+
+// checkDirectory checks the request name keys in the csiDirectory of the
+// connection, and adds the dangling entries to the report. It returns false
+// when the csiDirectory does not exist.
+func (conn *Connection) checkDirectory(
+	ctx context.Context,
+	journalPool string,
+	exists VolumeExistsFunc,
+	report *CheckReport,
+	referenced map[string]bool,
+	poolNames map[int64]string,
+) (bool, error) {
+	cj := conn.config
+	snapSource := cj.cephSnapSourceKey != ""
+
+	keys, err := listOMapValues(ctx, conn, journalPool, cj.namespace, cj.csiDirectory, cj.csiNameKeyPrefix)
+	if errors.Is(err, util.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	reqNames := make([]string, 0, len(keys))
+	for key := range keys {
+		reqNames = append(reqNames, strings.TrimPrefix(key, cj.csiNameKeyPrefix))
+	}
+	sort.Strings(reqNames)
+	report.Entries += len(reqNames)
+
+	for _, reqName := range reqNames {
+		entry := &Entry{
+			Directory:   cj.csiDirectory,
+			RequestName: reqName,
+			Pool:        journalPool,
+			IsSnapshot:  snapSource,
+		}
+
+		value := keys[cj.csiNameKeyPrefix+reqName]
+		if len(value) == uuidEncodedLength {
+			entry.UUID = value
+		} else {
+			// the value is encoded as <poolID>/<uuid> for volumes in a
+			// different pool than the journal
+			poolID, id, found := strings.Cut(value, "/")
+			if !found {
+				return false, fmt.Errorf("invalid value %q of key %q in %s", value, cj.csiNameKeyPrefix+reqName,
+					cj.csiDirectory)
+			}
+			entry.UUID = id
+			entry.Pool, err = conn.getPoolName(poolID, poolNames)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		oid := cj.cephUUIDDirectoryPrefix + entry.UUID
+		if entry.Pool == journalPool {
+			referenced[oid] = true
+		}
+
+		fetchKeys := []string{cj.csiImageKey}
+		if snapSource {
+			fetchKeys = append(fetchKeys, cj.cephSnapSourceKey)
+		} else {
+			fetchKeys = append(fetchKeys, cj.backingSnapshotIDKey)
+		}
+		values, err := getOMapValues(ctx, conn, entry.Pool, cj.namespace, oid, fetchKeys)
+		if err != nil {
+			if !errors.Is(err, util.ErrKeyNotFound) && !errors.Is(err, util.ErrPoolNotFound) {
+				return false, err
+			}
+			entry.Reason = ReasonMissingUUIDDirectory
+			report.Dangling = append(report.Dangling, entry)
+
+			continue
+		}
+
+		entry.VolumeName = cj.GetNameForUUID("", entry.UUID, snapSource)
+		if name, ok := values[cj.csiImageKey]; ok {
+			entry.VolumeName = name
+		}
+		entry.SourceName = values[cj.cephSnapSourceKey]
+		entry.BackingSnapshotID = values[cj.backingSnapshotIDKey]
+
+		found, err := exists(ctx, entry)
+		if err != nil {
+			return false, fmt.Errorf("failed to check volume %q of request %q: %w", entry.VolumeName, reqName, err)
+		}
+		if !found {
+			entry.Reason = ReasonMissingVolume
+			report.Dangling = append(report.Dangling, entry)
+		}
+	}
+
+	return true, nil
+}
+
+// checkUUIDDirectory checks a UUID directory that is not referenced by a
+// request name key, and returns an entry when its volume does not exist. The
+// UUID directories of existing volumes are still needed to delete the volumes.
+func (conn *Connection) checkUUIDDirectory(
+	ctx context.Context,
+	pool, oid string,
+	exists VolumeExistsFunc,
+) (*Entry, error) {
+	_, snapSource := isUUIDDirectory(oid)
+	cj := NewCSIVolumeJournalWithNamespace("", conn.config.namespace)
+	if snapSource {
+		cj = NewCSISnapshotJournalWithNamespace("", conn.config.namespace)
+	}
+
+	fetchKeys := []string{cj.csiNameKey, cj.csiImageKey, cj.csiJournalPool}
+	if snapSource {
+		fetchKeys = append(fetchKeys, cj.cephSnapSourceKey)
+	} else {
+		fetchKeys = append(fetchKeys, cj.backingSnapshotIDKey)
+	}
+	values, err := getOMapValues(ctx, conn, pool, cj.namespace, oid, fetchKeys)
+	if err != nil {
+		if errors.Is(err, util.ErrKeyNotFound) {
+			// removed since the objects were listed
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	// the request name key is in the csiDirectory of another pool
+	if _, ok := values[cj.csiJournalPool]; ok {
+		return nil, nil
+	}
+
+	entry := &Entry{
+		RequestName: values[cj.csiNameKey],
+		UUID:        strings.TrimPrefix(oid, cj.cephUUIDDirectoryPrefix),
+		Pool:        pool,
+		SourceName:  values[cj.cephSnapSourceKey],
+		IsSnapshot:  snapSource,
+		Reason:      ReasonMissingRequestName,
+
+		BackingSnapshotID: values[cj.backingSnapshotIDKey],
+	}
+	entry.VolumeName = cj.GetNameForUUID("", entry.UUID, snapSource)
+	if name, ok := values[cj.csiImageKey]; ok {
+		entry.VolumeName = name
+	}
+
+	found, err := exists(ctx, entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check volume %q of %s: %w", entry.VolumeName, oid, err)
+	}
+	if found {
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+// getPoolName returns the name of the pool with the hex encoded ID.
+func (conn *Connection) getPoolName(poolIDStr string, poolNames map[int64]string) (string, error) {
+	buf64, err := hex.DecodeString(poolIDStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode string: %w", err)
+	}
+	poolID := int64(binary.BigEndian.Uint64(buf64))
+
+	if name, ok := poolNames[poolID]; ok {
+		return name, nil
+	}
+	name, err := util.GetPoolName(conn.monitors, conn.cr, poolID)
+	if err != nil {
+		return "", err
+	}
+	poolNames[poolID] = name
+
+	return name, nil
+}
+
+// RepairJournals removes the dangling entries of the report from the journals.
+// The UUID directory of an entry is removed before its request name key, as
+// done by UndoReservation.
+func (conn *Connection) RepairJournals(ctx context.Context, report *CheckReport) error {
+	for _, entry := range report.Dangling {
+		cj := NewCSIVolumeJournalWithNamespace("", report.Namespace)
+		if entry.IsSnapshot {
+			cj = NewCSISnapshotJournalWithNamespace("", report.Namespace)
+		}
+
+		if entry.Reason != ReasonMissingUUIDDirectory {
+			err := removeOMap(ctx, conn, entry.Pool, report.Namespace, cj.cephUUIDDirectoryPrefix+entry.UUID)
+			if err != nil {
+				return fmt.Errorf("failed to remove UUID directory of %q: %w", entry.UUID, err)
+			}
+		}
+
+		if entry.Directory != "" {
+			err := removeMapKeys(ctx, conn, report.Pool, report.Namespace, entry.Directory,
+				[]string{cj.csiNameKeyPrefix + entry.RequestName})
+			if err != nil {
+				return fmt.Errorf("failed to remove request name key %q: %w", entry.RequestName, err)
+			}
+		}
+
+		log.DebugLog(ctx, "removed dangling journal entry %+v", *entry)
+	}
+
+	return nil
+}
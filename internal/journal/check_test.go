@@ -0,0 +1,290 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const (
+	uuidValid      = "00000000-0000-0000-0000-000000000001"
+	uuidNoDir      = "00000000-0000-0000-0000-000000000002"
+	uuidNoVolume   = "00000000-0000-0000-0000-000000000003"
+	uuidOrphan     = "00000000-0000-0000-0000-000000000004"
+	uuidLeaked     = "00000000-0000-0000-0000-000000000005"
+	uuidSnap       = "00000000-0000-0000-0000-000000000006"
+	uuidOtherValid = "00000000-0000-0000-0000-000000000007"
+	uuidBacked     = "00000000-0000-0000-0000-000000000008"
+	uuidNoBacking  = "00000000-0000-0000-0000-000000000009"
+)
+
+func (c *fakeOmapCluster) addObject(pool, oid string, pairs map[string]string) {
+	omap := map[string][]byte{}
+	for k, v := range pairs {
+		omap[k] = []byte(v)
+	}
+	c.objects[pool+"//"+oid] = omap
+}
+
+// newFakeJournal returns a cluster with valid and dangling journal entries
+// in the pool, and the names of the volumes (and backing snapshots) that
+// exist.
+func newFakeJournal(pool string) (*fakeOmapCluster, map[string]bool) {
+	c := newFakeOmapCluster()
+
+	c.addObject(pool, "csi.volumes.default", map[string]string{
+		"csi.volume.valid":      uuidValid,
+		"csi.volume.no-dir":     uuidNoDir,
+		"csi.volume.no-volume":  uuidNoVolume,
+		"csi.volume.backed":     uuidBacked,
+		"csi.volume.no-backing": uuidNoBacking,
+	})
+	c.addObject(pool, "csi.volume."+uuidValid, map[string]string{
+		"csi.volname":   "valid",
+		"csi.imagename": "csi-vol-" + uuidValid,
+	})
+	c.addObject(pool, "csi.volume."+uuidNoVolume, map[string]string{
+		"csi.volname":   "no-volume",
+		"csi.imagename": "csi-vol-" + uuidNoVolume,
+	})
+	// snapshot-backed volumes have no volume of their own
+	c.addObject(pool, "csi.volume."+uuidBacked, map[string]string{
+		"csi.volname":                  "backed",
+		"csi.imagename":                "csi-vol-" + uuidBacked,
+		"csi.volume.backingsnapshotid": "backing-snap",
+	})
+	c.addObject(pool, "csi.volume."+uuidNoBacking, map[string]string{
+		"csi.volname":                  "no-backing",
+		"csi.imagename":                "csi-vol-" + uuidNoBacking,
+		"csi.volume.backingsnapshotid": "deleted-snap",
+	})
+	c.addObject(pool, "csi.volume."+uuidOrphan, map[string]string{
+		"csi.volname":   "orphan",
+		"csi.imagename": "csi-vol-" + uuidOrphan,
+	})
+	c.addObject(pool, "csi.volume."+uuidLeaked, map[string]string{
+		"csi.volname":   "leaked",
+		"csi.imagename": "csi-vol-" + uuidLeaked,
+	})
+
+	c.addObject(pool, "csi.snaps.default", map[string]string{
+		"csi.snap.snap": uuidSnap,
+	})
+	c.addObject(pool, "csi.snap."+uuidSnap, map[string]string{
+		"csi.snapname":  "snap",
+		"csi.imagename": "csi-snap-" + uuidSnap,
+		"csi.source":    "csi-vol-" + uuidValid,
+	})
+
+	// journal of another instance sharing the pool
+	c.addObject(pool, "csi.volumes.other", map[string]string{
+		"csi.volume.other": uuidOtherValid,
+	})
+	c.addObject(pool, "csi.volume."+uuidOtherValid, map[string]string{
+		"csi.volname": "other",
+	})
+
+	c.addObject(pool, "rbd_directory", nil)
+
+	volumes := map[string]bool{
+		"csi-vol-" + uuidValid:      true,
+		"csi-vol-" + uuidLeaked:     true,
+		"csi-snap-" + uuidSnap:      true,
+		"csi-vol-" + uuidOtherValid: true,
+		"backing-snap":              true,
+	}
+
+	return c, volumes
+}
+
+func volumeExists(volumes map[string]bool) VolumeExistsFunc {
+	return func(ctx context.Context, entry *Entry) (bool, error) {
+		if entry.BackingSnapshotID != "" {
+			return volumes[entry.BackingSnapshotID], nil
+		}
+
+		return volumes[entry.VolumeName], nil
+	}
+}
+
+func TestCheckJournals(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	cluster, volumes := newFakeJournal("pool")
+	conn := cluster.connection()
+
+	opts := CheckOptions{InstanceID: "default", ScanPool: true}
+	report, err := conn.CheckJournals(ctx, "pool", opts, volumeExists(volumes))
+	if err != nil {
+		t.Fatalf("CheckJournals() error = %v", err)
+	}
+	if cluster.writes != 0 {
+		t.Errorf("CheckJournals() writes = %d, want 0", cluster.writes)
+	}
+
+	wantDirectories := []string{"csi.snaps.default", "csi.volumes.default", "csi.volumes.other"}
+	if !reflect.DeepEqual(report.Directories, wantDirectories) {
+		t.Errorf("CheckJournals() directories = %v, want %v", report.Directories, wantDirectories)
+	}
+	if report.Entries != 7 {
+		t.Errorf("CheckJournals() entries = %d, want 7", report.Entries)
+	}
+
+	wantDangling := []*Entry{
+		{
+			Directory:   "csi.volumes.default",
+			RequestName: "no-backing",
+			UUID:        uuidNoBacking,
+			Pool:        "pool",
+			VolumeName:  "csi-vol-" + uuidNoBacking,
+			Reason:      ReasonMissingVolume,
+
+			BackingSnapshotID: "deleted-snap",
+		},
+		{
+			Directory:   "csi.volumes.default",
+			RequestName: "no-dir",
+			UUID:        uuidNoDir,
+			Pool:        "pool",
+			Reason:      ReasonMissingUUIDDirectory,
+		},
+		{
+			Directory:   "csi.volumes.default",
+			RequestName: "no-volume",
+			UUID:        uuidNoVolume,
+			Pool:        "pool",
+			VolumeName:  "csi-vol-" + uuidNoVolume,
+			Reason:      ReasonMissingVolume,
+		},
+		{
+			RequestName: "orphan",
+			UUID:        uuidOrphan,
+			Pool:        "pool",
+			VolumeName:  "csi-vol-" + uuidOrphan,
+			Reason:      ReasonMissingRequestName,
+		},
+	}
+	if !reflect.DeepEqual(report.Dangling, wantDangling) {
+		for _, e := range report.Dangling {
+			t.Logf("dangling: %+v", *e)
+		}
+		t.Errorf("CheckJournals() dangling entries differ")
+	}
+
+	err = conn.RepairJournals(ctx, report)
+	if err != nil {
+		t.Fatalf("RepairJournals() error = %v", err)
+	}
+
+	report, err = conn.CheckJournals(ctx, "pool", opts, volumeExists(volumes))
+	if err != nil {
+		t.Fatalf("CheckJournals() error = %v", err)
+	}
+	if len(report.Dangling) != 0 {
+		t.Errorf("CheckJournals() after repair found %d dangling entries, want 0", len(report.Dangling))
+	}
+	if report.Entries != 4 {
+		t.Errorf("CheckJournals() after repair entries = %d, want 4", report.Entries)
+	}
+	for _, id := range []string{uuidValid, uuidLeaked, uuidOtherValid, uuidBacked} {
+		if _, ok := cluster.objects["pool//csi.volume."+id]; !ok {
+			t.Errorf("RepairJournals() removed UUID directory of %s", id)
+		}
+	}
+}
+
+func TestCheckJournalsInstance(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	cluster, volumes := newFakeJournal("pool")
+	conn := cluster.connection()
+
+	report, err := conn.CheckJournals(ctx, "pool", CheckOptions{InstanceID: "default"}, volumeExists(volumes))
+	if err != nil {
+		t.Fatalf("CheckJournals() error = %v", err)
+	}
+	if cluster.listings != 0 {
+		t.Errorf("CheckJournals() listed the objects of the pool %d times, want 0", cluster.listings)
+	}
+
+	wantDirectories := []string{"csi.snaps.default", "csi.volumes.default"}
+	if !reflect.DeepEqual(report.Directories, wantDirectories) {
+		t.Errorf("CheckJournals() directories = %v, want %v", report.Directories, wantDirectories)
+	}
+	if report.Entries != 6 {
+		t.Errorf("CheckJournals() entries = %d, want 6", report.Entries)
+	}
+
+	// the orphaned UUID directory is only found when the pool is scanned
+	var dangling []string
+	for _, e := range report.Dangling {
+		dangling = append(dangling, e.RequestName)
+	}
+	wantDangling := []string{"no-backing", "no-dir", "no-volume"}
+	if !reflect.DeepEqual(dangling, wantDangling) {
+		t.Errorf("CheckJournals() dangling = %v, want %v", dangling, wantDangling)
+	}
+
+	// an instance without journals in the pool
+	report, err = conn.CheckJournals(ctx, "pool", CheckOptions{InstanceID: "missing"}, volumeExists(volumes))
+	if err != nil {
+		t.Fatalf("CheckJournals() error = %v", err)
+	}
+	if len(report.Directories) != 0 || report.Entries != 0 || len(report.Dangling) != 0 {
+		t.Errorf("CheckJournals() of missing instance = %+v, want an empty report", *report)
+	}
+}
+
+func TestCheckJournalsPaginated(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	cluster := newFakeOmapCluster()
+	conn := cluster.connection()
+
+	numEntries := 3*int(chunkSize) + 1
+	keys := map[string]string{}
+	for i := 0; i < numEntries; i++ {
+		id := fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+		keys[fmt.Sprintf("csi.volume.req-%d", i)] = id
+		cluster.addObject("pool", "csi.volume."+id, map[string]string{
+			"csi.volname": fmt.Sprintf("req-%d", i),
+		})
+	}
+	// dangling entry after the last chunk
+	keys["csi.volume.zz-no-dir"] = "ffffffff-0000-0000-0000-000000000000"
+	cluster.addObject("pool", "csi.volumes.default", keys)
+
+	opts := CheckOptions{InstanceID: "default"}
+	report, err := conn.CheckJournals(ctx, "pool", opts, func(ctx context.Context, entry *Entry) (bool, error) {
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("CheckJournals() error = %v", err)
+	}
+	if report.Entries != numEntries+1 {
+		t.Errorf("CheckJournals() entries = %d, want %d", report.Entries, numEntries+1)
+	}
+	if len(report.Dangling) != 1 || report.Dangling[0].RequestName != "zz-no-dir" {
+		t.Errorf("CheckJournals() dangling = %v, want only zz-no-dir", report.Dangling)
+	}
+}
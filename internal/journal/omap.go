@@ -26,88 +26,235 @@ import (
 	"github.com/ceph/go-ceph/rados"
 )
 
+// omapIOContext contains the operations on rados objects and their omaps that
+// are used by the journal. Every operation is a single round-trip to the OSD.
+// It is implemented by radosOmapIOContext.
+type omapIOContext interface {
+	SetNamespace(namespace string)
+	// GetOmapValuesByKeys returns the values of the keys that are set in
+	// the omap of the object.
+	GetOmapValuesByKeys(oid string, keys []string) (map[string][]byte, error)
+	// CreateOmap creates the object and sets the keys in its omap, it fails
+	// with rados.ErrObjectExists when the object exists already.
+	CreateOmap(oid string, pairs map[string][]byte) error
+	SetOmap(oid string, pairs map[string][]byte) error
+	RmOmapKeys(oid string, keys []string) error
+	// ListOmapValues lists up to maxReturn keys after startAfter that have
+	// the filterPrefix.
+	ListOmapValues(oid, startAfter, filterPrefix string, maxReturn int64, listFn rados.OmapListFunc) error
+	// ListObjects lists the objects in the namespace of the pool.
+	ListObjects(listFn rados.ObjectListFunc) error
+	Delete(oid string) error
+	Destroy()
+}
+
 // chunkSize is the number of key-value pairs that will be fetched in
-// one call. This is set fairly large to avoid calling into ceph APIs
-// over and over.
+// one call when listing an omap. This is set fairly large to avoid
+// calling into ceph APIs over and over.
 const chunkSize int64 = 512
 
+// radosOmapIOContext implements omapIOContext with a rados.IOContext.
+type radosOmapIOContext struct {
+	*rados.IOContext
+}
+
+// newRadosOmapIOContext returns a function that opens an omapIOContext for a
+// pool of the cluster connection.
+func newRadosOmapIOContext(cc *util.ClusterConnection) func(string) (omapIOContext, error) {
+	return func(poolName string) (omapIOContext, error) {
+		ioctx, err := cc.GetIoctx(poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		return radosOmapIOContext{ioctx}, nil
+	}
+}
+
+// GetOmapValuesByKeys fetches the keys in a single read operation.
+func (ioctx radosOmapIOContext) GetOmapValuesByKeys(oid string, keys []string) (map[string][]byte, error) {
+	op := rados.CreateReadOp()
+	defer op.Release()
+
+	step := op.GetOmapValuesByKeys(keys)
+	err := op.Operate(ioctx.IOContext, oid, rados.OperationNoFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for {
+		kv, err := step.Next()
+		if err != nil {
+			return nil, err
+		}
+		if kv == nil {
+			break
+		}
+		values[kv.Key] = kv.Value
+	}
+
+	return values, nil
+}
+
+// CreateOmap creates the object exclusively and sets the keys in a single
+// write operation.
+func (ioctx radosOmapIOContext) CreateOmap(oid string, pairs map[string][]byte) error {
+	op := rados.CreateWriteOp()
+	defer op.Release()
+
+	op.Create(rados.CreateExclusive)
+	if len(pairs) != 0 {
+		op.SetOmap(pairs)
+	}
+
+	return op.Operate(ioctx.IOContext, oid, rados.OperationNoFlag)
+}
+
+// openOmapIOContext opens the omapIOContext for the pool and namespace.
+func openOmapIOContext(conn *Connection, poolName, namespace string) (omapIOContext, error) {
+	ioctx, err := conn.newOmapIOContext(poolName)
+	if err != nil {
+		return nil, omapPoolError(err)
+	}
+
+	if namespace != "" {
+		ioctx.SetNamespace(namespace)
+	}
+
+	return ioctx, nil
+}
+
 func getOMapValues(
 	ctx context.Context,
 	conn *Connection,
-	poolName, namespace, oid, prefix string, keys []string,
+	poolName, namespace, oid string, keys []string,
 ) (map[string]string, error) {
-	// fetch and configure the rados ioctx
-	ioctx, err := conn.conn.GetIoctx(poolName)
+	ioctx, err := openOmapIOContext(conn, poolName, namespace)
 	if err != nil {
-		return nil, omapPoolError(err)
+		return nil, err
 	}
 	defer ioctx.Destroy()
 
-	if namespace != "" {
-		ioctx.SetNamespace(namespace)
+	// only the requested keys are fetched, the omap of the csiDirectory
+	// contains a key for every volume and listing it takes many round-trips
+	var values map[string][]byte
+	err = util.RetryRadosOperation(ctx, "get omap values", func() error {
+		var opErr error
+		values, opErr = ioctx.GetOmapValuesByKeys(oid, keys)
+
+		return opErr
+	})
+	if err != nil {
+		if errors.Is(err, rados.ErrNotFound) {
+			log.ErrorLog(ctx, "omap not found (pool=%q, namespace=%q, name=%q): %v",
+				poolName, namespace, oid, err)
+
+			return nil, util.JoinErrors(util.ErrKeyNotFound, err)
+		}
+
+		return nil, err
 	}
 
-	results := map[string]string{}
-	// want is our "lookup map" that ensures O(1) checks for keys
-	// while iterating, without needing to complicate the caller.
-	want := make(map[string]bool, len(keys))
-	for i := range keys {
-		want[keys[i]] = true
+	results := make(map[string]string, len(values))
+	for k, v := range values {
+		results[k] = string(v)
+	}
+
+	log.DebugLog(ctx, "got omap values: (pool=%q, namespace=%q, name=%q): %+v",
+		poolName, namespace, oid, results)
+
+	return results, nil
+}
+
+// listOMapValues lists all keys with the prefix in the omap of the object,
+// the keys are fetched in chunks of chunkSize.
+func listOMapValues(
+	ctx context.Context,
+	conn *Connection,
+	poolName, namespace, oid, prefix string,
+) (map[string]string, error) {
+	ioctx, err := openOmapIOContext(conn, poolName, namespace)
+	if err != nil {
+		return nil, err
 	}
-	numKeys := uint64(0)
+	defer ioctx.Destroy()
+
+	results := map[string]string{}
 	startAfter := ""
 	for {
-		prevNumKeys := numKeys
-		err = ioctx.ListOmapValues(
-			oid, startAfter, prefix, chunkSize,
-			func(key string, value []byte) {
-				numKeys++
-				startAfter = key
-				if want[key] {
+		numKeys := 0
+		err = util.RetryRadosOperation(ctx, "list omap values", func() error {
+			return ioctx.ListOmapValues(
+				oid, startAfter, prefix, chunkSize,
+				func(key string, value []byte) {
+					numKeys++
+					startAfter = key
 					results[key] = string(value)
-				}
-			},
-		)
+				},
+			)
+		})
 		// if we hit an error, or no new keys were seen, exit the loop
-		if err != nil || numKeys == prevNumKeys {
+		if err != nil || numKeys == 0 {
 			break
 		}
 	}
 
 	if err != nil {
 		if errors.Is(err, rados.ErrNotFound) {
-			log.ErrorLog(ctx, "omap not found (pool=%q, namespace=%q, name=%q): %v",
-				poolName, namespace, oid, err)
-
 			return nil, util.JoinErrors(util.ErrKeyNotFound, err)
 		}
+		log.ErrorLog(ctx, "failed listing omap (pool=%q, namespace=%q, name=%q): %v",
+			poolName, namespace, oid, err)
 
 		return nil, err
 	}
 
-	log.DebugLog(ctx, "got omap values: (pool=%q, namespace=%q, name=%q): %+v",
-		poolName, namespace, oid, results)
-
 	return results, nil
 }
 
-func removeMapKeys(
+// removeOMap removes the object of the omap, an object that does not exist
+// is not an error.
+func removeOMap(
 	ctx context.Context,
 	conn *Connection,
-	poolName, namespace, oid string, keys []string,
+	poolName, namespace, oid string,
 ) error {
-	// fetch and configure the rados ioctx
-	ioctx, err := conn.conn.GetIoctx(poolName)
+	ioctx, err := openOmapIOContext(conn, poolName, namespace)
 	if err != nil {
-		return omapPoolError(err)
+		return err
 	}
 	defer ioctx.Destroy()
 
-	if namespace != "" {
-		ioctx.SetNamespace(namespace)
+	err = util.RetryRadosOperation(ctx, "remove omap", func() error {
+		return ioctx.Delete(oid)
+	})
+	if err != nil && !errors.Is(err, rados.ErrNotFound) {
+		log.ErrorLog(ctx, "failed removing omap (pool=%q, namespace=%q, name=%q): %v",
+			poolName, namespace, oid, err)
+
+		return err
 	}
+	log.DebugLog(ctx, "removed omap (pool=%q, namespace=%q, name=%q)",
+		poolName, namespace, oid)
 
-	err = ioctx.RmOmapKeys(oid, keys)
+	return nil
+}
+
+func removeMapKeys(
+	ctx context.Context,
+	conn *Connection,
+	poolName, namespace, oid string, keys []string,
+) error {
+	ioctx, err := openOmapIOContext(conn, poolName, namespace)
+	if err != nil {
+		return err
+	}
+	defer ioctx.Destroy()
+
+	err = util.RetryRadosOperation(ctx, "remove omap keys", func() error {
+		return ioctx.RmOmapKeys(oid, keys)
+	})
 	if err != nil {
 		if errors.Is(err, rados.ErrNotFound) {
 			// the previous implementation of removing omap keys (via the cli)
@@ -133,22 +280,16 @@ func setOMapKeys(
 	conn *Connection,
 	poolName, namespace, oid string, pairs map[string]string,
 ) error {
-	// fetch and configure the rados ioctx
-	ioctx, err := conn.conn.GetIoctx(poolName)
+	ioctx, err := openOmapIOContext(conn, poolName, namespace)
 	if err != nil {
-		return omapPoolError(err)
+		return err
 	}
 	defer ioctx.Destroy()
 
-	if namespace != "" {
-		ioctx.SetNamespace(namespace)
-	}
-
-	bpairs := make(map[string][]byte, len(pairs))
-	for k, v := range pairs {
-		bpairs[k] = []byte(v)
-	}
-	err = ioctx.SetOmap(oid, bpairs)
+	bpairs := toBytePairs(pairs)
+	err = util.RetryRadosOperation(ctx, "set omap keys", func() error {
+		return ioctx.SetOmap(oid, bpairs)
+	})
 	if err != nil {
 		log.ErrorLog(ctx, "failed setting omap keys (pool=%q, namespace=%q, name=%q, pairs=%+v): %v",
 			poolName, namespace, oid, pairs, err)
@@ -161,6 +302,49 @@ func setOMapKeys(
 	return nil
 }
 
+// createOMap creates the object and sets the keys in its omap with a single
+// operation. It returns util.ErrObjectExists when the object exists already.
+func createOMap(
+	ctx context.Context,
+	conn *Connection,
+	poolName, namespace, oid string, pairs map[string]string,
+) error {
+	ioctx, err := openOmapIOContext(conn, poolName, namespace)
+	if err != nil {
+		if errors.Is(err, util.ErrPoolNotFound) {
+			err = util.JoinErrors(util.ErrObjectNotFound, err)
+		}
+
+		return err
+	}
+	defer ioctx.Destroy()
+
+	// not retried, the exclusive create is not idempotent and a retry would
+	// fail with ErrObjectExists when the first attempt created the omap
+	err = ioctx.CreateOmap(oid, toBytePairs(pairs))
+	if errors.Is(err, rados.ErrObjectExists) {
+		return util.JoinErrors(util.ErrObjectExists, err)
+	} else if err != nil {
+		log.ErrorLog(ctx, "failed creating omap (pool=%q, namespace=%q, name=%q): %v",
+			poolName, namespace, oid, err)
+
+		return err
+	}
+	log.DebugLog(ctx, "created omap (pool=%q, namespace=%q, name=%q): %+v)",
+		poolName, namespace, oid, pairs)
+
+	return nil
+}
+
+func toBytePairs(pairs map[string]string) map[string][]byte {
+	bpairs := make(map[string][]byte, len(pairs))
+	for k, v := range pairs {
+		bpairs[k] = []byte(v)
+	}
+
+	return bpairs
+}
+
 func omapPoolError(err error) error {
 	if errors.Is(err, rados.ErrNotFound) {
 		return util.JoinErrors(util.ErrPoolNotFound, err)
@@ -0,0 +1,473 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/util"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+// fakeOmapCluster keeps the omaps of the objects in memory and counts the
+// operations that would be sent to the OSDs.
+type fakeOmapCluster struct {
+	objects map[string]map[string][]byte
+	reads   int
+	writes  int
+	// listings is the number of times the objects of a pool were listed
+	listings int
+	// failures are returned by the next operations, before they are run
+	failures []error
+}
+
+func newFakeOmapCluster() *fakeOmapCluster {
+	return &fakeOmapCluster{objects: map[string]map[string][]byte{}}
+}
+
+func (c *fakeOmapCluster) connection() *Connection {
+	return &Connection{
+		config: NewCSIVolumeJournal("default"),
+		newOmapIOContext: func(poolName string) (omapIOContext, error) {
+			return &fakeOmapIOContext{cluster: c, pool: poolName}, nil
+		},
+	}
+}
+
+func (c *fakeOmapCluster) ops() int {
+	return c.reads + c.writes
+}
+
+func (c *fakeOmapCluster) fail() error {
+	if len(c.failures) == 0 {
+		return nil
+	}
+	err := c.failures[0]
+	c.failures = c.failures[1:]
+
+	return err
+}
+
+// errnoError is an error with an errno, like the errors of the Ceph APIs.
+type errnoError int
+
+func (e errnoError) Error() string {
+	return fmt.Sprintf("errno %d", int(e))
+}
+
+func (e errnoError) ErrorCode() int {
+	return int(e)
+}
+
+type fakeOmapIOContext struct {
+	cluster   *fakeOmapCluster
+	pool      string
+	namespace string
+}
+
+func (ioctx *fakeOmapIOContext) name(oid string) string {
+	return ioctx.pool + "/" + ioctx.namespace + "/" + oid
+}
+
+func (ioctx *fakeOmapIOContext) SetNamespace(namespace string) {
+	ioctx.namespace = namespace
+}
+
+func (ioctx *fakeOmapIOContext) GetOmapValuesByKeys(oid string, keys []string) (map[string][]byte, error) {
+	ioctx.cluster.reads++
+	if err := ioctx.cluster.fail(); err != nil {
+		return nil, err
+	}
+	omap, ok := ioctx.cluster.objects[ioctx.name(oid)]
+	if !ok {
+		return nil, rados.ErrNotFound
+	}
+
+	values := map[string][]byte{}
+	for _, k := range keys {
+		if v, ok := omap[k]; ok {
+			values[k] = v
+		}
+	}
+
+	return values, nil
+}
+
+func (ioctx *fakeOmapIOContext) CreateOmap(oid string, pairs map[string][]byte) error {
+	ioctx.cluster.writes++
+	if err := ioctx.cluster.fail(); err != nil {
+		return err
+	}
+	if _, ok := ioctx.cluster.objects[ioctx.name(oid)]; ok {
+		return rados.ErrObjectExists
+	}
+
+	omap := map[string][]byte{}
+	for k, v := range pairs {
+		omap[k] = v
+	}
+	ioctx.cluster.objects[ioctx.name(oid)] = omap
+
+	return nil
+}
+
+func (ioctx *fakeOmapIOContext) SetOmap(oid string, pairs map[string][]byte) error {
+	ioctx.cluster.writes++
+	if err := ioctx.cluster.fail(); err != nil {
+		return err
+	}
+	omap, ok := ioctx.cluster.objects[ioctx.name(oid)]
+	if !ok {
+		omap = map[string][]byte{}
+		ioctx.cluster.objects[ioctx.name(oid)] = omap
+	}
+	for k, v := range pairs {
+		omap[k] = v
+	}
+
+	return nil
+}
+
+func (ioctx *fakeOmapIOContext) RmOmapKeys(oid string, keys []string) error {
+	ioctx.cluster.writes++
+	if err := ioctx.cluster.fail(); err != nil {
+		return err
+	}
+	omap, ok := ioctx.cluster.objects[ioctx.name(oid)]
+	if !ok {
+		return rados.ErrNotFound
+	}
+	for _, k := range keys {
+		delete(omap, k)
+	}
+
+	return nil
+}
+
+func (ioctx *fakeOmapIOContext) ListOmapValues(
+	oid, startAfter, filterPrefix string,
+	maxReturn int64,
+	listFn rados.OmapListFunc,
+) error {
+	ioctx.cluster.reads++
+	omap, ok := ioctx.cluster.objects[ioctx.name(oid)]
+	if !ok {
+		return rados.ErrNotFound
+	}
+
+	keys := make([]string, 0, len(omap))
+	for k := range omap {
+		if k > startAfter && strings.HasPrefix(k, filterPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		if int64(i) == maxReturn {
+			break
+		}
+		listFn(k, omap[k])
+	}
+
+	return nil
+}
+
+func (ioctx *fakeOmapIOContext) ListObjects(listFn rados.ObjectListFunc) error {
+	ioctx.cluster.reads++
+	ioctx.cluster.listings++
+	prefix := ioctx.name("")
+	for name := range ioctx.cluster.objects {
+		if strings.HasPrefix(name, prefix) {
+			listFn(strings.TrimPrefix(name, prefix))
+		}
+	}
+
+	return nil
+}
+
+func (ioctx *fakeOmapIOContext) Delete(oid string) error {
+	ioctx.cluster.writes++
+	if _, ok := ioctx.cluster.objects[ioctx.name(oid)]; !ok {
+		return rados.ErrNotFound
+	}
+	delete(ioctx.cluster.objects, ioctx.name(oid))
+
+	return nil
+}
+
+func (ioctx *fakeOmapIOContext) Destroy() {}
+
+func TestReserveNameOps(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		journalPool string
+		imagePool   string
+		imagePoolID int64
+		wantWrites  int
+	}{
+		{
+			name:        "journal and image in the same pool",
+			journalPool: "pool",
+			imagePool:   "pool",
+			imagePoolID: 1,
+			// create the UUID omap with its keys, set the request name key
+			wantWrites: 2,
+		},
+		{
+			name:        "journal and image in different pools",
+			journalPool: "journal",
+			imagePool:   "image",
+			imagePoolID: 2,
+			// create the UUID omap, set the request name key, set the UUID keys
+			wantWrites: 3,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.TODO()
+			cluster := newFakeOmapCluster()
+			conn := cluster.connection()
+
+			_, imageName, err := conn.ReserveName(ctx, ts.journalPool, 1, ts.imagePool, ts.imagePoolID,
+				"req-name", "csi-vol-", "", "", "", "", "", util.EncryptionTypeNone)
+			if err != nil {
+				t.Fatalf("ReserveName() error = %v", err)
+			}
+			if cluster.writes != ts.wantWrites {
+				t.Errorf("ReserveName() writes = %d, want %d", cluster.writes, ts.wantWrites)
+			}
+
+			if ts.journalPool != ts.imagePool {
+				// CheckReservation resolves the image pool by its ID,
+				// which needs a cluster
+				return
+			}
+			cluster.reads = 0
+			imageData, err := conn.CheckReservation(ctx, ts.journalPool, "req-name", "csi-vol-", "", "",
+				util.EncryptionTypeNone)
+			if err != nil {
+				t.Fatalf("CheckReservation() error = %v", err)
+			}
+			if imageData == nil || imageData.ImageAttributes.ImageName != imageName {
+				t.Errorf("CheckReservation() = %+v, want image %q", imageData, imageName)
+			}
+			// one read of the csiDirectory and one of the UUID omap
+			if cluster.reads != 2 {
+				t.Errorf("CheckReservation() reads = %d, want 2", cluster.reads)
+			}
+		})
+	}
+}
+
+func TestReserveNameJournalPool(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		journalPool   string
+		journalPoolID int64
+		// wantJournalPoolID is returned by GetImageAttributes, the image
+		// pool is used for the journal when it is InvalidPoolID
+		wantJournalPoolID int64
+	}{
+		{
+			// volumes created before the journalPool parameter existed
+			name:              "journal in the image pool",
+			journalPool:       "image",
+			journalPoolID:     1,
+			wantJournalPoolID: util.InvalidPoolID,
+		},
+		{
+			name:              "separate journal pool",
+			journalPool:       "journal",
+			journalPoolID:     2,
+			wantJournalPoolID: 2,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.TODO()
+			cluster := newFakeOmapCluster()
+			conn := cluster.connection()
+
+			volUUID, imageName, err := conn.ReserveName(ctx, ts.journalPool, ts.journalPoolID, "image", 1,
+				"req-name", "csi-vol-", "", "", "", "", "", util.EncryptionTypeNone)
+			if err != nil {
+				t.Fatalf("ReserveName() error = %v", err)
+			}
+
+			// the request name is stored in the journal pool, the UUID
+			// directory with the image
+			csiDirectory := ts.journalPool + "//csi.volumes.default"
+			if _, ok := cluster.objects[csiDirectory]; !ok {
+				t.Errorf("journal %q not created, objects: %v", csiDirectory, cluster.objects)
+			}
+			uuidDirectory := "image//csi.volume." + volUUID
+			if keys := cluster.objects[uuidDirectory]; len(keys) == 0 {
+				t.Errorf("UUID directory %q not created, objects: %v", uuidDirectory, cluster.objects)
+			}
+			if _, ok := cluster.objects[ts.journalPool+"//csi.volume."+volUUID]; ok && ts.journalPool != "image" {
+				t.Errorf("UUID directory created in journal pool %q", ts.journalPool)
+			}
+
+			// DeleteVolume resolves the journal pool from the UUID directory
+			attrs, err := conn.GetImageAttributes(ctx, "image", volUUID, false)
+			if err != nil {
+				t.Fatalf("GetImageAttributes() error = %v", err)
+			}
+			if attrs.JournalPoolID != ts.wantJournalPoolID {
+				t.Errorf("GetImageAttributes() JournalPoolID = %d, want %d", attrs.JournalPoolID, ts.wantJournalPoolID)
+			}
+
+			err = conn.UndoReservation(ctx, ts.journalPool, "image", imageName, "req-name")
+			if err != nil {
+				t.Fatalf("UndoReservation() error = %v", err)
+			}
+			if keys := cluster.objects[csiDirectory]; len(keys) != 0 {
+				t.Errorf("journal %q still has keys %v", csiDirectory, keys)
+			}
+		})
+	}
+}
+
+func TestGetOMapValuesOps(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	cluster := newFakeOmapCluster()
+	conn := cluster.connection()
+
+	_, err := getOMapValues(ctx, conn, "pool", "", "missing", []string{"key"})
+	if !errors.Is(err, util.ErrKeyNotFound) {
+		t.Errorf("getOMapValues() error = %v, want %v", err, util.ErrKeyNotFound)
+	}
+
+	// the number of reads does not depend on the number of keys in the omap
+	pairs := map[string]string{}
+	for i := 0; i < 2000; i++ {
+		pairs[fmt.Sprintf("csi.volume.req-%d", i)] = fmt.Sprintf("uuid-%d", i)
+	}
+	if err = setOMapKeys(ctx, conn, "pool", "", "csi.volumes.default", pairs); err != nil {
+		t.Fatalf("setOMapKeys() error = %v", err)
+	}
+
+	cluster.reads = 0
+	values, err := getOMapValues(ctx, conn, "pool", "", "csi.volumes.default",
+		[]string{"csi.volume.req-1999", "csi.volume.absent"})
+	if err != nil {
+		t.Fatalf("getOMapValues() error = %v", err)
+	}
+	if len(values) != 1 || values["csi.volume.req-1999"] != "uuid-1999" {
+		t.Errorf("getOMapValues() = %v, want only csi.volume.req-1999", values)
+	}
+	if cluster.reads != 1 {
+		t.Errorf("getOMapValues() reads = %d, want 1", cluster.reads)
+	}
+}
+
+func TestReserveOMapNameExists(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	cluster := newFakeOmapCluster()
+	conn := cluster.connection()
+
+	volUUID := "f7ce3a8e-0e3c-4b1a-9f0a-1c2d3e4f5a6b"
+	_, err := reserveOMapName(ctx, conn, "pool", "", "csi.volume.", volUUID, nil)
+	if err != nil {
+		t.Fatalf("reserveOMapName() error = %v", err)
+	}
+
+	// a requested UUID that is in use is not retried
+	_, err = reserveOMapName(ctx, conn, "pool", "", "csi.volume.", volUUID, nil)
+	if !errors.Is(err, util.ErrObjectExists) {
+		t.Errorf("reserveOMapName() error = %v, want %v", err, util.ErrObjectExists)
+	}
+}
+
+func TestOMapRetries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.TODO()
+	cluster := newFakeOmapCluster()
+	conn := cluster.connection()
+	pairs := map[string]string{"key": "value"}
+
+	// transient errors are retried
+	cluster.failures = []error{errnoError(-int(syscall.ETIMEDOUT))}
+	err := setOMapKeys(ctx, conn, "pool", "", "oid", pairs)
+	if err != nil {
+		t.Errorf("setOMapKeys() error = %v", err)
+	}
+	if cluster.writes != 2 {
+		t.Errorf("setOMapKeys() writes = %d, want 2", cluster.writes)
+	}
+
+	// a blocklisted client does not retry
+	cluster.failures = []error{errnoError(-int(syscall.ESHUTDOWN))}
+	_, err = getOMapValues(ctx, conn, "pool", "", "oid", []string{"key"})
+	if !errors.Is(err, util.ErrBlocklisted) {
+		t.Errorf("getOMapValues() error = %v, want %v", err, util.ErrBlocklisted)
+	}
+	if cluster.reads != 1 {
+		t.Errorf("getOMapValues() reads = %d, want 1", cluster.reads)
+	}
+
+	// the exclusive create is not retried
+	cluster.writes = 0
+	cluster.failures = []error{errnoError(-int(syscall.ETIMEDOUT))}
+	err = createOMap(ctx, conn, "pool", "", "new-oid", pairs)
+	if err == nil {
+		t.Error("createOMap() should have failed")
+	}
+	if cluster.writes != 1 {
+		t.Errorf("createOMap() writes = %d, want 1", cluster.writes)
+	}
+}
+
+func BenchmarkReserveName(b *testing.B) {
+	ctx := context.TODO()
+	cluster := newFakeOmapCluster()
+	conn := cluster.connection()
+
+	for i := 0; i < b.N; i++ {
+		reqName := fmt.Sprintf("req-%d", i)
+		_, _, err := conn.ReserveName(ctx, "pool", 1, "pool", 1,
+			reqName, "csi-vol-", "", "", "", "", "", util.EncryptionTypeNone)
+		if err != nil {
+			b.Fatalf("ReserveName() error = %v", err)
+		}
+		_, err = conn.CheckReservation(ctx, "pool", reqName, "csi-vol-", "", "", util.EncryptionTypeNone)
+		if err != nil {
+			b.Fatalf("CheckReservation() error = %v", err)
+		}
+	}
+	b.ReportMetric(float64(cluster.ops())/float64(b.N), "ops/reservation")
+}
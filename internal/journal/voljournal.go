@@ -155,7 +155,8 @@ type Config struct {
 	// ownerKey is used to identify the owner of the volume, can be used with some KMS configurations
 	ownerKey string
 
-	// backingSnapshotIDKey ID of the snapshot on which the CephFS snapshot-backed volume is based
+	// backingSnapshotIDKey ID of the snapshot on which the CephFS snapshot-backed or the RBD
+	// roxSharedClone volume is based
 	backingSnapshotIDKey string
 
 	// commonPrefix is the prefix common to all omap keys for this Config
@@ -249,6 +250,8 @@ type Connection struct {
 	cr       *util.Credentials
 	// cached cluster connection (required by go-ceph)
 	conn *util.ClusterConnection
+	// newOmapIOContext opens the omapIOContext for a pool
+	newOmapIOContext func(poolName string) (omapIOContext, error)
 }
 
 // Connect establishes a new connection to a ceph cluster for journal metadata.
@@ -259,10 +262,11 @@ func (cj *Config) Connect(monitors, namespace string, cr *util.Credentials) (*Co
 		return nil, fmt.Errorf("failed to establish the connection: %w", err)
 	}
 	conn := &Connection{
-		config:   cj,
-		monitors: monitors,
-		cr:       cr,
-		conn:     cc,
+		config:           cj,
+		monitors:         monitors,
+		cr:               cr,
+		conn:             cc,
+		newOmapIOContext: newRadosOmapIOContext(cc),
 	}
 
 	return conn, nil
@@ -309,8 +313,7 @@ func (conn *Connection) CheckReservation(ctx context.Context,
 		cj.csiNameKeyPrefix + reqName,
 	}
 	values, err := getOMapValues(
-		ctx, conn, journalPool, cj.namespace, cj.csiDirectory,
-		cj.commonPrefix, fetchKeys)
+		ctx, conn, journalPool, cj.namespace, cj.csiDirectory, fetchKeys)
 	if err != nil {
 		if errors.Is(err, util.ErrKeyNotFound) || errors.Is(err, util.ErrPoolNotFound) {
 			// pool or omap (oid) was not present
@@ -485,11 +488,13 @@ func (conn *Connection) UndoReservation(ctx context.Context,
 // already exists. If the passed volUUID is empty, it ensures generated omap name
 // does not already exist and if conflicts are detected, a set number of
 // retries with newer uuids are attempted before returning an error.
+// When omapValues is not nil, the keys it returns for the <uuid> are set in
+// the same operation that creates the omap.
 func reserveOMapName(
 	ctx context.Context,
-	monitors string,
-	cr *util.Credentials,
+	conn *Connection,
 	pool, namespace, oMapNamePrefix, volUUID string,
+	omapValues func(string) map[string]string,
 ) (string, error) {
 	var iterUUID string
 
@@ -503,7 +508,12 @@ func reserveOMapName(
 			iterUUID = uuid.New().String()
 		}
 
-		err := util.CreateObject(ctx, monitors, cr, pool, namespace, oMapNamePrefix+iterUUID)
+		var values map[string]string
+		if omapValues != nil {
+			values = omapValues(iterUUID)
+		}
+
+		err := createOMap(ctx, conn, pool, namespace, oMapNamePrefix+iterUUID, values)
 		if err != nil {
 			// if the volUUID is empty continue with retry as consumer of this
 			// function didn't request to create object with specific value.
@@ -549,7 +559,8 @@ Input arguments:
 	- encryptionType: Type of encryption used when kmsConf is set (optional)
 	- volUUID: UUID need to be reserved instead of auto-generating one (this is useful for mirroring and metro-DR)
 	- owner: the owner of the volume (optional)
-	- backingSnapshotID: ID of the snapshot on which the CephFS snapshot-backed volume is based (optional)
+	- backingSnapshotID: ID of the snapshot on which the CephFS snapshot-backed or the RBD roxSharedClone
+	  volume is based (optional)
 
 Return values:
 	- string: Contains the UUID that was reserved for the passed in reqName
@@ -580,18 +591,73 @@ func (conn *Connection) ReserveName(ctx context.Context,
 		snapSource = true
 	}
 
+	// NOTE: UUID directory is stored on the same pool as the image, helps determine image attributes
+	// 	and also CSI journal pool, when only the VolumeID is passed in (e.g DeleteVolume/DeleteSnapshot,
+	// 	VolID during CreateSnapshot).
+	omapValues := func(imageName string) map[string]string {
+		values := map[string]string{}
+
+		// Update UUID directory to store CSI request name
+		values[cj.csiNameKey] = reqName
+
+		// Update UUID directory to store image name
+		values[cj.csiImageKey] = imageName
+
+		// Update UUID directory to store encryption values
+		if kmsConf != "" {
+			values[cj.encryptKMSKey] = kmsConf
+			values[cj.encryptionType] = util.EncryptionTypeString(encryptionType)
+		}
+
+		// if owner is passed, set it in the UUID directory too
+		if owner != "" {
+			values[cj.ownerKey] = owner
+		}
+
+		if journalPool != imagePool && journalPoolID != util.InvalidPoolID {
+			buf64 := make([]byte, 8)
+			binary.BigEndian.PutUint64(buf64, uint64(journalPoolID))
+			journalPoolIDStr := hex.EncodeToString(buf64)
+
+			// Update UUID directory to store CSI journal pool name (prefer ID instead of name to be pool rename proof)
+			values[cj.csiJournalPool] = journalPoolIDStr
+		}
+
+		if snapSource {
+			// Update UUID directory to store source volume UUID in case of snapshots
+			values[cj.cephSnapSourceKey] = parentName
+		}
+
+		// Update backing snapshot ID for snapshot-backed CephFS and roxSharedClone RBD volumes
+		if backingSnapshotID != "" {
+			values[cj.backingSnapshotIDKey] = backingSnapshotID
+		}
+
+		return values
+	}
+
+	// When the UUID directory and the journal are in the same pool, the UUID
+	// based omap is created together with its keys in a single operation.
+	// Otherwise the keys are set after the request name key is stored.
+	var uuidValues func(string) map[string]string
+	if journalPool == imagePool {
+		uuidValues = func(id string) map[string]string {
+			return omapValues(cj.GetNameForUUID(namePrefix, id, snapSource))
+		}
+	}
+
 	// Create the UUID based omap first, to reserve the same and avoid conflicts
 	// NOTE: If any service loss occurs post creation of the UUID directory, and before
 	// setting the request name key (csiNameKey) to point back to the UUID directory, the
 	// UUID directory key will be leaked
 	volUUID, err = reserveOMapName(
 		ctx,
-		conn.monitors,
-		conn.cr,
+		conn,
 		imagePool,
 		cj.namespace,
 		cj.cephUUIDDirectoryPrefix,
-		volUUID)
+		volUUID,
+		uuidValues)
 	if err != nil {
 		return "", "", err
 	}
@@ -620,58 +686,19 @@ func (conn *Connection) ReserveName(ctx context.Context,
 	defer func() {
 		if err != nil {
 			log.WarningLog(ctx, "reservation failed for volume: %s", reqName)
-			errDefer := conn.UndoReservation(ctx, imagePool, journalPool, imageName, reqName)
+			errDefer := conn.UndoReservation(ctx, journalPool, imagePool, imageName, reqName)
 			if errDefer != nil {
 				log.WarningLog(ctx, "failed undoing reservation of volume: %s (%v)", reqName, errDefer)
 			}
 		}
 	}()
 
-	oid := cj.cephUUIDDirectoryPrefix + volUUID
-	omapValues := map[string]string{}
-
-	// NOTE: UUID directory is stored on the same pool as the image, helps determine image attributes
-	// 	and also CSI journal pool, when only the VolumeID is passed in (e.g DeleteVolume/DeleteSnapshot,
-	// 	VolID during CreateSnapshot).
-	// Update UUID directory to store CSI request name
-	omapValues[cj.csiNameKey] = reqName
-
-	// Update UUID directory to store image name
-	omapValues[cj.csiImageKey] = imageName
-
-	// Update UUID directory to store encryption values
-	if kmsConf != "" {
-		omapValues[cj.encryptKMSKey] = kmsConf
-		omapValues[cj.encryptionType] = util.EncryptionTypeString(encryptionType)
-	}
-
-	// if owner is passed, set it in the UUID directory too
-	if owner != "" {
-		omapValues[cj.ownerKey] = owner
-	}
-
-	if journalPool != imagePool && journalPoolID != util.InvalidPoolID {
-		buf64 := make([]byte, 8)
-		binary.BigEndian.PutUint64(buf64, uint64(journalPoolID))
-		journalPoolIDStr := hex.EncodeToString(buf64)
-
-		// Update UUID directory to store CSI journal pool name (prefer ID instead of name to be pool rename proof)
-		omapValues[cj.csiJournalPool] = journalPoolIDStr
-	}
-
-	if snapSource {
-		// Update UUID directory to store source volume UUID in case of snapshots
-		omapValues[cj.cephSnapSourceKey] = parentName
-	}
-
-	// Update backing snapshot ID for snapshot-backed CephFS volume
-	if backingSnapshotID != "" {
-		omapValues[cj.backingSnapshotIDKey] = backingSnapshotID
-	}
-
-	err = setOMapKeys(ctx, conn, journalPool, cj.namespace, oid, omapValues)
-	if err != nil {
-		return "", "", err
+	if uuidValues == nil {
+		oid := cj.cephUUIDDirectoryPrefix + volUUID
+		err = setOMapKeys(ctx, conn, imagePool, cj.namespace, oid, omapValues(imageName))
+		if err != nil {
+			return "", "", err
+		}
 	}
 
 	return volUUID, imageName, nil
@@ -687,7 +714,7 @@ type ImageAttributes struct {
 	Owner             string              // Contains the owner to be used in combination with KmsID (for some KMS)
 	ImageID           string              // Contains the image id
 	JournalPoolID     int64               // Pool ID of the CSI journal pool, stored in big endian format (on-disk data)
-	BackingSnapshotID string              // ID of the snapshot on which the CephFS snapshot-backed volume is based
+	BackingSnapshotID string              // ID of the snapshot of a CephFS snapshot-backed or RBD roxSharedClone volume
 }
 
 // GetImageAttributes fetches all keys and their values, from a UUID directory, returning ImageAttributes structure.
@@ -720,8 +747,7 @@ func (conn *Connection) GetImageAttributes(
 		cj.backingSnapshotIDKey,
 	}
 	values, err := getOMapValues(
-		ctx, conn, pool, cj.namespace, cj.cephUUIDDirectoryPrefix+objectUUID,
-		cj.commonPrefix, fetchKeys)
+		ctx, conn, pool, cj.namespace, cj.cephUUIDDirectoryPrefix+objectUUID, fetchKeys)
 	if err != nil {
 		if !errors.Is(err, util.ErrKeyNotFound) && !errors.Is(err, util.ErrPoolNotFound) {
 			return nil, err
@@ -772,7 +798,9 @@ func (conn *Connection) GetImageAttributes(
 	return imageAttributes, nil
 }
 
-// StoreImageID stores the image ID in omap.
+// StoreImageID stores the image ID in omap. pool is the pool of the image,
+// which has the UUID directory of the reservation, also when the request name
+// is reserved in a different journal pool.
 func (conn *Connection) StoreImageID(ctx context.Context, pool, reservedUUID, imageID string) error {
 	err := setOMapKeys(ctx, conn, pool, conn.config.namespace, conn.config.cephUUIDDirectoryPrefix+reservedUUID,
 		map[string]string{conn.config.csiImageIDKey: imageID})
@@ -800,7 +828,7 @@ func (conn *Connection) FetchAttribute(ctx context.Context, pool, reservedUUID,
 	key := conn.config.commonPrefix + attribute
 	values, err := getOMapValues(
 		ctx, conn, pool, conn.config.namespace, conn.config.cephUUIDDirectoryPrefix+reservedUUID,
-		conn.config.commonPrefix, []string{key})
+		[]string{key})
 	if err != nil {
 		return "", fmt.Errorf("failed to get values for key %q from OMAP: %w", key, err)
 	}
@@ -832,8 +860,7 @@ func (conn *Connection) CheckNewUUIDMapping(ctx context.Context,
 		cj.csiNameKeyPrefix + volumeHandle,
 	}
 	values, err := getOMapValues(
-		ctx, conn, journalPool, cj.namespace, cj.csiDirectory,
-		cj.commonPrefix, fetchKeys)
+		ctx, conn, journalPool, cj.namespace, cj.csiDirectory, fetchKeys)
 	if err != nil {
 		if errors.Is(err, util.ErrKeyNotFound) || errors.Is(err, util.ErrPoolNotFound) {
 			// pool or omap (oid) was not present
@@ -19,8 +19,10 @@ package controller
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/ceph/ceph-csi/internal/cephfs"
+	fscore "github.com/ceph/ceph-csi/internal/cephfs/core"
 	"github.com/ceph/ceph-csi/internal/cephfs/store"
 	fsutil "github.com/ceph/ceph-csi/internal/cephfs/util"
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
@@ -79,6 +81,20 @@ func (cs *Server) CreateVolume(
 ) (*csi.CreateVolumeResponse, error) {
 	// nfs does not supports shallow snapshots
 	req.Parameters["backingSnapshot"] = "false"
+
+	// subvolumes that are exported over NFS can not be earmarked for an
+	// other usage, like SMB
+	err := fscore.CheckEarmarkConflict(req.Parameters["earmark"], fscore.EarmarkScopeNFS)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	// validate the export parameters before creating the backend volume
+	_, _, err = parseExportAccess(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	res, err := cs.backendServer.CreateVolume(ctx, req)
 	if err != nil {
 		return nil, err
@@ -88,6 +104,22 @@ func (cs *Server) CreateVolume(
 
 	log.DebugLog(ctx, "CephFS volume created: %s", backend.VolumeId)
 
+	err = checkSubvolumeEarmark(ctx, backend.VolumeId, req.GetSecrets())
+	if errors.Is(err, fscore.ErrEarmarkConflict) {
+		// the earmark can only be checked once the subvolume exists, do not
+		// leave the subvolume behind when it can not be exported
+		delErr := cs.deleteBackendVolume(ctx, backend.VolumeId, req.GetSecrets())
+		if delErr != nil {
+			log.ErrorLog(ctx, "failed to delete CephFS volume %s: %v", backend.VolumeId, delErr)
+		}
+
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	} else if err != nil {
+		// the subvolume is kept, the earmark is checked again when the
+		// CreateVolume request is retried
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
 	secret := req.GetSecrets()
 	cr, err := util.NewAdminCredentials(secret)
 	if err != nil {
@@ -109,7 +141,9 @@ func (cs *Server) CreateVolume(
 	defer nfsVolume.Destroy()
 
 	err = nfsVolume.CreateExport(backend)
-	if err != nil {
+	if errors.Is(err, ErrClientsNotSupported) {
+		return nil, status.Errorf(codes.Unimplemented, "failed to create export: %v", err)
+	} else if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "failed to create export: %v", err)
 	}
 
@@ -122,6 +156,39 @@ func (cs *Server) CreateVolume(
 	return &csi.CreateVolumeResponse{Volume: backend}, nil
 }
 
+// deleteBackendVolume deletes the backend volume of a volume that was not
+// exported yet.
+func (cs *Server) deleteBackendVolume(ctx context.Context, volumeID string, secrets map[string]string) error {
+	_, err := cs.backendServer.DeleteVolume(ctx, &csi.DeleteVolumeRequest{
+		VolumeId: volumeID,
+		Secrets:  secrets,
+	})
+
+	return err
+}
+
+// checkSubvolumeEarmark verifies that the subvolume backing the volume is not
+// earmarked for an other usage than NFS. The earmark may have been set outside
+// of Ceph-CSI, so the actual earmark of the subvolume is checked.
+func checkSubvolumeEarmark(ctx context.Context, volumeID string, secrets map[string]string) error {
+	volOptions, _, err := store.NewVolumeOptionsFromVolID(ctx, volumeID, nil, secrets, "", false)
+	if err != nil {
+		return err
+	}
+	defer volOptions.Destroy()
+
+	volClient := fscore.NewSubVolume(volOptions.GetConnection(), &volOptions.SubVolume,
+		volOptions.ClusterID, "", false)
+	earmark, err := volClient.GetEarmark(ctx)
+	if errors.Is(err, fscore.ErrEarmarkNotSupported) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return fscore.CheckEarmarkConflict(earmark, fscore.EarmarkScopeNFS)
+}
+
 // DeleteVolume deletes the volume in backend and its reservation.
 func (cs *Server) DeleteVolume(
 	ctx context.Context,
@@ -147,6 +214,8 @@ func (cs *Server) DeleteVolume(
 	}
 	defer nfsVolume.Destroy()
 
+	// the export is removed by its pseudo path, changes to the clients of
+	// the export that were made outside of Ceph-CSI do not prevent deletion
 	err = nfsVolume.DeleteExport()
 	// if the export does not exist, continue with deleting the backend volume
 	if err != nil && !errors.Is(err, ErrNotFound) {
@@ -160,14 +229,52 @@ func (cs *Server) DeleteVolume(
 
 // ControllerExpandVolume calls the backend (CephFS) procedure to expand the
 // volume. There is no interaction with the NFS-server needed to publish the
-// new size.
+// new size. Shrinking the volume is not supported.
 func (cs *Server) ControllerExpandVolume(
 	ctx context.Context,
 	req *csi.ControllerExpandVolumeRequest,
 ) (*csi.ControllerExpandVolumeResponse, error) {
+	// requests without capacity range are rejected by the backend
+	if req.GetCapacityRange() != nil {
+		err := checkSubvolumeShrink(ctx, req.GetVolumeId(), req.GetCapacityRange().GetRequiredBytes(),
+			req.GetSecrets())
+		if errors.Is(err, ErrVolumeShrink) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		} else if err != nil {
+			log.ErrorLog(ctx, "failed to get size of volume %s: %v", req.GetVolumeId(), err)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	return cs.backendServer.ControllerExpandVolume(ctx, req)
 }
 
+// checkSubvolumeShrink returns ErrVolumeShrink when the requested size is
+// smaller than the current size of the subvolume backing the volume.
+func checkSubvolumeShrink(ctx context.Context, volumeID string, requestedBytes int64, secrets map[string]string) error {
+	volOptions, _, err := store.NewVolumeOptionsFromVolID(ctx, volumeID, nil, secrets, "", false)
+	if err != nil {
+		return err
+	}
+	defer volOptions.Destroy()
+
+	// the size of the subvolume was fetched with the volume options
+	return validateExpandSize(volOptions.Size, util.RoundOffCephFSVolSize(requestedBytes))
+}
+
+// validateExpandSize returns ErrVolumeShrink when the new size is smaller
+// than the current size. A current size of 0 means the subvolume has no
+// quota, any new size is accepted then.
+func validateExpandSize(currentBytes, newBytes int64) error {
+	if currentBytes != 0 && newBytes < currentBytes {
+		return fmt.Errorf("%w: requested size %d is smaller than current size %d",
+			ErrVolumeShrink, newBytes, currentBytes)
+	}
+
+	return nil
+}
+
 // CreateSnapshot calls the backend (CephFS) procedure to create snapshot.
 // There is no interaction with the NFS-server needed for snapshot creation.
 func (cs *Server) CreateSnapshot(
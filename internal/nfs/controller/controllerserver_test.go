@@ -0,0 +1,50 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExpandSize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		current   int64
+		requested int64
+		wantErr   bool
+	}{
+		{"grow", 1 << 30, 2 << 30, false},
+		{"same size", 1 << 30, 1 << 30, false},
+		{"no quota", 0, 1 << 30, false},
+		{"shrink", 2 << 30, 1 << 30, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateExpandSize(tt.current, tt.requested)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrVolumeShrink)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -40,4 +40,17 @@ var (
 	// ErrFilesystemNotFound is returned in case the filesystem
 	// does not exist.
 	ErrFilesystemNotFound = fmt.Errorf("filesystem %w", ErrNotFound)
+
+	// ErrInvalidParameter is returned when a parameter for the NFS-export
+	// is not valid.
+	ErrInvalidParameter = errors.New("invalid parameter")
+
+	// ErrClientsNotSupported is returned when the NFS module of the Ceph
+	// Mgr can not create exports that are restricted to clients or
+	// read-only access.
+	ErrClientsNotSupported = errors.New("restricting the clients or access type of NFS-exports is not supported")
+
+	// ErrVolumeShrink is returned when a volume is expanded to a smaller
+	// size than it currently has.
+	ErrVolumeShrink = errors.New("shrinking a volume is not supported")
 )
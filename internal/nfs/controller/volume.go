@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 
 	fscore "github.com/ceph/ceph-csi/internal/cephfs/core"
@@ -35,6 +36,17 @@ const (
 	// clusterNameKey is the key in OMAP that contains the name of the
 	// NFS-cluster. It will be prefixed with the journal configuration.
 	clusterNameKey = "nfs.cluster"
+
+	// exportClientsKey is the parameter with the comma separated IP
+	// addresses and networks (CIDR) of the clients that can access the
+	// export.
+	exportClientsKey = "clients"
+	// exportAccessTypeKey is the parameter for the access type of the
+	// export, accessTypeReadWrite or accessTypeReadOnly.
+	exportAccessTypeKey = "accessType"
+
+	accessTypeReadWrite = "rw"
+	accessTypeReadOnly  = "ro"
 )
 
 // NFSVolume presents the API for consumption by the CSI-controller to create,
@@ -143,18 +155,9 @@ func (nv *NFSVolume) CreateExport(backend *csi.Volume) error {
 		return fmt.Errorf("failed to get NFSAdmin: %w", err)
 	}
 
-	export := nfs.CephFSExportSpec{
-		FileSystemName: fs,
-		ClusterID:      nfsCluster,
-		PseudoPath:     nv.GetExportPath(),
-		Path:           path,
-	}
-
-	if secTypes != "" {
-		export.SecType = []nfs.SecType{}
-		for _, secType := range strings.Split(secTypes, ",") {
-			export.SecType = append(export.SecType, nfs.SecType(secType))
-		}
+	export, err := newCephFSExportSpec(fs, nfsCluster, nv.GetExportPath(), path, secTypes, backend.VolumeContext)
+	if err != nil {
+		return err
 	}
 
 	_, err = nfsa.CreateCephFSExport(export)
@@ -170,6 +173,12 @@ func (nv *NFSVolume) CreateExport(backend *csi.Volume) error {
 
 	// if we get here, the API call failed, fallback to the old command
 
+	// the old command can not restrict the clients or access type, do not
+	// create an export that is more open than requested
+	if len(export.ClientAddr) != 0 || export.ReadOnly {
+		return fmt.Errorf("exporting %q on NFS-cluster %q failed: %w", nv, nfsCluster, ErrClientsNotSupported)
+	}
+
 	// ceph nfs export create cephfs ${FS} ${NFS} /${EXPORT} ${SUBVOL_PATH}
 	cmd := nv.createExportCommand(nfsCluster, fs, nv.GetExportPath(), path)
 
@@ -182,6 +191,68 @@ func (nv *NFSVolume) CreateExport(backend *csi.Volume) error {
 	return nil
 }
 
+// newCephFSExportSpec returns the specification for the export of the CephFS
+// path. The clients and accessType parameters restrict the export to the
+// listed client addresses and networks, and select read-write or read-only
+// access.
+func newCephFSExportSpec(
+	fs, nfsCluster, pseudoPath, path, secTypes string,
+	parameters map[string]string,
+) (nfs.CephFSExportSpec, error) {
+	export := nfs.CephFSExportSpec{
+		FileSystemName: fs,
+		ClusterID:      nfsCluster,
+		PseudoPath:     pseudoPath,
+		Path:           path,
+	}
+
+	if secTypes != "" {
+		export.SecType = []nfs.SecType{}
+		for _, secType := range strings.Split(secTypes, ",") {
+			export.SecType = append(export.SecType, nfs.SecType(secType))
+		}
+	}
+
+	clients, readOnly, err := parseExportAccess(parameters)
+	if err != nil {
+		return export, err
+	}
+	export.ClientAddr = clients
+	export.ReadOnly = readOnly
+
+	return export, nil
+}
+
+// parseExportAccess validates the clients and accessType parameters. It
+// returns the client addresses and networks that may access the export, and
+// true if the access is read-only. Without clients, the export is accessible
+// by all clients.
+func parseExportAccess(parameters map[string]string) ([]string, bool, error) {
+	var clients []string
+	if val := parameters[exportClientsKey]; val != "" {
+		for _, client := range strings.Split(val, ",") {
+			client = strings.TrimSpace(client)
+			if net.ParseIP(client) == nil {
+				if _, _, err := net.ParseCIDR(client); err != nil {
+					return nil, false, fmt.Errorf("%w: %s %q is not an IP address or CIDR",
+						ErrInvalidParameter, exportClientsKey, client)
+				}
+			}
+			clients = append(clients, client)
+		}
+	}
+
+	switch accessType := parameters[exportAccessTypeKey]; accessType {
+	case "", accessTypeReadWrite:
+		return clients, false, nil
+	case accessTypeReadOnly:
+		return clients, true, nil
+	default:
+		return nil, false, fmt.Errorf("%w: %s %q should be %q or %q", ErrInvalidParameter,
+			exportAccessTypeKey, accessType, accessTypeReadWrite, accessTypeReadOnly)
+	}
+}
+
 // createExportCommand returns the "ceph nfs export create ..." command
 // arguments (without "ceph"). The order of the parameters matches old Ceph
 // releases, new Ceph releases added --option formats, which can be added  when
@@ -0,0 +1,127 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/ceph/go-ceph/common/admin/nfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExportAccess(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		clients    []string
+		readOnly   bool
+		wantErr    bool
+	}{
+		{
+			name:       "no restrictions",
+			parameters: map[string]string{},
+		},
+		{
+			name:       "read-only for all clients",
+			parameters: map[string]string{"accessType": "ro"},
+			readOnly:   true,
+		},
+		{
+			name:       "clients with default access type",
+			parameters: map[string]string{"clients": "192.168.0.0/16, 10.0.0.1"},
+			clients:    []string{"192.168.0.0/16", "10.0.0.1"},
+		},
+		{
+			name:       "read-write clients",
+			parameters: map[string]string{"clients": "2001:db8::/32", "accessType": "rw"},
+			clients:    []string{"2001:db8::/32"},
+		},
+		{
+			name:       "read-only clients",
+			parameters: map[string]string{"clients": "10.0.0.0/8", "accessType": "ro"},
+			clients:    []string{"10.0.0.0/8"},
+			readOnly:   true,
+		},
+		{
+			name:       "hostname as client",
+			parameters: map[string]string{"clients": "nfs-client.example.com"},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid network",
+			parameters: map[string]string{"clients": "10.0.0.0/33"},
+			wantErr:    true,
+		},
+		{
+			name:       "empty client",
+			parameters: map[string]string{"clients": "10.0.0.1,"},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown access type",
+			parameters: map[string]string{"accessType": "none"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			clients, readOnly, err := parseExportAccess(tt.parameters)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidParameter)
+
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.clients, clients)
+			assert.Equal(t, tt.readOnly, readOnly)
+		})
+	}
+}
+
+func TestNewCephFSExportSpec(t *testing.T) {
+	t.Parallel()
+
+	export, err := newCephFSExportSpec("myfs", "mynfs", "/0001-vol", "/volumes/csi/vol/uuid", "sys,krb5",
+		map[string]string{"clients": "10.0.0.0/8,192.168.1.10", "accessType": "ro"})
+	assert.NoError(t, err)
+	assert.Equal(t, nfs.CephFSExportSpec{
+		FileSystemName: "myfs",
+		ClusterID:      "mynfs",
+		PseudoPath:     "/0001-vol",
+		Path:           "/volumes/csi/vol/uuid",
+		ReadOnly:       true,
+		ClientAddr:     []string{"10.0.0.0/8", "192.168.1.10"},
+		SecType:        []nfs.SecType{"sys", "krb5"},
+	}, export)
+
+	// without parameters the export is not restricted
+	export, err = newCephFSExportSpec("myfs", "mynfs", "/0001-vol", "/volumes/csi/vol/uuid", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, nfs.CephFSExportSpec{
+		FileSystemName: "myfs",
+		ClusterID:      "mynfs",
+		PseudoPath:     "/0001-vol",
+		Path:           "/volumes/csi/vol/uuid",
+	}, export)
+
+	_, err = newCephFSExportSpec("myfs", "mynfs", "/0001-vol", "/volumes/csi/vol/uuid", "",
+		map[string]string{"clients": "everyone"})
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
@@ -77,13 +77,18 @@ func (fs *Driver) Run(conf *util.Config) {
 		srv.CS = controller.NewControllerServer(cd)
 	}
 
+	if conf.EnableRPCMetrics {
+		csicommon.EnableRPCMetrics()
+	}
 	server.Start(conf.Endpoint, conf.HistogramOption, srv, conf.EnableGRPCMetrics)
 	if conf.EnableGRPCMetrics {
 		log.WarningLogMsg("EnableGRPCMetrics is deprecated")
+	}
+	if conf.EnableGRPCMetrics || conf.EnableRPCMetrics {
 		go util.StartMetricsServer(conf)
 	}
 	if conf.EnableProfiling {
-		if !conf.EnableGRPCMetrics {
+		if !conf.EnableGRPCMetrics && !conf.EnableRPCMetrics {
 			go util.StartMetricsServer(conf)
 		}
 		log.DebugLogMsg("Registering profiling handler")
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	utilexec "k8s.io/utils/exec"
+)
+
+// ensureUniqueBtrfsUUID gives the btrfs filesystem on devicePath a new UUID
+// when another device of the node has a filesystem with the same UUID. Clones
+// and restored snapshots keep the UUID of their parent, and btrfs can not use
+// two filesystems with the same UUID on one node. The filesystem must not be
+// mounted.
+func ensureUniqueBtrfsUUID(ctx context.Context, exec utilexec.Interface, devicePath string) error {
+	// do not use the blkid cache, it may not contain new devices
+	out, err := exec.Command("blkid", "-c", "/dev/null", "-s", "UUID", "-o", "value", devicePath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to get the filesystem UUID of device %s: %w (%s)", devicePath, err, string(out))
+	}
+	fsUUID := strings.TrimSpace(string(out))
+	if fsUUID == "" {
+		return nil
+	}
+
+	out, err = exec.Command("blkid", "-c", "/dev/null", "-t", "UUID="+fsUUID, "-o", "device").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to find the devices with filesystem UUID %s: %w (%s)", fsUUID, err, string(out))
+	}
+	if !hasOtherDevice(strings.Fields(string(out)), devicePath) {
+		return nil
+	}
+
+	log.DebugLog(ctx, "btrfs filesystem UUID %s of device %s is used by another device, generating a new one",
+		fsUUID, devicePath)
+	// -f skips the confirmation, the filesystem is not mounted
+	out, err = exec.Command("btrfstune", "-f", "-u", devicePath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to generate a new filesystem UUID for device %s: %w (%s)",
+			devicePath, err, string(out))
+	}
+
+	return nil
+}
+
+// hasOtherDevice returns true if devices contains a device other than
+// devicePath. Symlinks, like /dev/mapper/<name>, are resolved to compare the
+// devices.
+func hasOtherDevice(devices []string, devicePath string) bool {
+	resolve := func(device string) string {
+		resolved, err := filepath.EvalSymlinks(device)
+		if err != nil {
+			return device
+		}
+
+		return resolved
+	}
+
+	self := resolve(devicePath)
+	for _, device := range devices {
+		if resolve(device) != self {
+			return true
+		}
+	}
+
+	return false
+}
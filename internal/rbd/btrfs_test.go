@@ -0,0 +1,58 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasOtherDevice(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	dm0 := filepath.Join(dir, "dm-0")
+	dm1 := filepath.Join(dir, "dm-1")
+	mapper := filepath.Join(dir, "luks-rbd-vol")
+	for _, device := range []string{dm0, dm1} {
+		require.NoError(t, os.WriteFile(device, nil, 0o600))
+	}
+	require.NoError(t, os.Symlink(dm0, mapper))
+
+	tests := []struct {
+		name       string
+		devices    []string
+		devicePath string
+		want       bool
+	}{
+		{"only the device", []string{"/dev/rbd0"}, "/dev/rbd0", false},
+		{"no devices", nil, "/dev/rbd0", false},
+		{"clone of the device", []string{"/dev/rbd0", "/dev/rbd1"}, "/dev/rbd1", true},
+		{"device by its symlink", []string{dm0}, mapper, false},
+		{"other device and symlink", []string{dm0, dm1}, mapper, true},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, ts.want, hasOtherDevice(ts.devices, ts.devicePath))
+		})
+	}
+}
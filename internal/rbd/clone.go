@@ -160,7 +160,7 @@ func (rv *rbdVolume) createCloneFromImage(ctx context.Context, parentVol *rbdVol
 		return fmt.Errorf("failed to copy encryption config for %q: %w", rv, err)
 	}
 
-	err = j.StoreImageID(ctx, rv.JournalPool, rv.ReservedID, rv.ImageID)
+	err = j.StoreImageID(ctx, rv.Pool, rv.ReservedID, rv.ImageID)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to store volume %s: %v", rv, err)
 
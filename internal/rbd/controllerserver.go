@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/util"
@@ -36,6 +37,10 @@ import (
 
 const (
 	oneGB = 1073741824
+
+	// stripeUnitAlignment is the size in bytes the stripeUnit needs to be
+	// a multiple of.
+	stripeUnitAlignment = 512
 )
 
 // ControllerServer struct of rbd CSI driver with supported methods of CSI
@@ -58,6 +63,10 @@ type ControllerServer struct {
 
 	// Set metadata on volume
 	SetMetadata bool
+
+	// TrashExpiry is the default time an image is kept in the trash when
+	// the volume is deleted.
+	TrashExpiry time.Duration
 }
 
 func (cs *ControllerServer) validateVolumeReq(ctx context.Context, req *csi.CreateVolumeRequest) error {
@@ -117,6 +126,26 @@ func validateStriping(parameters map[string]string) error {
 		return errors.New("stripeUnit must be specified when stripeCount is specified")
 	}
 
+	var unit uint64
+	if stripeUnit != "" {
+		var err error
+		unit, err = strconv.ParseUint(stripeUnit, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse stripeUnit %s: %w", stripeUnit, err)
+		}
+		if unit == 0 || unit%stripeUnitAlignment != 0 {
+			return fmt.Errorf("stripeUnit %s is not a multiple of %d", stripeUnit, stripeUnitAlignment)
+		}
+
+		count, err := strconv.ParseUint(stripeCount, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse stripeCount %s: %w", stripeCount, err)
+		}
+		if count < 1 {
+			return fmt.Errorf("stripeCount %s must be at least 1", stripeCount)
+		}
+	}
+
 	objectSize := parameters["objectSize"]
 	if objectSize != "" {
 		objSize, err := strconv.ParseUint(objectSize, 10, 64)
@@ -133,6 +162,11 @@ func validateStriping(parameters map[string]string) error {
 		if objSize == 0 || (objSize&(objSize-1)) != 0 {
 			return fmt.Errorf("objectSize %s is not power of 2", objectSize)
 		}
+
+		// librbd requires the objects to hold a whole number of stripe units
+		if unit != 0 && objSize%unit != 0 {
+			return fmt.Errorf("objectSize %s is not a multiple of stripeUnit %s", objectSize, stripeUnit)
+		}
 	}
 
 	return nil
@@ -210,8 +244,75 @@ func (cs *ControllerServer) parseVolCreateRequest(
 	rbdVol.RequestedVolSize = rbdVol.VolSize
 
 	// start with pool the same as journal pool, in case there is a topology
-	// based split, pool for the image will be updated subsequently
+	// based split, pool for the image will be updated subsequently. The
+	// journal can be placed in a separate pool with the journalPool
+	// parameter, the UUID directory of the image stays in the image pool.
 	rbdVol.JournalPool = rbdVol.Pool
+	if journalPool := req.GetParameters()["journalPool"]; journalPool != "" {
+		rbdVol.JournalPool = journalPool
+	}
+
+	if trashExpiry, ok := req.GetParameters()["trashExpiry"]; ok {
+		rbdVol.TrashExpiry, err = parseTrashExpiry(trashExpiry)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		rbdVol.trashExpirySet = true
+	}
+
+	if thickProvision, ok := req.GetParameters()["thickProvision"]; ok {
+		rbdVol.ThickProvision, err = strconv.ParseBool(thickProvision)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid thickProvision %q: %v", thickProvision, err)
+		}
+		// zero-filling would overwrite the data of the source
+		if rbdVol.ThickProvision && req.GetVolumeContentSource() != nil {
+			return nil, status.Error(codes.InvalidArgument,
+				"thickProvision is not supported for volumes with a data source")
+		}
+	}
+
+	if roxSharedClone, ok := req.GetParameters()["roxSharedClone"]; ok {
+		shared, pErr := strconv.ParseBool(roxSharedClone)
+		if pErr != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid roxSharedClone %q: %v", roxSharedClone, pErr)
+		}
+		// only read-only volumes restored from a snapshot use the shared
+		// clone, other volumes get a clone of their own
+		rbdVol.RoxSharedClone = shared && req.GetVolumeContentSource().GetSnapshot() != nil &&
+			isReaderOnlyVolume(req.GetVolumeCapabilities())
+		if rbdVol.RoxSharedClone && (rbdVol.isBlockEncrypted() || rbdVol.isFileEncrypted()) {
+			return nil, status.Error(codes.InvalidArgument,
+				"roxSharedClone is not supported for encrypted volumes")
+		}
+	}
+
+	// reject mkfsOptions that NodeStageVolume would refuse to use
+	err = validateMkfsOptions(req.GetParameters()["mkfsOptions"])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// the default mode is recorded in the volume context too, so that
+	// NodeStageVolume does not depend on the default of the node plugin
+	rbdVol.FsckMode, err = parseFsckMode(req.GetParameters()["fsckMode"])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	for _, name := range []string{"mapOptions", "unmapOptions"} {
+		err = validateMapOptions(name, req.GetParameters()[name])
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	for _, name := range []string{"nbdIOTimeout", "nbdReattachTimeout"} {
+		_, err = parseNbdTimeout(name, req.GetParameters()[name])
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
 
 	// store topology information from the request
 	rbdVol.TopologyPools, rbdVol.TopologyRequirement, err = util.GetTopologyFromRequest(req)
@@ -230,6 +331,9 @@ func buildCreateVolumeResponse(req *csi.CreateVolumeRequest, rbdVol *rbdVolume)
 	volumeContext["pool"] = rbdVol.Pool
 	volumeContext["journalPool"] = rbdVol.JournalPool
 	volumeContext["imageName"] = rbdVol.RbdImageName
+	if rbdVol.FsckMode != "" {
+		volumeContext["fsckMode"] = rbdVol.FsckMode
+	}
 	if rbdVol.RadosNamespace != "" {
 		volumeContext["radosNamespace"] = rbdVol.RadosNamespace
 	}
@@ -262,7 +366,7 @@ func getGRPCErrorForCreateVolume(err error) error {
 	if errors.Is(err, ErrVolNameConflict) {
 		return status.Error(codes.AlreadyExists, err.Error())
 	}
-	if errors.Is(err, ErrFlattenInProgress) {
+	if errors.Is(err, ErrFlattenInProgress) || errors.Is(err, ErrAllocationInProgress) {
 		return status.Error(codes.Aborted, err.Error())
 	}
 
@@ -358,6 +462,10 @@ func (cs *ControllerServer) CreateVolume(
 		return nil, err
 	}
 
+	if rbdVol.RoxSharedClone {
+		return cs.createRoxSharedCloneVolume(ctx, req, cr, rbdVol, rbdSnap)
+	}
+
 	err = reserveVol(ctx, rbdVol, rbdSnap, cr)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -376,6 +484,9 @@ func (cs *ControllerServer) CreateVolume(
 		if errors.Is(err, ErrFlattenInProgress) {
 			return nil, status.Error(codes.Aborted, err.Error())
 		}
+		if errors.Is(err, ErrInvalidStriping) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 
 		return nil, err
 	}
@@ -383,6 +494,9 @@ func (cs *ControllerServer) CreateVolume(
 	// Set Metadata on PV Create
 	metadata := k8s.GetVolumeMetadata(req.GetParameters())
 	err = rbdVol.setAllMetadata(metadata)
+	if err == nil {
+		err = rbdVol.storeTrashExpiry()
+	}
 	if err != nil {
 		if deleteErr := rbdVol.deleteImage(ctx); deleteErr != nil {
 			log.ErrorLog(ctx, "failed to delete rbd image: %s with error: %v", rbdVol, deleteErr)
@@ -391,6 +505,14 @@ func (cs *ControllerServer) CreateVolume(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// the image is allocated in the background, the CO retries the request
+	// until the allocation completed
+	if rbdVol.ThickProvision {
+		if aErr := rbdVol.ensureAllocated(ctx); aErr != nil {
+			return nil, getGRPCErrorForCreateVolume(aErr)
+		}
+	}
+
 	return buildCreateVolumeResponse(req, rbdVol), nil
 }
 
@@ -474,6 +596,10 @@ func flattenParentImage(
 func (cs *ControllerServer) repairExistingVolume(ctx context.Context, req *csi.CreateVolumeRequest,
 	cr *util.Credentials, rbdVol *rbdVolume, rbdSnap *rbdSnapshot,
 ) (*csi.CreateVolumeResponse, error) {
+	if rbdVol.BackingSnapshotID != "" {
+		return cs.repairRoxSharedCloneVolume(ctx, req, cr, rbdVol, rbdSnap)
+	}
+
 	vcs := req.GetVolumeContentSource()
 
 	switch {
@@ -525,6 +651,17 @@ func (cs *ControllerServer) repairExistingVolume(ctx context.Context, req *csi.C
 	if err != nil {
 		return nil, err
 	}
+	err = rbdVol.storeTrashExpiry()
+	if err != nil {
+		return nil, err
+	}
+
+	if rbdVol.ThickProvision {
+		err = rbdVol.ensureAllocated(ctx)
+		if err != nil {
+			return nil, getGRPCErrorForCreateVolume(err)
+		}
+	}
 
 	return buildCreateVolumeResponse(req, rbdVol), nil
 }
@@ -650,12 +787,35 @@ func (cs *ControllerServer) createVolumeFromSnapshot(
 	// as we are operating on single cluster reuse the connection
 	parentVol.conn = rbdVol.conn.Copy()
 
-	// create clone image and delete snapshot
-	err = rbdVol.cloneRbdImageFromSnapshot(ctx, rbdSnap, parentVol)
-	if err != nil {
-		log.ErrorLog(ctx, "failed to clone rbd image %s from snapshot %s: %v", rbdVol, rbdSnap, err)
+	fullCopy := false
+	if rbdVol.MaxCloneDepth != 0 {
+		var depth uint
+		depth, err = parentVol.getCloneDepth(ctx)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to get clone depth of snapshot %s: %v", rbdSnap, err)
 
-		return err
+			return status.Error(codes.Internal, err.Error())
+		}
+		fullCopy = exceedsMaxCloneDepth(depth, rbdVol.MaxCloneDepth)
+	}
+
+	if fullCopy {
+		// copy the snapshot to avoid a parent chain deeper than maxCloneDepth
+		log.DebugLog(ctx, "copying snapshot %s as clone depth would exceed %d", rbdSnap, rbdVol.MaxCloneDepth)
+		err = rbdVol.copyRbdImageFromSnapshot(ctx, rbdSnap, parentVol)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to copy rbd image %s from snapshot %s: %v", rbdVol, rbdSnap, err)
+
+			return err
+		}
+	} else {
+		// create clone image and delete snapshot
+		err = rbdVol.cloneRbdImageFromSnapshot(ctx, rbdSnap, parentVol)
+		if err != nil {
+			log.ErrorLog(ctx, "failed to clone rbd image %s from snapshot %s: %v", rbdVol, rbdSnap, err)
+
+			return err
+		}
 	}
 
 	defer func() {
@@ -806,6 +966,14 @@ func checkContentSource(
 			return nil, nil, status.Errorf(codes.NotFound, "%s image does not exist", volID)
 		}
 
+		// the shared clone belongs to all the volumes of the snapshot
+		if rbdvol.RoxSharedClone {
+			rbdvol.Destroy()
+
+			return nil, nil, status.Errorf(codes.InvalidArgument,
+				"volume %s uses the shared clone of a snapshot and can not be cloned", volID)
+		}
+
 		return rbdvol, nil, nil
 	}
 
@@ -859,6 +1027,13 @@ func (cs *ControllerServer) checkErrAndUndoReserve(
 	}
 	defer cs.VolumeLocks.Release(rbdVol.RequestName)
 
+	// the missing shared clone is not used by this volume anymore
+	if rbdVol.BackingSnapshotID != "" {
+		if _, err = rbdVol.removeRoxSharedCloneRef(); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	if err = undoVolReservation(ctx, rbdVol, cr); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -936,6 +1111,14 @@ func (cs *ControllerServer) DeleteVolume(
 	}
 	defer cs.VolumeLocks.Release(rbdVol.RequestName)
 
+	// the shared clone is only deleted together with its last volume
+	if rbdVol.BackingSnapshotID != "" {
+		return cs.deleteRoxSharedCloneVolume(ctx, rbdVol, cr)
+	}
+
+	// the trash expiry of the image overrides the default of the driver
+	rbdVol.TrashExpiry = cs.TrashExpiry
+
 	return cleanupRBDImage(ctx, rbdVol, cr)
 }
 
@@ -1013,9 +1196,30 @@ func cleanupRBDImage(ctx context.Context,
 		}
 	}
 
+	expiry, err := rbdVol.getTrashExpiry()
+	if err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// images with mirroring enabled can not be kept in the trash, they are
+	// removed right away
+	if expiry > 0 && mirroringInfo.State == librbd.MirrorImageEnabled {
+		log.WarningLog(ctx, "image %s has mirroring enabled, it is not kept in the trash for %s", rbdVol, expiry)
+		expiry = 0
+	}
+	if expiry > 0 {
+		err = rbdVol.storeRestoreMetadata()
+		if err != nil {
+			log.ErrorLog(ctx, "failed to store the restore metadata of image %s: %v", rbdVol, err)
+
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	// Deleting rbd image
 	log.DebugLog(ctx, "deleting image %s", rbdVol.RbdImageName)
-	if err = rbdVol.deleteImage(ctx); err != nil {
+	if err = rbdVol.trashImage(ctx, expiry); err != nil {
 		log.ErrorLog(ctx, "failed to delete rbd image: %s with error: %v",
 			rbdVol, err)
 
@@ -1093,6 +1297,13 @@ func (cs *ControllerServer) CreateSnapshot(
 	}
 	rbdVol.EnableMetadata = cs.SetMetadata
 
+	// the shared clone belongs to all the volumes of the snapshot
+	if rbdVol.RoxSharedClone {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"volume %s uses the shared clone of a snapshot, create a snapshot of a writable volume instead",
+			req.GetSourceVolumeId())
+	}
+
 	// Check if source volume was created with required image features for snaps
 	if !rbdVol.hasSnapshotFeature() {
 		return nil, status.Errorf(
@@ -1355,7 +1566,7 @@ func (cs *ControllerServer) doSnapshotClone(
 	}
 	defer j.Destroy()
 
-	err = j.StoreImageID(ctx, rbdSnap.JournalPool, rbdSnap.ReservedID, cloneRbd.ImageID)
+	err = j.StoreImageID(ctx, rbdSnap.Pool, rbdSnap.ReservedID, cloneRbd.ImageID)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to reserve volume id: %v", err)
 
@@ -1584,8 +1795,42 @@ func (cs *ControllerServer) ControllerExpandVolume(
 	// always round up the request size in bytes to the nearest MiB/GiB
 	volSize := util.RoundOffBytes(req.GetCapacityRange().GetRequiredBytes())
 
+	// the shared clone would grow for all the volumes of the snapshot
+	if rbdVol.RoxSharedClone && volSize > rbdVol.VolSize {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"volume %s uses the shared clone of a snapshot and can not be expanded", volID)
+	}
+
+	thick, err := rbdVol.isThickProvisioned()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	pending, err := rbdVol.allocationPending()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	thick = thick || pending
+
 	// resize volume if required
 	if rbdVol.VolSize < volSize {
+		// a running allocation zero-fills the image up to the size it had
+		// when it started, the image is resized after it completed
+		if pending {
+			err = rbdVol.ensureAllocated(ctx)
+			if errors.Is(err, ErrAllocationInProgress) {
+				return nil, status.Error(codes.Aborted, err.Error())
+			} else if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+		// the grown region of a thick-provisioned image is zero-filled
+		// after the resize, also when the resize is interrupted
+		if thick {
+			err = rbdVol.setAllocationOffset(uint64(rbdVol.VolSize))
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
 		log.DebugLog(ctx, "rbd volume %s size is %v,resizing to %v", rbdVol, rbdVol.VolSize, volSize)
 		err = rbdVol.resize(volSize)
 		if err != nil {
@@ -1595,6 +1840,15 @@ func (cs *ControllerServer) ControllerExpandVolume(
 		}
 	}
 
+	if thick {
+		err = rbdVol.ensureAllocated(ctx)
+		if errors.Is(err, ErrAllocationInProgress) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		} else if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
 	return &csi.ControllerExpandVolumeResponse{
 		CapacityBytes:         rbdVol.VolSize,
 		NodeExpansionRequired: nodeExpansion,
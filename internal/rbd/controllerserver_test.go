@@ -16,7 +16,10 @@ limitations under the License.
 
 package rbd
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestValidateStriping(t *testing.T) {
 	t.Parallel()
@@ -70,6 +73,82 @@ func TestValidateStriping(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "when stripeUnit is not a multiple of 512",
+			parameters: map[string]string{
+				"stripeUnit":  "1000",
+				"stripeCount": "8",
+				"objectSize":  "131072",
+			},
+			wantErr: true,
+		},
+		{
+			name: "when stripeUnit is 0",
+			parameters: map[string]string{
+				"stripeUnit":  "0",
+				"stripeCount": "8",
+			},
+			wantErr: true,
+		},
+		{
+			name: "when stripeUnit is not a number",
+			parameters: map[string]string{
+				"stripeUnit":  "4k",
+				"stripeCount": "8",
+			},
+			wantErr: true,
+		},
+		{
+			name: "when stripeCount is 0",
+			parameters: map[string]string{
+				"stripeUnit":  "4096",
+				"stripeCount": "0",
+				"objectSize":  "131072",
+			},
+			wantErr: true,
+		},
+		{
+			name: "when stripeCount is negative",
+			parameters: map[string]string{
+				"stripeUnit":  "4096",
+				"stripeCount": "-1",
+			},
+			wantErr: true,
+		},
+		{
+			name: "when objectSize is not a multiple of stripeUnit",
+			parameters: map[string]string{
+				"stripeUnit":  "1536",
+				"stripeCount": "8",
+				"objectSize":  "131072",
+			},
+			wantErr: true,
+		},
+		{
+			name: "when objectSize is smaller than stripeUnit",
+			parameters: map[string]string{
+				"stripeUnit":  "8192",
+				"stripeCount": "8",
+				"objectSize":  "4096",
+			},
+			wantErr: true,
+		},
+		{
+			name: "when stripeUnit equals objectSize",
+			parameters: map[string]string{
+				"stripeUnit":  "4194304",
+				"stripeCount": "1",
+				"objectSize":  "4194304",
+			},
+			wantErr: false,
+		},
+		{
+			name: "when only objectSize is specified",
+			parameters: map[string]string{
+				"objectSize": "4194304",
+			},
+			wantErr: false,
+		},
 		{
 			name:       "when no stripe parameters are specified",
 			parameters: map[string]string{},
@@ -86,3 +165,58 @@ func TestValidateStriping(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateCloneStriping(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name             string
+		stripeUnit       uint64
+		objectSize       uint64
+		parentObjectSize uint64
+		wantErr          bool
+	}{
+		{
+			name:             "when no stripeUnit is specified",
+			parentObjectSize: 4194304,
+			wantErr:          false,
+		},
+		{
+			name:             "when stripeUnit fits the object size of the parent",
+			stripeUnit:       65536,
+			parentObjectSize: 4194304,
+			wantErr:          false,
+		},
+		{
+			name:             "when stripeUnit is larger than the object size of the parent",
+			stripeUnit:       8388608,
+			parentObjectSize: 4194304,
+			wantErr:          true,
+		},
+		{
+			name:             "when stripeUnit is not a divisor of the object size of the parent",
+			stripeUnit:       1536,
+			parentObjectSize: 131072,
+			wantErr:          true,
+		},
+		{
+			name:             "when objectSize of the clone is specified",
+			stripeUnit:       8388608,
+			objectSize:       16777216,
+			parentObjectSize: 4194304,
+			wantErr:          false,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateCloneStriping(ts.stripeUnit, ts.objectSize, ts.parentObjectSize)
+			if (err != nil) != ts.wantErr {
+				t.Errorf("validateCloneStriping() error = %v, wantErr %v", err, ts.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidStriping) {
+				t.Errorf("validateCloneStriping() error = %v, want %v", err, ErrInvalidStriping)
+			}
+		})
+	}
+}
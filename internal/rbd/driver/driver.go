@@ -169,12 +169,23 @@ func (r *Driver) Run(conf *util.Config) {
 		rbd.SetGlobalInt("krbdFeatures", krbdFeatures)
 
 		rbd.SetRbdNbdToolFeatures()
+
+		if conf.VerifyEncryptionPrereqs {
+			err = util.VerifyEncryptionPrereqs()
+			if err != nil {
+				// keep running, the node can still serve unencrypted
+				// volumes, but report the plugin as not ready
+				log.ErrorLogMsg("node is not ready for encrypted volumes: %v", err)
+				r.ids.SetProbeError(err)
+			}
+		}
 	}
 
 	if conf.IsControllerServer {
 		r.cs = NewControllerServer(r.cd)
 		r.cs.ClusterName = conf.ClusterName
 		r.cs.SetMetadata = conf.SetMetadata
+		r.cs.TrashExpiry = conf.RbdTrashExpiry
 		log.WarningLogMsg("replication service running on controller server is deprecated " +
 			"and replaced by CSI-Addons, see https://github.com/ceph/ceph-csi/issues/3314 for more details")
 		r.rs = NewReplicationServer(r.cs)
@@ -189,9 +200,14 @@ func (r *Driver) Run(conf *util.Config) {
 		// operations.
 		RS: r.rs,
 	}
+	if conf.EnableRPCMetrics {
+		csicommon.EnableRPCMetrics()
+	}
 	s.Start(conf.Endpoint, conf.HistogramOption, srv, conf.EnableGRPCMetrics)
 	if conf.EnableGRPCMetrics {
 		log.WarningLogMsg("EnableGRPCMetrics is deprecated")
+	}
+	if conf.EnableGRPCMetrics || conf.EnableRPCMetrics {
 		go util.StartMetricsServer(conf)
 	}
 
@@ -253,7 +269,7 @@ func (r *Driver) setupCSIAddonsServer(conf *util.Config) error {
 // starts the required profiling services.
 func (r *Driver) startProfiling(conf *util.Config) {
 	if conf.EnableProfiling {
-		if !conf.EnableGRPCMetrics {
+		if !conf.EnableGRPCMetrics && !conf.EnableRPCMetrics {
 			go util.StartMetricsServer(conf)
 		}
 		log.DebugLogMsg("Registering profiling handler")
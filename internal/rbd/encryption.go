@@ -293,7 +293,7 @@ func (rv *rbdVolume) openEncryptedDevice(ctx context.Context, devicePath string)
 	if isOpen {
 		log.DebugLog(ctx, "encrypted device is already open at %s", mapperFilePath)
 	} else {
-		err = util.OpenEncryptedVolume(ctx, devicePath, mapperFile, passphrase)
+		err = util.OpenEncryptedVolume(ctx, devicePath, mapperFile, passphrase, rv.EncryptionAllowDiscards, rv.readOnly)
 		if err != nil {
 			log.ErrorLog(ctx, "failed to open device %s: %v",
 				rv, err)
@@ -34,6 +34,12 @@ var (
 	ErrMissingStash = errors.New("missing stash")
 	// ErrFlattenInProgress is returned when flatten is in progress for an image.
 	ErrFlattenInProgress = errors.New("flatten in progress")
+	// ErrAllocationInProgress is returned when a thick-provisioned image is
+	// still being allocated.
+	ErrAllocationInProgress = errors.New("allocation in progress")
+	// ErrInvalidStriping is returned when the requested striping can not be
+	// used for the image.
+	ErrInvalidStriping = errors.New("invalid striping")
 	// ErrMissingMonitorsInVolID is returned when monitor information is missing in migration volID.
 	ErrMissingMonitorsInVolID = errors.New("monitor information can not be empty in volID")
 	// ErrMissingPoolNameInVolID is returned when pool information is missing in migration volID.
@@ -45,4 +51,7 @@ var (
 	// ErrLastSyncTimeNotFound is returned when last sync time is not found for
 	// the image.
 	ErrLastSyncTimeNotFound = errors.New("last sync time not found")
+	// ErrMirroringDisabled is returned when mirroring is disabled for the
+	// pool or rados namespace of the image.
+	ErrMirroringDisabled = errors.New("mirroring disabled")
 )
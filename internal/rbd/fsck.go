@@ -0,0 +1,96 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// fsckModeNone does not check the filesystem before it is mounted.
+	fsckModeNone = "none"
+	// fsckModePreferred checks the filesystem, but mounts it whatever fsck
+	// reports.
+	fsckModePreferred = "preferred"
+	// fsckModeForced checks the filesystem and does not mount it when fsck
+	// found errors it could not correct. This is the default.
+	fsckModeForced = "forced"
+
+	// fsckErrorsUncorrected is the exit code of fsck when it found errors
+	// that it could not correct.
+	fsckErrorsUncorrected = 4
+)
+
+// parseFsckMode parses the fsckMode parameter, an empty value is the default
+// fsckModeForced.
+func parseFsckMode(value string) (string, error) {
+	switch value {
+	case "":
+		return fsckModeForced, nil
+	case fsckModeNone, fsckModePreferred, fsckModeForced:
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid fsckMode %q: must be one of %q, %q or %q",
+		value, fsckModeNone, fsckModePreferred, fsckModeForced)
+}
+
+// isFsckExitCodeFatal returns true if the filesystem must not be mounted
+// after fsck exited with exitCode in the fsck mode. Like FormatAndMount(),
+// fsckModeForced only fails when fsck could not correct the errors it found.
+func isFsckExitCodeFatal(mode string, exitCode int) bool {
+	switch mode {
+	case fsckModeNone, fsckModePreferred:
+		return false
+	default:
+		return exitCode == fsckErrorsUncorrected
+	}
+}
+
+// checkFilesystem runs fsck on devicePath and returns an error if the
+// filesystem must not be mounted in the fsck mode. A missing fsck command or
+// a failure of fsck itself is logged, but not returned.
+func checkFilesystem(ctx context.Context, exec utilexec.Interface, mode, devicePath string) error {
+	if mode == fsckModeNone {
+		return nil
+	}
+
+	out, err := exec.Command("fsck", "-a", devicePath).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr utilexec.ExitError
+	if !errors.As(err, &exitErr) {
+		log.WarningLog(ctx, "failed to run fsck on device %s: %v", devicePath, err)
+
+		return nil
+	}
+	if isFsckExitCodeFatal(mode, exitErr.ExitStatus()) {
+		return fmt.Errorf("fsck found errors on device %s but could not correct them: %s", devicePath, string(out))
+	}
+	log.WarningLog(ctx, "fsck on device %s exited with %d, mounting it with fsckMode %q, output: %s",
+		devicePath, exitErr.ExitStatus(), mode, string(out))
+
+	return nil
+}
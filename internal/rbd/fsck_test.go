@@ -0,0 +1,79 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFsckMode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", fsckModeForced, false},
+		{"none", fsckModeNone, false},
+		{"preferred", fsckModePreferred, false},
+		{"forced", fsckModeForced, false},
+		{"Forced", "", true},
+		{"always", "", true},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.value, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseFsckMode(ts.value)
+			if ts.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, ts.want, got)
+		})
+	}
+}
+
+func TestIsFsckExitCodeFatal(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		mode     string
+		exitCode int
+		want     bool
+	}{
+		{"forced errors corrected", fsckModeForced, 1, false},
+		{"forced reboot needed", fsckModeForced, 2, false},
+		{"forced errors uncorrected", fsckModeForced, 4, true},
+		{"forced operational error", fsckModeForced, 8, false},
+		{"preferred errors corrected", fsckModePreferred, 1, false},
+		{"preferred errors uncorrected", fsckModePreferred, 4, false},
+		{"preferred operational error", fsckModePreferred, 8, false},
+		{"none errors uncorrected", fsckModeNone, 4, false},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, ts.want, isFsckExitCodeFatal(ts.mode, ts.exitCode))
+		})
+	}
+}
@@ -22,12 +22,34 @@ import (
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // IdentityServer struct of rbd CSI driver with supported methods of CSI
 // identity server spec.
 type IdentityServer struct {
 	*csicommon.DefaultIdentityServer
+
+	// probeErr is returned by Probe when the plugin is missing
+	// prerequisites
+	probeErr error
+}
+
+// SetProbeError configures the error that Probe returns, to signal that the
+// plugin is missing prerequisites.
+func (is *IdentityServer) SetProbeError(err error) {
+	is.probeErr = err
+}
+
+// Probe returns an empty response when the plugin is healthy, and
+// FailedPrecondition when prerequisites are missing.
+func (is *IdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if is.probeErr != nil {
+		return nil, status.Error(codes.FailedPrecondition, is.probeErr.Error())
+	}
+
+	return is.DefaultIdentityServer.Probe(ctx, req)
 }
 
 // GetPluginCapabilities returns available capabilities of the rbd driver.
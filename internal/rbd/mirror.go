@@ -41,6 +41,56 @@ func (ri *rbdImage) EnableImageMirroring(mode librbd.ImageMirrorMode) error {
 	return nil
 }
 
+// GetMirroringMode returns the mirroring mode of the pool, or of the rados
+// namespace in the pool when the image is part of one. Rados namespaces are
+// configured for mirroring independently of the pool.
+func (ri *rbdImage) GetMirroringMode() (librbd.MirrorMode, error) {
+	err := ri.openIoctx()
+	if err != nil {
+		return librbd.MirrorModeDisabled, err
+	}
+
+	mode, err := librbd.GetMirrorMode(ri.ioctx)
+	if err != nil {
+		return librbd.MirrorModeDisabled, fmt.Errorf("failed to get mirroring mode of %s: %w",
+			ri.mirroringScope(), err)
+	}
+
+	return mode, nil
+}
+
+// mirroringScope describes the pool or rados namespace that holds the
+// mirroring configuration for the image.
+func (ri *rbdImage) mirroringScope() string {
+	if ri.RadosNamespace != "" {
+		return fmt.Sprintf("namespace %q in pool %q", ri.RadosNamespace, ri.Pool)
+	}
+
+	return fmt.Sprintf("pool %q", ri.Pool)
+}
+
+// ValidateMirroringMode returns an error when image mirroring can not be
+// enabled because mirroring is disabled for the pool or rados namespace of
+// the image.
+func (ri *rbdImage) ValidateMirroringMode() error {
+	mode, err := ri.GetMirroringMode()
+	if err != nil {
+		return err
+	}
+
+	return ri.checkMirroringMode(mode)
+}
+
+// checkMirroringMode returns an error when the mirroring mode of the pool or
+// rados namespace of the image does not allow image mirroring.
+func (ri *rbdImage) checkMirroringMode(mode librbd.MirrorMode) error {
+	if mode == librbd.MirrorModeDisabled {
+		return fmt.Errorf("%w: mirroring is disabled for %s", ErrMirroringDisabled, ri.mirroringScope())
+	}
+
+	return nil
+}
+
 // DisableImageMirroring disables mirroring on an image.
 func (ri *rbdImage) DisableImageMirroring(force bool) error {
 	image, err := ri.open()
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+func TestCheckMirroringMode(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		namespace string
+		mode      librbd.MirrorMode
+		wantErr   string
+	}{
+		{
+			name:    "pool mirroring disabled",
+			mode:    librbd.MirrorModeDisabled,
+			wantErr: `mirroring is disabled for pool "replicapool"`,
+		},
+		{
+			name: "pool image mirroring",
+			mode: librbd.MirrorModeImage,
+		},
+		{
+			name: "pool mirroring",
+			mode: librbd.MirrorModePool,
+		},
+		{
+			name:      "namespace mirroring disabled",
+			namespace: "tenant",
+			mode:      librbd.MirrorModeDisabled,
+			wantErr:   `mirroring is disabled for namespace "tenant" in pool "replicapool"`,
+		},
+		{
+			name:      "namespace image mirroring",
+			namespace: "tenant",
+			mode:      librbd.MirrorModeImage,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			ri := &rbdImage{Pool: "replicapool", RadosNamespace: ts.namespace}
+			err := ri.checkMirroringMode(ts.mode)
+			if ts.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkMirroringMode() error = %v, want nil", err)
+				}
+
+				return
+			}
+			if !errors.Is(err, ErrMirroringDisabled) {
+				t.Errorf("checkMirroringMode() error = %v, want %v", err, ErrMirroringDisabled)
+			}
+			if err != nil && !strings.Contains(err.Error(), ts.wantErr) {
+				t.Errorf("checkMirroringMode() error = %q, want it to contain %q", err, ts.wantErr)
+			}
+		})
+	}
+}
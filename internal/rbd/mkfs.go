@@ -0,0 +1,150 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// mkfsForbiddenChars are the characters that are not accepted in the
+// mkfsOptions parameter. mkfs is not run through a shell, but options with
+// shell syntax are almost certainly a mistake or an attempt to inject commands.
+const mkfsForbiddenChars = ";&|$`<>\\\"'(){}*?!\n\r"
+
+// validateMkfsOptions checks that the mkfsOptions parameter does not contain
+// any of the mkfsForbiddenChars.
+func validateMkfsOptions(mkfsOptions string) error {
+	if i := strings.IndexAny(mkfsOptions, mkfsForbiddenChars); i != -1 {
+		return fmt.Errorf("invalid mkfsOptions %q: character %q is not allowed", mkfsOptions, mkfsOptions[i])
+	}
+
+	return nil
+}
+
+// mkfsCommand returns the mkfs command and its arguments that format
+// devicePath with fsType. The mkfsOptions are added to the default arguments
+// of the filesystem, for ext4 the extended options (-E) are merged with the
+// defaults so that the user can override a single one of them. Encryption is
+// enabled for ext4 with fileEncryption, and reflink is disabled for xfs with
+// disableReflink, unless mkfsOptions configure reflink.
+func mkfsCommand(
+	fsType, mkfsOptions, devicePath string,
+	fileEncryption, disableReflink bool,
+) (string, []string, error) {
+	err := validateMkfsOptions(mkfsOptions)
+	if err != nil {
+		return "", nil, err
+	}
+	userArgs := strings.Fields(mkfsOptions)
+
+	mkfs := "mkfs." + fsType
+	var args []string
+	switch fsType {
+	case "ext4":
+		args = mergeExt4Args(mkfsDefaultArgs[fsType], userArgs)
+		if fileEncryption {
+			args = append(args, "-Oencrypt")
+		}
+	case "xfs":
+		args = append(args, mkfsDefaultArgs[fsType]...)
+		args = append(args, userArgs...)
+		// always disable reflink, unless it is configured in mkfsOptions
+		// TODO: make enabling an option, see ceph/ceph-csi#1256
+		if disableReflink && !strings.Contains(mkfsOptions, "reflink=") {
+			args = append(args, "-m", "reflink=0")
+		}
+	case "":
+		// no filesystem type specified, just use "mkfs"
+		mkfs = "mkfs"
+		args = userArgs
+	default:
+		args = append(args, mkfsDefaultArgs[fsType]...)
+		args = append(args, userArgs...)
+	}
+
+	// add device as last argument
+	args = append(args, devicePath)
+
+	return mkfs, args, nil
+}
+
+// mergeExt4Args returns the defaults followed by the user arguments. mke2fs
+// only uses the last -E option, so the extended options of all -E arguments
+// are combined into the first one, later options replace earlier options with
+// the same name.
+func mergeExt4Args(defaults, userArgs []string) []string {
+	args := make([]string, 0, len(defaults)+len(userArgs))
+	extendedIndex := -1
+	var extended []string
+	all := append(append([]string{}, defaults...), userArgs...)
+	for i := 0; i < len(all); i++ {
+		arg := all[i]
+		if !strings.HasPrefix(arg, "-E") {
+			args = append(args, arg)
+
+			continue
+		}
+
+		value := strings.TrimPrefix(arg, "-E")
+		// the value can be passed as a separate argument, "-E discard"
+		if value == "" && i+1 < len(all) {
+			i++
+			value = all[i]
+		}
+		for _, opt := range strings.Split(value, ",") {
+			if opt != "" {
+				extended = setExtendedOption(extended, opt)
+			}
+		}
+		if extendedIndex == -1 {
+			extendedIndex = len(args)
+			args = append(args, "")
+		}
+	}
+	if extendedIndex != -1 {
+		args[extendedIndex] = "-E" + strings.Join(extended, ",")
+	}
+
+	return args
+}
+
+// setExtendedOption adds the extended option opt to options, or replaces the
+// option with the same name. "discard" and "nodiscard" are the same option.
+func setExtendedOption(options []string, opt string) []string {
+	name := extendedOptionName(opt)
+	for i := range options {
+		if extendedOptionName(options[i]) == name {
+			options[i] = opt
+
+			return options
+		}
+	}
+
+	return append(options, opt)
+}
+
+// extendedOptionName returns the name of the extended option opt, without
+// its value.
+func extendedOptionName(opt string) string {
+	name := strings.SplitN(opt, "=", 2)[0]
+	if name == "nodiscard" {
+		return "discard"
+	}
+
+	return name
+}
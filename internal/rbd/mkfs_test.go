@@ -0,0 +1,135 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMkfsCommand(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		fsType         string
+		mkfsOptions    string
+		fileEncryption bool
+		disableReflink bool
+		wantCmd        string
+		wantArgs       []string
+		wantErr        bool
+	}{
+		{
+			name:     "ext4 defaults",
+			fsType:   "ext4",
+			wantCmd:  "mkfs.ext4",
+			wantArgs: []string{"-m0", "-Enodiscard,lazy_itable_init=1,lazy_journal_init=1", "/dev/rbd0"},
+		},
+		{
+			name:           "ext4 with options and encryption",
+			fsType:         "ext4",
+			mkfsOptions:    "-N1024  -b 4096",
+			fileEncryption: true,
+			wantCmd:        "mkfs.ext4",
+			wantArgs: []string{
+				"-m0", "-Enodiscard,lazy_itable_init=1,lazy_journal_init=1",
+				"-N1024", "-b", "4096", "-Oencrypt", "/dev/rbd0",
+			},
+		},
+		{
+			name:        "ext4 merges extended options",
+			fsType:      "ext4",
+			mkfsOptions: "-Ediscard -E stride=16,lazy_itable_init=0",
+			wantCmd:     "mkfs.ext4",
+			wantArgs: []string{
+				"-m0", "-Ediscard,lazy_itable_init=0,lazy_journal_init=1,stride=16", "/dev/rbd0",
+			},
+		},
+		{
+			name:           "xfs defaults",
+			fsType:         "xfs",
+			disableReflink: true,
+			wantCmd:        "mkfs.xfs",
+			wantArgs:       []string{"-K", "-m", "reflink=0", "/dev/rbd0"},
+		},
+		{
+			name:           "xfs with options",
+			fsType:         "xfs",
+			mkfsOptions:    "-d su=64k,sw=4",
+			disableReflink: true,
+			wantCmd:        "mkfs.xfs",
+			wantArgs:       []string{"-K", "-d", "su=64k,sw=4", "-m", "reflink=0", "/dev/rbd0"},
+		},
+		{
+			name:           "xfs with reflink option",
+			fsType:         "xfs",
+			mkfsOptions:    "-m reflink=1",
+			disableReflink: true,
+			wantCmd:        "mkfs.xfs",
+			wantArgs:       []string{"-K", "-m", "reflink=1", "/dev/rbd0"},
+		},
+		{
+			name:        "btrfs with options",
+			fsType:      "btrfs",
+			mkfsOptions: "-m single",
+			wantCmd:     "mkfs.btrfs",
+			wantArgs:    []string{"-K", "-m", "single", "/dev/rbd0"},
+		},
+		{
+			name:        "unknown fsType",
+			fsType:      "ext3",
+			mkfsOptions: "-Ediscard",
+			wantCmd:     "mkfs.ext3",
+			wantArgs:    []string{"-Ediscard", "/dev/rbd0"},
+		},
+		{
+			name:        "no fsType",
+			mkfsOptions: "-t ext3",
+			wantCmd:     "mkfs",
+			wantArgs:    []string{"-t", "ext3", "/dev/rbd0"},
+		},
+		{
+			name:        "command injection",
+			fsType:      "ext4",
+			mkfsOptions: "-m0; rm -rf /",
+			wantErr:     true,
+		},
+		{
+			name:        "command substitution",
+			fsType:      "xfs",
+			mkfsOptions: "-L $(hostname)",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			cmd, args, err := mkfsCommand(ts.fsType, ts.mkfsOptions, "/dev/rbd0", ts.fileEncryption, ts.disableReflink)
+			if ts.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, ts.wantCmd, cmd)
+			assert.Equal(t, ts.wantArgs, args)
+		})
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
 	"github.com/ceph/ceph-csi/internal/util"
@@ -103,8 +104,9 @@ var (
 	xfsHasReflink = xfsReflinkUnset
 
 	mkfsDefaultArgs = map[string][]string{
-		"ext4": {"-m0", "-Enodiscard,lazy_itable_init=1,lazy_journal_init=1"},
-		"xfs":  {"-K"},
+		"ext4":  {"-m0", "-Enodiscard,lazy_itable_init=1,lazy_journal_init=1"},
+		"xfs":   {"-K"},
+		"btrfs": {"-K"},
 	}
 
 	mountDefaultOpts = map[string][]string{
@@ -140,6 +142,14 @@ func healerStageTransaction(ctx context.Context, cr *util.Credentials, volOps *r
 	if imgInfo.DevicePath == "" {
 		return fmt.Errorf("device is empty in image metadata, at stagingPath: %s", metaDataPath)
 	}
+	// attach with the timeouts the image was mapped with
+	if imgInfo.NbdIOTimeout != 0 {
+		volOps.NbdIOTimeout = time.Duration(imgInfo.NbdIOTimeout) * time.Second
+	}
+	if imgInfo.NbdReattachTimeout != 0 {
+		volOps.NbdReattachTimeout = time.Duration(imgInfo.NbdReattachTimeout) * time.Second
+	}
+	volOps.readOnly = imgInfo.ReadOnly
 	var devicePath string
 	devicePath, err = attachRBDImage(ctx, volOps, imgInfo.DevicePath, cr)
 	if err != nil {
@@ -212,6 +222,14 @@ func (ns *NodeServer) populateRbdVol(
 	}
 
 	rv.DisableInUseChecks = disableInUseChecks
+	// the image of a read-only volume is mapped read-only
+	rv.readOnly = isReadOnlyStage(req.GetVolumeCapability())
+	// the shared clone is mapped by all the volumes of its snapshot, on any
+	// number of nodes, and is never written to
+	if rv.RoxSharedClone {
+		rv.readOnly = true
+		rv.DisableInUseChecks = true
+	}
 
 	err = rv.Connect(cr)
 	if err != nil {
@@ -274,20 +292,30 @@ func (ns *NodeServer) populateRbdVol(
 		rv.LogStrategy = defaultLogStrategy
 	}
 
+	if rv.Mounter == rbdTonbd {
+		rv.NbdIOTimeout, err = parseNbdTimeout("nbdIOTimeout", req.GetVolumeContext()["nbdIOTimeout"])
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		rv.NbdReattachTimeout, err = parseNbdTimeout("nbdReattachTimeout",
+			req.GetVolumeContext()["nbdReattachTimeout"])
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
 	return rv, err
 }
 
 // appendReadAffinityMapOptions appends readAffinityMapOptions to mapOptions
 // if mounter is rbdDefaultMounter and readAffinityMapOptions is not empty.
+// The mapOptions of the volume take precedence over the readAffinityMapOptions
+// of the node.
 func (ns NodeServer) appendReadAffinityMapOptions(rv *rbdVolume) {
-	switch {
-	case ns.readAffinityMapOptions == "" || rv.Mounter != rbdDefaultMounter:
+	if ns.readAffinityMapOptions == "" || rv.Mounter != rbdDefaultMounter {
 		return
-	case rv.MapOptions != "":
-		rv.MapOptions += "," + ns.readAffinityMapOptions
-	default:
-		rv.MapOptions = ns.readAffinityMapOptions
 	}
+	rv.MapOptions = mergeMapOptions(rv.MapOptions, ns.readAffinityMapOptions)
 }
 
 // NodeStageVolume mounts the volume to a staging path on the node.
@@ -349,6 +377,14 @@ func (ns *NodeServer) NodeStageVolume(
 	}
 	defer rv.Destroy()
 
+	if rv.RoxSharedClone {
+		imageSpec := rv.String()
+		if err = ns.lockSharedDevice(ctx, imageSpec); err != nil {
+			return nil, err
+		}
+		defer ns.unlockSharedDevice(imageSpec)
+	}
+
 	rv.NetNamespaceFilePath, err = util.GetRBDNetNamespaceFilePath(util.CsiConfigFile, rv.ClusterID)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -405,7 +441,6 @@ func (ns *NodeServer) stageTransaction(
 	if req.VolumeCapability.AccessMode.Mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
 		log.ExtendedLog(ctx, "setting disableInUseChecks on rbd volume to: %v", req.GetVolumeId)
 		volOptions.DisableInUseChecks = true
-		volOptions.readOnly = true
 	}
 
 	err = flattenImageBeforeMapping(ctx, volOptions)
@@ -479,9 +514,12 @@ func (ns *NodeServer) stageTransaction(
 	// creating bigger size clone from a volume, we need to check filesystem
 	// resize is required, if required resize filesystem.
 	// in case of encrypted block PVC resize only the LUKS device.
-	err = resizeNodeStagePath(ctx, isBlock, transaction, req.GetVolumeId(), stagingTargetPath)
-	if err != nil {
-		return transaction, err
+	// read-only volumes can not be resized.
+	if !volOptions.readOnly {
+		err = resizeNodeStagePath(ctx, isBlock, transaction, req.GetVolumeId(), stagingTargetPath)
+		if err != nil {
+			return transaction, err
+		}
 	}
 
 	return transaction, err
@@ -634,8 +672,14 @@ func (ns *NodeServer) undoStagingTransaction(
 
 	volID := req.GetVolumeId()
 
-	// Unmapping rbd device
-	if transaction.devicePath != "" {
+	// Unmapping rbd device, unless other staged volumes share it
+	inUse, err := sharedDeviceInUse(ctx, req.GetStagingTargetPath(), volOptions.String())
+	if err != nil {
+		log.ErrorLog(ctx, "failed to check if device %s is shared: %v", transaction.devicePath, err)
+		// do not unmap a device that may still be used
+		inUse = true
+	}
+	if transaction.devicePath != "" && !inUse {
 		err = detachRBDDevice(ctx, transaction.devicePath, volID, volOptions.UnmapOptions, transaction.isBlockEncrypted)
 		if err != nil {
 			log.ErrorLog(
@@ -703,6 +747,16 @@ func (ns *NodeServer) NodePublishVolume(
 	volID := req.GetVolumeId()
 	stagingPath += "/" + volID
 
+	// a volume that was staged read-only can only be published read-only
+	stagedReadOnly, err := isStagedReadOnly(req.GetStagingTargetPath())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if stagedReadOnly && !req.GetReadonly() && !isReadOnlyStage(req.GetVolumeCapability()) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"volume %s is staged read-only and can not be published read-write", volID)
+	}
+
 	// Considering kubelet make sure the stage and publish operations
 	// are serialized, we dont need any extra locking in nodePublish
 
@@ -728,7 +782,7 @@ func (ns *NodeServer) NodePublishVolume(
 	}
 
 	// Publish Path
-	err = ns.mountVolume(ctx, stagingPath, req)
+	err = ns.mountVolume(ctx, stagingPath, req, stagedReadOnly)
 	if err != nil {
 		return nil, err
 	}
@@ -782,49 +836,56 @@ func (ns *NodeServer) mountVolumeToStagePath(
 	}
 
 	if existingFormat == "" && !staticVol && !readOnly && !isBlock {
-		args := mkfsDefaultArgs[fsType]
-
-		// if the VolumeContext contains "mkfsOptions", use those as args instead
-		volumeCtx := req.GetVolumeContext()
-		if volumeCtx != nil {
-			mkfsOptions := volumeCtx["mkfsOptions"]
-			if mkfsOptions != "" {
-				args = strings.Split(mkfsOptions, " ")
-			}
-		}
+		// the user options in "mkfsOptions" are added to the defaults
+		mkfs, args, mErr := mkfsCommand(fsType, req.GetVolumeContext()["mkfsOptions"], devicePath,
+			fileEncryption, fsType == "xfs" && ns.xfsSupportsReflink())
+		if mErr != nil {
+			log.ErrorLog(ctx, "failed to format device path %s: %v", devicePath, mErr)
 
-		// add extra arguments depending on the filesystem
-		mkfs := "mkfs." + fsType
-		switch fsType {
-		case "ext4":
-			if fileEncryption {
-				args = append(args, "-Oencrypt")
-			}
-		case "xfs":
-			// always disable reflink
-			// TODO: make enabling an option, see ceph/ceph-csi#1256
-			if ns.xfsSupportsReflink() {
-				args = append(args, "-m", "reflink=0")
-			}
-		case "":
-			// no filesystem type specified, just use "mkfs"
-			mkfs = "mkfs"
+			return status.Error(codes.InvalidArgument, mErr.Error())
 		}
 
-		// add device as last argument
-		args = append(args, devicePath)
+		log.DebugLog(ctx, "formatting device path %s: %s %s", devicePath, mkfs, strings.Join(args, " "))
 		cmdOut, cmdErr := diskMounter.Exec.Command(mkfs, args...).CombinedOutput()
 		if cmdErr != nil {
 			log.ErrorLog(ctx, "failed to run mkfs.%s (%v) error: %v, output: %v", fsType, args, cmdErr, string(cmdOut))
 
 			return cmdErr
 		}
+		existingFormat = fsType
 	}
 
-	if isBlock {
+	fsckMode, err := parseFsckMode(req.GetVolumeContext()["fsckMode"])
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	// btrfs has no fsck, "fsck.btrfs" does nothing or is missing
+	if fsType == "btrfs" {
+		fsckMode = fsckModeNone
+	}
+
+	switch {
+	case isBlock:
 		opt = append(opt, "bind")
 		err = diskMounter.MountSensitiveWithoutSystemd(devicePath, stagingPath, fsType, opt, nil)
-	} else {
+	case existingFormat != "" && fsckMode != fsckModeForced:
+		// FormatAndMount() always checks the filesystem, so check it here
+		// with the fsck mode of the volume and mount it
+		if !readOnly {
+			// clones keep the btrfs UUID of their parent
+			if existingFormat == "btrfs" {
+				err = ensureUniqueBtrfsUUID(ctx, diskMounter.Exec, devicePath)
+				if err != nil {
+					return err
+				}
+			}
+			err = checkFilesystem(ctx, diskMounter.Exec, fsckMode, devicePath)
+			if err != nil {
+				return err
+			}
+		}
+		err = diskMounter.MountSensitiveWithoutSystemd(devicePath, stagingPath, fsType, opt, nil)
+	default:
 		err = diskMounter.FormatAndMount(devicePath, stagingPath, fsType, opt)
 	}
 	if err != nil {
@@ -840,10 +901,15 @@ func (ns *NodeServer) mountVolumeToStagePath(
 	return err
 }
 
-func (ns *NodeServer) mountVolume(ctx context.Context, stagingPath string, req *csi.NodePublishVolumeRequest) error {
+func (ns *NodeServer) mountVolume(
+	ctx context.Context,
+	stagingPath string,
+	req *csi.NodePublishVolumeRequest,
+	stagedReadOnly bool,
+) error {
 	// Publish Path
 	fsType := req.GetVolumeCapability().GetMount().GetFsType()
-	readOnly := req.GetReadonly()
+	readOnly := req.GetReadonly() || stagedReadOnly
 	mountOptions := []string{"bind", "_netdev"}
 	isBlock := req.GetVolumeCapability().GetBlock() != nil
 	targetPath := req.GetTargetPath()
@@ -1029,6 +1095,28 @@ func (ns *NodeServer) NodeUnstageVolume(
 	// Unmapping rbd device
 	imageSpec := imgInfo.String()
 
+	if isRoxSharedCloneImage(imgInfo.ImageName) {
+		if err = ns.lockSharedDevice(ctx, imageSpec); err != nil {
+			return nil, err
+		}
+		defer ns.unlockSharedDevice(imageSpec)
+
+		var inUse bool
+		inUse, err = sharedDeviceInUse(ctx, stagingParentPath, imageSpec)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if inUse {
+			if err = cleanupRBDImageMetadataStash(stagingParentPath); err != nil {
+				log.ErrorLog(ctx, "failed to cleanup image metadata stash (%v)", err)
+
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+
+			return &csi.NodeUnstageVolumeResponse{}, nil
+		}
+	}
+
 	dArgs := detachRBDImageArgs{
 		imageOrDeviceSpec: imageSpec,
 		isImageSpec:       true,
@@ -1141,19 +1229,26 @@ func (ns *NodeServer) NodeExpandVolume(
 			"failed to get device for stagingtarget path %v", volumePath)
 	}
 
-	mapperFile, mapperPath := util.VolumeMapper(volumeID)
 	if imgInfo.Encrypted {
-		// The volume is encrypted, resize an active mapping
-		err = util.ResizeEncryptedVolume(ctx, mapperFile)
-		if err != nil {
-			log.ErrorLog(ctx, "failed to resize device %s, mapper %s: %w",
-				devicePath, mapperFile, err)
+		// the LUKS mapping and the filesystem on it are grown together,
+		// steps that completed before are skipped
+		mapperFile, _ := util.VolumeMapper(volumeID)
+		fsType, mountPath := "", ""
+		if req.GetVolumeCapability().GetBlock() == nil {
+			fsType = req.GetVolumeCapability().GetMount().GetFsType()
+			mountPath = volumePath + "/" + volumeID
+		}
+		steps, eErr := util.ExpandEncryptedVolume(ctx, mapperFile, fsType, mountPath)
+		if eErr != nil {
+			log.ErrorLog(ctx, "failed to expand device %s, mapper %s (completed steps %v): %v",
+				devicePath, mapperFile, steps, eErr)
 
 			return nil, status.Errorf(codes.Internal,
-				"failed to resize device %s, mapper %s: %v", devicePath, mapperFile, err)
+				"failed to expand device %s, mapper %s: %v", devicePath, mapperFile, eErr)
 		}
-		// Use mapper device path for fs resize
-		devicePath = mapperPath
+		log.DebugLog(ctx, "expanded encrypted volume %s with steps %v", volumeID, steps)
+
+		return &csi.NodeExpandVolumeResponse{}, nil
 	}
 
 	if req.GetVolumeCapability().GetBlock() == nil {
@@ -1225,6 +1320,10 @@ func (ns *NodeServer) processEncryptedDevice(
 	}
 
 	switch {
+	case volOptions.readOnly && encrypted != rbdImageEncrypted:
+		// a read-only device can not be formatted, it can only be opened
+		return "", fmt.Errorf("rbd image %s is staged read-only, but the encryption is not set up (%s)",
+			imageSpec, encrypted)
 	case encrypted == rbdImageRequiresEncryption:
 		// If we get here, it means the image was created with a
 		// ceph-csi version that creates a passphrase for the encrypted
@@ -1281,6 +1380,32 @@ func (ns *NodeServer) processEncryptedDevice(
 	return devicePath, nil
 }
 
+// isReadOnlyStage returns true if the volume capability only allows reading,
+// either with a read-only access mode or the "ro" mount option.
+func isReadOnlyStage(volCap *csi.VolumeCapability) bool {
+	if csicommon.IsReaderOnly([]*csi.VolumeCapability{volCap}) {
+		return true
+	}
+
+	return csicommon.MountOptionContains(volCap.GetMount().GetMountFlags(), "ro")
+}
+
+// isStagedReadOnly returns true if the image metadata stashed at
+// stagingParentPath marks the volume as staged read-only. Volumes that were
+// staged without the stash are not read-only.
+func isStagedReadOnly(stagingParentPath string) (bool, error) {
+	imgInfo, err := lookupRBDImageMetadataStash(stagingParentPath)
+	if err != nil {
+		if errors.Is(err, ErrMissingStash) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return imgInfo.ReadOnly, nil
+}
+
 // xfsSupportsReflink checks if mkfs.xfs supports the "-m reflink=0|1"
 // argument. In case it is supported, return true.
 func (ns *NodeServer) xfsSupportsReflink() bool {
@@ -1387,21 +1512,8 @@ func getDeviceSize(ctx context.Context, devicePath string) (uint64, error) {
 	return size, nil
 }
 
+// SetReadAffinityMapOptions sets the map options that enable read affinity
+// from the crush location of the node.
 func (ns *NodeServer) SetReadAffinityMapOptions(crushLocationMap map[string]string) {
-	if len(crushLocationMap) == 0 {
-		return
-	}
-
-	var b strings.Builder
-	b.WriteString("read_from_replica=localize,crush_location=")
-	first := true
-	for key, val := range crushLocationMap {
-		if first {
-			b.WriteString(fmt.Sprintf("%s:%s", key, val))
-			first = false
-		} else {
-			b.WriteString(fmt.Sprintf("|%s:%s", key, val))
-		}
-	}
-	ns.readAffinityMapOptions = b.String()
+	ns.readAffinityMapOptions = util.ConstructReadAffinityMapOption(crushLocationMap)
 }
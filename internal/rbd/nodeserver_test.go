@@ -209,6 +209,15 @@ func TestNodeServer_appendReadAffinityMapOptions(t *testing.T) {
 			},
 			want: "notrim",
 		},
+		{
+			name: "mapOptions overriding readAffinityMapOptions & default mounter",
+			args: input{
+				mapOptions:             "read_from_replica=balance,queue_depth=256",
+				readAffinityMapOptions: "read_from_replica=localize,crush_location=region:west",
+				mounter:                rbdDefaultMounter,
+			},
+			want: "read_from_replica=balance,queue_depth=256,crush_location=region:west",
+		},
 		{
 			name: "filled mapOptions, empty readAffinityMapOptions & default mounter",
 			args: input{
@@ -244,3 +253,40 @@ func TestNodeServer_appendReadAffinityMapOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestIsReadOnlyStage(t *testing.T) {
+	t.Parallel()
+	blockCap := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+	mountCap := func(mode csi.VolumeCapability_AccessMode_Mode, flags ...string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+	tests := []struct {
+		name   string
+		volCap *csi.VolumeCapability
+		want   bool
+	}{
+		{"block ROX", blockCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY), true},
+		{"block single node reader", blockCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY), true},
+		{"block RWO", blockCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER), false},
+		{"block RWX", blockCap(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER), false},
+		{"filesystem ROX", mountCap(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY), true},
+		{"filesystem RWO", mountCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "noatime"), false},
+		{"filesystem RWO with ro", mountCap(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "noatime", "ro"), true},
+		{"no access mode", &csi.VolumeCapability{}, false},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, ts.want, isReadOnlyStage(ts.volCap))
+		})
+	}
+}
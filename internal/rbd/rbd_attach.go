@@ -294,6 +294,71 @@ func parseMapOptions(mapOptions string) (string, string, error) {
 	return krbdMapOptions, nbdMapOptions, nil
 }
 
+var (
+	// krbdOnlyMapOptions are map options of krbd that rbd-nbd does not know.
+	krbdOnlyMapOptions = []string{
+		"abort_on_full", "alloc_size", "compression_hint", "crush_location", "lock_on_read",
+		"lock_timeout", "ms_mode", "noudev", "osd_request_timeout", "queue_depth",
+		"read_from_replica", "rxbounce",
+	}
+	// nbdOnlyMapOptions are map options of rbd-nbd that krbd does not know.
+	nbdOnlyMapOptions = []string{
+		"cookie", "encryption-format", "encryption-passphrase-file", "io-timeout", "max_part",
+		"nbds_max", "quiesce", "quiesce-hook", "reattach-timeout", "timeout", "try-netlink",
+	}
+)
+
+// mapOptionName returns the name of the map option opt, without its value.
+func mapOptionName(opt string) string {
+	return strings.TrimSpace(strings.SplitN(opt, "=", 2)[0])
+}
+
+// validateMapOptions checks that the formatted mapOptions or unmapOptions
+// parameter can be parsed, and that the options of each mounter are not
+// options that only the other mounter knows.
+func validateMapOptions(name, mapOptions string) error {
+	krbdOptions, nbdOptions, err := parseMapOptions(mapOptions)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, mapOptions, err)
+	}
+	for _, opt := range strings.Split(krbdOptions, ",") {
+		if CheckSliceContains(nbdOnlyMapOptions, mapOptionName(opt)) {
+			return fmt.Errorf("invalid %s %q: %q is an option of %s, not of %s",
+				name, mapOptions, opt, accessTypeNbd, accessTypeKRbd)
+		}
+	}
+	for _, opt := range strings.Split(nbdOptions, ",") {
+		if CheckSliceContains(krbdOnlyMapOptions, mapOptionName(opt)) {
+			return fmt.Errorf("invalid %s %q: %q is an option of %s, not of %s",
+				name, mapOptions, opt, accessTypeKRbd, accessTypeNbd)
+		}
+	}
+
+	return nil
+}
+
+// mergeMapOptions returns the volumeOptions followed by the nodeOptions that
+// are not set in volumeOptions, so that the options of the volume take
+// precedence over the options of the node.
+func mergeMapOptions(volumeOptions, nodeOptions string) string {
+	if volumeOptions == "" {
+		return nodeOptions
+	}
+
+	var set []string
+	for _, opt := range strings.Split(volumeOptions, ",") {
+		set = append(set, mapOptionName(opt))
+	}
+	merged := volumeOptions
+	for _, opt := range strings.Split(nodeOptions, ",") {
+		if opt != "" && !CheckSliceContains(set, mapOptionName(opt)) {
+			merged += "," + opt
+		}
+	}
+
+	return merged
+}
+
 // getMapOptions is a wrapper func, calls parse map/unmap funcs and feeds the
 // rbdVolume object.
 func getMapOptions(req *csi.NodeStageVolumeRequest, rv *rbdVolume) error {
@@ -344,7 +409,49 @@ func attachRBDImage(ctx context.Context, volOptions *rbdVolume, device string, c
 	return devicePath, err
 }
 
-func appendNbdDeviceTypeAndOptions(cmdArgs []string, userOptions, cookie string) []string {
+// nbdTimeouts are the reattach-timeout and io-timeout of rbd-nbd, in
+// seconds.
+type nbdTimeouts struct {
+	reattach int
+	io       int
+}
+
+// parseNbdTimeout parses the nbdIOTimeout or nbdReattachTimeout parameter,
+// a positive duration in whole seconds. An empty value returns 0, which
+// selects the default timeout.
+func parseNbdTimeout(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if timeout <= 0 || timeout%time.Second != 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive number of seconds", name, value)
+	}
+
+	return timeout, nil
+}
+
+// getNbdTimeouts returns the rbd-nbd timeouts of the volume, with the
+// defaults for the timeouts that are not set.
+func getNbdTimeouts(volOpt *rbdVolume) nbdTimeouts {
+	timeouts := nbdTimeouts{
+		reattach: defaultNbdReAttachTimeout,
+		io:       defaultNbdIOTimeout,
+	}
+	if volOpt.NbdReattachTimeout != 0 {
+		timeouts.reattach = int(volOpt.NbdReattachTimeout / time.Second)
+	}
+	if volOpt.NbdIOTimeout != 0 {
+		timeouts.io = int(volOpt.NbdIOTimeout / time.Second)
+	}
+
+	return timeouts
+}
+
+func appendNbdDeviceTypeAndOptions(cmdArgs []string, userOptions, cookie string, timeouts nbdTimeouts) []string {
 	cmdArgs = append(cmdArgs, "--device-type", accessTypeNbd)
 
 	isUnmap := CheckSliceContains(cmdArgs, "unmap")
@@ -353,10 +460,10 @@ func appendNbdDeviceTypeAndOptions(cmdArgs []string, userOptions, cookie string)
 			cmdArgs = append(cmdArgs, "--options", useNbdNetlink)
 		}
 		if !strings.Contains(userOptions, setNbdReattach) {
-			cmdArgs = append(cmdArgs, "--options", fmt.Sprintf("%s=%d", setNbdReattach, defaultNbdReAttachTimeout))
+			cmdArgs = append(cmdArgs, "--options", fmt.Sprintf("%s=%d", setNbdReattach, timeouts.reattach))
 		}
 		if !strings.Contains(userOptions, setNbdIOTimeout) {
-			cmdArgs = append(cmdArgs, "--options", fmt.Sprintf("%s=%d", setNbdIOTimeout, defaultNbdIOTimeout))
+			cmdArgs = append(cmdArgs, "--options", fmt.Sprintf("%s=%d", setNbdIOTimeout, timeouts.io))
 		}
 
 		if hasNBDCookieSupport {
@@ -390,15 +497,15 @@ func appendKRbdDeviceTypeAndOptions(cmdArgs []string, userOptions string) []stri
 
 // appendRbdNbdCliOptions append mandatory options and convert list of useroptions
 // provided for rbd integrated cli to rbd-nbd cli format specific.
-func appendRbdNbdCliOptions(cmdArgs []string, userOptions, cookie string) []string {
+func appendRbdNbdCliOptions(cmdArgs []string, userOptions, cookie string, timeouts nbdTimeouts) []string {
 	if !strings.Contains(userOptions, useNbdNetlink) {
 		cmdArgs = append(cmdArgs, fmt.Sprintf("--%s", useNbdNetlink))
 	}
 	if !strings.Contains(userOptions, setNbdReattach) {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--%s=%d", setNbdReattach, defaultNbdReAttachTimeout))
+		cmdArgs = append(cmdArgs, fmt.Sprintf("--%s=%d", setNbdReattach, timeouts.reattach))
 	}
 	if !strings.Contains(userOptions, setNbdIOTimeout) {
-		cmdArgs = append(cmdArgs, fmt.Sprintf("--%s=%d", setNbdIOTimeout, defaultNbdIOTimeout))
+		cmdArgs = append(cmdArgs, fmt.Sprintf("--%s=%d", setNbdIOTimeout, timeouts.io))
 	}
 	if hasNBDCookieSupport {
 		cmdArgs = append(cmdArgs, fmt.Sprintf("--cookie=%s", cookie))
@@ -440,11 +547,11 @@ func createPath(ctx context.Context, volOpt *rbdVolume, device string, cr *util.
 		// TODO: use rbd cli for attach/detach in the future
 		cli = rbdNbdMounter
 		mapArgs = append(mapArgs, "attach", imagePath, "--device", device)
-		mapArgs = appendRbdNbdCliOptions(mapArgs, volOpt.MapOptions, volOpt.VolID)
+		mapArgs = appendRbdNbdCliOptions(mapArgs, volOpt.MapOptions, volOpt.VolID, getNbdTimeouts(volOpt))
 	} else {
 		mapArgs = append(mapArgs, "map", imagePath)
 		if isNbd {
-			mapArgs = appendNbdDeviceTypeAndOptions(mapArgs, volOpt.MapOptions, volOpt.VolID)
+			mapArgs = appendNbdDeviceTypeAndOptions(mapArgs, volOpt.MapOptions, volOpt.VolID, getNbdTimeouts(volOpt))
 		} else {
 			mapArgs = appendKRbdDeviceTypeAndOptions(mapArgs, volOpt.MapOptions)
 		}
@@ -564,7 +671,8 @@ func detachRBDImageOrDeviceSpec(
 
 	unmapArgs := []string{"unmap", dArgs.imageOrDeviceSpec}
 	if dArgs.isNbd {
-		unmapArgs = appendNbdDeviceTypeAndOptions(unmapArgs, dArgs.unmapOptions, dArgs.volumeID)
+		// the timeouts are only used for mapping
+		unmapArgs = appendNbdDeviceTypeAndOptions(unmapArgs, dArgs.unmapOptions, dArgs.volumeID, nbdTimeouts{})
 	} else {
 		unmapArgs = appendKRbdDeviceTypeAndOptions(unmapArgs, dArgs.unmapOptions)
 	}
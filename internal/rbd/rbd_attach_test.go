@@ -19,6 +19,7 @@ package rbd
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseMapOptions(t *testing.T) {
@@ -104,3 +105,189 @@ func TestParseMapOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNbdTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value     string
+		expect    time.Duration
+		expectErr bool
+	}{
+		{value: "", expect: 0},
+		{value: "30s", expect: 30 * time.Second},
+		{value: "10m", expect: 10 * time.Minute},
+		{value: "0s", expectErr: true},
+		{value: "-30s", expectErr: true},
+		{value: "1500ms", expectErr: true},
+		{value: "30", expectErr: true},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.value, func(t *testing.T) {
+			t.Parallel()
+			timeout, err := parseNbdTimeout("nbdIOTimeout", ts.value)
+			if ts.expectErr {
+				if err == nil {
+					t.Errorf("expected an error for %q, got timeout %s", ts.value, timeout)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error for %q: %v", ts.value, err)
+			}
+			if timeout != ts.expect {
+				t.Errorf("expected timeout %s for %q, got %s", ts.expect, ts.value, timeout)
+			}
+		})
+	}
+}
+
+func TestAppendNbdTimeouts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		volume      *rbdVolume
+		userOptions string
+		expect      []string
+	}{
+		{
+			name:   "default timeouts",
+			volume: &rbdVolume{},
+			expect: []string{"--reattach-timeout=300", "--io-timeout=0"},
+		},
+		{
+			name:   "configured timeouts",
+			volume: &rbdVolume{NbdIOTimeout: time.Minute, NbdReattachTimeout: 10 * time.Minute},
+			expect: []string{"--reattach-timeout=600", "--io-timeout=60"},
+		},
+		{
+			name:        "map options override the timeouts",
+			volume:      &rbdVolume{NbdIOTimeout: time.Minute},
+			userOptions: "io-timeout=30",
+			expect:      []string{"--reattach-timeout=300", "--io-timeout=30"},
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			args := appendRbdNbdCliOptions(nil, ts.userOptions, "", getNbdTimeouts(ts.volume))
+			for _, arg := range ts.expect {
+				if !CheckSliceContains(args, arg) {
+					t.Errorf("expected %q in %v", arg, args)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMapOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		mapOptions string
+		expectErr  string
+	}{
+		{
+			name:       "empty",
+			mapOptions: "",
+		},
+		{
+			name:       "krbd and nbd options",
+			mapOptions: "krbd:queue_depth=256,rxbounce;nbd:try-netlink,io-timeout=30",
+		},
+		{
+			name:       "old format with krbd options",
+			mapOptions: "lock_on_read,queue_depth=1024",
+		},
+		{
+			name:       "shared options",
+			mapOptions: "krbd:notrim,exclusive;nbd:notrim,exclusive",
+		},
+		{
+			name:       "nbd option for krbd",
+			mapOptions: "krbd:queue_depth=256,try-netlink",
+			expectErr:  `"try-netlink" is an option of nbd, not of krbd`,
+		},
+		{
+			name:       "nbd option in old format",
+			mapOptions: "reattach-timeout=600",
+			expectErr:  `"reattach-timeout=600" is an option of nbd, not of krbd`,
+		},
+		{
+			name:       "krbd option for nbd",
+			mapOptions: "nbd:read_from_replica=localize",
+			expectErr:  `"read_from_replica=localize" is an option of krbd, not of nbd`,
+		},
+		{
+			name:       "unknown mounter",
+			mapOptions: "xyz:xOp1",
+			expectErr:  "unknown mounter type",
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateMapOptions("mapOptions", ts.mapOptions)
+			if ts.expectErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error for %q: %v", ts.mapOptions, err)
+				}
+
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), ts.expectErr) {
+				t.Errorf("expected error containing %q for %q, got %v", ts.expectErr, ts.mapOptions, err)
+			}
+		})
+	}
+}
+
+func TestMergeMapOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		volumeOptions string
+		nodeOptions   string
+		expect        string
+	}{
+		{
+			name:        "only node options",
+			nodeOptions: "read_from_replica=localize,crush_location=zone:z1",
+			expect:      "read_from_replica=localize,crush_location=zone:z1",
+		},
+		{
+			name:          "only volume options",
+			volumeOptions: "queue_depth=256",
+			expect:        "queue_depth=256",
+		},
+		{
+			name:          "different options",
+			volumeOptions: "queue_depth=256",
+			nodeOptions:   "read_from_replica=localize",
+			expect:        "queue_depth=256,read_from_replica=localize",
+		},
+		{
+			name:          "volume options take precedence",
+			volumeOptions: "read_from_replica=balance,rxbounce",
+			nodeOptions:   "read_from_replica=localize,crush_location=zone:z1",
+			expect:        "read_from_replica=balance,rxbounce,crush_location=zone:z1",
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			merged := mergeMapOptions(ts.volumeOptions, ts.nodeOptions)
+			if merged != ts.expect {
+				t.Errorf("expected %q, got %q", ts.expect, merged)
+			}
+		})
+	}
+}
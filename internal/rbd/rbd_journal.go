@@ -221,7 +221,7 @@ func checkSnapCloneExists(
 
 			return false, err
 		}
-		sErr = j.StoreImageID(ctx, vol.JournalPool, vol.ReservedID, vol.ImageID)
+		sErr = j.StoreImageID(ctx, rbdSnap.Pool, vol.ReservedID, vol.ImageID)
 		if sErr != nil {
 			log.ErrorLog(ctx, "failed to store volume id %s: %v", vol, sErr)
 			err = undoSnapshotCloning(ctx, parentVol, rbdSnap, vol, cr)
@@ -288,6 +288,18 @@ func (rv *rbdVolume) Exists(ctx context.Context, parentVol *rbdVolume) (bool, er
 		rv.Pool = imageData.ImagePool
 	}
 
+	// the shared clone of a roxSharedClone volume is created or repaired by
+	// repairRoxSharedCloneVolume, the reservation does not own an image
+	if backingSnapID := imageData.ImageAttributes.BackingSnapshotID; backingSnapID != "" {
+		rv.VolID, err = util.GenerateVolID(ctx, rv.Monitors, rv.conn.Creds, imageData.ImagePoolID, rv.Pool,
+			rv.ClusterID, rv.ReservedID, volIDVersion)
+		if err != nil {
+			return false, err
+		}
+
+		return true, rv.useRoxSharedClone(backingSnapID)
+	}
+
 	// NOTE: Return volsize should be on-disk volsize, not request vol size, so
 	// save it for size checks before fetching image data
 	requestSize := rv.VolSize //nolint:ifshort // FIXME: rename and split function into helpers
@@ -368,7 +380,7 @@ func (rv *rbdVolume) repairImageID(ctx context.Context, j *journal.Connection, f
 
 		return err
 	}
-	err = j.StoreImageID(ctx, rv.JournalPool, rv.ReservedID, rv.ImageID)
+	err = j.StoreImageID(ctx, rv.Pool, rv.ReservedID, rv.ImageID)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to store volume id %s: %v", rv, err)
 
@@ -476,7 +488,8 @@ func reserveVol(ctx context.Context, rbdVol *rbdVolume, rbdSnap *rbdSnapshot, cr
 
 	rbdVol.ReservedID, rbdVol.RbdImageName, err = j.ReserveName(
 		ctx, rbdVol.JournalPool, journalPoolID, rbdVol.Pool, imagePoolID,
-		rbdVol.RequestName, rbdVol.NamePrefix, "", kmsID, rbdVol.ReservedID, rbdVol.Owner, "", encryptionType)
+		rbdVol.RequestName, rbdVol.NamePrefix, "", kmsID, rbdVol.ReservedID, rbdVol.Owner,
+		rbdVol.BackingSnapshotID, encryptionType)
 	if err != nil {
 		return err
 	}
@@ -516,8 +529,8 @@ func undoVolReservation(ctx context.Context, rbdVol *rbdVolume, cr *util.Credent
 	}
 	defer j.Destroy()
 
-	err = j.UndoReservation(ctx, rbdVol.JournalPool, rbdVol.Pool,
-		rbdVol.RbdImageName, rbdVol.RequestName)
+	pool, imageName := rbdVol.reservation()
+	err = j.UndoReservation(ctx, rbdVol.JournalPool, pool, imageName, rbdVol.RequestName)
 
 	return err
 }
@@ -689,7 +702,7 @@ func (rv *rbdVolume) storeImageID(ctx context.Context, j *journal.Connection) er
 
 		return err
 	}
-	err = j.StoreImageID(ctx, rv.JournalPool, rv.ReservedID, rv.ImageID)
+	err = j.StoreImageID(ctx, rv.Pool, rv.ReservedID, rv.ImageID)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to store volume id %s: %v", rv, err)
 
@@ -698,3 +711,53 @@ func (rv *rbdVolume) storeImageID(ctx context.Context, j *journal.Connection) er
 
 	return nil
 }
+
+// JournalVolumeExists returns a journal.VolumeExistsFunc that checks if the
+// RBD image of a journal entry exists. Snapshots are backed by RBD images
+// too, so both are checked the same way. roxSharedClone volumes have no image
+// of their own, the shared clone of their backing snapshot is checked.
+func JournalVolumeExists(monitors, radosNamespace string, cr *util.Credentials) journal.VolumeExistsFunc {
+	return func(ctx context.Context, entry *journal.Entry) (bool, error) {
+		ri := &rbdImage{
+			Monitors:       monitors,
+			Pool:           entry.Pool,
+			RadosNamespace: radosNamespace,
+			RbdImageName:   entry.VolumeName,
+		}
+		defer ri.Destroy()
+
+		if entry.BackingSnapshotID != "" {
+			var vi util.CSIIdentifier
+			err := vi.DecomposeCSIID(entry.BackingSnapshotID)
+			if err != nil {
+				return false, fmt.Errorf("failed to decode backing snapshot ID %q: %w", entry.BackingSnapshotID, err)
+			}
+			ri.Pool, err = util.GetPoolName(monitors, cr, vi.LocationID)
+			if err != nil {
+				if errors.Is(err, util.ErrPoolNotFound) {
+					return false, nil
+				}
+
+				return false, err
+			}
+			ri.RbdImageName = roxSharedCloneName(vi.ObjectUUID)
+		}
+
+		err := ri.Connect(cr)
+		if err != nil {
+			return false, err
+		}
+
+		image, err := ri.open()
+		if err != nil {
+			if errors.Is(err, ErrImageNotFound) {
+				return false, nil
+			}
+
+			return false, err
+		}
+		defer image.Close()
+
+		return true, nil
+	}
+}
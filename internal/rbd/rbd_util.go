@@ -129,6 +129,9 @@ type rbdImage struct {
 	StripeCount uint64
 	StripeUnit  uint64
 	ObjectSize  uint64
+	// InheritPoolStriping sets the stripe unit and count from the pool
+	// defaults when they are not configured for the image.
+	InheritPoolStriping bool
 
 	ImageFeatureSet librbd.FeatureSet
 
@@ -146,6 +149,13 @@ type rbdImage struct {
 
 	// Set metadata on volume
 	EnableMetadata bool
+
+	// TrashExpiry is the time the image is kept in the trash when the
+	// volume is deleted, the image is removed right away when it is zero.
+	TrashExpiry time.Duration
+	// trashExpirySet is true when the trashExpiry parameter was given, a
+	// TrashExpiry of zero then overrides the default of the driver.
+	trashExpirySet bool
 }
 
 // rbdVolume represents a CSI volume and its RBD image specifics.
@@ -174,7 +184,32 @@ type rbdVolume struct {
 	NetNamespaceFilePath string
 	// RequestedVolSize has the size of the volume requested by the user and
 	// this value will not be updated when doing getImageInfo() on rbdVolume.
-	RequestedVolSize   int64
+	RequestedVolSize int64
+	// MaxCloneDepth is the maximum depth of the parent chain of an image
+	// that is restored from a snapshot. When the depth would be exceeded,
+	// the snapshot is copied instead of cloned. Zero means no limit.
+	MaxCloneDepth uint
+	// EncryptionAllowDiscards passes discards through the LUKS mapping of
+	// an encrypted volume to the RBD image.
+	EncryptionAllowDiscards bool
+	// ThickProvision allocates the image by writing zeros to it after it
+	// is created.
+	ThickProvision bool
+	// NbdIOTimeout and NbdReattachTimeout are the io-timeout and
+	// reattach-timeout of rbd-nbd, zero selects the default.
+	NbdIOTimeout       time.Duration
+	NbdReattachTimeout time.Duration
+	// RoxSharedClone is set for read-only volumes that are restored from a
+	// snapshot with the roxSharedClone parameter. The image of such a volume
+	// is the shared clone of BackingSnapshotID, reservedPool and
+	// reservedImageName are the pool and image name of its reservation.
+	RoxSharedClone    bool
+	BackingSnapshotID string
+	reservedPool      string
+	reservedImageName string
+	// FsckMode is the fsckMode parameter, it is passed in the volume
+	// context to NodeStageVolume.
+	FsckMode           string
 	DisableInUseChecks bool
 	readOnly           bool
 }
@@ -426,12 +461,19 @@ func createImage(ctx context.Context, pOpts *rbdVolume, cr *util.Credentials) er
 	options := librbd.NewRbdImageOptions()
 	defer options.Destroy()
 
-	err := pOpts.setImageOptions(ctx, options)
+	err := pOpts.Connect(cr)
 	if err != nil {
 		return err
 	}
 
-	err = pOpts.Connect(cr)
+	if pOpts.InheritPoolStriping && pOpts.StripeUnit == 0 && pOpts.StripeCount == 0 {
+		err = pOpts.inheritPoolStriping(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pOpts.setImageOptions(ctx, options)
 	if err != nil {
 		return err
 	}
@@ -602,7 +644,8 @@ func isCephMgrSupported(ctx context.Context, clusterID string, err error) bool {
 }
 
 // ensureImageCleanup finds image in trash and if found removes it
-// from trash.
+// from trash. An image that is kept in the trash until its deferment period
+// ended is not removed, Ceph purges it after that.
 func (ri *rbdImage) ensureImageCleanup(ctx context.Context) error {
 	trashInfoList, err := librbd.GetTrashList(ri.ioctx)
 	if err != nil {
@@ -613,6 +656,12 @@ func (ri *rbdImage) ensureImageCleanup(ctx context.Context) error {
 	for _, val := range trashInfoList {
 		if val.Name == ri.RbdImageName {
 			ri.ImageID = val.Id
+			if isDeferredInTrash(val, time.Now()) {
+				log.DebugLog(ctx, "rbd: image %q with id %q is kept in trash until %s",
+					ri, ri.ImageID, val.DefermentEndTime)
+
+				return nil
+			}
 
 			return ri.trashRemoveImage(ctx)
 		}
@@ -623,6 +672,12 @@ func (ri *rbdImage) ensureImageCleanup(ctx context.Context) error {
 
 // deleteImage deletes a ceph image with provision and volume options.
 func (ri *rbdImage) deleteImage(ctx context.Context) error {
+	return ri.trashImage(ctx, 0)
+}
+
+// trashImage moves the image to the trash, and keeps it there for expiry.
+// With a zero expiry, the image is removed from the trash right away.
+func (ri *rbdImage) trashImage(ctx context.Context, expiry time.Duration) error {
 	image := ri.RbdImageName
 
 	log.DebugLog(ctx, "rbd: delete %s using mon %s, pool %s", image, ri.Monitors, ri.Pool)
@@ -653,13 +708,19 @@ func (ri *rbdImage) deleteImage(ctx context.Context) error {
 	}
 
 	rbdImage := librbd.GetImage(ri.ioctx, image)
-	err = rbdImage.Trash(0)
+	err = rbdImage.Trash(expiry)
 	if err != nil {
 		log.ErrorLog(ctx, "failed to delete rbd image: %s, error: %v", ri, err)
 
 		return err
 	}
 
+	if expiry > 0 {
+		log.DebugLog(ctx, "rbd: moved image %q to trash, it is kept there for %s", ri, expiry)
+
+		return nil
+	}
+
 	return ri.trashRemoveImage(ctx)
 }
 
@@ -1147,7 +1208,18 @@ func generateVolumeFromVolumeID(
 		}
 	}
 
-	if rbdVol.ImageID == "" {
+	// the image of a volume that uses the shared clone of its snapshot is
+	// the shared clone
+	if imageAttributes.BackingSnapshotID != "" {
+		err = rbdVol.useRoxSharedClone(imageAttributes.BackingSnapshotID)
+		if err != nil {
+			return rbdVol, err
+		}
+	}
+
+	// the image ID of the shared clone is not stored in the reservation of
+	// the volume, which does not own the image
+	if rbdVol.ImageID == "" && !rbdVol.RoxSharedClone {
 		err = rbdVol.storeImageID(ctx, j)
 		if err != nil {
 			return rbdVol, err
@@ -1266,14 +1338,23 @@ func genVolFromVolumeOptions(
 		ok         bool
 		err        error
 		namePrefix string
+		pool       string
+		namespace  string
 	)
 
 	rbdVol := &rbdVolume{}
-	rbdVol.Pool, ok = volOptions["pool"]
+	pool, ok = volOptions["pool"]
 	if !ok {
 		return nil, errors.New("missing required parameter pool")
 	}
 
+	// the pool may be passed as "pool/namespace", the namespace needs to
+	// match the radosNamespace from the cluster configuration
+	rbdVol.Pool, namespace, err = util.ParsePoolNamespace(pool)
+	if err != nil {
+		return nil, err
+	}
+
 	rbdVol.DataPool = volOptions["dataPool"]
 	if namePrefix, ok = volOptions["volumeNamePrefix"]; ok {
 		rbdVol.NamePrefix = namePrefix
@@ -1294,6 +1375,10 @@ func genVolFromVolumeOptions(
 	if err != nil {
 		return nil, err
 	}
+	if namespace != "" && namespace != rbdVol.RadosNamespace {
+		return nil, fmt.Errorf("%w: namespace %q does not match radosNamespace %q of cluster %q",
+			util.ErrInvalidPoolNamespace, namespace, rbdVol.RadosNamespace, rbdVol.ClusterID)
+	}
 	if rbdVol.Mounter, ok = volOptions["mounter"]; !ok {
 		rbdVol.Mounter = rbdDefaultMounter
 	}
@@ -1318,6 +1403,21 @@ func genVolFromVolumeOptions(
 		return nil, err
 	}
 
+	if val, ok := volOptions["maxCloneDepth"]; ok {
+		depth, pErr := strconv.ParseUint(val, 10, 32)
+		if pErr != nil {
+			return nil, fmt.Errorf("failed to parse maxCloneDepth %s: %w", val, pErr)
+		}
+		rbdVol.MaxCloneDepth = uint(depth)
+	}
+
+	if val, ok := volOptions["encryptionAllowDiscards"]; ok {
+		rbdVol.EncryptionAllowDiscards, err = strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse encryptionAllowDiscards %s: %w", val, err)
+		}
+	}
+
 	return rbdVol, nil
 }
 
@@ -1344,9 +1444,94 @@ func (ri *rbdImage) setStripeConfiguration(options map[string]string) error {
 		}
 	}
 
+	if val, ok := options["inheritPoolStriping"]; ok {
+		ri.InheritPoolStriping, err = strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("failed to parse inheritPoolStriping %s: %w", val, err)
+		}
+	}
+
+	return nil
+}
+
+// inheritPoolStriping sets the stripe unit and count of the image to the
+// defaults that are configured for the pool with "rbd config pool set".
+func (rv *rbdVolume) inheritPoolStriping(ctx context.Context) error {
+	// pool configuration is not stored in the rados namespace of the
+	// image, use an ioctx for the pool itself
+	ioctx, err := rv.conn.GetIoctx(rv.Pool)
+	if err != nil {
+		return err
+	}
+	defer ioctx.Destroy()
+
+	unit, count, err := getPoolStriping(func(key string) (string, error) {
+		return librbd.GetPoolMetadata(ioctx, key)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get striping defaults of pool %q: %w", rv.Pool, err)
+	}
+
+	if unit == 0 {
+		log.DebugLog(ctx, "pool %q has no striping defaults, not striping image %s", rv.Pool, rv)
+
+		return nil
+	}
+
+	if rv.ObjectSize != 0 && rv.ObjectSize%unit != 0 {
+		return fmt.Errorf("objectSize %d is not a multiple of the stripe unit %d of pool %q",
+			rv.ObjectSize, unit, rv.Pool)
+	}
+
+	log.DebugLog(ctx, "using striping defaults of pool %q for image %s: stripe unit %d, stripe count %d",
+		rv.Pool, rv, unit, count)
+	rv.StripeUnit = unit
+	rv.StripeCount = count
+
 	return nil
 }
 
+// getPoolStriping returns the default stripe unit and count from the pool
+// configuration that is read with getPoolMetadata. When the pool has no
+// default stripe unit configured, zero values are returned.
+func getPoolStriping(getPoolMetadata func(key string) (string, error)) (uint64, uint64, error) {
+	unit, err := getPoolConfigUint64(getPoolMetadata, "rbd_default_stripe_unit")
+	if err != nil || unit == 0 {
+		return 0, 0, err
+	}
+
+	count, err := getPoolConfigUint64(getPoolMetadata, "rbd_default_stripe_count")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// librbd uses a stripe count of 1 when it is not configured
+	if count == 0 {
+		count = 1
+	}
+
+	return unit, count, nil
+}
+
+// getPoolConfigUint64 returns the value of a pool configuration option, or 0
+// if the option is not set. Pool configuration options are stored as pool
+// metadata with the "conf_" prefix.
+func getPoolConfigUint64(getPoolMetadata func(key string) (string, error), option string) (uint64, error) {
+	val, err := getPoolMetadata("conf_" + option)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get %s: %w", option, err)
+	}
+
+	v, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s %q: %w", option, val, err)
+	}
+
+	return v, nil
+}
+
 func (rv *rbdVolume) validateImageFeatures(imageFeatures string) error {
 	// It is possible for image features to be an empty string which
 	// the Go split function would return a single item array with
@@ -1460,6 +1645,11 @@ func (rv *rbdVolume) cloneRbdImageFromSnapshot(
 		parentVol.ioctx = nil
 	}()
 
+	err = rv.setCloneStriping(ctx, parentVol.ioctx, pSnapOpts)
+	if err != nil {
+		return err
+	}
+
 	options := librbd.NewRbdImageOptions()
 	defer options.Destroy()
 	err = rv.setImageOptions(ctx, options)
@@ -1511,6 +1701,172 @@ func (rv *rbdVolume) cloneRbdImageFromSnapshot(
 	return nil
 }
 
+// setCloneStriping sets the striping of the clone of the snapshot, and checks
+// that it can be used with the object size of the clone. A clone gets the
+// object size of its parent when no objectSize is requested.
+func (rv *rbdVolume) setCloneStriping(ctx context.Context, parentIoctx *rados.IOContext, pSnapOpts *rbdSnapshot) error {
+	if rv.InheritPoolStriping && rv.StripeUnit == 0 && rv.StripeCount == 0 {
+		err := rv.inheritPoolStriping(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if rv.StripeUnit == 0 || rv.ObjectSize != 0 {
+		// the object size is validated with the striping parameters
+		return nil
+	}
+
+	parent, err := librbd.OpenImageReadOnly(parentIoctx, pSnapOpts.RbdImageName, pSnapOpts.RbdSnapName)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot %s: %w", pSnapOpts, err)
+	}
+	defer parent.Close()
+
+	info, err := parent.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get object size of snapshot %s: %w", pSnapOpts, err)
+	}
+
+	return validateCloneStriping(rv.StripeUnit, rv.ObjectSize, 1<<info.Order)
+}
+
+// validateCloneStriping checks that the objects of a clone hold a whole number
+// of stripe units, like librbd requires. When objectSize is zero, the clone
+// gets the parentObjectSize.
+func validateCloneStriping(stripeUnit, objectSize, parentObjectSize uint64) error {
+	if stripeUnit == 0 {
+		return nil
+	}
+	if objectSize == 0 {
+		objectSize = parentObjectSize
+	}
+	if objectSize%stripeUnit != 0 {
+		return fmt.Errorf("%w: object size %d of the clone is not a multiple of stripeUnit %d, "+
+			"set an objectSize that is", ErrInvalidStriping, objectSize, stripeUnit)
+	}
+
+	return nil
+}
+
+// exceedsMaxCloneDepth returns true when a clone of an image with a parent
+// chain of parentDepth would be deeper than maxDepth. A maxDepth of zero
+// means there is no limit.
+func exceedsMaxCloneDepth(parentDepth, maxDepth uint) bool {
+	return maxDepth != 0 && parentDepth+1 > maxDepth
+}
+
+// copyRbdImageFromSnapshot creates the image by copying the data of the
+// snapshot, the new image does not have a parent.
+func (rv *rbdVolume) copyRbdImageFromSnapshot(
+	ctx context.Context,
+	pSnapOpts *rbdSnapshot,
+	parentVol *rbdVolume,
+) error {
+	var err error
+	log.DebugLog(ctx, "rbd: copy %s %s (features: %s) using mon %s",
+		pSnapOpts, rv, rv.ImageFeatureSet.Names(), rv.Monitors)
+
+	err = parentVol.openIoctx()
+	if err != nil {
+		return fmt.Errorf("failed to get parent IOContext: %w", err)
+	}
+	defer func() {
+		defer parentVol.ioctx.Destroy()
+		parentVol.ioctx = nil
+	}()
+
+	srcImage, err := librbd.OpenImageReadOnly(parentVol.ioctx, pSnapOpts.RbdImageName, pSnapOpts.RbdSnapName)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot %s: %w", pSnapOpts, err)
+	}
+	defer srcImage.Close()
+
+	size, err := srcImage.GetSize()
+	if err != nil {
+		return fmt.Errorf("failed to get size of snapshot %s: %w", pSnapOpts, err)
+	}
+
+	if rv.InheritPoolStriping && rv.StripeUnit == 0 && rv.StripeCount == 0 {
+		err = rv.inheritPoolStriping(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	options := librbd.NewRbdImageOptions()
+	defer options.Destroy()
+	err = rv.setImageOptions(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	// As the image is yet to be created, open the Ioctx.
+	err = rv.openIoctx()
+	if err != nil {
+		return fmt.Errorf("failed to get IOContext: %w", err)
+	}
+
+	// The data is copied into a temporary image, which is renamed once the
+	// copy is complete. A copy that is interrupted by a restart of the
+	// provisioner is then never found by Exists() as a valid volume.
+	copyName := copyImageName(rv.RbdImageName)
+	err = librbd.RemoveImage(rv.ioctx, copyName)
+	if err != nil && !errors.Is(err, librbd.ErrNotFound) {
+		return fmt.Errorf("failed to delete stale image %s/%s: %w", rv.Pool, copyName, err)
+	}
+
+	err = librbd.CreateImage(rv.ioctx, copyName, size, options)
+	if err != nil {
+		return fmt.Errorf("failed to create rbd image: %w", err)
+	}
+
+	// delete the copied image if a next step fails, the image is renamed
+	// to rv.RbdImageName once the copy is complete
+	deleteImage := copyName
+	defer func() {
+		if deleteImage != "" {
+			rmErr := librbd.RemoveImage(rv.ioctx, deleteImage)
+			if rmErr != nil {
+				log.ErrorLog(ctx, "failed to delete image %s/%s: %v", rv.Pool, deleteImage, rmErr)
+			}
+		}
+	}()
+
+	dstImage, err := librbd.OpenImage(rv.ioctx, copyName, librbd.NoSnapshot)
+	if err != nil {
+		return fmt.Errorf("failed to open image %s/%s: %w", rv.Pool, copyName, err)
+	}
+
+	err = srcImage.Copy2(dstImage)
+	dstImage.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy snapshot %s to image %s: %w", pSnapOpts, rv, err)
+	}
+
+	err = librbd.GetImage(rv.ioctx, copyName).Rename(rv.RbdImageName)
+	if err != nil {
+		return fmt.Errorf("failed to rename image %s/%s to %s: %w", rv.Pool, copyName, rv, err)
+	}
+	deleteImage = rv.RbdImageName
+
+	// get image latest information
+	err = rv.getImageInfo()
+	if err != nil {
+		return fmt.Errorf("failed to get image info of %s: %w", rv, err)
+	}
+
+	// Success! Do not delete the copied image now :)
+	deleteImage = ""
+
+	return nil
+}
+
+// copyImageName returns the name of the temporary image that the data of a
+// snapshot is copied into, before it is renamed to imageName.
+func copyImageName(imageName string) string {
+	return imageName + "-copy"
+}
+
 // setImageOptions sets the image options.
 func (rv *rbdVolume) setImageOptions(ctx context.Context, options *librbd.ImageOptions) error {
 	var err error
@@ -1683,6 +2039,11 @@ type rbdImageMetadataStash struct {
 	DevicePath     string `json:"device"`          // holds NBD device path for now
 	LogDir         string `json:"logDir"`          // holds the client log path
 	LogStrategy    string `json:"logFileStrategy"` // ceph client log strategy
+	// rbd-nbd timeouts in seconds, reused when the healer attaches the image
+	NbdIOTimeout       int `json:"nbdIOTimeout,omitempty"`
+	NbdReattachTimeout int `json:"nbdReattachTimeout,omitempty"`
+	// the image is mapped read-only, and can only be published read-only
+	ReadOnly bool `json:"readOnly,omitempty"`
 }
 
 // file name in which image metadata is stashed.
@@ -1708,6 +2069,7 @@ func stashRBDImageMetadata(volOptions *rbdVolume, metaDataPath string) error {
 		ImageName:      volOptions.RbdImageName,
 		Encrypted:      volOptions.isBlockEncrypted(),
 		UnmapOptions:   volOptions.UnmapOptions,
+		ReadOnly:       volOptions.readOnly,
 	}
 
 	imgMeta.NbdAccess = false
@@ -1715,6 +2077,8 @@ func stashRBDImageMetadata(volOptions *rbdVolume, metaDataPath string) error {
 		imgMeta.NbdAccess = true
 		imgMeta.LogDir = volOptions.LogDir
 		imgMeta.LogStrategy = volOptions.LogStrategy
+		imgMeta.NbdIOTimeout = int(volOptions.NbdIOTimeout / time.Second)
+		imgMeta.NbdReattachTimeout = int(volOptions.NbdReattachTimeout / time.Second)
 	}
 
 	encodedBytes, err := json.Marshal(imgMeta)
@@ -391,3 +391,105 @@ func Test_checkValidImageFeatures(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPoolStriping(t *testing.T) {
+	t.Parallel()
+	errFailed := errors.New("failed to read pool metadata")
+	tests := []struct {
+		name       string
+		poolConfig map[string]string
+		err        error
+		unit       uint64
+		count      uint64
+		wantErr    bool
+	}{
+		{
+			name:       "no striping defaults",
+			poolConfig: map[string]string{},
+		},
+		{
+			name: "stripe unit and count",
+			poolConfig: map[string]string{
+				"conf_rbd_default_stripe_unit":  "65536",
+				"conf_rbd_default_stripe_count": "16",
+			},
+			unit:  65536,
+			count: 16,
+		},
+		{
+			name: "stripe unit without count",
+			poolConfig: map[string]string{
+				"conf_rbd_default_stripe_unit": "65536",
+			},
+			unit:  65536,
+			count: 1,
+		},
+		{
+			name: "stripe count without unit",
+			poolConfig: map[string]string{
+				"conf_rbd_default_stripe_count": "16",
+			},
+		},
+		{
+			name: "invalid stripe unit",
+			poolConfig: map[string]string{
+				"conf_rbd_default_stripe_unit": "64K",
+			},
+			wantErr: true,
+		},
+		{
+			name:       "failure to read pool metadata",
+			poolConfig: map[string]string{},
+			err:        errFailed,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			getPoolMetadata := func(key string) (string, error) {
+				if tc.err != nil {
+					return "", tc.err
+				}
+				val, ok := tc.poolConfig[key]
+				if !ok {
+					return "", librbd.ErrNotFound
+				}
+
+				return val, nil
+			}
+
+			unit, count, err := getPoolStriping(getPoolMetadata)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("getPoolStriping() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			assert.Equal(t, tc.unit, unit)
+			assert.Equal(t, tc.count, count)
+		})
+	}
+}
+
+func TestExceedsMaxCloneDepth(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		parentDepth uint
+		maxDepth    uint
+		want        bool
+	}{
+		{"no limit", 100, 0, false},
+		{"parent without parent", 0, 1, false},
+		{"clone within depth", 2, 4, false},
+		{"clone reaches depth", 3, 4, false},
+		{"clone past depth", 4, 4, true},
+		{"parent past depth", 6, 4, true},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, exceedsMaxCloneDepth(tc.parentDepth, tc.maxDepth))
+		})
+	}
+}
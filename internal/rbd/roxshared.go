@@ -0,0 +1,465 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	csicommon "github.com/ceph/ceph-csi/internal/csi-common"
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+	"github.com/ceph/ceph-csi/internal/util/reftracker"
+	rterrors "github.com/ceph/ceph-csi/internal/util/reftracker/errors"
+	"github.com/ceph/ceph-csi/internal/util/reftracker/radoswrapper"
+	"github.com/ceph/ceph-csi/internal/util/reftracker/reftype"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// roxSharedClonePrefix is the prefix of the name of the shared clone of a
+// snapshot, the name ends with the UUID of the snapshot.
+const roxSharedClonePrefix = "csi-rox-"
+
+// roxSharedCloneName returns the name of the shared clone of the snapshot
+// with the UUID snapUUID.
+func roxSharedCloneName(snapUUID string) string {
+	return roxSharedClonePrefix + snapUUID
+}
+
+// fmtRoxSharedCloneReftrackerName returns the name of the reftracker object
+// that counts the volumes that use the shared clone of the snapshot.
+func fmtRoxSharedCloneReftrackerName(backingSnapID string) string {
+	return fmt.Sprintf("rt-roxsharedclone-%s", backingSnapID)
+}
+
+// isReaderOnlyVolume returns true if all the capabilities of the volume have
+// a read-only access mode.
+func isReaderOnlyVolume(caps []*csi.VolumeCapability) bool {
+	if len(caps) == 0 {
+		return false
+	}
+	for _, c := range caps {
+		if !csicommon.IsReaderOnly([]*csi.VolumeCapability{c}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reservation returns the pool and the image name of the journal reservation
+// of the volume. These are not the pool and image name of the shared clone
+// that a roxSharedClone volume uses.
+func (rv *rbdVolume) reservation() (string, string) {
+	if rv.BackingSnapshotID != "" {
+		return rv.reservedPool, rv.reservedImageName
+	}
+
+	return rv.Pool, rv.RbdImageName
+}
+
+// useRoxSharedClone makes rv use the shared clone of the snapshot with the ID
+// backingSnapID. The shared clone is in the pool of the snapshot, so that
+// the volume does not depend on the journal of the snapshot, which may be
+// deleted before the volume.
+func (rv *rbdVolume) useRoxSharedClone(backingSnapID string) error {
+	var vi util.CSIIdentifier
+
+	err := vi.DecomposeCSIID(backingSnapID)
+	if err != nil {
+		return fmt.Errorf("%w: error decoding backing snapshot ID (%s) (%s)",
+			ErrInvalidVolID, err, backingSnapID)
+	}
+
+	pool, err := util.GetPoolName(rv.Monitors, rv.conn.Creds, vi.LocationID)
+	if err != nil {
+		return err
+	}
+
+	// the ioctx of the reserved pool can not be used for the shared clone
+	if rv.ioctx != nil {
+		rv.ioctx.Destroy()
+		rv.ioctx = nil
+	}
+
+	rv.RoxSharedClone = true
+	rv.BackingSnapshotID = backingSnapID
+	rv.reservedPool = rv.Pool
+	rv.reservedImageName = rv.RbdImageName
+	rv.Pool = pool
+	rv.RbdImageName = roxSharedCloneName(vi.ObjectUUID)
+
+	return nil
+}
+
+// addRoxSharedCloneRef adds the reference of the volume with the reserved
+// UUID volUUID to the shared clone of the snapshot.
+func addRoxSharedCloneRef(ioctx radoswrapper.IOContextW, backingSnapID, volUUID string) error {
+	_, err := reftracker.Add(
+		ioctx,
+		fmtRoxSharedCloneReftrackerName(backingSnapID),
+		map[string]struct{}{
+			volUUID: {},
+		},
+	)
+
+	return err
+}
+
+// removeRoxSharedCloneRef removes the reference of the volume with the
+// reserved UUID volUUID to the shared clone of the snapshot. It returns true
+// if the shared clone is not used by any volume anymore, and needs to be
+// deleted.
+func removeRoxSharedCloneRef(ioctx radoswrapper.IOContextW, backingSnapID, volUUID string) (bool, error) {
+	return reftracker.Remove(
+		ioctx,
+		fmtRoxSharedCloneReftrackerName(backingSnapID),
+		map[string]reftype.RefType{
+			volUUID: reftype.Normal,
+		},
+	)
+}
+
+// addRoxSharedCloneRef adds the reference of the volume to its shared clone,
+// the reftracker is stored in the pool of the shared clone.
+func (rv *rbdVolume) addRoxSharedCloneRef() error {
+	err := rv.openIoctx()
+	if err != nil {
+		return err
+	}
+
+	return addRoxSharedCloneRef(radoswrapper.NewIOContext(rv.ioctx), rv.BackingSnapshotID, rv.ReservedID)
+}
+
+// removeRoxSharedCloneRef removes the reference of the volume to its shared
+// clone, and returns true if the shared clone needs to be deleted.
+func (rv *rbdVolume) removeRoxSharedCloneRef() (bool, error) {
+	err := rv.openIoctx()
+	if err != nil {
+		return false, err
+	}
+
+	return removeRoxSharedCloneRef(radoswrapper.NewIOContext(rv.ioctx), rv.BackingSnapshotID, rv.ReservedID)
+}
+
+// setRoxSharedCloneSize sets the size of the volume to the size of the
+// snapshot, which is the size of the shared clone. A volume can not be
+// larger than the shared clone, as the shared clone is never expanded.
+func setRoxSharedCloneSize(rbdVol *rbdVolume, rbdSnap *rbdSnapshot) error {
+	if rbdVol.RequestedVolSize > rbdSnap.VolSize {
+		return status.Errorf(codes.InvalidArgument,
+			"roxSharedClone volumes have the size of snapshot %s (%d bytes), %d bytes requested",
+			rbdSnap, rbdSnap.VolSize, rbdVol.RequestedVolSize)
+	}
+	rbdVol.VolSize = rbdSnap.VolSize
+	rbdVol.RequestedVolSize = rbdSnap.VolSize
+
+	return nil
+}
+
+// createRoxSharedCloneVolume creates a volume that uses the shared clone of
+// the snapshot. The volume is reserved in the journal and references the
+// shared clone, which is created and flattened for the first volume.
+func (cs *ControllerServer) createRoxSharedCloneVolume(
+	ctx context.Context,
+	req *csi.CreateVolumeRequest,
+	cr *util.Credentials,
+	rbdVol *rbdVolume,
+	rbdSnap *rbdSnapshot,
+) (*csi.CreateVolumeResponse, error) {
+	err := setRoxSharedCloneSize(rbdVol, rbdSnap)
+	if err != nil {
+		return nil, err
+	}
+
+	// lock out the deletion of the shared clone by DeleteVolume, and of the
+	// snapshot by DeleteSnapshot
+	if err = cs.OperationLocks.GetRestoreLock(rbdSnap.VolID); err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer cs.OperationLocks.ReleaseRestoreLock(rbdSnap.VolID)
+
+	rbdVol.BackingSnapshotID = rbdSnap.VolID
+	err = reserveVol(ctx, rbdVol, rbdSnap, cr)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = rbdVol.useRoxSharedClone(rbdSnap.VolID)
+	if err != nil {
+		// the volume was not switched to the shared clone, the reservation
+		// is undone with its own pool and image name
+		rbdVol.BackingSnapshotID = ""
+		if errDefer := undoVolReservation(ctx, rbdVol, cr); errDefer != nil {
+			log.WarningLog(ctx, "failed undoing reservation of volume: %s (%s)", req.GetName(), errDefer)
+		}
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = cs.ensureRoxSharedClone(ctx, cr, req.GetSecrets(), rbdVol)
+	if err != nil {
+		// the CO retries the request until the shared clone is flattened,
+		// CreateVolume finds the reservation and checks the flatten again
+		if errors.Is(err, ErrFlattenInProgress) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		cs.undoRoxSharedCloneVolume(ctx, rbdVol, cr)
+
+		return nil, err
+	}
+
+	log.DebugLog(ctx, "volume %s uses shared clone %s of snapshot %s", rbdVol.VolID, rbdVol, rbdSnap)
+
+	return buildCreateVolumeResponse(req, rbdVol), nil
+}
+
+// repairRoxSharedCloneVolume completes the creation of a volume that uses the
+// shared clone of a snapshot, when it was interrupted.
+func (cs *ControllerServer) repairRoxSharedCloneVolume(
+	ctx context.Context,
+	req *csi.CreateVolumeRequest,
+	cr *util.Credentials,
+	rbdVol *rbdVolume,
+	rbdSnap *rbdSnapshot,
+) (*csi.CreateVolumeResponse, error) {
+	if rbdSnap != nil {
+		err := setRoxSharedCloneSize(rbdVol, rbdSnap)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cs.OperationLocks.GetRestoreLock(rbdVol.BackingSnapshotID); err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer cs.OperationLocks.ReleaseRestoreLock(rbdVol.BackingSnapshotID)
+
+	err := cs.ensureRoxSharedClone(ctx, cr, req.GetSecrets(), rbdVol)
+	if err != nil {
+		if errors.Is(err, ErrFlattenInProgress) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+
+		return nil, err
+	}
+
+	return buildCreateVolumeResponse(req, rbdVol), nil
+}
+
+// ensureRoxSharedClone adds the reference of the volume to the shared clone,
+// creates the shared clone from the snapshot if it does not exist yet, and
+// flattens it. ErrFlattenInProgress is returned until the shared clone does
+// not depend on the snapshot anymore.
+func (cs *ControllerServer) ensureRoxSharedClone(
+	ctx context.Context,
+	cr *util.Credentials,
+	secrets map[string]string,
+	rbdVol *rbdVolume,
+) error {
+	// the reference is added first, so that the shared clone is not deleted
+	// while it is created or flattened
+	err := rbdVol.addRoxSharedCloneRef()
+	if err != nil {
+		log.ErrorLog(ctx, "failed to add ref for shared clone %s: %v", rbdVol, err)
+
+		if errors.Is(err, rterrors.ErrObjectOutOfDate) {
+			return status.Error(codes.Aborted, err.Error())
+		}
+
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	err = rbdVol.getImageInfo()
+	if errors.Is(err, ErrImageNotFound) {
+		log.DebugLog(ctx, "creating shared clone %s of snapshot %s", rbdVol, rbdVol.BackingSnapshotID)
+		// createVolumeFromSnapshot returns gRPC errors
+		err = cs.createVolumeFromSnapshot(ctx, cr, secrets, rbdVol, rbdVol.BackingSnapshotID)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	return rbdVol.flattenRbdImage(ctx, true, rbdHardMaxCloneDepth, rbdSoftMaxCloneDepth)
+}
+
+// undoRoxSharedCloneVolume removes the reference of a volume that could not
+// be created, and its reservation. The shared clone is deleted when it is
+// not used by any other volume.
+func (cs *ControllerServer) undoRoxSharedCloneVolume(ctx context.Context, rbdVol *rbdVolume, cr *util.Credentials) {
+	unused, err := rbdVol.removeRoxSharedCloneRef()
+	if err != nil {
+		log.WarningLog(ctx, "failed to remove ref for shared clone %s: %v", rbdVol, err)
+
+		return
+	}
+	if unused {
+		err = rbdVol.deleteImage(ctx)
+		if err != nil && !errors.Is(err, ErrImageNotFound) {
+			log.WarningLog(ctx, "failed to delete shared clone %s: %v", rbdVol, err)
+
+			return
+		}
+	}
+
+	err = undoVolReservation(ctx, rbdVol, cr)
+	if err != nil {
+		log.WarningLog(ctx, "failed undoing reservation of volume: %s (%s)", rbdVol.RequestName, err)
+	}
+}
+
+// deleteRoxSharedCloneVolume deletes a volume that uses the shared clone of
+// a snapshot. The reference of the volume is removed, and the last volume
+// that uses the shared clone deletes it.
+func (cs *ControllerServer) deleteRoxSharedCloneVolume(
+	ctx context.Context,
+	rbdVol *rbdVolume,
+	cr *util.Credentials,
+) (*csi.DeleteVolumeResponse, error) {
+	// lock out the creation of volumes that use the shared clone
+	if err := cs.OperationLocks.GetDeleteLock(rbdVol.BackingSnapshotID); err != nil {
+		log.ErrorLog(ctx, err.Error())
+
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer cs.OperationLocks.ReleaseDeleteLock(rbdVol.BackingSnapshotID)
+
+	unused, err := rbdVol.removeRoxSharedCloneRef()
+	if err != nil {
+		log.ErrorLog(ctx, "failed to remove ref for shared clone %s: %v", rbdVol, err)
+
+		if errors.Is(err, rterrors.ErrObjectOutOfDate) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if unused {
+		// removes the shared clone and the reservation of the volume
+		log.DebugLog(ctx, "deleting shared clone %s of snapshot %s", rbdVol, rbdVol.BackingSnapshotID)
+
+		return cleanupRBDImage(ctx, rbdVol, cr)
+	}
+
+	err = undoVolReservation(ctx, rbdVol, cr)
+	if err != nil {
+		log.ErrorLog(ctx, "failed to remove reservation for volume (%s) with shared clone (%s) (%s)",
+			rbdVol.RequestName, rbdVol.RbdImageName, err)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// The volumes that use the shared clone of a snapshot are all backed by the
+// same image, so the volumes that are staged on one node share the device
+// the image is mapped to. The device is only unmapped when the last of these
+// volumes is unstaged, the other volumes are found by their stashed image
+// metadata.
+
+// isRoxSharedCloneImage returns true if imageName is the name of the shared
+// clone of a snapshot.
+func isRoxSharedCloneImage(imageName string) bool {
+	return strings.HasPrefix(imageName, roxSharedClonePrefix)
+}
+
+// stagedImageUsers returns the staging paths of the other volumes, that are
+// staged on the node with the image imageSpec (pool/{namespace/}image). The
+// staging paths of all volumes are siblings of stagingParentPath, which is
+// <kubelet>/plugins/kubernetes.io/csi/{pv/<pv-name>|<driver>/<hash>}/globalmount.
+func stagedImageUsers(stagingParentPath, imageSpec string) ([]string, error) {
+	stagingRoot := filepath.Dir(filepath.Dir(filepath.Dir(filepath.Clean(stagingParentPath))))
+	stashes, err := filepath.Glob(filepath.Join(stagingRoot, "*", "*", "globalmount", stashFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staged volumes in %q: %w", stagingRoot, err)
+	}
+
+	users := []string{}
+	for _, stash := range stashes {
+		stagingPath := filepath.Dir(stash)
+		if stagingPath == filepath.Clean(stagingParentPath) {
+			continue
+		}
+		imgInfo, lErr := lookupRBDImageMetadataStash(stagingPath)
+		if lErr != nil {
+			// the volume was unstaged in the meantime
+			if errors.Is(lErr, ErrMissingStash) {
+				continue
+			}
+
+			return nil, lErr
+		}
+		if imgInfo.String() == imageSpec {
+			users = append(users, stagingPath)
+		}
+	}
+
+	return users, nil
+}
+
+// lockSharedDevice serializes staging and unstaging of the volumes that
+// share the device of the image imageSpec, so that a volume is not staged
+// on the device while it is unmapped. The caller must call
+// unlockSharedDevice if it returns nil.
+func (ns *NodeServer) lockSharedDevice(ctx context.Context, imageSpec string) error {
+	if acquired := ns.VolumeLocks.TryAcquire(imageSpec); !acquired {
+		log.ErrorLog(ctx, util.VolumeOperationAlreadyExistsFmt, imageSpec)
+
+		return status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, imageSpec)
+	}
+
+	return nil
+}
+
+// unlockSharedDevice releases the lock of lockSharedDevice.
+func (ns *NodeServer) unlockSharedDevice(imageSpec string) {
+	ns.VolumeLocks.Release(imageSpec)
+}
+
+// sharedDeviceInUse returns true if the device of the image imageSpec is
+// still used by other volumes that are staged on the node, in which case
+// the device must not be unmapped.
+func sharedDeviceInUse(ctx context.Context, stagingParentPath, imageSpec string) (bool, error) {
+	if !isRoxSharedCloneImage(path.Base(imageSpec)) {
+		return false, nil
+	}
+	users, err := stagedImageUsers(stagingParentPath, imageSpec)
+	if err != nil {
+		return false, err
+	}
+	if len(users) != 0 {
+		log.DebugLog(ctx, "device of shared clone %s is still used by the volumes staged at %v", imageSpec, users)
+
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ceph/ceph-csi/internal/util/reftracker/radoswrapper"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsReaderOnlyVolume(t *testing.T) {
+	t.Parallel()
+	capability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+	tests := []struct {
+		name string
+		caps []*csi.VolumeCapability
+		want bool
+	}{
+		{"no capabilities", nil, false},
+		{
+			"multi node reader only",
+			[]*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)},
+			true,
+		},
+		{
+			"reader only modes",
+			[]*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+				capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+			},
+			true,
+		},
+		{
+			"reader only and writer modes",
+			[]*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+				capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, ts.want, isReaderOnlyVolume(ts.caps))
+		})
+	}
+}
+
+func TestReservation(t *testing.T) {
+	t.Parallel()
+	rv := &rbdVolume{}
+	rv.Pool = "replicapool"
+	rv.RbdImageName = "csi-vol-1"
+
+	pool, imageName := rv.reservation()
+	assert.Equal(t, "replicapool", pool)
+	assert.Equal(t, "csi-vol-1", imageName)
+
+	// a roxSharedClone volume is reserved with its own names
+	rv.BackingSnapshotID = "snap-id"
+	rv.reservedPool = "replicapool"
+	rv.reservedImageName = "csi-vol-1"
+	rv.Pool = "snappool"
+	rv.RbdImageName = roxSharedCloneName("snap-uuid")
+
+	pool, imageName = rv.reservation()
+	assert.Equal(t, "replicapool", pool)
+	assert.Equal(t, "csi-vol-1", imageName)
+	assert.Equal(t, "csi-rox-snap-uuid", rv.RbdImageName)
+}
+
+func TestRoxSharedCloneRefs(t *testing.T) {
+	t.Parallel()
+	ioctx := radoswrapper.NewFakeIOContext(radoswrapper.NewFakeRados())
+
+	assert.NoError(t, addRoxSharedCloneRef(ioctx, "snap-1", "vol-1"))
+	assert.NoError(t, addRoxSharedCloneRef(ioctx, "snap-1", "vol-2"))
+	// adding a reference again is a no-op
+	assert.NoError(t, addRoxSharedCloneRef(ioctx, "snap-1", "vol-2"))
+	// the shared clones of other snapshots are counted separately
+	assert.NoError(t, addRoxSharedCloneRef(ioctx, "snap-2", "vol-3"))
+
+	unused, err := removeRoxSharedCloneRef(ioctx, "snap-1", "vol-1")
+	assert.NoError(t, err)
+	assert.False(t, unused)
+
+	unused, err = removeRoxSharedCloneRef(ioctx, "snap-1", "vol-2")
+	assert.NoError(t, err)
+	assert.True(t, unused)
+
+	// removing the reference again, after the shared clone was unused
+	unused, err = removeRoxSharedCloneRef(ioctx, "snap-1", "vol-2")
+	assert.NoError(t, err)
+	assert.True(t, unused)
+
+	unused, err = removeRoxSharedCloneRef(ioctx, "snap-2", "vol-3")
+	assert.NoError(t, err)
+	assert.True(t, unused)
+}
+
+func TestSetRoxSharedCloneSize(t *testing.T) {
+	t.Parallel()
+	rbdSnap := &rbdSnapshot{}
+	rbdSnap.VolSize = 2 * oneGB
+
+	rv := &rbdVolume{}
+	rv.VolSize = oneGB
+	rv.RequestedVolSize = oneGB
+	assert.NoError(t, setRoxSharedCloneSize(rv, rbdSnap))
+	assert.Equal(t, int64(2*oneGB), rv.VolSize)
+	assert.Equal(t, int64(2*oneGB), rv.RequestedVolSize)
+
+	rv.RequestedVolSize = 3 * oneGB
+	assert.Error(t, setRoxSharedCloneSize(rv, rbdSnap))
+}
+
+func TestSharedDeviceInUse(t *testing.T) {
+	t.Parallel()
+	stagingRoot := t.TempDir()
+	stage := func(pvName, pool, imageName string) string {
+		stagingParentPath := filepath.Join(stagingRoot, "pv", pvName, "globalmount")
+		require.NoError(t, os.MkdirAll(stagingParentPath, 0o750))
+		rv := &rbdVolume{}
+		rv.Pool = pool
+		rv.RbdImageName = imageName
+		require.NoError(t, stashRBDImageMetadata(rv, stagingParentPath))
+
+		return stagingParentPath
+	}
+	sharedImage := roxSharedCloneName("snap-uuid")
+	imageSpec := "snappool/" + sharedImage
+
+	// two volumes of the same snapshot, and one of another snapshot
+	vol1 := stage("pvc-1", "snappool", sharedImage)
+	vol2 := stage("pvc-2", "snappool", sharedImage)
+	vol3 := stage("pvc-3", "snappool", roxSharedCloneName("other-snap-uuid"))
+
+	users, err := stagedImageUsers(vol1, imageSpec)
+	require.NoError(t, err)
+	assert.Equal(t, []string{vol2}, users)
+
+	inUse, err := sharedDeviceInUse(context.TODO(), vol1, imageSpec)
+	require.NoError(t, err)
+	assert.True(t, inUse)
+
+	// unstaging the first volume keeps the device, unstaging the second
+	// one unmaps it
+	require.NoError(t, cleanupRBDImageMetadataStash(vol1))
+	inUse, err = sharedDeviceInUse(context.TODO(), vol2, imageSpec)
+	require.NoError(t, err)
+	assert.False(t, inUse)
+
+	inUse, err = sharedDeviceInUse(context.TODO(), vol3, "snappool/"+roxSharedCloneName("other-snap-uuid"))
+	require.NoError(t, err)
+	assert.False(t, inUse)
+
+	// images that are not shared clones are always unmapped
+	vol4 := stage("pvc-4", "replicapool", "csi-vol-1")
+	stage("pvc-5", "replicapool", "csi-vol-1")
+	inUse, err = sharedDeviceInUse(context.TODO(), vol4, "replicapool/csi-vol-1")
+	require.NoError(t, err)
+	assert.False(t, inUse)
+}
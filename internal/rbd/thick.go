@@ -0,0 +1,255 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/ceph/go-ceph/rados"
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+const (
+	// thickProvisionMetaKey is the image metadata key that marks an image
+	// as thick-provisioned, it is set once the image is fully allocated.
+	thickProvisionMetaKey = "rbd.csi.ceph.com/thick-provisioned"
+	// thickProgressMetaKey is the image metadata key that stores the offset
+	// up to which the image is allocated, while the allocation is running.
+	thickProgressMetaKey = "rbd.csi.ceph.com/thick-provision-offset"
+
+	// thickWriteSize is the maximum number of bytes zero-filled with a
+	// single WriteSame call, the progress is recorded after each call.
+	thickWriteSize = oneGB
+)
+
+var (
+	// allocations are the images that are being allocated, by image spec.
+	allocationsLock sync.Mutex
+	allocations     = make(map[string]struct{})
+)
+
+// nextZeroFill returns the number of bytes to zero-fill next, for an image of
+// size that is allocated up to offset. While more than blockSize bytes are
+// left, a multiple of blockSize up to thickWriteSize is returned, and same is
+// true to write it with WriteSame. The last partial block is returned with
+// same set to false, it needs to be written with WriteAt.
+func nextZeroFill(offset, size, blockSize uint64) (uint64, bool) {
+	if offset >= size {
+		return 0, false
+	}
+	remaining := size - offset
+	if remaining < blockSize {
+		return remaining, false
+	}
+
+	length := remaining
+	if length > thickWriteSize {
+		length = thickWriteSize
+	}
+
+	return length - length%blockSize, true
+}
+
+// isThickProvisioned returns true if the image is marked as thick-provisioned.
+func (ri *rbdImage) isThickProvisioned() (bool, error) {
+	_, err := ri.GetMetadata(thickProvisionMetaKey)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check thick-provisioning of image %s: %w", ri, err)
+	}
+
+	return true, nil
+}
+
+// setAllocationOffset records that the image needs to be allocated from
+// offset, unless an allocation from a lower offset is pending already. It is
+// used before an image of a thick-provisioned volume is expanded.
+func (ri *rbdImage) setAllocationOffset(offset uint64) error {
+	pending, err := ri.getAllocationOffset()
+	if err == nil && pending <= offset {
+		return nil
+	} else if err != nil && !errors.Is(err, librbd.ErrNotFound) {
+		return err
+	}
+
+	return ri.SetMetadata(thickProgressMetaKey, strconv.FormatUint(offset, 10))
+}
+
+// allocationPending returns true if the allocation of the image has not
+// completed yet.
+func (ri *rbdImage) allocationPending() (bool, error) {
+	_, err := ri.getAllocationOffset()
+	if errors.Is(err, librbd.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// getAllocationOffset returns the offset up to which the image is allocated.
+// librbd.ErrNotFound is returned when no allocation is pending.
+func (ri *rbdImage) getAllocationOffset() (uint64, error) {
+	value, err := ri.GetMetadata(thickProgressMetaKey)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s metadata %q of image %s: %w", thickProgressMetaKey, value, ri, err)
+	}
+
+	return offset, nil
+}
+
+// ensureAllocated makes sure that the image is fully allocated. nil is
+// returned when the image is thick-provisioned and no allocation is pending.
+// Otherwise the allocation is started in the background, or resumed from the
+// recorded offset after an interruption, and ErrAllocationInProgress is
+// returned until it completes.
+func (ri *rbdImage) ensureAllocated(ctx context.Context) error {
+	spec := ri.String()
+	allocationsLock.Lock()
+	defer allocationsLock.Unlock()
+
+	if _, running := allocations[spec]; running {
+		return fmt.Errorf("%w: image %s", ErrAllocationInProgress, ri)
+	}
+
+	offset, err := ri.getAllocationOffset()
+	switch {
+	case errors.Is(err, librbd.ErrNotFound):
+		thick, tErr := ri.isThickProvisioned()
+		if tErr != nil {
+			return tErr
+		}
+		if thick {
+			return nil
+		}
+		// a new image, nothing allocated yet. The offset is recorded before
+		// the allocation starts, so that the pending allocation is found by
+		// ControllerExpandVolume.
+		offset = 0
+		err = ri.SetMetadata(thickProgressMetaKey, "0")
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	// the background allocation uses its own connection, the image of the
+	// request is destroyed when the request returns
+	image := &rbdImage{
+		RbdImageName:   ri.RbdImageName,
+		Monitors:       ri.Monitors,
+		Pool:           ri.Pool,
+		RadosNamespace: ri.RadosNamespace,
+		ClusterID:      ri.ClusterID,
+		conn:           ri.conn.Copy(),
+	}
+	allocations[spec] = struct{}{}
+	go func() {
+		defer func() {
+			allocationsLock.Lock()
+			delete(allocations, spec)
+			allocationsLock.Unlock()
+		}()
+		defer image.Destroy()
+
+		log.DebugLog(ctx, "rbd: allocating image %s from offset %d", image, offset)
+		aErr := image.allocate(ctx, offset)
+		if aErr != nil {
+			log.ErrorLog(ctx, "failed to allocate image %s: %v", image, aErr)
+
+			return
+		}
+		log.DebugLog(ctx, "rbd: allocated image %s", image)
+	}()
+
+	return fmt.Errorf("%w: image %s", ErrAllocationInProgress, ri)
+}
+
+// allocate writes zeros to the image from offset up to its size, and records
+// the progress in the image metadata. The image is marked as thick-provisioned
+// when the allocation completes.
+func (ri *rbdImage) allocate(ctx context.Context, offset uint64) error {
+	// WriteSame of zeros would otherwise discard the range
+	err := ri.conn.DisableDiscardOnZeroedWriteSame()
+	if err != nil {
+		return err
+	}
+
+	image, err := ri.open()
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	st, err := image.Stat()
+	if err != nil {
+		return err
+	}
+	sc, err := image.GetStripeCount()
+	if err != nil {
+		return err
+	}
+	// blockSize is the stripe-period, the object size multiplied by the
+	// stripe count, WriteSame is most efficient with a block of this size
+	blockSize := sc * (1 << st.Order)
+	zeroBlock := make([]byte, blockSize)
+
+	for {
+		length, same := nextZeroFill(offset, st.Size, blockSize)
+		if length == 0 {
+			break
+		}
+		if same {
+			_, err = image.WriteSame(offset, length, zeroBlock, rados.OpFlagNone)
+		} else {
+			_, err = image.WriteAt(zeroBlock[:length], int64(offset))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to zero-fill %d bytes at offset %d: %w", length, offset, err)
+		}
+		offset += length
+
+		err = image.SetMetadata(thickProgressMetaKey, strconv.FormatUint(offset, 10))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = image.Flush()
+	if err != nil {
+		return err
+	}
+	err = image.SetMetadata(thickProvisionMetaKey, "true")
+	if err != nil {
+		return err
+	}
+
+	return image.RemoveMetadata(thickProgressMetaKey)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"testing"
+)
+
+func TestNextZeroFill(t *testing.T) {
+	t.Parallel()
+	const (
+		mib       = 1024 * 1024
+		blockSize = 4 * mib
+	)
+	tests := []struct {
+		name       string
+		offset     uint64
+		size       uint64
+		wantLength uint64
+		wantSame   bool
+	}{
+		{
+			name:       "small image",
+			offset:     0,
+			size:       100 * mib,
+			wantLength: 100 * mib,
+			wantSame:   true,
+		},
+		{
+			name:       "limited to thickWriteSize",
+			offset:     0,
+			size:       10 * oneGB,
+			wantLength: oneGB,
+			wantSame:   true,
+		},
+		{
+			name:       "resumed",
+			offset:     9 * oneGB,
+			size:       10 * oneGB,
+			wantLength: oneGB,
+			wantSame:   true,
+		},
+		{
+			name:       "rounded down to the block size",
+			offset:     0,
+			size:       10*mib + 512,
+			wantLength: 8 * mib,
+			wantSame:   true,
+		},
+		{
+			name:       "partial block",
+			offset:     8 * mib,
+			size:       10*mib + 512,
+			wantLength: 2*mib + 512,
+			wantSame:   false,
+		},
+		{
+			name:       "done",
+			offset:     10 * mib,
+			size:       10 * mib,
+			wantLength: 0,
+			wantSame:   false,
+		},
+		{
+			name:       "offset beyond size",
+			offset:     20 * mib,
+			size:       10 * mib,
+			wantLength: 0,
+			wantSame:   false,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			length, same := nextZeroFill(ts.offset, ts.size, blockSize)
+			if length != ts.wantLength || same != ts.wantSame {
+				t.Errorf("nextZeroFill() = %d, %t, want %d, %t", length, same, ts.wantLength, ts.wantSame)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util"
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+const (
+	// trashExpiryMetaKey is the image metadata key that stores the
+	// trashExpiry StorageClass parameter of the volume.
+	trashExpiryMetaKey = "rbd.csi.ceph.com/trash-expiry"
+
+	// trashVolNameMetaKey and trashJournalPoolMetaKey are the image metadata
+	// keys that store the request name and the journal pool of a volume that
+	// is kept in the trash, they are needed to restore the journal.
+	trashVolNameMetaKey     = "rbd.csi.ceph.com/csi.volname"
+	trashJournalPoolMetaKey = "rbd.csi.ceph.com/csi.journalpool"
+)
+
+// parseTrashExpiry parses the trashExpiry parameter, a duration that is not
+// negative.
+func parseTrashExpiry(value string) (time.Duration, error) {
+	expiry, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid trashExpiry %q: %w", value, err)
+	}
+	if expiry < 0 {
+		return 0, fmt.Errorf("invalid trashExpiry %q: must not be negative", value)
+	}
+
+	return expiry, nil
+}
+
+// storeTrashExpiry records the trash expiry of the volume in the image
+// metadata, so that it is known when the volume is deleted. A trash expiry of
+// zero is stored too, it overrides the default of the driver. Without a trash
+// expiry, the one that a clone inherited from its parent image is removed.
+func (ri *rbdImage) storeTrashExpiry() error {
+	if ri.trashExpirySet {
+		return ri.SetMetadata(trashExpiryMetaKey, ri.TrashExpiry.String())
+	}
+
+	err := ri.RemoveMetadata(trashExpiryMetaKey)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// getTrashExpiry returns the time the image is kept in the trash when it is
+// deleted. The expiry stored in the image metadata takes precedence over
+// ri.TrashExpiry.
+func (ri *rbdImage) getTrashExpiry() (time.Duration, error) {
+	value, err := ri.GetMetadata(trashExpiryMetaKey)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return ri.TrashExpiry, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get the trash expiry of image %s: %w", ri, err)
+	}
+
+	return parseTrashExpiry(value)
+}
+
+// isDeferredInTrash returns true if the image in the trash may not be removed
+// yet, as its deferment period has not ended.
+func isDeferredInTrash(info librbd.TrashInfo, now time.Time) bool {
+	return now.Before(info.DefermentEndTime)
+}
+
+// storeRestoreMetadata records the request name and the journal pool of the
+// volume in the image metadata, before the image is kept in the trash.
+// Encrypted volumes can not be restored, as their passphrase is removed when
+// the volume is deleted, nothing is stored for them.
+func (ri *rbdImage) storeRestoreMetadata() error {
+	if ri.isBlockEncrypted() || ri.isFileEncrypted() {
+		return nil
+	}
+
+	err := ri.SetMetadata(trashVolNameMetaKey, ri.RequestName)
+	if err != nil {
+		return err
+	}
+
+	return ri.SetMetadata(trashJournalPoolMetaKey, ri.JournalPool)
+}
+
+// findTrashedImage returns the image of the volume with the UUID from the
+// images in the trash. The name of the image is the name prefix of the
+// volume followed by the UUID.
+func findTrashedImage(trashList []librbd.TrashInfo, volUUID string) (*librbd.TrashInfo, error) {
+	var found *librbd.TrashInfo
+	for i := range trashList {
+		if !strings.HasSuffix(trashList[i].Name, volUUID) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("found images %q and %q in trash for volume UUID %s",
+				found.Name, trashList[i].Name, volUUID)
+		}
+		found = &trashList[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: no image in trash for volume UUID %s", ErrImageNotFound, volUUID)
+	}
+
+	return found, nil
+}
+
+// RestoreTrashedVolume restores the image of the volume with volumeID from
+// the trash of pool and radosNamespace, and re-creates the journal of the
+// volume so that volumeID can be used again, for example by a statically
+// provisioned PersistentVolume. Only the images of volumes that were deleted
+// with a trashExpiry can be restored.
+func RestoreTrashedVolume(
+	ctx context.Context,
+	volumeID, pool, radosNamespace string,
+	cr *util.Credentials,
+) error {
+	var vi util.CSIIdentifier
+	err := vi.DecomposeCSIID(volumeID)
+	if err != nil {
+		return fmt.Errorf("%w: error decoding volume ID (%s) (%s)", ErrInvalidVolID, err, volumeID)
+	}
+
+	rbdVol := &rbdVolume{}
+	rbdVol.VolID = volumeID
+	rbdVol.ClusterID = vi.ClusterID
+	rbdVol.ReservedID = vi.ObjectUUID
+	rbdVol.Pool = pool
+	rbdVol.RadosNamespace = radosNamespace
+
+	rbdVol.Monitors, _, err = util.GetMonsAndClusterID(ctx, rbdVol.ClusterID, false)
+	if err != nil {
+		return err
+	}
+
+	// the volume ID only resolves with the pool and namespace it encodes
+	namespace, err := util.GetRadosNamespace(util.CsiConfigFile, rbdVol.ClusterID)
+	if err != nil {
+		return err
+	}
+	if namespace != radosNamespace {
+		return fmt.Errorf("volume ID %s belongs to radosNamespace %q of cluster %q, not %q",
+			volumeID, namespace, rbdVol.ClusterID, radosNamespace)
+	}
+	poolID, err := util.GetPoolID(rbdVol.Monitors, cr, pool)
+	if err != nil {
+		return err
+	}
+	if poolID != vi.LocationID {
+		return fmt.Errorf("volume ID %s belongs to pool ID %d, not to pool %q (%d)",
+			volumeID, vi.LocationID, pool, poolID)
+	}
+
+	err = rbdVol.Connect(cr)
+	if err != nil {
+		return err
+	}
+	defer rbdVol.Destroy()
+
+	err = rbdVol.openIoctx()
+	if err != nil {
+		return err
+	}
+
+	trashList, err := librbd.GetTrashList(rbdVol.ioctx)
+	if err != nil {
+		return fmt.Errorf("failed to list images in trash: %w", err)
+	}
+	info, err := findTrashedImage(trashList, rbdVol.ReservedID)
+	if err != nil {
+		return err
+	}
+	rbdVol.RbdImageName = info.Name
+	rbdVol.ImageID = info.Id
+
+	// a new image may have been created with the same name
+	err = rbdVol.getImageInfo()
+	if err == nil {
+		return fmt.Errorf("%w: image %s exists, not restoring image with id %q from trash",
+			ErrVolNameConflict, rbdVol, rbdVol.ImageID)
+	} else if !errors.Is(err, ErrImageNotFound) {
+		return err
+	}
+
+	err = librbd.TrashRestore(rbdVol.ioctx, info.Id, info.Name)
+	if err != nil {
+		return fmt.Errorf("failed to restore image %s with id %q from trash: %w", rbdVol, info.Id, err)
+	}
+	log.DefaultLog("restored image %s with id %q from trash", rbdVol, info.Id)
+
+	err = rbdVol.reserveRestoredVolume(ctx, cr)
+	if err != nil {
+		// keep the image in the trash for the rest of its deferment period
+		remaining := time.Until(info.DefermentEndTime)
+		if remaining < 0 {
+			remaining = 0
+		}
+		tErr := librbd.GetImage(rbdVol.ioctx, info.Name).Trash(remaining)
+		if tErr != nil {
+			log.ErrorLog(ctx, "failed to move image %s back to trash: %v", rbdVol, tErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// reserveRestoredVolume re-creates the journal of a volume whose image was
+// restored from the trash, with the request name and journal pool stored in
+// the image metadata.
+func (rv *rbdVolume) reserveRestoredVolume(ctx context.Context, cr *util.Credentials) error {
+	var err error
+	rv.RequestName, err = rv.GetMetadata(trashVolNameMetaKey)
+	if errors.Is(err, librbd.ErrNotFound) {
+		return fmt.Errorf("image %s has no %s metadata, only volumes deleted with a trashExpiry "+
+			"and without encryption can be restored", rv, trashVolNameMetaKey)
+	} else if err != nil {
+		return err
+	}
+	rv.JournalPool, err = rv.GetMetadata(trashJournalPoolMetaKey)
+	if err != nil {
+		return fmt.Errorf("failed to get the journal pool of image %s: %w", rv, err)
+	}
+	rv.NamePrefix = strings.TrimSuffix(rv.RbdImageName, rv.ReservedID)
+
+	j, err := volJournal.Connect(rv.Monitors, rv.RadosNamespace, cr)
+	if err != nil {
+		return err
+	}
+	defer j.Destroy()
+
+	imageData, err := j.CheckReservation(ctx, rv.JournalPool, rv.RequestName, rv.NamePrefix, "", "",
+		util.EncryptionTypeNone)
+	if err != nil {
+		return err
+	}
+	if imageData != nil {
+		return fmt.Errorf("%w: request name %q is reserved for volume UUID %s",
+			ErrVolNameConflict, rv.RequestName, imageData.ImageUUID)
+	}
+
+	journalPoolID, imagePoolID, err := util.GetPoolIDs(ctx, rv.Monitors, rv.JournalPool, rv.Pool, cr)
+	if err != nil {
+		return err
+	}
+	_, _, err = j.ReserveName(ctx, rv.JournalPool, journalPoolID, rv.Pool, imagePoolID,
+		rv.RequestName, rv.NamePrefix, "", "", rv.ReservedID, "", "", util.EncryptionTypeNone)
+	if err != nil {
+		return err
+	}
+
+	err = j.StoreImageID(ctx, rv.Pool, rv.ReservedID, rv.ImageID)
+	if err != nil {
+		uErr := j.UndoReservation(ctx, rv.JournalPool, rv.Pool, rv.RbdImageName, rv.RequestName)
+		if uErr != nil {
+			log.WarningLog(ctx, "failed undoing reservation of volume: %s (%s)", rv.RequestName, uErr)
+		}
+
+		return err
+	}
+	log.DefaultLog("re-created journal of volume %s with request name %q", rv.VolID, rv.RequestName)
+
+	return nil
+}
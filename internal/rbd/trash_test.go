@@ -0,0 +1,185 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	librbd "github.com/ceph/go-ceph/rbd"
+)
+
+func TestParseTrashExpiry(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name:  "hours",
+			value: "72h",
+			want:  72 * time.Hour,
+		},
+		{
+			name:  "zero",
+			value: "0",
+			want:  0,
+		},
+		{
+			name:    "negative",
+			value:   "-1h",
+			wantErr: true,
+		},
+		{
+			name:    "no unit",
+			value:   "10",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			value:   "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseTrashExpiry(ts.value)
+			if (err != nil) != ts.wantErr {
+				t.Errorf("parseTrashExpiry() error = %v, wantErr %v", err, ts.wantErr)
+
+				return
+			}
+			if got != ts.want {
+				t.Errorf("parseTrashExpiry() = %v, want %v", got, ts.want)
+			}
+		})
+	}
+}
+
+func TestIsDeferredInTrash(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	tests := []struct {
+		name string
+		info librbd.TrashInfo
+		want bool
+	}{
+		{
+			name: "removed right away",
+			info: librbd.TrashInfo{DeletionTime: now, DefermentEndTime: now},
+			want: false,
+		},
+		{
+			name: "deferred",
+			info: librbd.TrashInfo{DeletionTime: now, DefermentEndTime: now.Add(time.Hour)},
+			want: true,
+		},
+		{
+			name: "expired",
+			info: librbd.TrashInfo{DeletionTime: now.Add(-2 * time.Hour), DefermentEndTime: now.Add(-time.Hour)},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			if got := isDeferredInTrash(ts.info, now); got != ts.want {
+				t.Errorf("isDeferredInTrash() = %v, want %v", got, ts.want)
+			}
+		})
+	}
+}
+
+func TestFindTrashedImage(t *testing.T) {
+	t.Parallel()
+	const volUUID = "c6b8a5f0-2d4e-11ee-9d1a-0242ac110002"
+	trashList := []librbd.TrashInfo{
+		{Id: "1", Name: "csi-vol-0a1b2c3d-2d4e-11ee-9d1a-0242ac110002"},
+		{Id: "2", Name: "csi-vol-" + volUUID + "-temp"},
+		{Id: "3", Name: "csi-vol-" + volUUID},
+		{Id: "4", Name: "user-image"},
+	}
+	tests := []struct {
+		name      string
+		trashList []librbd.TrashInfo
+		volUUID   string
+		wantID    string
+		wantErr   error
+	}{
+		{
+			name:      "found",
+			trashList: trashList,
+			volUUID:   volUUID,
+			wantID:    "3",
+		},
+		{
+			name:      "custom name prefix",
+			trashList: []librbd.TrashInfo{{Id: "5", Name: "tenant-a-" + volUUID}},
+			volUUID:   volUUID,
+			wantID:    "5",
+		},
+		{
+			name:      "not in trash",
+			trashList: trashList,
+			volUUID:   "f1e2d3c4-2d4e-11ee-9d1a-0242ac110002",
+			wantErr:   ErrImageNotFound,
+		},
+		{
+			name:      "empty trash",
+			trashList: nil,
+			volUUID:   volUUID,
+			wantErr:   ErrImageNotFound,
+		},
+		{
+			name: "multiple images",
+			trashList: []librbd.TrashInfo{
+				{Id: "3", Name: "csi-vol-" + volUUID},
+				{Id: "6", Name: "tenant-a-" + volUUID},
+			},
+			volUUID: volUUID,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := findTrashedImage(ts.trashList, ts.volUUID)
+			if ts.wantID == "" {
+				if err == nil {
+					t.Fatalf("findTrashedImage() = %v, want error", got)
+				}
+				if ts.wantErr != nil && !errors.Is(err, ts.wantErr) {
+					t.Errorf("findTrashedImage() error = %v, want %v", err, ts.wantErr)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("findTrashedImage() error = %v", err)
+			}
+			if got.Id != ts.wantID {
+				t.Errorf("findTrashedImage() = %q, want %q", got.Id, ts.wantID)
+			}
+		})
+	}
+}
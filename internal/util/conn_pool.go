@@ -17,14 +17,42 @@ limitations under the License.
 package util
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/ceph/go-ceph/rados"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// reasons for evicting a connection, used as metrics label
+	evictionReasonIdle    = "idle"
+	evictionReasonMaxSize = "max_size"
+)
+
+var (
+	connPoolConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "csi",
+		Subsystem: "connpool",
+		Name:      "connections",
+		Help:      "Number of connections to Ceph clusters in the connection pool",
+	})
+
+	connPoolEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "csi",
+		Subsystem: "connpool",
+		Name:      "evictions_total",
+		Help:      "Number of connections evicted from the connection pool, by reason (idle or max_size)",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(connPoolConnections, connPoolEvictions)
+}
+
 type connEntry struct {
 	conn     *rados.Conn
 	lastUsed time.Time
@@ -37,6 +65,10 @@ type ConnPool struct {
 	interval time.Duration
 	// timeout for a connEntry to get garbage collected
 	expiry time.Duration
+	// maximum number of connEntry's in the pool, 0 for no limit
+	maxSize int
+	// now returns the current time, replaced by tests
+	now func() time.Time
 	// Timer used to schedule calls to the garbage collector
 	timer *time.Timer
 	// Mutex for loading and touching connEntry's from the conns Map
@@ -53,29 +85,82 @@ func NewConnPool(interval, expiry time.Duration) *ConnPool {
 		expiry:   expiry,
 		lock:     &sync.RWMutex{},
 		conns:    make(map[string]*connEntry),
+		now:      time.Now,
 	}
 	cp.timer = time.AfterFunc(interval, cp.gc)
 
 	return &cp
 }
 
+// Configure sets the time after which unused connections are destroyed, and
+// the maximum number of connections in the pool. A zero idleTimeout keeps the
+// current timeout, a zero maxSize does not limit the number of connections.
+func (cp *ConnPool) Configure(idleTimeout time.Duration, maxSize int) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if idleTimeout > 0 {
+		cp.expiry = idleTimeout
+		// check for idle connections at least as often as they expire
+		if idleTimeout < cp.interval {
+			cp.interval = idleTimeout
+			cp.timer.Reset(cp.interval)
+		}
+	}
+	cp.maxSize = maxSize
+}
+
 // loop through all cp.conns and destroy objects that have not been used for cp.expiry.
 func (cp *ConnPool) gc() {
 	cp.lock.Lock()
 	defer cp.lock.Unlock()
 
-	now := time.Now()
+	now := cp.now()
 	for key, ce := range cp.conns {
 		if ce.users == 0 && (now.Sub(ce.lastUsed)) > cp.expiry {
 			ce.destroy()
 			delete(cp.conns, key)
+			connPoolEvictions.WithLabelValues(evictionReasonIdle).Inc()
 		}
 	}
+	connPoolConnections.Set(float64(len(cp.conns)))
 
 	// schedule the next gc() run
 	cp.timer.Reset(cp.interval)
 }
 
+// evict destroys the least recently used connEntry's without users, until
+// there is room for a new connection in the pool. Connections that are in use
+// are never evicted, so the pool can grow beyond the maximum size while they
+// are.
+//
+// Requires: locked cp.lock.
+func (cp *ConnPool) evict() {
+	for cp.maxSize != 0 && len(cp.conns) >= cp.maxSize {
+		var (
+			lruKey   string
+			lruEntry *connEntry
+		)
+		for key, ce := range cp.conns {
+			if ce.users != 0 {
+				continue
+			}
+			if lruEntry == nil || ce.lastUsed.Before(lruEntry.lastUsed) {
+				lruKey = key
+				lruEntry = ce
+			}
+		}
+		if lruEntry == nil {
+			return
+		}
+
+		lruEntry.destroy()
+		delete(cp.conns, lruKey)
+		connPoolEvictions.WithLabelValues(evictionReasonMaxSize).Inc()
+		connPoolConnections.Set(float64(len(cp.conns)))
+	}
+}
+
 // Destroy stops the garbage collector and destroys all connections in the pool.
 func (cp *ConnPool) Destroy() {
 	cp.timer.Stop()
@@ -92,6 +177,7 @@ func (cp *ConnPool) Destroy() {
 		ce.destroy()
 		delete(cp.conns, key)
 	}
+	connPoolConnections.Set(float64(len(cp.conns)))
 }
 
 func (cp *ConnPool) generateUniqueKey(monitors, user, keyfile string) (string, error) {
@@ -110,7 +196,7 @@ func (cp *ConnPool) generateUniqueKey(monitors, user, keyfile string) (string, e
 func (cp *ConnPool) getConn(unique string) *rados.Conn {
 	ce, exists := cp.conns[unique]
 	if exists {
-		ce.get()
+		ce.get(cp.now())
 
 		return ce.conn
 	}
@@ -134,34 +220,32 @@ func (cp *ConnPool) Get(monitors, user, keyfile string) (*rados.Conn, error) {
 		return conn, nil
 	}
 
-	// construct and connect a new rados.Conn
-	args := []string{"-m", monitors, "--keyfile=" + keyfile}
-	conn, err = rados.NewConnWithUser(user)
-	if err != nil {
-		return nil, fmt.Errorf("creating a new connection failed: %w", err)
-	}
-	err = conn.ParseCmdLineArgs(args)
-	if err != nil {
-		return nil, fmt.Errorf("parsing cmdline args (%v) failed: %w", args, err)
-	}
+	// make room for the new connection
+	cp.lock.Lock()
+	cp.evict()
+	cp.lock.Unlock()
 
-	if err = conn.ReadConfigFile(CephConfigPath); err != nil {
-		return nil, fmt.Errorf("failed to read config file %q: %w", CephConfigPath, err)
-	}
+	// construct and connect a new rados.Conn, a failed connection can not be
+	// reused, so a new one is constructed for every attempt
+	err = RetryRadosOperation(context.TODO(), "connect", func() error {
+		var connErr error
+		conn, connErr = newConn(monitors, user, keyfile)
 
-	err = conn.Connect()
+		return connErr
+	})
 	if err != nil {
-		return nil, fmt.Errorf("connecting failed: %w", err)
+		return nil, err
 	}
 
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
 	ce := &connEntry{
 		conn:     conn,
-		lastUsed: time.Now(),
+		lastUsed: cp.now(),
 		users:    1,
 	}
 
-	cp.lock.Lock()
-	defer cp.lock.Unlock()
 	if oldConn := cp.getConn(unique); oldConn != nil {
 		// there was a race, oldConn already exists
 		ce.destroy()
@@ -170,6 +254,37 @@ func (cp *ConnPool) Get(monitors, user, keyfile string) (*rados.Conn, error) {
 	}
 	// this really is a new connection, add it to the map
 	cp.conns[unique] = ce
+	connPoolConnections.Set(float64(len(cp.conns)))
+
+	return conn, nil
+}
+
+// newConn constructs and connects a new rados.Conn.
+func newConn(monitors, user, keyfile string) (*rados.Conn, error) {
+	args := []string{"-m", monitors, "--keyfile=" + keyfile}
+	conn, err := rados.NewConnWithUser(user)
+	if err != nil {
+		return nil, fmt.Errorf("creating a new connection failed: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			conn.Shutdown()
+		}
+	}()
+
+	err = conn.ParseCmdLineArgs(args)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cmdline args (%v) failed: %w", args, err)
+	}
+
+	if err = conn.ReadConfigFile(CephConfigPath); err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %w", CephConfigPath, err)
+	}
+
+	err = conn.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("connecting failed: %w", err)
+	}
 
 	return conn, nil
 }
@@ -182,7 +297,7 @@ func (cp *ConnPool) Copy(conn *rados.Conn) *rados.Conn {
 
 	for _, ce := range cp.conns {
 		if ce.conn == conn {
-			ce.get()
+			ce.get(cp.now())
 
 			return ce.conn
 		}
@@ -208,8 +323,8 @@ func (cp *ConnPool) Put(conn *rados.Conn) {
 
 // Add a reference to the connEntry.
 // /!\ Only call this while holding the ConnPool.lock.
-func (ce *connEntry) get() {
-	ce.lastUsed = time.Now()
+func (ce *connEntry) get(now time.Time) {
+	ce.lastUsed = now
 	ce.users++
 }
 
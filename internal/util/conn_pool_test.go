@@ -47,20 +47,25 @@ func (cp *ConnPool) fakeGet(monitors, user, keyfile string) (*rados.Conn, string
 		return conn, unique, nil
 	}
 
+	cp.lock.Lock()
+	cp.evict()
+	cp.lock.Unlock()
+
 	// cp.Get() creates and connects a rados.Conn here
 	conn, err = rados.NewConn()
 	if err != nil {
 		return nil, "", err
 	}
 
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
 	ce := &connEntry{
 		conn:     conn,
-		lastUsed: time.Now(),
+		lastUsed: cp.now(),
 		users:    1,
 	}
 
-	cp.lock.Lock()
-	defer cp.lock.Unlock()
 	if oldConn := cp.getConn(unique); oldConn != nil {
 		// there was a race, oldConn already exists
 		ce.destroy()
@@ -177,3 +182,118 @@ func TestConnPool(t *testing.T) {
 		}
 	})
 }
+
+// fakeClock is used as ConnPool.now, and only moves when advanced.
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.now = fc.now.Add(d)
+}
+
+// nolint:paralleltest // these tests cannot run in parallel
+func TestConnPoolReaper(t *testing.T) {
+	cp := NewConnPool(interval, expiry)
+	defer cp.Destroy()
+	clock := &fakeClock{now: time.Now()}
+	cp.now = clock.Now
+
+	keyfile := "/tmp/conn_pool_reaper.keyfile"
+	err := os.WriteFile(keyfile, []byte("the-key"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to create keyfile: %v", err)
+	}
+	defer os.Remove(keyfile)
+
+	idle, _, err := cp.fakeGet("monitors", "idle", keyfile)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	_, active, err := cp.fakeGet("monitors", "active", keyfile)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+
+	cp.Put(idle)
+	cp.gc()
+	if len(cp.conns) != 2 {
+		t.Errorf("gc() should not have removed a connection: %v", len(cp.conns))
+	}
+
+	clock.advance(expiry + time.Second)
+	cp.gc()
+	if len(cp.conns) != 1 {
+		t.Errorf("gc() should have removed the idle connection: %v", len(cp.conns))
+	}
+	if _, exists := cp.conns[active]; !exists {
+		t.Error("gc() removed the active connection")
+	}
+
+	cp.Put(cp.conns[active].conn)
+}
+
+// nolint:paralleltest // these tests cannot run in parallel
+func TestConnPoolMaxSize(t *testing.T) {
+	cp := NewConnPool(interval, expiry)
+	defer cp.Destroy()
+	clock := &fakeClock{now: time.Now()}
+	cp.now = clock.Now
+	cp.Configure(0, 2)
+
+	keyfile := "/tmp/conn_pool_max_size.keyfile"
+	err := os.WriteFile(keyfile, []byte("the-key"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to create keyfile: %v", err)
+	}
+	defer os.Remove(keyfile)
+
+	first, firstKey, err := cp.fakeGet("monitors", "first", keyfile)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	clock.advance(time.Second)
+	second, secondKey, err := cp.fakeGet("monitors", "second", keyfile)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+
+	// the pool is full and all connections are in use, none is evicted
+	clock.advance(time.Second)
+	third, thirdKey, err := cp.fakeGet("monitors", "third", keyfile)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	if len(cp.conns) != 3 {
+		t.Errorf("active connections should not be evicted: %v", len(cp.conns))
+	}
+
+	cp.Put(first)
+	cp.Put(second)
+	cp.Put(third)
+
+	// the two least recently used connections are evicted to make room
+	_, fourthKey, err := cp.fakeGet("monitors", "fourth", keyfile)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	if len(cp.conns) != 2 {
+		t.Errorf("the pool should not contain more than 2 connections: %v", len(cp.conns))
+	}
+	for _, key := range []string{firstKey, secondKey} {
+		if _, exists := cp.conns[key]; exists {
+			t.Errorf("connection %q should have been evicted", key)
+		}
+	}
+	for _, key := range []string{thirdKey, fourthKey} {
+		if _, exists := cp.conns[key]; !exists {
+			t.Errorf("connection %q should not have been evicted", key)
+		}
+	}
+
+	cp.Put(cp.conns[fourthKey].conn)
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package util
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -47,6 +48,18 @@ var (
 	connPool   = NewConnPool(cpInterval, cpExpiry)
 )
 
+// ConfigureConnPool sets the idle timeout and the maximum size of the pool
+// with the connections to the Ceph clusters.
+func ConfigureConnPool(idleTimeout time.Duration, maxSize int) {
+	connPool.Configure(idleTimeout, maxSize)
+}
+
+// DestroyConnPool closes all connections to the Ceph clusters. It may only be
+// called when no operations are running anymore, like on shutdown.
+func DestroyConnPool() {
+	connPool.Destroy()
+}
+
 // rbdVol.Connect() connects to the Ceph cluster and sets rbdVol.conn for further usage.
 func (cc *ClusterConnection) Connect(monitors string, cr *Credentials) error {
 	if cc.conn == nil {
@@ -87,6 +100,28 @@ func (cc *ClusterConnection) Copy() *ClusterConnection {
 	return &c
 }
 
+// DisableDiscardOnZeroedWriteSame sets the `rbd_discard_on_zeroed_write_same`
+// option of librbd to false. By default librbd discards the range of a
+// WriteSame call with a buffer of zeros, instead of writing the zeros, which
+// would undo the allocation of thick-provisioned images. The option is read
+// when an image is opened, so it must be set before.
+func (cc *ClusterConnection) DisableDiscardOnZeroedWriteSame() error {
+	if cc.discardOnZeroedWriteSameDisabled {
+		return nil
+	}
+	if cc.conn == nil {
+		return errors.New("cluster is not connected yet")
+	}
+
+	err := cc.conn.SetConfigOption("rbd_discard_on_zeroed_write_same", "false")
+	if err != nil {
+		return fmt.Errorf("failed to disable rbd_discard_on_zeroed_write_same: %w", err)
+	}
+	cc.discardOnZeroedWriteSameDisabled = true
+
+	return nil
+}
+
 func (cc *ClusterConnection) GetIoctx(pool string) (*rados.IOContext, error) {
 	if cc.conn == nil {
 		return nil, errors.New("cluster is not connected yet")
@@ -151,3 +186,18 @@ func (cc *ClusterConnection) GetNFSAdmin() (*nfs.Admin, error) {
 
 	return nfs.NewFromConn(cc.conn), nil
 }
+
+// MgrCommand encodes the command as JSON and sends it to the Ceph Manager. It
+// returns the response body and the status string of the command.
+func (cc *ClusterConnection) MgrCommand(cmd interface{}) ([]byte, string, error) {
+	if cc.conn == nil {
+		return nil, "", errors.New("cluster is not connected yet")
+	}
+
+	buf, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal command: %w", err)
+	}
+
+	return cc.conn.MgrCommand([][]byte{buf})
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package util
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/ceph/ceph-csi/internal/util/log"
@@ -75,3 +77,27 @@ func getCrushLocationMap(crushLocationLabels string, nodeLabels map[string]strin
 
 	return crushLocationMap
 }
+
+// ConstructReadAffinityMapOption constructs the kernel options that enable
+// read affinity from the crush location map of the node, they are used as
+// rbd map options and CephFS mount options. The crush location types are
+// sorted so that the options are the same every time. An empty string is
+// returned when the map is empty.
+func ConstructReadAffinityMapOption(crushLocationMap map[string]string) string {
+	if len(crushLocationMap) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(crushLocationMap))
+	for key := range crushLocationMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	locations := make([]string, 0, len(keys))
+	for _, key := range keys {
+		locations = append(locations, fmt.Sprintf("%s:%s", key, crushLocationMap[key]))
+	}
+
+	return "read_from_replica=localize,crush_location=" + strings.Join(locations, "|")
+}
@@ -111,3 +111,46 @@ func Test_getCrushLocationMap(t *testing.T) {
 		})
 	}
 }
+
+func TestConstructReadAffinityMapOption(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name             string
+		crushLocationMap map[string]string
+		want             string
+	}{
+		{
+			name:             "nil crushLocationMap",
+			crushLocationMap: nil,
+			want:             "",
+		},
+		{
+			name:             "empty crushLocationMap",
+			crushLocationMap: map[string]string{},
+			want:             "",
+		},
+		{
+			name: "single entry in crushLocationMap",
+			crushLocationMap: map[string]string{
+				"region": "east",
+			},
+			want: "read_from_replica=localize,crush_location=region:east",
+		},
+		{
+			name: "multiple entries in crushLocationMap",
+			crushLocationMap: map[string]string{
+				"zone":   "east-1",
+				"region": "east",
+				"host":   "node-1",
+			},
+			want: "read_from_replica=localize,crush_location=host:node-1|region:east|zone:east-1",
+		},
+	}
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, ConstructReadAffinityMapOption(tc.crushLocationMap))
+		})
+	}
+}
@@ -268,9 +268,16 @@ func EncryptVolume(ctx context.Context, devicePath, passphrase string) error {
 }
 
 // OpenEncryptedVolume opens volume so that it can be used by the client.
-func OpenEncryptedVolume(ctx context.Context, devicePath, mapperFile, passphrase string) error {
-	log.DebugLog(ctx, "Opening device %q with LUKS on %q", devicePath, mapperFile)
-	_, stdErr, err := LuksOpen(devicePath, mapperFile, passphrase)
+// Discards are passed to the device when allowDiscards is set, and the
+// mapping is read-only when readOnly is set.
+func OpenEncryptedVolume(
+	ctx context.Context,
+	devicePath, mapperFile, passphrase string,
+	allowDiscards, readOnly bool,
+) error {
+	log.DebugLog(ctx, "Opening device %q with LUKS on %q (allow discards: %t, read-only: %t)",
+		devicePath, mapperFile, allowDiscards, readOnly)
+	_, stdErr, err := LuksOpen(devicePath, mapperFile, passphrase, allowDiscards, readOnly)
 	if err != nil || stdErr != "" {
 		log.ErrorLog(ctx, "failed to open device %q (%v): %s", devicePath, err, stdErr)
 	}
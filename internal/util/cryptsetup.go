@@ -18,19 +18,118 @@ package util
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Limit memory used by Argon2i PBKDF to 32 MiB.
+	cryptsetupPBKDFMemoryLimit = 32 << 10 // 32768 KiB
+
+	// cryptsetupBusyRetries is the number of times a cryptsetup command is
+	// retried when the device is busy, cryptsetupBusyDelay is the delay
+	// before the first retry, it doubles (plus jitter) for every next retry.
+	// No retry is started after cryptsetupBusyTimeout.
+	cryptsetupBusyRetries = 5
+	cryptsetupBusyDelay   = 200 * time.Millisecond
+	cryptsetupBusyTimeout = time.Minute
 )
 
-// Limit memory used by Argon2i PBKDF to 32 MiB.
-const cryptsetupPBKDFMemoryLimit = 32 << 10 // 32768 KiB
+var (
+	cryptsetupCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "csi",
+		Subsystem: "cryptsetup",
+		Name:      "command_duration_seconds",
+		Help:      "Duration of cryptsetup commands in seconds",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"subcommand"})
+
+	cryptsetupCommandFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "csi",
+		Subsystem: "cryptsetup",
+		Name:      "command_failures_total",
+		Help:      "Number of failed cryptsetup commands",
+	}, []string{"subcommand"})
+)
+
+func init() {
+	prometheus.MustRegister(cryptsetupCommandDuration, cryptsetupCommandFailures)
+}
+
+// stdinKeyFile is passed as key file to cryptsetup, to read the passphrase
+// from stdin.
+const stdinKeyFile = "/dev/stdin"
+
+// DefaultMinPassphraseLength is the minimal length in bytes of the passphrase
+// that a device is formatted with, unless configured otherwise.
+const DefaultMinPassphraseLength = 8
 
-// LuksFormat sets up volume as an encrypted LUKS partition.
+// minPassphraseLength is the minimal length in bytes of the passphrase that a
+// device is formatted with. A shorter passphrase, like an empty one returned
+// by a misconfigured KMS, would encrypt the device with a weak key.
+var minPassphraseLength = DefaultMinPassphraseLength
+
+// ConfigureMinPassphraseLength sets the minimal length in bytes of the
+// passphrase that a device is formatted with.
+func ConfigureMinPassphraseLength(length int) {
+	minPassphraseLength = length
+}
+
+// checkPassphraseLength returns ErrWeakPassphrase when a passphrase of length
+// bytes is shorter than minLength.
+func checkPassphraseLength(length int64, minLength int) error {
+	if length < int64(minLength) {
+		return fmt.Errorf("%w: the passphrase has %d bytes, at least %d are required",
+			ErrWeakPassphrase, length, minLength)
+	}
+
+	return nil
+}
+
+// LuksFormat sets up volume as an encrypted LUKS partition. The passphrase is
+// rejected with ErrWeakPassphrase when it is too short.
 func LuksFormat(devicePath, passphrase string) (string, string, error) {
-	return execCryptsetupCommand(
-		&passphrase,
+	err := checkPassphraseLength(int64(len(passphrase)), minPassphraseLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	return execCryptsetupCommand(&passphrase, luksFormatArgs(devicePath, stdinKeyFile)...)
+}
+
+// FormatWithKeyFile sets up volume as an encrypted LUKS partition, with the
+// key in keyFilePath. The key file is passed to cryptsetup as is, so that the
+// key does not need to be read into memory. The file may only be accessible
+// by its owner, and is rejected with ErrWeakPassphrase when it is too short.
+func FormatWithKeyFile(devicePath, keyFilePath string) (string, string, error) {
+	err := checkKeyFile(keyFilePath)
+	if err != nil {
+		return "", "", err
+	}
+	err = checkKeyFileLength(keyFilePath, minPassphraseLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	return execCryptsetupCommand(nil, luksFormatArgs(devicePath, keyFilePath)...)
+}
+
+// luksFormatArgs returns the cryptsetup arguments to format a LUKS device
+// with the key in keyFile.
+func luksFormatArgs(devicePath, keyFile string) []string {
+	return []string{
 		"-q",
 		"luksFormat",
 		"--type",
@@ -41,14 +140,82 @@ func LuksFormat(devicePath, passphrase string) (string, string, error) {
 		strconv.Itoa(cryptsetupPBKDFMemoryLimit),
 		devicePath,
 		"-d",
-		"/dev/stdin")
+		keyFile,
+	}
 }
 
-// LuksOpen opens LUKS encrypted partition and sets up a mapping.
-func LuksOpen(devicePath, mapperFile, passphrase string) (string, string, error) {
+// LuksOpen opens LUKS encrypted partition and sets up a mapping. When
+// allowDiscards is set, discard (TRIM) requests are passed to the underlying
+// device so that freed blocks can be reclaimed. This is disabled by default
+// as discards reveal which blocks of the encrypted device are unused, which
+// may leak information about the filesystem type and usage. When readOnly is
+// set, the mapping is created read-only.
+func LuksOpen(devicePath, mapperFile, passphrase string, allowDiscards, readOnly bool) (string, string, error) {
+	args := luksOpenArgs(devicePath, mapperFile, stdinKeyFile, supportsDisableKeyring(), allowDiscards, readOnly)
+
+	return execCryptsetupCommand(&passphrase, args...)
+}
+
+// OpenWithKeyFile opens LUKS encrypted partition and sets up a mapping, with
+// the key in keyFilePath. Like for FormatWithKeyFile, the file may only be
+// accessible by its owner.
+func OpenWithKeyFile(devicePath, mapperFile, keyFilePath string) (string, string, error) {
+	err := checkKeyFile(keyFilePath)
+	if err != nil {
+		return "", "", err
+	}
+	args := luksOpenArgs(devicePath, mapperFile, keyFilePath, supportsDisableKeyring(), false, false)
+
+	return execCryptsetupCommand(nil, args...)
+}
+
+// luksOpenArgs returns the cryptsetup arguments to open a LUKS device with
+// the key in keyFile.
+func luksOpenArgs(devicePath, mapperFile, keyFile string, disableKeyring, allowDiscards, readOnly bool) []string {
+	args := []string{"luksOpen", devicePath, mapperFile}
 	// cryptsetup option --disable-keyring (introduced with cryptsetup v2.0.0)
-	// will be ignored with luks1
-	return execCryptsetupCommand(&passphrase, "luksOpen", devicePath, mapperFile, "--disable-keyring", "-d", "/dev/stdin")
+	// will be ignored with luks1, older cryptsetup versions fail on it
+	if disableKeyring {
+		args = append(args, "--disable-keyring")
+	}
+	if allowDiscards {
+		args = append(args, "--allow-discards")
+	}
+	// the device of a read-only volume is mapped read-only too
+	if readOnly {
+		args = append(args, "--readonly")
+	}
+
+	// -d is the short option of --key-file
+	return append(args, "-d", keyFile)
+}
+
+// checkKeyFile verifies that the key file is a regular file that is not
+// accessible by the group or others, mode 0600 or stricter.
+func checkKeyFile(keyFilePath string) error {
+	info, err := os.Stat(keyFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to access key file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("key file %q is not a regular file", keyFilePath)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("key file %q has mode %#o, it should be 0600", keyFilePath, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+// checkKeyFileLength returns ErrWeakPassphrase when the key in keyFilePath is
+// shorter than minLength. The whole file is used as key by cryptsetup.
+func checkKeyFileLength(keyFilePath string, minLength int) error {
+	info, err := os.Stat(keyFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to access key file: %w", err)
+	}
+
+	return checkPassphraseLength(info.Size(), minLength)
 }
 
 // LuksResize resizes LUKS encrypted partition.
@@ -66,11 +233,372 @@ func LuksStatus(mapperFile string) (string, string, error) {
 	return execCryptsetupCommand(nil, "status", mapperFile)
 }
 
+// LuksListKeySlots returns the indices of the active keyslots of the LUKS
+// device, in the order they are listed by `cryptsetup luksDump`.
+func LuksListKeySlots(devicePath string) ([]int, error) {
+	stdout, _, err := execCryptsetupCommand(nil, "luksDump", devicePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLuksKeySlots(stdout)
+}
+
+// luks2MaxTokens is the number of token slots in a LUKS2 header.
+const luks2MaxTokens = 32
+
+// SetToken imports the LUKS2 token in tokenJSON into the header of the
+// device, for example to store a reference to the KMS key in the header. The
+// JSON is validated before it is passed to cryptsetup.
+func SetToken(devicePath, tokenJSON string) error {
+	err := validateToken(tokenJSON)
+	if err != nil {
+		return err
+	}
+
+	_, stderr, err := execCryptsetupCommand(&tokenJSON, tokenImportArgs(devicePath)...)
+	if err != nil {
+		return fmt.Errorf("failed to import LUKS token to %s: %w (%s)", devicePath, err, strings.TrimSpace(stderr))
+	}
+
+	return nil
+}
+
+// GetToken returns the JSON of the LUKS2 token with tokenID from the header of
+// the device.
+func GetToken(devicePath string, tokenID int) (string, error) {
+	if tokenID < 0 || tokenID >= luks2MaxTokens {
+		return "", fmt.Errorf("invalid LUKS token id %d, it should be between 0 and %d", tokenID, luks2MaxTokens-1)
+	}
+
+	stdout, stderr, err := execCryptsetupCommand(nil, tokenExportArgs(devicePath, tokenID)...)
+	if err != nil {
+		return "", fmt.Errorf("failed to export LUKS token %d from %s: %w (%s)",
+			tokenID, devicePath, err, strings.TrimSpace(stderr))
+	}
+
+	return strings.TrimSpace(stdout), nil
+}
+
+// tokenImportArgs returns the cryptsetup arguments to import a token, the
+// JSON of the token is read from stdin.
+func tokenImportArgs(devicePath string) []string {
+	return []string{"token", "import", devicePath}
+}
+
+// tokenExportArgs returns the cryptsetup arguments to export the token with
+// tokenID to stdout.
+func tokenExportArgs(devicePath string, tokenID int) []string {
+	return []string{"token", "export", "--token-id", strconv.Itoa(tokenID), devicePath}
+}
+
+// validateToken checks that tokenJSON is a JSON object with the fields that
+// cryptsetup requires for a LUKS2 token, a "type" string and a "keyslots"
+// array of strings.
+func validateToken(tokenJSON string) error {
+	var token map[string]json.RawMessage
+	err := json.Unmarshal([]byte(tokenJSON), &token)
+	if err != nil {
+		return fmt.Errorf("invalid LUKS token JSON: %w", err)
+	}
+
+	var tokenType string
+	if err = json.Unmarshal(token["type"], &tokenType); err != nil || tokenType == "" {
+		return errors.New("invalid LUKS token JSON: missing \"type\" string")
+	}
+	var keyslots []string
+	if err = json.Unmarshal(token["keyslots"], &keyslots); err != nil || keyslots == nil {
+		return errors.New("invalid LUKS token JSON: missing \"keyslots\" array of strings")
+	}
+
+	return nil
+}
+
+// corruptHeaderMessages are the messages that cryptsetup prints on stderr when
+// the LUKS header of a device can not be parsed.
+var corruptHeaderMessages = []string{
+	"is not a valid LUKS device",
+	"Unsupported LUKS version",
+	"header checksum",
+	"Invalid LUKS",
+	"LUKS keyslot",
+}
+
+// LuksVerifyHeader checks that the LUKS header of the device can be parsed,
+// without opening the device. The passphrase is not needed for this. It
+// returns ErrCorruptHeader when the header is not valid.
+func LuksVerifyHeader(devicePath string) error {
+	stdout, stderr, err := execCryptsetupCommand(nil, "luksDump", devicePath)
+
+	return checkLuksHeader(stdout, stderr, err)
+}
+
+// checkLuksHeader checks the result of `cryptsetup luksDump`. Failures that are
+// caused by the header, like a missing LUKS signature, an unsupported version
+// or a checksum mismatch, are returned as ErrCorruptHeader, other failures are
+// returned as they are.
+func checkLuksHeader(stdout, stderr string, err error) error {
+	if err != nil {
+		for _, msg := range corruptHeaderMessages {
+			if strings.Contains(stderr, msg) {
+				return fmt.Errorf("%w: %s", ErrCorruptHeader, strings.TrimSpace(stderr))
+			}
+		}
+
+		return err
+	}
+
+	if _, err = parseLuksKeySlots(stdout); err != nil {
+		return JoinErrors(ErrCorruptHeader, err)
+	}
+
+	return nil
+}
+
+// parseLuksKeySlots parses the output of `cryptsetup luksDump` and returns the
+// indices of the active keyslots.
+func parseLuksKeySlots(dump string) ([]int, error) {
+	lines := strings.Split(dump, "\n")
+	version := ""
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Version:") {
+			version = strings.TrimSpace(strings.TrimPrefix(line, "Version:"))
+
+			break
+		}
+	}
+
+	switch version {
+	case "1":
+		return parseLuks1KeySlots(lines)
+	case "2":
+		return parseLuks2KeySlots(lines)
+	default:
+		return nil, fmt.Errorf("unsupported LUKS version %q in luksDump output", version)
+	}
+}
+
+// parseLuks1KeySlots returns the active keyslots of a LUKS1 dump. All 8
+// keyslots are listed, like "Key Slot 0: ENABLED" or "Key Slot 1: DISABLED".
+func parseLuks1KeySlots(lines []string) ([]int, error) {
+	slots := []int{}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "Key Slot ") {
+			continue
+		}
+		index, state, found := strings.Cut(strings.TrimPrefix(line, "Key Slot "), ":")
+		if !found {
+			return nil, fmt.Errorf("unexpected LUKS1 keyslot line %q", line)
+		}
+		if strings.TrimSpace(state) != "ENABLED" {
+			continue
+		}
+		n, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse LUKS1 keyslot %q: %w", line, err)
+		}
+		slots = append(slots, n)
+	}
+
+	return slots, nil
+}
+
+// parseLuks2KeySlots returns the active keyslots of a LUKS2 dump. Only the
+// active keyslots are listed in the "Keyslots:" section, indented by two
+// spaces like "  0: luks2". The properties of a keyslot are indented by a tab.
+func parseLuks2KeySlots(lines []string) ([]int, error) {
+	slots := []int{}
+	inKeyslots := false
+	for _, line := range lines {
+		// sections start at the beginning of the line
+		if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			inKeyslots = line == "Keyslots:"
+
+			continue
+		}
+		if !inKeyslots || !strings.HasPrefix(line, "  ") {
+			continue
+		}
+		index, _, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse LUKS2 keyslot %q: %w", line, err)
+		}
+		slots = append(slots, n)
+	}
+
+	return slots, nil
+}
+
+// CryptsetupVersion holds the version of the cryptsetup executable.
+type CryptsetupVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+var (
+	// cryptsetupVersion caches the detected version, the executable does not
+	// change while the plugin is running.
+	cryptsetupVersion     *CryptsetupVersion
+	cryptsetupVersionLock sync.Mutex
+)
+
+// GetCryptsetupVersion returns the version of the cryptsetup executable. The
+// version is detected once, successive calls return the cached version.
+func GetCryptsetupVersion() (CryptsetupVersion, error) {
+	cryptsetupVersionLock.Lock()
+	defer cryptsetupVersionLock.Unlock()
+
+	if cryptsetupVersion != nil {
+		return *cryptsetupVersion, nil
+	}
+
+	stdout, _, err := execCryptsetupCommand(nil, "--version")
+	if err != nil {
+		return CryptsetupVersion{}, err
+	}
+
+	version, err := parseCryptsetupVersion(stdout)
+	if err != nil {
+		return CryptsetupVersion{}, err
+	}
+	cryptsetupVersion = &version
+
+	return version, nil
+}
+
+// parseCryptsetupVersion parses the output of `cryptsetup --version`, like
+// "cryptsetup 2.3.7" or "cryptsetup 2.6.1 flags: UDEV BLKID KEYRING ...".
+func parseCryptsetupVersion(output string) (CryptsetupVersion, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 2 || fields[0] != "cryptsetup" {
+		return CryptsetupVersion{}, fmt.Errorf("unexpected cryptsetup version output: %q", output)
+	}
+
+	// strip suffixes of pre-releases, like "2.4.0-rc1"
+	release := strings.SplitN(fields[1], "-", 2)[0]
+	parts := strings.Split(release, ".")
+	const versionParts = 3
+	if len(parts) != versionParts {
+		return CryptsetupVersion{}, fmt.Errorf("unexpected cryptsetup version %q", fields[1])
+	}
+
+	numbers := make([]int, versionParts)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return CryptsetupVersion{}, fmt.Errorf("failed to parse cryptsetup version %q: %w", fields[1], err)
+		}
+		numbers[i] = n
+	}
+
+	return CryptsetupVersion{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+// AtLeast returns true when the version is equal to, or newer than the given
+// version.
+func (v CryptsetupVersion) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+
+	return v.Patch >= patch
+}
+
+func (v CryptsetupVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// supportsDisableKeyring checks if cryptsetup accepts --disable-keyring. In
+// case the version can not be detected, the option is assumed to be
+// supported as all maintained distributions ship cryptsetup 2.x.
+func supportsDisableKeyring() bool {
+	version, err := GetCryptsetupVersion()
+	if err != nil {
+		log.WarningLogMsg("failed to detect cryptsetup version: %v", err)
+
+		return true
+	}
+
+	return version.AtLeast(2, 0, 0)
+}
+
+// cryptsetupBusyErrors are the messages of cryptsetup for a device that is
+// (still) in use, for example when udev has not settled yet. These errors are
+// transient and the command is retried.
+var cryptsetupBusyErrors = []string{
+	"Device or resource busy",
+	"is still in use",
+}
+
 func execCryptsetupCommand(stdin *string, args ...string) (string, string, error) {
+	// cryptsetup is not killed while it runs, that could leave a partially
+	// written LUKS header behind
+	run := func() (string, string, error) {
+		return execCommandWithMetrics("cryptsetup", stdin, args...)
+	}
+
+	return retryCryptsetupCommand(run, cryptsetupBusyRetries, cryptsetupBusyDelay, cryptsetupBusyTimeout, time.Sleep)
+}
+
+// isCryptsetupBusy returns true if the stderr of cryptsetup reports that the
+// device is busy.
+func isCryptsetupBusy(stderr string) bool {
+	for _, msg := range cryptsetupBusyErrors {
+		if strings.Contains(stderr, msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryCryptsetupCommand calls run until it succeeds, fails with an error
+// that is not caused by a busy device, or the retries are exhausted. The
+// delay between the retries doubles every time and gets a random jitter, no
+// retry is started after the timeout passed.
+func retryCryptsetupCommand(
+	run func() (string, string, error),
+	retries int,
+	delay time.Duration,
+	timeout time.Duration,
+	sleep func(time.Duration),
+) (string, string, error) {
+	deadline := time.Now().Add(timeout)
+	for attempt := 0; ; attempt++ {
+		stdout, stderr, err := run()
+		if err == nil || !isCryptsetupBusy(stderr) || attempt >= retries {
+			return stdout, stderr, err
+		}
+
+		wait := delay + time.Duration(rand.Int63n(int64(delay))) // #nosec:G404, no need for a secure random.
+		if time.Now().Add(wait).After(deadline) {
+			return stdout, stderr, err
+		}
+
+		log.DebugLogMsg("cryptsetup failed because the device is busy, retrying in %s (attempt %d of %d): %s",
+			wait, attempt+1, retries, strings.TrimSpace(stderr))
+		sleep(wait)
+		delay *= 2
+	}
+}
+
+// execCommandWithMetrics runs program with args, and records the duration
+// and failures of the command in the cryptsetup metrics, labeled with the
+// cryptsetup subcommand that is found in args. The command is not killed
+// while it runs, as that could leave a partially written LUKS header behind.
+func execCommandWithMetrics(program string, stdin *string, args ...string) (string, string, error) {
 	var (
-		program       = "cryptsetup"
 		cmd           = exec.Command(program, args...) // #nosec:G204, commands executing not vulnerable.
 		sanitizedArgs = StripSecretInArgs(args)
+		subcommand    = cryptsetupSubcommand(args)
 		stdoutBuf     bytes.Buffer
 		stderrBuf     bytes.Buffer
 	)
@@ -80,14 +608,34 @@ func execCryptsetupCommand(stdin *string, args ...string) (string, string, error
 	if stdin != nil {
 		cmd.Stdin = strings.NewReader(*stdin)
 	}
+	start := time.Now()
 	err := cmd.Run()
+	cryptsetupCommandDuration.WithLabelValues(subcommand).Observe(time.Since(start).Seconds())
 	stdout := stdoutBuf.String()
 	stderr := stderrBuf.String()
 
 	if err != nil {
+		cryptsetupCommandFailures.WithLabelValues(subcommand).Inc()
+
 		return stdout, stderr, fmt.Errorf("an error (%v)"+
 			" occurred while running %s args: %v", err, program, sanitizedArgs)
 	}
 
 	return stdout, stderr, err
 }
+
+// cryptsetupSubcommand returns the cryptsetup subcommand that is passed in
+// args. Only known subcommands are returned, so that arguments that may
+// contain secrets never end up in a metrics label.
+func cryptsetupSubcommand(args []string) string {
+	for _, arg := range args {
+		switch arg {
+		case "luksFormat", "luksOpen", "luksClose", "luksDump", "resize", "status", "token":
+			return arg
+		case "--version":
+			return "version"
+		}
+	}
+
+	return "unknown"
+}
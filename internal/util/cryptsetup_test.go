@@ -0,0 +1,814 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCryptsetupVersion(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		output  string
+		want    CryptsetupVersion
+		wantErr bool
+	}{
+		{
+			"plain version",
+			"cryptsetup 2.3.7\n",
+			CryptsetupVersion{Major: 2, Minor: 3, Patch: 7},
+			false,
+		},
+		{
+			"version with flags",
+			"cryptsetup 2.6.1 flags: UDEV BLKID KEYRING FIPS KERNEL_CAPI PWQUALITY\n",
+			CryptsetupVersion{Major: 2, Minor: 6, Patch: 1},
+			false,
+		},
+		{
+			"pre-release version",
+			"cryptsetup 2.4.0-rc1",
+			CryptsetupVersion{Major: 2, Minor: 4, Patch: 0},
+			false,
+		},
+		{
+			"luks1 era version",
+			"cryptsetup 1.7.5",
+			CryptsetupVersion{Major: 1, Minor: 7, Patch: 5},
+			false,
+		},
+		{
+			"empty output",
+			"",
+			CryptsetupVersion{},
+			true,
+		},
+		{
+			"unexpected program",
+			"veritysetup 2.3.7",
+			CryptsetupVersion{},
+			true,
+		},
+		{
+			"incomplete version",
+			"cryptsetup 2.3",
+			CryptsetupVersion{},
+			true,
+		},
+		{
+			"non-numeric version",
+			"cryptsetup 2.x.1",
+			CryptsetupVersion{},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseCryptsetupVersion(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCryptsetupVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCryptsetupVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCryptsetupVersionAtLeast(t *testing.T) {
+	t.Parallel()
+	v := CryptsetupVersion{Major: 2, Minor: 3, Patch: 7}
+	tests := []struct {
+		major, minor, patch int
+		want                bool
+	}{
+		{2, 0, 0, true},
+		{2, 3, 7, true},
+		{2, 3, 8, false},
+		{2, 4, 0, false},
+		{1, 9, 9, true},
+		{3, 0, 0, false},
+	}
+	for _, tt := range tests {
+		if got := v.AtLeast(tt.major, tt.minor, tt.patch); got != tt.want {
+			t.Errorf("%s.AtLeast(%d, %d, %d) = %t, want %t", v, tt.major, tt.minor, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestLuksOpenArgs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		disableKeyring bool
+		allowDiscards  bool
+		readOnly       bool
+		want           []string
+	}{
+		{
+			"defaults",
+			false,
+			false,
+			false,
+			[]string{"luksOpen", "/dev/rbd0", "mapper", "-d", "/dev/stdin"},
+		},
+		{
+			"disable keyring",
+			true,
+			false,
+			false,
+			[]string{"luksOpen", "/dev/rbd0", "mapper", "--disable-keyring", "-d", "/dev/stdin"},
+		},
+		{
+			"allow discards",
+			false,
+			true,
+			false,
+			[]string{"luksOpen", "/dev/rbd0", "mapper", "--allow-discards", "-d", "/dev/stdin"},
+		},
+		{
+			"disable keyring and allow discards",
+			true,
+			true,
+			false,
+			[]string{"luksOpen", "/dev/rbd0", "mapper", "--disable-keyring", "--allow-discards", "-d", "/dev/stdin"},
+		},
+		{
+			"read-only",
+			true,
+			false,
+			true,
+			[]string{"luksOpen", "/dev/rbd0", "mapper", "--disable-keyring", "--readonly", "-d", "/dev/stdin"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := luksOpenArgs("/dev/rbd0", "mapper", "/dev/stdin", tt.disableKeyring, tt.allowDiscards, tt.readOnly)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("luksOpenArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyFileArgs(t *testing.T) {
+	t.Parallel()
+
+	got := luksOpenArgs("/dev/rbd0", "mapper", "/etc/keys/luks", true, false, false)
+	want := []string{"luksOpen", "/dev/rbd0", "mapper", "--disable-keyring", "-d", "/etc/keys/luks"}
+	assert.Equal(t, want, got)
+
+	got = luksFormatArgs("/dev/rbd0", "/etc/keys/luks")
+	want = []string{
+		"-q", "luksFormat", "--type", "luks2", "--hash", "sha256", "--pbkdf-memory", "32768",
+		"/dev/rbd0", "-d", "/etc/keys/luks",
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestTokenArgs(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"token", "import", "/dev/rbd0"}, tokenImportArgs("/dev/rbd0"))
+	assert.Equal(t,
+		[]string{"token", "export", "--token-id", "3", "/dev/rbd0"},
+		tokenExportArgs("/dev/rbd0", 3))
+}
+
+func TestValidateToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"kms reference", `{"type":"ceph-csi-kms","keyslots":["0"],"kmsID":"vault","keyID":"csi-vol-1"}`, false},
+		{"no keyslots assigned", `{"type":"ceph-csi-kms","keyslots":[]}`, false},
+		{"not json", `type=ceph-csi-kms`, true},
+		{"empty", ``, true},
+		{"not an object", `["ceph-csi-kms"]`, true},
+		{"missing type", `{"keyslots":["0"]}`, true},
+		{"empty type", `{"type":"","keyslots":["0"]}`, true},
+		{"type not a string", `{"type":1,"keyslots":["0"]}`, true},
+		{"missing keyslots", `{"type":"ceph-csi-kms"}`, true},
+		{"keyslots not strings", `{"type":"ceph-csi-kms","keyslots":[0]}`, true},
+		{"keyslots null", `{"type":"ceph-csi-kms","keyslots":null}`, true},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateToken(ts.token)
+			if ts.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetTokenInvalidID(t *testing.T) {
+	t.Parallel()
+
+	// the token id is checked before cryptsetup is run
+	for _, id := range []int{-1, luks2MaxTokens} {
+		_, err := GetToken("/dev/rbd0", id)
+		assert.Error(t, err)
+	}
+}
+
+func TestSetTokenInvalidJSON(t *testing.T) {
+	t.Parallel()
+
+	// invalid JSON is rejected before cryptsetup is run
+	err := SetToken("/dev/rbd0", `{"type":"ceph-csi-kms"`)
+	assert.ErrorContains(t, err, "invalid LUKS token JSON")
+}
+
+func TestCheckKeyFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		mode    os.FileMode // 0 creates a directory
+		wantErr bool
+	}{
+		{"owner read-write", 0o600, false},
+		{"owner read-only", 0o400, false},
+		{"group readable", 0o640, true},
+		{"world readable", 0o644, true},
+		{"directory", 0, true},
+		{"missing", 0o600, true},
+	}
+	for _, tt := range tests {
+		ts := tt
+		path := filepath.Join(dir, strings.ReplaceAll(ts.name, " ", "-"))
+		switch {
+		case ts.name == "missing":
+		case ts.mode == 0:
+			require.NoError(t, os.Mkdir(path, 0o700))
+		default:
+			require.NoError(t, os.WriteFile(path, []byte("key"), 0o600))
+			// set the mode explicitly, WriteFile applies the umask
+			require.NoError(t, os.Chmod(path, ts.mode))
+		}
+
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkKeyFile(path)
+			if ts.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckPassphraseLength(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		passphrase string
+		minLength  int
+		wantErr    bool
+	}{
+		{"empty", "", DefaultMinPassphraseLength, true},
+		{"below", "1234567", DefaultMinPassphraseLength, true},
+		{"at", "12345678", DefaultMinPassphraseLength, false},
+		{"above", "123456789", DefaultMinPassphraseLength, false},
+		{"overridden minimum", "1234", 4, false},
+		{"no minimum", "", 0, false},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkPassphraseLength(int64(len(ts.passphrase)), ts.minLength)
+			if ts.wantErr {
+				assert.ErrorIs(t, err, ErrWeakPassphrase)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckKeyFileLength(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"below", "1234567", true},
+		{"at", "12345678", false},
+		{"above", "123456789", false},
+	}
+	for _, tt := range tests {
+		ts := tt
+		path := filepath.Join(dir, ts.name)
+		require.NoError(t, os.WriteFile(path, []byte(ts.key), 0o600))
+
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkKeyFileLength(path, DefaultMinPassphraseLength)
+			if ts.wantErr {
+				assert.ErrorIs(t, err, ErrWeakPassphrase)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	err := checkKeyFileLength(filepath.Join(dir, "missing"), DefaultMinPassphraseLength)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrWeakPassphrase)
+}
+
+// luks1Dump is the output of `cryptsetup luksDump` for a LUKS1 device
+// with keyslots 0 and 3 enabled.
+const luks1Dump = `LUKS header information for /dev/rbd0
+
+Version:       	1
+Cipher name:   	aes
+Cipher mode:   	xts-plain64
+Hash spec:     	sha256
+Payload offset:	4096
+MK bits:       	512
+MK digest:     	d3 0e 4b 6a 1c 9f 27 58 e2 a0 6e 1d 39 c4 77 5b 0a 34 52 91
+MK salt:       	6f 1c 2d 8e 3a 94 b7 c0 51 72 0e 4f 99 a3 d6 28
+               	0b 47 e1 5a 23 c8 16 9d 70 be 44 f2 8c 35 6e 01
+MK iterations: 	109226
+UUID:          	5c6a4c1e-8f55-4b0e-9d1a-3c2e0f8b7a61
+
+Key Slot 0: ENABLED
+	Iterations:         	1747626
+	Salt:               	4c 1f 7a 92 e0 5d 38 b6 21 c4 9e 0f 73 da 58 11
+	                      	8a 36 fd 02 64 bb 1e 97 c5 70 29 e4 4d 83 fa 6c
+	Key material offset:	8
+	AF stripes:            	4000
+Key Slot 1: DISABLED
+Key Slot 2: DISABLED
+Key Slot 3: ENABLED
+	Iterations:         	1747626
+	Salt:               	4c 1f 7a 92 e0 5d 38 b6 21 c4 9e 0f 73 da 58 11
+	                      	8a 36 fd 02 64 bb 1e 97 c5 70 29 e4 4d 83 fa 6c
+	Key material offset:	1544
+	AF stripes:            	4000
+Key Slot 4: DISABLED
+Key Slot 5: DISABLED
+Key Slot 6: DISABLED
+Key Slot 7: DISABLED
+`
+
+// luks1DumpNoSlots is the output of `cryptsetup luksDump` for a LUKS1
+// device without enabled keyslots.
+const luks1DumpNoSlots = `LUKS header information for /dev/rbd0
+
+Version:       	1
+Cipher name:   	aes
+Cipher mode:   	xts-plain64
+Hash spec:     	sha256
+Payload offset:	4096
+MK bits:       	512
+MK digest:     	d3 0e 4b 6a 1c 9f 27 58 e2 a0 6e 1d 39 c4 77 5b 0a 34 52 91
+MK salt:       	6f 1c 2d 8e 3a 94 b7 c0 51 72 0e 4f 99 a3 d6 28
+               	0b 47 e1 5a 23 c8 16 9d 70 be 44 f2 8c 35 6e 01
+MK iterations: 	109226
+UUID:          	5c6a4c1e-8f55-4b0e-9d1a-3c2e0f8b7a61
+
+Key Slot 0: DISABLED
+Key Slot 1: DISABLED
+Key Slot 2: DISABLED
+Key Slot 3: DISABLED
+Key Slot 4: DISABLED
+Key Slot 5: DISABLED
+Key Slot 6: DISABLED
+Key Slot 7: DISABLED
+`
+
+// luks2Dump is the output of `cryptsetup luksDump` for a LUKS2 device
+// with keyslots 0, 1 and 7 active.
+const luks2Dump = `LUKS header information
+Version:       	2
+Epoch:         	5
+Metadata area: 	16384 [bytes]
+Keyslots area: 	16744448 [bytes]
+UUID:          	0a8e3b7f-2c91-4d6e-b5a4-71f0c3d2e9b8
+Label:         	(no label)
+Subsystem:     	(no subsystem)
+Flags:       	(no flags)
+
+Data segments:
+  0: crypt
+	offset: 16777216 [bytes]
+	length: (whole device)
+	cipher: aes-xts-plain64
+	sector: 512 [bytes]
+
+Keyslots:
+  0: luks2
+	Key:        512 bits
+	Priority:   normal
+	Cipher:     aes-xts-plain64
+	Cipher key: 512 bits
+	PBKDF:      argon2i
+	Time cost:  4
+	Memory:     32768
+	Threads:    4
+	Salt:       9e 27 4b 0c d1 38 6a f5 82 1d e4 70 3b c9 56 aa
+	            17 e8 42 9d 05 bc 63 f1 2a 7e d8 94 30 5b c6 0f
+	AF stripes: 4000
+	AF hash:    sha256
+	Area offset:32768 [bytes]
+	Area length:258048 [bytes]
+	Digest ID:  0
+  1: luks2
+	Key:        512 bits
+	Priority:   normal
+	Cipher:     aes-xts-plain64
+	Cipher key: 512 bits
+	PBKDF:      argon2i
+	Time cost:  4
+	Memory:     32768
+	Threads:    4
+	Salt:       9e 27 4b 0c d1 38 6a f5 82 1d e4 70 3b c9 56 aa
+	            17 e8 42 9d 05 bc 63 f1 2a 7e d8 94 30 5b c6 0f
+	AF stripes: 4000
+	AF hash:    sha256
+	Area offset:290816 [bytes]
+	Area length:258048 [bytes]
+	Digest ID:  0
+  7: luks2
+	Key:        512 bits
+	Priority:   normal
+	Cipher:     aes-xts-plain64
+	Cipher key: 512 bits
+	PBKDF:      argon2i
+	Time cost:  4
+	Memory:     32768
+	Threads:    4
+	Salt:       9e 27 4b 0c d1 38 6a f5 82 1d e4 70 3b c9 56 aa
+	            17 e8 42 9d 05 bc 63 f1 2a 7e d8 94 30 5b c6 0f
+	AF stripes: 4000
+	AF hash:    sha256
+	Area offset:1839104 [bytes]
+	Area length:258048 [bytes]
+	Digest ID:  0
+Tokens:
+Digests:
+  0: pbkdf2
+	Hash:       sha256
+	Iterations: 129774
+	Salt:       3d 8a 52 e1 7c 09 b4 66 f0 2e 95 4b a8 13 d7 6c
+	            c2 59 0e 87 34 fb 61 a0 1d 48 e6 92 7b 05 bc 3f
+	Digest:     51 c7 2a 9e 04 db 68 f3 b1 3c 87 20 e5 4a 96 1f
+	            ad 72 0b 5e c8 31 97 64 fa 06 2d b9 40 e3 18 7c
+`
+
+// luks2DumpOneSlot is the output of `cryptsetup luksDump` for a LUKS2
+// device with keyslot 2 active.
+const luks2DumpOneSlot = `LUKS header information
+Version:       	2
+Epoch:         	5
+Metadata area: 	16384 [bytes]
+Keyslots area: 	16744448 [bytes]
+UUID:          	0a8e3b7f-2c91-4d6e-b5a4-71f0c3d2e9b8
+Label:         	(no label)
+Subsystem:     	(no subsystem)
+Flags:       	(no flags)
+
+Data segments:
+  0: crypt
+	offset: 16777216 [bytes]
+	length: (whole device)
+	cipher: aes-xts-plain64
+	sector: 512 [bytes]
+
+Keyslots:
+  2: luks2
+	Key:        512 bits
+	Priority:   normal
+	Cipher:     aes-xts-plain64
+	Cipher key: 512 bits
+	PBKDF:      argon2i
+	Time cost:  4
+	Memory:     32768
+	Threads:    4
+	Salt:       9e 27 4b 0c d1 38 6a f5 82 1d e4 70 3b c9 56 aa
+	            17 e8 42 9d 05 bc 63 f1 2a 7e d8 94 30 5b c6 0f
+	AF stripes: 4000
+	AF hash:    sha256
+	Area offset:548864 [bytes]
+	Area length:258048 [bytes]
+	Digest ID:  0
+Tokens:
+Digests:
+  0: pbkdf2
+	Hash:       sha256
+	Iterations: 129774
+	Salt:       3d 8a 52 e1 7c 09 b4 66 f0 2e 95 4b a8 13 d7 6c
+	            c2 59 0e 87 34 fb 61 a0 1d 48 e6 92 7b 05 bc 3f
+	Digest:     51 c7 2a 9e 04 db 68 f3 b1 3c 87 20 e5 4a 96 1f
+	            ad 72 0b 5e c8 31 97 64 fa 06 2d b9 40 e3 18 7c
+`
+
+func TestParseLuksKeySlots(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		dump    string
+		want    []int
+		wantErr bool
+	}{
+		{"luks1 with two keyslots", luks1Dump, []int{0, 3}, false},
+		{"luks1 without keyslots", luks1DumpNoSlots, []int{}, false},
+		{"luks2 with three keyslots", luks2Dump, []int{0, 1, 7}, false},
+		{"luks2 with one keyslot", luks2DumpOneSlot, []int{2}, false},
+		{"luks1 with invalid keyslot", "Version:\t1\nKey Slot x: ENABLED\n", nil, true},
+		{"luks2 with invalid keyslot", "Version:\t2\nKeyslots:\n  x: luks2\n", nil, true},
+		{"unknown version", "Version:\t3\n", nil, true},
+		{"no luks header", "Device /dev/rbd0 is not a valid LUKS device.\n", nil, true},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseLuksKeySlots(ts.dump)
+			if ts.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, ts.want, got)
+		})
+	}
+}
+
+func TestCheckLuksHeader(t *testing.T) {
+	t.Parallel()
+	cmdErr := errors.New("an error (exit status 1) occurred while running cryptsetup")
+	tests := []struct {
+		name        string
+		stdout      string
+		stderr      string
+		err         error
+		wantErr     bool
+		wantCorrupt bool
+	}{
+		{
+			name:   "valid luks1 header",
+			stdout: luks1Dump,
+		},
+		{
+			name:   "valid luks2 header",
+			stdout: luks2Dump,
+		},
+		{
+			name:        "not a luks device",
+			stderr:      "Device /dev/rbd0 is not a valid LUKS device.\n",
+			err:         cmdErr,
+			wantErr:     true,
+			wantCorrupt: true,
+		},
+		{
+			name:        "unsupported version",
+			stderr:      "Unsupported LUKS version 3.\n",
+			err:         cmdErr,
+			wantErr:     true,
+			wantCorrupt: true,
+		},
+		{
+			name:        "checksum mismatch",
+			stderr:      "LUKS2 header checksum error.\nDevice /dev/rbd0 is not a valid LUKS device.\n",
+			err:         cmdErr,
+			wantErr:     true,
+			wantCorrupt: true,
+		},
+		{
+			name:        "invalid keyslot",
+			stderr:      "LUKS keyslot 3 is invalid.\n",
+			err:         cmdErr,
+			wantErr:     true,
+			wantCorrupt: true,
+		},
+		{
+			name:        "unparsable dump",
+			stdout:      "LUKS header information\nVersion:\t7\n",
+			wantErr:     true,
+			wantCorrupt: true,
+		},
+		{
+			name:    "missing device",
+			stderr:  "Device /dev/rbd0 does not exist or access denied.\n",
+			err:     cmdErr,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkLuksHeader(ts.stdout, ts.stderr, ts.err)
+			if !ts.wantErr {
+				assert.NoError(t, err)
+
+				return
+			}
+			assert.Error(t, err)
+			assert.Equal(t, ts.wantCorrupt, errors.Is(err, ErrCorruptHeader))
+		})
+	}
+}
+
+func TestCryptsetupSubcommand(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-q", "luksFormat", "--type", "luks2", "/dev/rbd0", "-d", "/dev/stdin"}, "luksFormat"},
+		{[]string{"luksOpen", "/dev/rbd0", "mapper", "-d", "/dev/stdin"}, "luksOpen"},
+		{[]string{"resize", "mapper"}, "resize"},
+		{[]string{"luksDump", "/dev/rbd0"}, "luksDump"},
+		{tokenImportArgs("/dev/rbd0"), "token"},
+		{tokenExportArgs("/dev/rbd0", 0), "token"},
+		{[]string{"--version"}, "version"},
+		{[]string{"--key-file", "secret"}, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := cryptsetupSubcommand(tt.args); got != tt.want {
+			t.Errorf("cryptsetupSubcommand(%v) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+// sampleCount returns the number of observations of the cryptsetup duration
+// histogram for the subcommand.
+func sampleCount(t *testing.T, subcommand string) uint64 {
+	t.Helper()
+
+	metric := &dto.Metric{}
+	observer := cryptsetupCommandDuration.WithLabelValues(subcommand)
+	m, ok := observer.(prometheus.Metric)
+	require.True(t, ok)
+	require.NoError(t, m.Write(metric))
+
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestExecCommandWithMetrics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(ts *testing.T) {
+		ts.Parallel()
+		observed := sampleCount(ts, "resize")
+		failures := testutil.ToFloat64(cryptsetupCommandFailures.WithLabelValues("resize"))
+
+		_, _, err := execCommandWithMetrics("sh", nil, "-c", "exit 0", "resize")
+		assert.NoError(ts, err)
+		assert.Equal(ts, observed+1, sampleCount(ts, "resize"))
+		assert.Equal(ts, failures, testutil.ToFloat64(cryptsetupCommandFailures.WithLabelValues("resize")))
+	})
+
+	t.Run("failure", func(ts *testing.T) {
+		ts.Parallel()
+		observed := sampleCount(ts, "luksClose")
+		failures := testutil.ToFloat64(cryptsetupCommandFailures.WithLabelValues("luksClose"))
+
+		_, _, err := execCommandWithMetrics("sh", nil, "-c", "exit 1", "luksClose")
+		assert.Error(ts, err)
+		assert.Equal(ts, observed+1, sampleCount(ts, "luksClose"))
+		assert.Equal(ts, failures+1, testutil.ToFloat64(cryptsetupCommandFailures.WithLabelValues("luksClose")))
+	})
+}
+
+func TestRetryCryptsetupCommand(t *testing.T) {
+	t.Parallel()
+
+	const busy = "Device rbd-0 is still in use.\n"
+	errFailed := errors.New("exit status 5")
+
+	tests := []struct {
+		name     string
+		results  []string // stderr of every attempt, an empty stderr succeeds
+		retries  int
+		timeout  time.Duration
+		wantErr  bool
+		attempts int
+	}{
+		{
+			name:     "success",
+			results:  []string{""},
+			retries:  3,
+			timeout:  time.Minute,
+			attempts: 1,
+		},
+		{
+			name:     "busy then success",
+			results:  []string{busy, "Cannot deactivate: Device or resource busy", ""},
+			retries:  3,
+			timeout:  time.Minute,
+			attempts: 3,
+		},
+		{
+			name:     "persistent busy",
+			results:  []string{busy, busy, busy, busy, busy},
+			retries:  3,
+			timeout:  time.Minute,
+			wantErr:  true,
+			attempts: 4,
+		},
+		{
+			name:     "not transient",
+			results:  []string{"No key available with this passphrase.", ""},
+			retries:  3,
+			timeout:  time.Minute,
+			wantErr:  true,
+			attempts: 1,
+		},
+		{
+			name:     "timeout",
+			results:  []string{busy, ""},
+			retries:  3,
+			timeout:  0,
+			wantErr:  true,
+			attempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+
+			attempts := 0
+			run := func() (string, string, error) {
+				stderr := ts.results[attempts]
+				attempts++
+				if stderr != "" {
+					return "", stderr, errFailed
+				}
+
+				return "ok", "", nil
+			}
+			var delays []time.Duration
+			sleep := func(d time.Duration) {
+				delays = append(delays, d)
+			}
+
+			stdout, _, err := retryCryptsetupCommand(run, ts.retries, time.Millisecond, ts.timeout, sleep)
+			if ts.wantErr {
+				assert.ErrorIs(t, err, errFailed)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "ok", stdout)
+			}
+			assert.Equal(t, ts.attempts, attempts)
+			require.Len(t, delays, ts.attempts-1)
+			// the delay doubles for every retry, with a jitter up to the delay
+			for i, d := range delays {
+				base := time.Millisecond << i
+				assert.GreaterOrEqual(t, d, base)
+				assert.Less(t, d, 2*base)
+			}
+		})
+	}
+}
@@ -36,6 +36,15 @@ const (
 	ClusterIDKey = "clusterID"
 )
 
+// SubvolumeGroupPin contains the MDS pinning of the CephFS
+// SubvolumeGroup.
+type SubvolumeGroupPin struct {
+	// Type is the pin type, one of export, distributed or random
+	Type string `json:"type"`
+	// Setting is the value for the pin type
+	Setting string `json:"setting"`
+}
+
 // ClusterInfo strongly typed JSON spec for the below JSON structure.
 type ClusterInfo struct {
 	// ClusterID is used for unique identification
@@ -50,6 +59,12 @@ type ClusterInfo struct {
 		NetNamespaceFilePath string `json:"netNamespaceFilePath"`
 		// SubvolumeGroup contains the name of the SubvolumeGroup for CSI volumes
 		SubvolumeGroup string `json:"subvolumeGroup"`
+		// SubvolumeGroupPin contains the MDS pinning of the SubvolumeGroup
+		SubvolumeGroupPin SubvolumeGroupPin `json:"subvolumeGroupPin"`
+		// KernelMountOptions contains the kernel mount options for CephFS volumes
+		KernelMountOptions string `json:"kernelMountOptions"`
+		// FuseMountOptions contains the ceph-fuse mount options for CephFS volumes
+		FuseMountOptions string `json:"fuseMountOptions"`
 	} `json:"cephFS"`
 
 	// RBD Contains RBD specific options
@@ -79,7 +94,13 @@ type ClusterInfo struct {
 		"<monitor-value>"
 	],
 	"cephFS": {
-		"subvolumeGroup": "<subvolumegroup for cephfs volumes>"
+		"subvolumeGroup": "<subvolumegroup for cephfs volumes>",
+		"subvolumeGroupPin": {
+			"type": "<export, distributed or random>",
+			"setting": "<pin setting for the type>"
+		},
+		"kernelMountOptions": "<comma separated kernel mount options>",
+		"fuseMountOptions": "<comma separated ceph-fuse mount options>"
 	}
 }]
 */
@@ -151,6 +172,18 @@ func CephFSSubvolumeGroup(pathToConfig, clusterID string) (string, error) {
 	return cluster.CephFS.SubvolumeGroup, nil
 }
 
+// CephFSSubvolumeGroupPin returns the pin of the subvolumeGroup of CephFS
+// volumes. The type and setting of the pin are empty when no pin is
+// configured.
+func CephFSSubvolumeGroupPin(pathToConfig, clusterID string) (SubvolumeGroupPin, error) {
+	cluster, err := readClusterInfo(pathToConfig, clusterID)
+	if err != nil {
+		return SubvolumeGroupPin{}, err
+	}
+
+	return cluster.CephFS.SubvolumeGroupPin, nil
+}
+
 // GetMonsAndClusterID returns monitors and clusterID information read from
 // configfile.
 func GetMonsAndClusterID(ctx context.Context, clusterID string, checkClusterIDMapping bool) (string, string, error) {
@@ -200,6 +233,17 @@ func GetCephFSNetNamespaceFilePath(pathToConfig, clusterID string) (string, erro
 	return cluster.CephFS.NetNamespaceFilePath, nil
 }
 
+// GetCephFSMountOptions returns the kernel and ceph-fuse mount options for
+// CephFS volumes of the cluster.
+func GetCephFSMountOptions(pathToConfig, clusterID string) (string, string, error) {
+	cluster, err := readClusterInfo(pathToConfig, clusterID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return cluster.CephFS.KernelMountOptions, cluster.CephFS.FuseMountOptions, nil
+}
+
 // GetNFSNetNamespaceFilePath returns the netNamespaceFilePath for NFS volumes.
 func GetNFSNetNamespaceFilePath(pathToConfig, clusterID string) (string, error) {
 	cluster, err := readClusterInfo(pathToConfig, clusterID)
@@ -245,8 +245,11 @@ func TestGetCephFSNetNamespaceFilePath(t *testing.T) {
 			ClusterID: "cluster-1",
 			Monitors:  []string{"ip-1", "ip-2"},
 			CephFS: struct {
-				NetNamespaceFilePath string `json:"netNamespaceFilePath"`
-				SubvolumeGroup       string `json:"subvolumeGroup"`
+				NetNamespaceFilePath string            `json:"netNamespaceFilePath"`
+				SubvolumeGroup       string            `json:"subvolumeGroup"`
+				SubvolumeGroupPin    SubvolumeGroupPin `json:"subvolumeGroupPin"`
+				KernelMountOptions   string            `json:"kernelMountOptions"`
+				FuseMountOptions     string            `json:"fuseMountOptions"`
 			}{
 				NetNamespaceFilePath: "/var/lib/kubelet/plugins/cephfs.ceph.csi.com/cluster1-net",
 			},
@@ -255,8 +258,11 @@ func TestGetCephFSNetNamespaceFilePath(t *testing.T) {
 			ClusterID: "cluster-2",
 			Monitors:  []string{"ip-3", "ip-4"},
 			CephFS: struct {
-				NetNamespaceFilePath string `json:"netNamespaceFilePath"`
-				SubvolumeGroup       string `json:"subvolumeGroup"`
+				NetNamespaceFilePath string            `json:"netNamespaceFilePath"`
+				SubvolumeGroup       string            `json:"subvolumeGroup"`
+				SubvolumeGroupPin    SubvolumeGroupPin `json:"subvolumeGroupPin"`
+				KernelMountOptions   string            `json:"kernelMountOptions"`
+				FuseMountOptions     string            `json:"fuseMountOptions"`
 			}{
 				NetNamespaceFilePath: "/var/lib/kubelet/plugins/cephfs.ceph.csi.com/cluster2-net",
 			},
@@ -292,6 +298,79 @@ func TestGetCephFSNetNamespaceFilePath(t *testing.T) {
 	}
 }
 
+func TestGetCephFSMountOptions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		clusterID  string
+		wantKernel string
+		wantFuse   string
+	}{
+		{
+			name:       "get cephFS specific mount options for cluster-1",
+			clusterID:  "cluster-1",
+			wantKernel: "nowsync,recover_session=clean",
+			wantFuse:   "",
+		},
+		{
+			name:       "get cephFS specific mount options for cluster-2",
+			clusterID:  "cluster-2",
+			wantKernel: "",
+			wantFuse:   "debug",
+		},
+		{
+			name:       "when cephFS specific mount options are empty",
+			clusterID:  "cluster-3",
+			wantKernel: "",
+			wantFuse:   "",
+		},
+	}
+
+	csiConfig := []ClusterInfo{
+		{
+			ClusterID: "cluster-1",
+			Monitors:  []string{"ip-1", "ip-2"},
+		},
+		{
+			ClusterID: "cluster-2",
+			Monitors:  []string{"ip-3", "ip-4"},
+		},
+		{
+			ClusterID: "cluster-3",
+			Monitors:  []string{"ip-5", "ip-6"},
+		},
+	}
+	csiConfig[0].CephFS.KernelMountOptions = "nowsync,recover_session=clean"
+	csiConfig[1].CephFS.FuseMountOptions = "debug"
+	csiConfigFileContent, err := json.Marshal(csiConfig)
+	if err != nil {
+		t.Errorf("failed to marshal csi config info %v", err)
+	}
+	tmpConfPath := t.TempDir() + "/ceph-csi.json"
+	err = os.WriteFile(tmpConfPath, csiConfigFileContent, 0o600)
+	if err != nil {
+		t.Errorf("failed to write %s file content: %v", CsiConfigFile, err)
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			kernel, fuse, err := GetCephFSMountOptions(tmpConfPath, ts.clusterID)
+			if err != nil {
+				t.Errorf("GetCephFSMountOptions() error = %v", err)
+
+				return
+			}
+			if kernel != ts.wantKernel {
+				t.Errorf("GetCephFSMountOptions() kernel = %v, want %v", kernel, ts.wantKernel)
+			}
+			if fuse != ts.wantFuse {
+				t.Errorf("GetCephFSMountOptions() fuse = %v, want %v", fuse, ts.wantFuse)
+			}
+		})
+	}
+}
+
 func TestGetNFSNetNamespaceFilePath(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -0,0 +1,225 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+
+	mount "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// ExpandStepLuks is the step of ExpandEncryptedVolume that grows the
+	// LUKS mapping to the size of the (already expanded) device.
+	ExpandStepLuks = "luks"
+	// ExpandStepFilesystem is the step of ExpandEncryptedVolume that grows
+	// the filesystem to the size of the LUKS mapping.
+	ExpandStepFilesystem = "filesystem"
+)
+
+// encryptedVolumeResizer performs the steps of ExpandEncryptedVolume, it is
+// replaced by a fake in the tests.
+type encryptedVolumeResizer interface {
+	// luksNeedsResize returns true if the LUKS mapping is smaller than its
+	// device.
+	luksNeedsResize(mapperFile string) (bool, error)
+	// resizeLuks grows the LUKS mapping to the size of its device.
+	resizeLuks(ctx context.Context, mapperFile string) error
+	// fsNeedsResize returns true if the filesystem on devicePath is smaller
+	// than the device.
+	fsNeedsResize(devicePath, mountPath string) (bool, error)
+	// resizeFs grows the filesystem on devicePath, mounted at mountPath.
+	resizeFs(devicePath, mountPath string) error
+}
+
+// nodeResizer resizes the LUKS mappings and filesystems of the node.
+type nodeResizer struct {
+	fs *mount.ResizeFs
+}
+
+func (r *nodeResizer) luksNeedsResize(mapperFile string) (bool, error) {
+	stdout, stderr, err := LuksStatus(mapperFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to get status of LUKS device %q: %w (%s)",
+			mapperFile, err, strings.TrimSpace(stderr))
+	}
+	device, offset, size, err := parseLuksStatus(stdout)
+	if err != nil {
+		return false, err
+	}
+	deviceSize, err := blockDeviceSectors(device)
+	if err != nil {
+		return false, err
+	}
+
+	return offset+size < deviceSize, nil
+}
+
+func (r *nodeResizer) resizeLuks(ctx context.Context, mapperFile string) error {
+	return ResizeEncryptedVolume(ctx, mapperFile)
+}
+
+func (r *nodeResizer) fsNeedsResize(devicePath, mountPath string) (bool, error) {
+	return r.fs.NeedResize(devicePath, mountPath)
+}
+
+func (r *nodeResizer) resizeFs(devicePath, mountPath string) error {
+	_, err := r.fs.Resize(devicePath, mountPath)
+
+	return err
+}
+
+// ExpandEncryptedVolume grows an encrypted volume on the node, after its
+// image was expanded. The LUKS mapping is grown to the size of the device,
+// and the filesystem to the size of the mapping. Steps that are not needed
+// anymore are skipped, so that an expansion that was interrupted can be
+// completed by running it again. It returns the steps that were performed,
+// ExpandStepLuks and ExpandStepFilesystem.
+//
+// fsType is the type of the filesystem on the volume, an empty fsType is
+// detected on the device. mountPath is where the filesystem is mounted, it is
+// empty for volumes in block mode, which have no filesystem to grow.
+func ExpandEncryptedVolume(ctx context.Context, mapperFile, fsType, mountPath string) ([]string, error) {
+	r := &nodeResizer{fs: mount.NewResizeFs(utilexec.New())}
+
+	return expandEncryptedVolume(ctx, r, mapperFile, fsType, mountPath)
+}
+
+func expandEncryptedVolume(
+	ctx context.Context,
+	r encryptedVolumeResizer,
+	mapperFile, fsType, mountPath string,
+) ([]string, error) {
+	// fail before the mapping is grown, the filesystem could not follow
+	if mountPath != "" && !isResizableFsType(fsType) {
+		return nil, fmt.Errorf("filesystem %q of LUKS device %q can not be resized", fsType, mapperFile)
+	}
+
+	steps := []string{}
+	needed, err := r.luksNeedsResize(mapperFile)
+	if err != nil {
+		return steps, err
+	}
+	if needed {
+		err = r.resizeLuks(ctx, mapperFile)
+		if err != nil {
+			return steps, fmt.Errorf("failed to resize LUKS device %q: %w", mapperFile, err)
+		}
+		steps = append(steps, ExpandStepLuks)
+	}
+
+	if mountPath == "" {
+		return steps, nil
+	}
+
+	devicePath := path.Join(mapperFilePathPrefix, mapperFile)
+	needed, err = r.fsNeedsResize(devicePath, mountPath)
+	if err != nil {
+		return steps, fmt.Errorf("failed to check the filesystem size of %q: %w", devicePath, err)
+	}
+	if needed {
+		err = r.resizeFs(devicePath, mountPath)
+		if err != nil {
+			return steps, fmt.Errorf("failed to resize the filesystem of %q: %w", devicePath, err)
+		}
+		steps = append(steps, ExpandStepFilesystem)
+	}
+	log.DebugLog(ctx, "expanded encrypted volume %q with steps %v", mapperFile, steps)
+
+	return steps, nil
+}
+
+// isResizableFsType returns true for the filesystem types that can be grown
+// while they are mounted. An empty fsType is detected when it is resized.
+func isResizableFsType(fsType string) bool {
+	switch fsType {
+	case "", "ext3", "ext4", "xfs", "btrfs":
+		return true
+	}
+
+	return false
+}
+
+// parseLuksStatus returns the underlying device, and the offset and size of
+// the data in sectors, from the output of `cryptsetup status`, like
+//
+//	/dev/mapper/luks-rbd-0001 is active and is in use.
+//	  type:    LUKS2
+//	  device:  /dev/rbd0
+//	  sector size:  512
+//	  offset:  32768 sectors
+//	  size:    2064384 sectors
+func parseLuksStatus(status string) (string, int64, int64, error) {
+	var (
+		device       string
+		offset, size int64
+		err          error
+	)
+	for _, line := range strings.Split(status, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "device":
+			device = value
+		case "offset":
+			offset, err = parseSectors(value)
+		case "size":
+			size, err = parseSectors(value)
+		}
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("failed to parse LUKS status line %q: %w", line, err)
+		}
+	}
+	if device == "" || size == 0 {
+		return "", 0, 0, fmt.Errorf("unexpected LUKS status output: %q", status)
+	}
+
+	return device, offset, size, nil
+}
+
+// parseSectors parses a number of sectors, like "32768 sectors".
+func parseSectors(value string) (int64, error) {
+	return strconv.ParseInt(strings.TrimSuffix(value, " sectors"), 10, 64)
+}
+
+// blockDeviceSectors returns the size of the block device in sectors of 512
+// bytes.
+func blockDeviceSectors(device string) (int64, error) {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve device %q: %w", device, err)
+	}
+	sizeFile := filepath.Join("/sys/class/block", filepath.Base(resolved), "size")
+	data, err := os.ReadFile(sizeFile) // #nosec:G304, the device is reported by cryptsetup.
+	if err != nil {
+		return 0, fmt.Errorf("failed to read size of device %q: %w", device, err)
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
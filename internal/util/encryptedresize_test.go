@@ -0,0 +1,192 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResizer records the calls of expandEncryptedVolume. The LUKS mapping
+// and the filesystem need a resize until they were resized.
+type fakeResizer struct {
+	luksSmall bool
+	fsSmall   bool
+	luksErr   error
+	fsErr     error
+	calls     []string
+}
+
+func (r *fakeResizer) luksNeedsResize(mapperFile string) (bool, error) {
+	r.calls = append(r.calls, "luksNeedsResize")
+
+	return r.luksSmall, nil
+}
+
+func (r *fakeResizer) resizeLuks(ctx context.Context, mapperFile string) error {
+	r.calls = append(r.calls, "resizeLuks")
+	if r.luksErr != nil {
+		return r.luksErr
+	}
+	r.luksSmall = false
+
+	return nil
+}
+
+func (r *fakeResizer) fsNeedsResize(devicePath, mountPath string) (bool, error) {
+	r.calls = append(r.calls, "fsNeedsResize")
+
+	return r.fsSmall, nil
+}
+
+func (r *fakeResizer) resizeFs(devicePath, mountPath string) error {
+	r.calls = append(r.calls, "resizeFs")
+	if r.fsErr != nil {
+		return r.fsErr
+	}
+	r.fsSmall = false
+
+	return nil
+}
+
+func TestExpandEncryptedVolume(t *testing.T) {
+	t.Parallel()
+	errResize := errors.New("resize failed")
+	tests := []struct {
+		name      string
+		resizer   *fakeResizer
+		fsType    string
+		mountPath string
+		wantSteps []string
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			name:      "filesystem",
+			resizer:   &fakeResizer{luksSmall: true, fsSmall: true},
+			fsType:    "ext4",
+			mountPath: "/staging/vol",
+			wantSteps: []string{ExpandStepLuks, ExpandStepFilesystem},
+			wantCalls: []string{"luksNeedsResize", "resizeLuks", "fsNeedsResize", "resizeFs"},
+		},
+		{
+			name:      "block",
+			resizer:   &fakeResizer{luksSmall: true, fsSmall: true},
+			wantSteps: []string{ExpandStepLuks},
+			wantCalls: []string{"luksNeedsResize", "resizeLuks"},
+		},
+		{
+			name:      "luks resized before",
+			resizer:   &fakeResizer{fsSmall: true},
+			fsType:    "xfs",
+			mountPath: "/staging/vol",
+			wantSteps: []string{ExpandStepFilesystem},
+			wantCalls: []string{"luksNeedsResize", "fsNeedsResize", "resizeFs"},
+		},
+		{
+			name:      "expanded before",
+			resizer:   &fakeResizer{},
+			mountPath: "/staging/vol",
+			wantSteps: []string{},
+			wantCalls: []string{"luksNeedsResize", "fsNeedsResize"},
+		},
+		{
+			name:      "luks resize fails",
+			resizer:   &fakeResizer{luksSmall: true, fsSmall: true, luksErr: errResize},
+			fsType:    "ext4",
+			mountPath: "/staging/vol",
+			wantSteps: []string{},
+			wantCalls: []string{"luksNeedsResize", "resizeLuks"},
+			wantErr:   true,
+		},
+		{
+			name:      "filesystem resize fails",
+			resizer:   &fakeResizer{luksSmall: true, fsSmall: true, fsErr: errResize},
+			fsType:    "ext4",
+			mountPath: "/staging/vol",
+			wantSteps: []string{ExpandStepLuks},
+			wantCalls: []string{"luksNeedsResize", "resizeLuks", "fsNeedsResize", "resizeFs"},
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported filesystem",
+			resizer:   &fakeResizer{luksSmall: true, fsSmall: true},
+			fsType:    "vfat",
+			mountPath: "/staging/vol",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			steps, err := expandEncryptedVolume(context.TODO(), ts.resizer, "luks-rbd-vol", ts.fsType, ts.mountPath)
+			if ts.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, ts.wantSteps, steps)
+			assert.Equal(t, ts.wantCalls, ts.resizer.calls)
+		})
+	}
+}
+
+func TestExpandEncryptedVolumeRerun(t *testing.T) {
+	t.Parallel()
+	r := &fakeResizer{luksSmall: true, fsSmall: true, fsErr: errors.New("resize failed")}
+
+	steps, err := expandEncryptedVolume(context.TODO(), r, "luks-rbd-vol", "ext4", "/staging/vol")
+	require.Error(t, err)
+	assert.Equal(t, []string{ExpandStepLuks}, steps)
+
+	// the second run completes the interrupted expansion
+	r.fsErr = nil
+	steps, err = expandEncryptedVolume(context.TODO(), r, "luks-rbd-vol", "ext4", "/staging/vol")
+	require.NoError(t, err)
+	assert.Equal(t, []string{ExpandStepFilesystem}, steps)
+}
+
+func TestParseLuksStatus(t *testing.T) {
+	t.Parallel()
+	status := `/dev/mapper/luks-rbd-0001 is active and is in use.
+  type:    LUKS2
+  cipher:  aes-xts-plain64
+  keysize: 512 bits
+  key location: keyring
+  device:  /dev/rbd0
+  sector size:  512
+  offset:  32768 sectors
+  size:    2064384 sectors
+  mode:    read/write
+`
+	device, offset, size, err := parseLuksStatus(status)
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/rbd0", device)
+	assert.Equal(t, int64(32768), offset)
+	assert.Equal(t, int64(2064384), size)
+
+	_, _, _, err = parseLuksStatus("/dev/mapper/luks-rbd-0001 is inactive.\n")
+	assert.Error(t, err)
+
+	_, _, _, err = parseLuksStatus("  device:  /dev/rbd0\n  size:    many sectors\n")
+	assert.Error(t, err)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	// dmCryptModule is the kernel module that provides the device-mapper
+	// target for LUKS devices.
+	dmCryptModule = "dm_crypt"
+
+	// sysModulePath lists the loaded and built-in kernel modules.
+	sysModulePath = "/sys/module"
+)
+
+// ErrEncryptionPrereqs is returned when the node is missing prerequisites
+// for encrypted volumes.
+var ErrEncryptionPrereqs = errors.New("missing prerequisites for encrypted volumes")
+
+// encryptionPrereqsProbe contains the functions that are used to detect the
+// prerequisites for encrypted volumes.
+type encryptionPrereqsProbe struct {
+	lookPath   func(file string) (string, error)
+	version    func() (CryptsetupVersion, error)
+	loadModule func(module string) error
+}
+
+// VerifyEncryptionPrereqs checks that the cryptsetup executable is available
+// in a version that supports LUKS2, and that the dm_crypt kernel module is
+// loaded or can be loaded. All missing prerequisites are reported in the
+// returned error.
+func VerifyEncryptionPrereqs() error {
+	return verifyEncryptionPrereqs(encryptionPrereqsProbe{
+		lookPath:   exec.LookPath,
+		version:    GetCryptsetupVersion,
+		loadModule: loadKernelModule,
+	})
+}
+
+func verifyEncryptionPrereqs(probe encryptionPrereqsProbe) error {
+	var missing []string
+
+	if _, err := probe.lookPath("cryptsetup"); err != nil {
+		missing = append(missing, "cryptsetup executable not found in PATH, install the cryptsetup package")
+	} else {
+		version, err := probe.version()
+		switch {
+		case err != nil:
+			missing = append(missing, fmt.Sprintf("failed to detect cryptsetup version: %v", err))
+		case !version.AtLeast(2, 0, 0):
+			// LUKS2 formatting (luksFormat --type luks2) needs cryptsetup 2.x
+			missing = append(missing, fmt.Sprintf("cryptsetup %s does not support LUKS2, "+
+				"version 2.0.0 or newer is required", version))
+		}
+	}
+
+	if err := probe.loadModule(dmCryptModule); err != nil {
+		missing = append(missing, fmt.Sprintf("kernel module %s is not available (%v), "+
+			"install the kernel modules of the running kernel on the node", dmCryptModule, err))
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("%w: %s", ErrEncryptionPrereqs, strings.Join(missing, "; "))
+	}
+
+	return nil
+}
+
+// loadKernelModule loads the kernel module when it is not loaded or built
+// into the kernel yet. The dm_crypt module is normally loaded on the first use
+// of a LUKS device, so a module that is not loaded at startup is only missing
+// when it can not be loaded either.
+func loadKernelModule(module string) error {
+	if _, err := os.Stat(path.Join(sysModulePath, module)); err == nil {
+		return nil
+	}
+
+	_, stderr, err := ExecCommand(context.TODO(), "modprobe", module)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+	}
+
+	return nil
+}
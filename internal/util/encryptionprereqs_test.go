@@ -0,0 +1,101 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyEncryptionPrereqs(t *testing.T) {
+	t.Parallel()
+
+	found := func(file string) (string, error) { return "/usr/sbin/" + file, nil }
+	notFound := func(file string) (string, error) { return "", exec.ErrNotFound }
+	version := func(major int) func() (CryptsetupVersion, error) {
+		return func() (CryptsetupVersion, error) {
+			return CryptsetupVersion{Major: major, Minor: 3, Patch: 7}, nil
+		}
+	}
+	noVersion := func() (CryptsetupVersion, error) {
+		return CryptsetupVersion{}, errors.New("unexpected output")
+	}
+	loaded := func(module string) error { return nil }
+	notLoaded := func(module string) error {
+		return errors.New("modprobe: FATAL: Module " + module + " not found")
+	}
+
+	tests := []struct {
+		name    string
+		probe   encryptionPrereqsProbe
+		missing []string
+	}{
+		{
+			name:  "all prerequisites",
+			probe: encryptionPrereqsProbe{found, version(2), loaded},
+		},
+		{
+			name:    "no cryptsetup",
+			probe:   encryptionPrereqsProbe{notFound, version(2), loaded},
+			missing: []string{"cryptsetup executable not found"},
+		},
+		{
+			name:    "old cryptsetup",
+			probe:   encryptionPrereqsProbe{found, version(1), loaded},
+			missing: []string{"does not support LUKS2"},
+		},
+		{
+			name:    "unknown cryptsetup version",
+			probe:   encryptionPrereqsProbe{found, noVersion, loaded},
+			missing: []string{"failed to detect cryptsetup version"},
+		},
+		{
+			name:    "no dm_crypt",
+			probe:   encryptionPrereqsProbe{found, version(2), notLoaded},
+			missing: []string{"dm_crypt is not available"},
+		},
+		{
+			name:    "no cryptsetup and no dm_crypt",
+			probe:   encryptionPrereqsProbe{notFound, version(2), notLoaded},
+			missing: []string{"cryptsetup executable not found", "dm_crypt is not available"},
+		},
+		{
+			name:    "old cryptsetup and no dm_crypt",
+			probe:   encryptionPrereqsProbe{found, version(1), notLoaded},
+			missing: []string{"does not support LUKS2", "dm_crypt is not available"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := verifyEncryptionPrereqs(tt.probe)
+			if len(tt.missing) == 0 {
+				assert.NoError(t, err)
+
+				return
+			}
+			assert.ErrorIs(t, err, ErrEncryptionPrereqs)
+			for _, msg := range tt.missing {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
@@ -37,6 +37,14 @@ var (
 	ErrClusterIDNotSet = errors.New("clusterID must be set")
 	// ErrMissingConfigForMonitor is returned when clusterID is not found for the mon.
 	ErrMissingConfigForMonitor = errors.New("missing configuration of cluster ID for monitor")
+	// ErrInvalidPoolNamespace is returned when a pool/namespace string can not be parsed.
+	ErrInvalidPoolNamespace = errors.New("invalid pool/namespace")
+	// ErrCorruptHeader is returned when the LUKS header of a device can not be parsed.
+	ErrCorruptHeader = errors.New("corrupt LUKS header")
+	// ErrWeakPassphrase is returned when a device would be formatted with a passphrase that is too short.
+	ErrWeakPassphrase = errors.New("weak LUKS passphrase")
+	// ErrBlocklisted is returned when the client has been blocklisted by the Ceph cluster.
+	ErrBlocklisted = errors.New("client is blocklisted")
 )
 
 type pairError struct {
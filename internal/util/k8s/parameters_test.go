@@ -93,3 +93,60 @@ func TestGetOwner(t *testing.T) {
 		})
 	}
 }
+
+func TestGetSnapshotMetadata(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		param map[string]string
+		want  map[string]string
+	}{
+		{
+			name: "without snapshot metadata",
+			param: map[string]string{
+				"foo":                         "bar",
+				"csi.storage.k8s.io/pvc/name": "pvc",
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "with snapshot metadata",
+			param: map[string]string{
+				"foo":                                    "bar",
+				"csi.storage.k8s.io/pv/name":             "pv",
+				"csi.storage.k8s.io/volumesnapshot/name": "snap",
+				"csi.storage.k8s.io/volumesnapshot/namespace":   "ns",
+				"csi.storage.k8s.io/volumesnapshotcontent/name": "content",
+			},
+			want: map[string]string{
+				"csi.storage.k8s.io/volumesnapshot/name":        "snap",
+				"csi.storage.k8s.io/volumesnapshot/namespace":   "ns",
+				"csi.storage.k8s.io/volumesnapshotcontent/name": "content",
+			},
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			got := GetSnapshotMetadata(ts.param)
+			if !reflect.DeepEqual(got, ts.want) {
+				t.Errorf("GetSnapshotMetadata() = %v, want %v", got, ts.want)
+			}
+		})
+	}
+}
+
+func TestGetSnapshotMetadataKeys(t *testing.T) {
+	t.Parallel()
+
+	// the keys that are set by GetSnapshotMetadata are the keys that are
+	// removed from images that are restored from a snapshot
+	param := map[string]string{}
+	for _, key := range GetSnapshotMetadataKeys() {
+		param[key] = "value"
+	}
+	if got := GetSnapshotMetadata(param); !reflect.DeepEqual(got, param) {
+		t.Errorf("GetSnapshotMetadata() = %v, want %v", got, param)
+	}
+}
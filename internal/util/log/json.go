@@ -0,0 +1,108 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// log formats that can be set with SetFormat.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// severities of the JSON records.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+var (
+	// useJSON is set when the log format is FormatJSON.
+	useJSON bool
+
+	// jsonOutput is where the JSON records are written to.
+	jsonOutput io.Writer = os.Stderr
+	// jsonLock serializes writing the JSON records.
+	jsonLock sync.Mutex
+)
+
+// SetFormat sets the format of the log messages, FormatText for the klog
+// format, or FormatJSON for a JSON record per message.
+func SetFormat(format string) error {
+	switch format {
+	case FormatText:
+		useJSON = false
+	case FormatJSON:
+		useJSON = true
+	default:
+		return fmt.Errorf("unsupported log format %q, should be %q or %q", format, FormatText, FormatJSON)
+	}
+
+	return nil
+}
+
+// jsonRecord is a log message in the JSON format.
+type jsonRecord struct {
+	Timestamp string      `json:"ts"`
+	Severity  string      `json:"level"`
+	ID        interface{} `json:"id,omitempty"`
+	RequestID interface{} `json:"reqID,omitempty"`
+	Method    interface{} `json:"method,omitempty"`
+	VolumeID  interface{} `json:"volumeID,omitempty"`
+	Message   string      `json:"msg"`
+}
+
+// logJSON writes the message as a JSON record with the IDs of the request in
+// the context. Messages with a verbosity level are only written when the
+// level is enabled, a zero level is always written.
+func logJSON(ctx context.Context, severity string, level klog.Level, message string, args ...interface{}) {
+	if level != 0 && !klog.V(level).Enabled() {
+		return
+	}
+
+	record := jsonRecord{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Severity:  severity,
+		Message:   fmt.Sprintf(message, args...),
+		ID:        ctx.Value(CtxKey),
+		RequestID: ctx.Value(ReqID),
+		Method:    ctx.Value(MethodKey),
+		VolumeID:  ctx.Value(VolumeIDKey),
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		klog.ErrorDepth(2, fmt.Sprintf("failed to encode log message %q: %v", record.Message, err))
+
+		return
+	}
+
+	jsonLock.Lock()
+	defer jsonLock.Unlock()
+	_, _ = jsonOutput.Write(append(data, '\n'))
+}
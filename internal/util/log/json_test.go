@@ -0,0 +1,124 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"testing"
+
+	"k8s.io/klog/v2"
+)
+
+// captureJSON enables the JSON format with the verbosity set to level, and
+// returns the buffer the records are written to.
+func captureJSON(t *testing.T, level string) *bytes.Buffer {
+	t.Helper()
+
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	if err := fs.Set("v", level); err != nil {
+		t.Fatalf("failed to set verbosity: %v", err)
+	}
+
+	if err := SetFormat(FormatJSON); err != nil {
+		t.Fatalf("SetFormat(%q) failed: %v", FormatJSON, err)
+	}
+	buf := &bytes.Buffer{}
+	output := jsonOutput
+	jsonOutput = buf
+
+	t.Cleanup(func() {
+		_ = fs.Set("v", "0")
+		_ = SetFormat(FormatText)
+		jsonOutput = output
+	})
+
+	return buf
+}
+
+// nolint:paralleltest // modifies the global log format and verbosity.
+func TestDebugLogJSON(t *testing.T) {
+	buf := captureJSON(t, "4")
+
+	ctx := context.WithValue(context.Background(), CtxKey, 42)
+	ctx = context.WithValue(ctx, ReqID, "pvc-1")
+	ctx = context.WithValue(ctx, MethodKey, "/csi.v1.Controller/DeleteVolume")
+	ctx = context.WithValue(ctx, VolumeIDKey, "0001-0009-rook-ceph")
+	DebugLog(ctx, "deleting volume %s", "csi-vol-1")
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("DebugLog did not write valid JSON %q: %v", buf.String(), err)
+	}
+
+	expected := map[string]interface{}{
+		"level":    severityInfo,
+		"id":       float64(42),
+		"reqID":    "pvc-1",
+		"method":   "/csi.v1.Controller/DeleteVolume",
+		"volumeID": "0001-0009-rook-ceph",
+		"msg":      "deleting volume csi-vol-1",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("record[%q] = %v, expected %v", key, record[key], value)
+		}
+	}
+	if ts, ok := record["ts"].(string); !ok || ts == "" {
+		t.Errorf("record has no timestamp: %q", buf.String())
+	}
+	if record["msg"] != "deleting volume csi-vol-1" {
+		t.Errorf("message should not carry the text prefix: %v", record["msg"])
+	}
+}
+
+// nolint:paralleltest // modifies the global log format and verbosity.
+func TestLogJSONLevels(t *testing.T) {
+	buf := captureJSON(t, "2")
+
+	ctx := context.Background()
+	DebugLog(ctx, "not written")
+	TraceLogMsg("not written")
+	if buf.Len() != 0 {
+		t.Fatalf("messages above the verbosity were written: %q", buf.String())
+	}
+
+	ErrorLog(ctx, "failed")
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("ErrorLog did not write valid JSON %q: %v", buf.String(), err)
+	}
+	if record["level"] != severityError {
+		t.Errorf("record[level] = %v, expected %v", record["level"], severityError)
+	}
+	for _, key := range []string{"id", "reqID", "method", "volumeID"} {
+		if _, ok := record[key]; ok {
+			t.Errorf("record has %q without a value in the context", key)
+		}
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	t.Parallel()
+
+	if err := SetFormat("xml"); err == nil {
+		t.Error("SetFormat succeeded with an unsupported format")
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// levelPollInterval is how often the log level file is read.
+	levelPollInterval = 2 * time.Second
+	// levelDebounce is how long the contents of the log level file need to
+	// be unchanged before the level is applied, so that a file that is being
+	// rewritten does not make the level flap.
+	levelDebounce = 5 * time.Second
+)
+
+// levelWatcher applies the verbosity that is written in a file.
+type levelWatcher struct {
+	path     string
+	debounce time.Duration
+	now      func() time.Time
+	setLevel func(klog.Level) error
+
+	// applied is the level that has been set last, valid if isApplied.
+	applied   klog.Level
+	isApplied bool
+	// pending is the level in the file, it is applied once it has been
+	// unchanged since pendingSince for the debounce time.
+	pending      klog.Level
+	pendingSince time.Time
+	// invalid is the last contents of the file that could not be parsed, to
+	// warn about it only once.
+	invalid string
+}
+
+func newLevelWatcher(path string) *levelWatcher {
+	return &levelWatcher{
+		path:     path,
+		debounce: levelDebounce,
+		now:      time.Now,
+		setLevel: setVerbosity,
+	}
+}
+
+// setVerbosity sets the verbosity used by klog.V, and so by the loggers of
+// this package.
+func setVerbosity(level klog.Level) error {
+	// Set updates the global verbosity of klog, not only the receiver.
+	var v klog.Level
+
+	return v.Set(strconv.Itoa(int(level)))
+}
+
+// WatchLogLevelFile periodically reads the verbosity from the file at path
+// and applies it when it changes. The file can be mounted from a ConfigMap, a
+// missing file keeps the current verbosity.
+func WatchLogLevelFile(path string) {
+	w := newLevelWatcher(path)
+
+	ticker := time.NewTicker(levelPollInterval)
+	defer ticker.Stop()
+	w.check()
+	for range ticker.C {
+		w.check()
+	}
+}
+
+// check reads the log level file and applies the level in it when it has not
+// changed for the debounce time.
+func (w *levelWatcher) check() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			WarningLogMsg("failed to read log level file %q: %v", w.path, err)
+		}
+
+		return
+	}
+
+	contents := strings.TrimSpace(string(data))
+	level, err := strconv.ParseInt(contents, 10, 32)
+	if err != nil || level < 0 {
+		if contents != w.invalid {
+			WarningLogMsg("invalid log level %q in file %q, should be a non-negative number", contents, w.path)
+			w.invalid = contents
+		}
+
+		return
+	}
+	w.invalid = ""
+
+	now := w.now()
+	if klog.Level(level) != w.pending || w.pendingSince.IsZero() {
+		w.pending = klog.Level(level)
+		w.pendingSince = now
+	}
+	if w.isApplied && w.pending == w.applied {
+		return
+	}
+	if now.Sub(w.pendingSince) < w.debounce {
+		return
+	}
+
+	err = w.setLevel(w.pending)
+	if err != nil {
+		ErrorLogMsg("failed to set log level to %d: %v", w.pending, err)
+
+		return
+	}
+	w.logTransition()
+	w.applied = w.pending
+	w.isApplied = true
+}
+
+// logTransition logs the change of the level at Info, independent of the
+// verbosity.
+func (w *levelWatcher) logTransition() {
+	from := "the command line"
+	if w.isApplied {
+		from = strconv.Itoa(int(w.applied))
+	}
+
+	if useJSON {
+		logJSON(context.Background(), severityInfo, 0, "log level changed from %s to %d", from, w.pending)
+
+		return
+	}
+	klog.Infof("log level changed from %s to %d", from, w.pending)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// newTestLevelWatcher returns a levelWatcher for a file in a temporary
+// directory with a fake clock, and a function to write the file.
+func newTestLevelWatcher(t *testing.T) (*levelWatcher, *time.Time, func(string)) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "loglevel")
+	now := time.Unix(1000, 0)
+	w := newLevelWatcher(path)
+	w.now = func() time.Time { return now }
+
+	write := func(contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatalf("failed to write log level file: %v", err)
+		}
+	}
+
+	return w, &now, write
+}
+
+// nolint:paralleltest // modifies the global log format and verbosity.
+func TestWatchLogLevel(t *testing.T) {
+	buf := captureJSON(t, "0")
+	w, now, write := newTestLevelWatcher(t)
+	ctx := context.Background()
+
+	// emitted returns true if a DebugLog message is written.
+	emitted := func() bool {
+		buf.Reset()
+		DebugLog(ctx, "debug message")
+
+		return strings.Contains(buf.String(), "debug message")
+	}
+
+	// a missing file keeps the current level
+	w.check()
+	if emitted() {
+		t.Fatal("debug message emitted with verbosity 0")
+	}
+
+	write("4\n")
+	w.check()
+	if emitted() {
+		t.Fatal("debug message emitted before the debounce time passed")
+	}
+
+	*now = now.Add(levelDebounce)
+	buf.Reset()
+	w.check()
+	if !strings.Contains(buf.String(), "log level changed from the command line to 4") {
+		t.Errorf("level transition not logged: %q", buf.String())
+	}
+	if !emitted() {
+		t.Fatal("debug message not emitted after setting verbosity 4")
+	}
+
+	write("0")
+	w.check()
+	*now = now.Add(levelDebounce)
+	w.check()
+	if emitted() {
+		t.Fatal("debug message emitted after setting verbosity 0")
+	}
+}
+
+func TestLevelWatcherDebounce(t *testing.T) {
+	t.Parallel()
+
+	w, now, write := newTestLevelWatcher(t)
+	var levels []klog.Level
+	w.setLevel = func(level klog.Level) error {
+		levels = append(levels, level)
+
+		return nil
+	}
+
+	write("4")
+	w.check()
+	*now = now.Add(3 * time.Second)
+	write("5")
+	w.check()
+	*now = now.Add(3 * time.Second)
+	w.check()
+	if len(levels) != 0 {
+		t.Fatalf("levels %v applied while the file was changing", levels)
+	}
+
+	*now = now.Add(2 * time.Second)
+	w.check()
+	*now = now.Add(levelDebounce)
+	w.check()
+	if len(levels) != 1 || levels[0] != 5 {
+		t.Fatalf("applied levels %v, expected [5]", levels)
+	}
+
+	for _, contents := range []string{"debug", "-1", ""} {
+		write(contents)
+		*now = now.Add(levelDebounce)
+		w.check()
+		w.check()
+	}
+	if len(levels) != 1 {
+		t.Errorf("invalid contents applied levels %v", levels)
+	}
+}
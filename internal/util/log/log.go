@@ -37,6 +37,12 @@ var CtxKey = contextKey("ID")
 // ReqID for logging request ID.
 var ReqID = contextKey("Req-ID")
 
+// MethodKey for logging the name of the CSI method.
+var MethodKey = contextKey("Method")
+
+// VolumeIDKey for logging the volume ID of the request.
+var VolumeIDKey = contextKey("Volume-ID")
+
 // Log helps in context based logging.
 func Log(ctx context.Context, format string) string {
 	id := ctx.Value(CtxKey)
@@ -61,30 +67,55 @@ func FatalLogMsg(message string, args ...interface{}) {
 
 // ErrorLogMsg helps in logging errors with message.
 func ErrorLogMsg(message string, args ...interface{}) {
+	if useJSON {
+		logJSON(context.Background(), severityError, 0, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(message, args...)
 	klog.ErrorDepth(1, logMessage)
 }
 
 // ErrorLog helps in logging errors with context.
 func ErrorLog(ctx context.Context, message string, args ...interface{}) {
+	if useJSON {
+		logJSON(ctx, severityError, 0, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(Log(ctx, message), args...)
 	klog.ErrorDepth(1, logMessage)
 }
 
 // WarningLogMsg helps in logging warnings with message.
 func WarningLogMsg(message string, args ...interface{}) {
+	if useJSON {
+		logJSON(context.Background(), severityWarning, 0, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(message, args...)
 	klog.WarningDepth(1, logMessage)
 }
 
 // WarningLog helps in logging warnings with context.
 func WarningLog(ctx context.Context, message string, args ...interface{}) {
+	if useJSON {
+		logJSON(ctx, severityWarning, 0, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(Log(ctx, message), args...)
 	klog.WarningDepth(1, logMessage)
 }
 
 // DefaultLog helps in logging with klog.level 1.
 func DefaultLog(message string, args ...interface{}) {
+	if useJSON {
+		logJSON(context.Background(), severityInfo, Default, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(message, args...)
 	// If logging is disabled, don't evaluate the arguments
 	if klog.V(Default).Enabled() {
@@ -94,6 +125,11 @@ func DefaultLog(message string, args ...interface{}) {
 
 // UsefulLog helps in logging with klog.level 2.
 func UsefulLog(ctx context.Context, message string, args ...interface{}) {
+	if useJSON {
+		logJSON(ctx, severityInfo, Useful, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(Log(ctx, message), args...)
 	// If logging is disabled, don't evaluate the arguments
 	if klog.V(Useful).Enabled() {
@@ -103,6 +139,11 @@ func UsefulLog(ctx context.Context, message string, args ...interface{}) {
 
 // ExtendedLogMsg helps in logging a message with klog.level 3.
 func ExtendedLogMsg(message string, args ...interface{}) {
+	if useJSON {
+		logJSON(context.Background(), severityInfo, Extended, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(message, args...)
 	// If logging is disabled, don't evaluate the arguments
 	if klog.V(Extended).Enabled() {
@@ -112,6 +153,11 @@ func ExtendedLogMsg(message string, args ...interface{}) {
 
 // ExtendedLog helps in logging with klog.level 3.
 func ExtendedLog(ctx context.Context, message string, args ...interface{}) {
+	if useJSON {
+		logJSON(ctx, severityInfo, Extended, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(Log(ctx, message), args...)
 	// If logging is disabled, don't evaluate the arguments
 	if klog.V(Extended).Enabled() {
@@ -121,6 +167,11 @@ func ExtendedLog(ctx context.Context, message string, args ...interface{}) {
 
 // DebugLogMsg helps in logging a message with klog.level 4.
 func DebugLogMsg(message string, args ...interface{}) {
+	if useJSON {
+		logJSON(context.Background(), severityInfo, Debug, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(message, args...)
 	// If logging is disabled, don't evaluate the arguments
 	if klog.V(Debug).Enabled() {
@@ -130,6 +181,11 @@ func DebugLogMsg(message string, args ...interface{}) {
 
 // DebugLog helps in logging with klog.level 4.
 func DebugLog(ctx context.Context, message string, args ...interface{}) {
+	if useJSON {
+		logJSON(ctx, severityInfo, Debug, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(Log(ctx, message), args...)
 	// If logging is disabled, don't evaluate the arguments
 	if klog.V(Debug).Enabled() {
@@ -139,6 +195,11 @@ func DebugLog(ctx context.Context, message string, args ...interface{}) {
 
 // TraceLogMsg helps in logging a message with klog.level 5.
 func TraceLogMsg(message string, args ...interface{}) {
+	if useJSON {
+		logJSON(context.Background(), severityInfo, Trace, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(message, args...)
 	// If logging is disabled, don't evaluate the arguments
 	if klog.V(Trace).Enabled() {
@@ -148,6 +209,11 @@ func TraceLogMsg(message string, args ...interface{}) {
 
 // TraceLog helps in logging with klog.level 5.
 func TraceLog(ctx context.Context, message string, args ...interface{}) {
+	if useJSON {
+		logJSON(ctx, severityInfo, Trace, message, args...)
+
+		return
+	}
 	logMessage := fmt.Sprintf(Log(ctx, message), args...)
 	// If logging is disabled, don't evaluate the arguments
 	if klog.V(Trace).Enabled() {
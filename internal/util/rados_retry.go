@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/ceph/ceph-csi/internal/util/log"
+)
+
+var (
+	// radosRetries is the number of times a failed rados operation is
+	// retried, when the error is transient.
+	radosRetries = 3
+	// radosRetryDelay is the delay before the first retry, it doubles for
+	// every next retry.
+	radosRetryDelay = 100 * time.Millisecond
+)
+
+// ConfigureRadosRetries sets the number of retries of rados operations that
+// failed with a transient error, and the delay before the first retry.
+func ConfigureRadosRetries(retries int, delay time.Duration) {
+	radosRetries = retries
+	radosRetryDelay = delay
+}
+
+// errorCode returns the (negative) errno of an error returned by the Ceph
+// APIs, or 0 if the error does not contain one.
+func errorCode(err error) int {
+	var ec interface{ ErrorCode() int }
+	if errors.As(err, &ec) {
+		return ec.ErrorCode()
+	}
+
+	return 0
+}
+
+// isBlocklisted returns true if the error indicates that the client has been
+// blocklisted. Ceph returns EBLOCKLISTED, which is ESHUTDOWN.
+func isBlocklisted(err error) bool {
+	return errorCode(err) == -int(syscall.ESHUTDOWN)
+}
+
+// isTransientRadosError returns true if the error is expected to go away
+// when the operation is retried, like a timeout during a monitor election.
+func isTransientRadosError(err error) bool {
+	switch errorCode(err) {
+	case -int(syscall.ETIMEDOUT), -int(syscall.EAGAIN), -int(syscall.ENOTCONN):
+		return true
+	}
+
+	return false
+}
+
+// RetryRadosOperation calls the idempotent operation op until it succeeds, it
+// fails with an error that is not transient, or the retries are exhausted. The
+// delay between the retries doubles every time. ErrBlocklisted is returned
+// when the client has been blocklisted, so that a fenced driver does not
+// keep on writing.
+func RetryRadosOperation(ctx context.Context, name string, op func() error) error {
+	return retryRadosOperation(ctx, name, radosRetries, radosRetryDelay, time.Sleep, op)
+}
+
+func retryRadosOperation(
+	ctx context.Context,
+	name string,
+	retries int,
+	delay time.Duration,
+	sleep func(time.Duration),
+	op func() error,
+) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		if isBlocklisted(err) {
+			return JoinErrors(ErrBlocklisted, err)
+		}
+		if !isTransientRadosError(err) || attempt >= retries {
+			return err
+		}
+
+		log.DebugLog(ctx, "rados operation %q failed with a transient error, retrying in %s (attempt %d of %d): %v",
+			name, delay, attempt+1, retries, err)
+		sleep(delay)
+		delay *= 2
+	}
+}
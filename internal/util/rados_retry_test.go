@@ -0,0 +1,120 @@
+/*
+Copyright 2023 The Ceph-CSI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// errnoError is an error with an errno, like the errors of the Ceph APIs.
+type errnoError int
+
+func (e errnoError) Error() string {
+	return fmt.Sprintf("errno %d", int(e))
+}
+
+func (e errnoError) ErrorCode() int {
+	return int(e)
+}
+
+var (
+	errTimedOut    = errnoError(-int(syscall.ETIMEDOUT))
+	errAgain       = errnoError(-int(syscall.EAGAIN))
+	errNotConn     = errnoError(-int(syscall.ENOTCONN))
+	errBlocklisted = errnoError(-int(syscall.ESHUTDOWN))
+	errNoEnt       = errnoError(-int(syscall.ENOENT))
+)
+
+func TestRetryRadosOperation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		// errors returned by the consecutive calls of the operation
+		errs       []error
+		wantCalls  int
+		wantDelays []time.Duration
+		wantErr    error
+	}{
+		{
+			name:      "success",
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:       "transient errors",
+			errs:       []error{errTimedOut, fmt.Errorf("wrapped: %w", errAgain), errNotConn, nil},
+			wantCalls:  4,
+			wantDelays: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:       "retries exhausted",
+			errs:       []error{errTimedOut, errTimedOut, errTimedOut, errTimedOut, nil},
+			wantCalls:  4,
+			wantDelays: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+			wantErr:    errTimedOut,
+		},
+		{
+			name:      "not transient",
+			errs:      []error{errNoEnt, nil},
+			wantCalls: 1,
+			wantErr:   errNoEnt,
+		},
+		{
+			name:       "blocklisted",
+			errs:       []error{errTimedOut, errBlocklisted, nil},
+			wantCalls:  2,
+			wantDelays: []time.Duration{time.Second},
+			wantErr:    ErrBlocklisted,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+
+			calls := 0
+			var delays []time.Duration
+			err := retryRadosOperation(context.TODO(), ts.name, 3, time.Second,
+				func(d time.Duration) {
+					delays = append(delays, d)
+				},
+				func() error {
+					err := ts.errs[calls]
+					calls++
+
+					return err
+				})
+
+			if !errors.Is(err, ts.wantErr) {
+				t.Errorf("retryRadosOperation() error = %v, want %v", err, ts.wantErr)
+			}
+			if calls != ts.wantCalls {
+				t.Errorf("retryRadosOperation() calls = %d, want %d", calls, ts.wantCalls)
+			}
+			if !reflect.DeepEqual(delays, ts.wantDelays) {
+				t.Errorf("retryRadosOperation() delays = %v, want %v", delays, ts.wantDelays)
+			}
+		})
+	}
+}
@@ -32,6 +32,13 @@ import (
 const (
 	keySeparator   rune   = '/'
 	labelSeparator string = ","
+
+	// topologyConstrainedPoolsKey is the StorageClass parameter that
+	// contains the pools per topology domain.
+	topologyConstrainedPoolsKey = "topologyConstrainedPools"
+	// topologyConstrainedDataPoolsKey is the StorageClass parameter that
+	// contains the CephFS data pools per topology domain.
+	topologyConstrainedDataPoolsKey = "topologyConstrainedDataPools"
 )
 
 func k8sGetNodeLabels(nodeName string) (map[string]string, error) {
@@ -142,11 +149,34 @@ type TopologyConstrainedPool struct {
 // from a CSI CreateVolume request.
 func GetTopologyFromRequest(
 	req *csi.CreateVolumeRequest,
+) (*[]TopologyConstrainedPool, *csi.TopologyRequirement, error) {
+	return getTopologyFromParameter(req, topologyConstrainedPoolsKey)
+}
+
+// GetDataPoolTopologyFromRequest extracts the CephFS data pools per topology
+// domain and passed in accessibility constraints from a CSI CreateVolume
+// request. The pools are read from the topologyConstrainedDataPools parameter,
+// or from the topologyConstrainedPools parameter if that is not set.
+func GetDataPoolTopologyFromRequest(
+	req *csi.CreateVolumeRequest,
+) (*[]TopologyConstrainedPool, *csi.TopologyRequirement, error) {
+	if req.GetParameters()[topologyConstrainedDataPoolsKey] == "" {
+		return getTopologyFromParameter(req, topologyConstrainedPoolsKey)
+	}
+
+	return getTopologyFromParameter(req, topologyConstrainedDataPoolsKey)
+}
+
+// getTopologyFromParameter extracts TopologyConstrainedPools from the request
+// parameter and the passed in accessibility constraints.
+func getTopologyFromParameter(
+	req *csi.CreateVolumeRequest,
+	parameter string,
 ) (*[]TopologyConstrainedPool, *csi.TopologyRequirement, error) {
 	var topologyPools []TopologyConstrainedPool
 
 	// check if parameters have pool configuration pertaining to topology
-	topologyPoolsStr := req.GetParameters()["topologyConstrainedPools"]
+	topologyPoolsStr := req.GetParameters()[parameter]
 	if topologyPoolsStr == "" {
 		return nil, nil, nil
 	}
@@ -161,7 +191,8 @@ func GetTopologyFromRequest(
 	err := json.Unmarshal([]byte(strings.Replace(topologyPoolsStr, "\n", " ", -1)), &topologyPools)
 	if err != nil {
 		return nil, nil, fmt.Errorf(
-			"failed to parse JSON encoded topology constrained pools parameter (%s): %w",
+			"failed to parse JSON encoded topology constrained pools parameter %s (%s): %w",
+			parameter,
 			topologyPoolsStr,
 			err)
 	}
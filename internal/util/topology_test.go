@@ -18,6 +18,7 @@ package util
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -332,6 +333,170 @@ func TestFindPoolAndTopology(t *testing.T) {
 	checkAndReportError(t, "expected success got:", err)
 }
 
+func TestFindPoolAndTopologyPreferredRequisite(t *testing.T) {
+	t.Parallel()
+	zoneA := map[string]string{"prefix/zone": "A"}
+	zoneB := map[string]string{"prefix/zone": "B"}
+	zoneC := map[string]string{"prefix/zone": "C"}
+	topologyPools := []TopologyConstrainedPool{
+		{
+			PoolName:       "pool-a",
+			DomainSegments: []topologySegment{{DomainLabel: "zone", DomainValue: "A"}},
+		},
+		{
+			PoolName:       "pool-b",
+			DomainSegments: []topologySegment{{DomainLabel: "zone", DomainValue: "B"}},
+		},
+	}
+	tests := []struct {
+		name         string
+		requirement  *csi.TopologyRequirement
+		wantPool     string
+		wantTopology map[string]string
+		wantErr      bool
+	}{
+		{
+			name: "preferred is selected over requisite",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: zoneA}, {Segments: zoneB}},
+				Preferred: []*csi.Topology{{Segments: zoneB}, {Segments: zoneA}},
+			},
+			wantPool:     "pool-b",
+			wantTopology: zoneB,
+		},
+		{
+			name: "first matching preferred is selected",
+			requirement: &csi.TopologyRequirement{
+				Preferred: []*csi.Topology{{Segments: zoneC}, {Segments: zoneA}, {Segments: zoneB}},
+			},
+			wantPool:     "pool-a",
+			wantTopology: zoneA,
+		},
+		{
+			name: "requisite is selected when preferred does not match",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: zoneC}, {Segments: zoneB}},
+				Preferred: []*csi.Topology{{Segments: zoneC}},
+			},
+			wantPool:     "pool-b",
+			wantTopology: zoneB,
+		},
+		{
+			name: "requisite only",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: zoneA}},
+			},
+			wantPool:     "pool-a",
+			wantTopology: zoneA,
+		},
+		{
+			name: "neither preferred nor requisite match",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: zoneC}},
+				Preferred: []*csi.Topology{{Segments: zoneC}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			poolName, _, topology, err := FindPoolAndTopology(&topologyPools, ts.requirement)
+			if ts.wantErr {
+				checkError(t, "expected failure due to mismatching topology", err)
+
+				return
+			}
+			checkAndReportError(t, "expected success got:", err)
+			if poolName != ts.wantPool {
+				t.Errorf("FindPoolAndTopology() pool = %s, want %s", poolName, ts.wantPool)
+			}
+			if !reflect.DeepEqual(topology, ts.wantTopology) {
+				t.Errorf("FindPoolAndTopology() topology = %v, want %v", topology, ts.wantTopology)
+			}
+		})
+	}
+}
+
+func TestGetDataPoolTopologyFromRequest(t *testing.T) {
+	t.Parallel()
+	pools := `[{"poolName":"pool-a","domainSegments":[{"domainLabel":"zone","value":"A"}]}]`
+	dataPools := `[{"poolName":"data-a","domainSegments":[{"domainLabel":"zone","value":"A"}]}]`
+	requirement := &csi.TopologyRequirement{
+		Preferred: []*csi.Topology{{Segments: map[string]string{"prefix/zone": "A"}}},
+	}
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		requirement *csi.TopologyRequirement
+		wantPool    string
+		wantErr     bool
+	}{
+		{
+			name:        "no topology parameters",
+			parameters:  map[string]string{},
+			requirement: requirement,
+			wantPool:    "",
+		},
+		{
+			name:        "no accessibility requirements",
+			parameters:  map[string]string{topologyConstrainedDataPoolsKey: dataPools},
+			requirement: nil,
+			wantPool:    "",
+		},
+		{
+			name:        "data pools",
+			parameters:  map[string]string{topologyConstrainedDataPoolsKey: dataPools},
+			requirement: requirement,
+			wantPool:    "data-a",
+		},
+		{
+			name:        "fall back to pools",
+			parameters:  map[string]string{topologyConstrainedPoolsKey: pools},
+			requirement: requirement,
+			wantPool:    "pool-a",
+		},
+		{
+			name: "data pools take precedence over pools",
+			parameters: map[string]string{
+				topologyConstrainedPoolsKey:     pools,
+				topologyConstrainedDataPoolsKey: dataPools,
+			},
+			requirement: requirement,
+			wantPool:    "data-a",
+		},
+		{
+			name:        "invalid data pools",
+			parameters:  map[string]string{topologyConstrainedDataPoolsKey: "[{"},
+			requirement: requirement,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			req := &csi.CreateVolumeRequest{
+				Parameters:                ts.parameters,
+				AccessibilityRequirements: ts.requirement,
+			}
+			topologyPools, topologyRequirement, err := GetDataPoolTopologyFromRequest(req)
+			if ts.wantErr {
+				checkError(t, "expected failure due to invalid topology pools", err)
+
+				return
+			}
+			checkAndReportError(t, "expected success got:", err)
+			poolName, _, _, err := FindPoolAndTopology(topologyPools, topologyRequirement)
+			checkAndReportError(t, "expected success got:", err)
+			if poolName != ts.wantPool {
+				t.Errorf("GetDataPoolTopologyFromRequest() pool = %s, want %s", poolName, ts.wantPool)
+			}
+		})
+	}
+}
+
 /*
 // TODO: To test GetTopologyFromDomainLabels we need it to accept a k8s client interface, to mock k8sGetNdeLabels output
 func TestGetTopologyFromDomainLabels(t *testing.T) {
@@ -93,6 +93,8 @@ type Config struct {
 	PluginPath      string // location of cephcsi plugin
 	StagingPath     string // location of cephcsi staging path
 	DomainLabels    string // list of domain labels to read from the node
+	LogFormat       string // format of the log messages [text|json]
+	LogLevelFile    string // file with the log verbosity, watched for changes
 
 	// metrics related flags
 	MetricsPath     string // path of prometheus endpoint where metrics will be available
@@ -109,6 +111,10 @@ type Config struct {
 	PollTime          time.Duration // time interval in seconds between each poll
 	PoolTimeout       time.Duration // probe timeout in seconds
 	EnableGRPCMetrics bool          // option to enable grpc metrics
+	EnableRPCMetrics  bool          // option to enable the duration and status code metrics of the RPCs
+	SlowRPCThreshold  time.Duration // time after which a warning is logged for a running RPC
+	SlowRPCInterval   time.Duration // interval at which the warning for a slow RPC is repeated
+	ShutdownGrace     time.Duration // time the running RPCs get to finish on shutdown
 
 	EnableProfiling    bool // flag to enable profiling
 	IsControllerServer bool // if set to true start provisioner server
@@ -119,11 +125,24 @@ type Config struct {
 	// rbd image or the image chain has the deep-flatten feature.
 	SkipForceFlatten bool
 
+	// VerifyEncryptionPrereqs is set to true to check the prerequisites
+	// for encrypted volumes when the node server starts.
+	VerifyEncryptionPrereqs bool
+
+	// LuksMinPassphraseLength is the minimal length in bytes of the
+	// passphrase that encrypted volumes are formatted with.
+	LuksMinPassphraseLength int
+
 	// cephfs related flags
 	ForceKernelCephFS bool // force to use the ceph kernel client even if the kernel is < 4.17
+	CephFSAutoRemount bool // remount stale kernel mounts that use recover_session=clean
 
 	SetMetadata bool // set metadata on the volume
 
+	// RbdTrashExpiry is the default time an image is kept in the trash when
+	// the volume is deleted, 0 removes the image right away.
+	RbdTrashExpiry time.Duration
+
 	// RbdHardMaxCloneDepth is the hard limit for maximum number of nested volume clones that are taken before a flatten
 	// occurs
 	RbdHardMaxCloneDepth uint
@@ -151,6 +170,28 @@ type Config struct {
 	// Read affinity related options
 	EnableReadAffinity  bool   // enable OSD read affinity.
 	CrushLocationLabels string // list of CRUSH location labels to read from the node.
+
+	// connection pool related options
+	ConnIdleTimeout time.Duration // time after which unused connections to Ceph clusters are closed
+	ConnPoolMaxSize int           // maximum number of connections to Ceph clusters, 0 for no limit
+
+	// rados retry related options
+	RadosRetries    int           // number of retries of rados operations that failed with a transient error
+	RadosRetryDelay time.Duration // delay before the first retry of a rados operation, doubles every retry
+
+	// journal check related options
+	JournalCheck      bool   // check the journals of a pool for dangling entries
+	JournalRepair     bool   // remove the dangling entries found by the journal check
+	JournalScanPool   bool   // list all objects in the pool to find the journals of all instances
+	JournalClusterID  string // clusterID of the pool with the journals
+	JournalPool       string // pool with the journals
+	JournalFsName     string // CephFS filesystem of the journals, RBD images are checked if empty
+	JournalSecretPath string // directory with the keys of the secret used to connect to the cluster
+
+	// trash restore related options
+	RestoreVolumeID       string // volume ID of the RBD volume to restore from the trash
+	RestorePool           string // pool of the trashed image
+	RestoreRadosNamespace string // rados namespace of the trashed image
 }
 
 // ValidateDriverName validates the driver name.
@@ -377,6 +418,42 @@ func MountOptionsAdd(options string, add ...string) string {
 	return strings.Join(newOpts, ",")
 }
 
+// MountOptionsMerge merges comma separated mount option strings into a new
+// string. The option strings are passed in order of precedence, the first
+// one has the highest. An option is dropped when an option string with a
+// higher precedence already sets it, also when it sets a different value
+// (`key=value`) or negates it (`ro`/`rw`, `wsync`/`nowsync`).
+func MountOptionsMerge(options ...string) string {
+	merged := []string{}
+	keys := make(map[string]struct{})
+	for _, opts := range options {
+		for _, opt := range strings.Split(opts, ",") {
+			if opt == "" {
+				continue
+			}
+			key := mountOptionKey(opt)
+			if _, ok := keys[key]; ok {
+				continue
+			}
+			keys[key] = struct{}{}
+			merged = append(merged, opt)
+		}
+	}
+
+	return strings.Join(merged, ",")
+}
+
+// mountOptionKey returns the name of the option without its value and
+// without a `no` prefix, so that conflicting options have the same key.
+func mountOptionKey(opt string) string {
+	key, _, _ := strings.Cut(opt, "=")
+	if key == "ro" {
+		return "rw"
+	}
+
+	return strings.TrimPrefix(key, "no")
+}
+
 func contains(s []string, key string) bool {
 	for _, v := range s {
 		if v == key {
@@ -396,3 +473,23 @@ func CallStack() string {
 
 	return string(stack)
 }
+
+// ParsePoolNamespace splits a "pool" or "pool/namespace" string into the pool
+// and the rados namespace. Surrounding whitespace is removed from both parts,
+// the namespace is empty when the string only contains a pool.
+func ParsePoolNamespace(s string) (string, string, error) {
+	pool, namespace, found := strings.Cut(s, "/")
+	pool = strings.TrimSpace(pool)
+	namespace = strings.TrimSpace(namespace)
+
+	switch {
+	case pool == "":
+		return "", "", fmt.Errorf("%w: missing pool in %q", ErrInvalidPoolNamespace, s)
+	case found && namespace == "":
+		return "", "", fmt.Errorf("%w: missing namespace in %q", ErrInvalidPoolNamespace, s)
+	case strings.Contains(namespace, "/"):
+		return "", "", fmt.Errorf("%w: too many separators in %q", ErrInvalidPoolNamespace, s)
+	}
+
+	return pool, namespace, nil
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package util
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -245,6 +246,82 @@ func TestMountOptionsAdd(t *testing.T) {
 	}
 }
 
+func TestMountOptionsMerge(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		options []string
+		result  string
+	}{
+		{
+			"no options",
+			[]string{},
+			"",
+		},
+		{
+			"empty options",
+			[]string{"", ",", ""},
+			"",
+		},
+		{
+			"single option string",
+			[]string{"wsync,recover_session=clean"},
+			"wsync,recover_session=clean",
+		},
+		{
+			"distinct options",
+			[]string{"wsync", "recover_session=clean", "ms_mode=secure"},
+			"wsync,recover_session=clean,ms_mode=secure",
+		},
+		{
+			"redundant options",
+			[]string{"wsync,ms_mode=secure", "ms_mode=secure", "wsync"},
+			"wsync,ms_mode=secure",
+		},
+		{
+			"volume value overrides cluster and driver value",
+			[]string{"recover_session=clean", "recover_session=no", "recover_session=no,ms_mode=crc"},
+			"recover_session=clean,ms_mode=crc",
+		},
+		{
+			"cluster value overrides driver value",
+			[]string{"", "ms_mode=secure", "ms_mode=crc"},
+			"ms_mode=secure",
+		},
+		{
+			"negated option overrides option",
+			[]string{"nowsync", "wsync"},
+			"nowsync",
+		},
+		{
+			"option overrides negated option",
+			[]string{"wsync", "", "nowsync"},
+			"wsync",
+		},
+		{
+			"ro overrides rw",
+			[]string{"ro", "rw"},
+			"ro",
+		},
+		{
+			"lower precedence options with leading and trailing ,",
+			[]string{",wsync", "recover_session=clean,"},
+			"wsync,recover_session=clean",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			result := MountOptionsMerge(tc.options...)
+			if result != tc.result {
+				t.Errorf("MountOptionsMerge(): %v, want %v", result, tc.result)
+			}
+		})
+	}
+}
+
 func TestParseKernelRelease(t *testing.T) {
 	t.Parallel()
 
@@ -401,3 +478,42 @@ func TestRoundOffCephFSVolSize(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePoolNamespace(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		input     string
+		pool      string
+		namespace string
+		wantErr   bool
+	}{
+		{"plain pool", "replicapool", "replicapool", "", false},
+		{"pool and namespace", "replicapool/ns1", "replicapool", "ns1", false},
+		{"surrounding whitespace", " replicapool / ns1 ", "replicapool", "ns1", false},
+		{"empty", "", "", "", true},
+		{"missing pool", "/ns1", "", "", true},
+		{"missing namespace", "replicapool/", "", "", true},
+		{"too many separators", "replicapool/ns1/image", "", "", true},
+	}
+	for _, tt := range tests {
+		ts := tt
+		t.Run(ts.name, func(t *testing.T) {
+			t.Parallel()
+			pool, namespace, err := ParsePoolNamespace(ts.input)
+			if ts.wantErr {
+				if !errors.Is(err, ErrInvalidPoolNamespace) {
+					t.Errorf("ParsePoolNamespace() error = %v, want %v", err, ErrInvalidPoolNamespace)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Errorf("ParsePoolNamespace() unexpected error = %v", err)
+			}
+			if pool != ts.pool || namespace != ts.namespace {
+				t.Errorf("ParsePoolNamespace() = %q, %q, want %q, %q", pool, namespace, ts.pool, ts.namespace)
+			}
+		})
+	}
+}